@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"os"
 	"os/signal"
@@ -16,22 +17,29 @@ import (
 	orderHandler "dunhayat-api/internal/orders/http"
 	orderRepo "dunhayat-api/internal/orders/repository"
 	orderUseCase "dunhayat-api/internal/orders/usecase"
+	"dunhayat-api/internal/payments"
 	paymentAdapter "dunhayat-api/internal/payments/adapter"
 	paymentHandler "dunhayat-api/internal/payments/http"
+	paymentRepo "dunhayat-api/internal/payments/repository"
 	paymentUseCase "dunhayat-api/internal/payments/usecase"
 	productAdapter "dunhayat-api/internal/products/adapter"
 	productHandler "dunhayat-api/internal/products/http"
 	productRepo "dunhayat-api/internal/products/repository"
 	productUseCase "dunhayat-api/internal/products/usecase"
 	usersAdapter "dunhayat-api/internal/users/adapter"
+	userHandler "dunhayat-api/internal/users/http"
 	userRepo "dunhayat-api/internal/users/repository"
+	userUseCase "dunhayat-api/internal/users/usecase"
 	"dunhayat-api/pkg/config"
 	"dunhayat-api/pkg/database"
 	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/mail"
 	"dunhayat-api/pkg/payment"
 	"dunhayat-api/pkg/redis"
 	"dunhayat-api/pkg/router"
+	"dunhayat-api/pkg/scheduler"
 	"dunhayat-api/pkg/sms"
+	"dunhayat-api/pkg/storage"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -144,6 +152,10 @@ func main() {
 		"Database connection established - migrations handled by Atlas",
 	)
 
+	transactor := database.NewTransactor(
+		dbConn,
+	)
+
 	userRepository := userRepo.NewUserRepository(
 		dbConn,
 	)
@@ -153,15 +165,42 @@ func main() {
 	productRepository := productRepo.NewProductRepository(
 		dbConn,
 	)
+	variantRepository := productRepo.NewVariantRepository(
+		dbConn,
+	)
+	imageRepository := productRepo.NewImageRepository(
+		dbConn,
+	)
+	inventoryMovementRepository := productRepo.NewInventoryMovementRepository(
+		dbConn,
+	)
 	saleRepository := orderRepo.NewSaleRepository(
 		dbConn,
 	)
 	saleItemRepository := orderRepo.NewSaleItemRepository(
 		dbConn,
 	)
+	saleShippingRepository := orderRepo.NewSaleShippingRepository(
+		dbConn,
+	)
 	cartReservationRepository := orderRepo.NewCartReservationRepository(
 		dbConn,
 	)
+	paymentRepository := paymentRepo.NewPaymentRepository(
+		dbConn,
+	)
+	paymentCallbackRepository := paymentRepo.NewPaymentCallbackRepository(
+		dbConn,
+	)
+	fraudAlertRepository := paymentRepo.NewFraudAlertRepository(
+		dbConn,
+	)
+	reconciliationRepository := paymentRepo.NewReconciliationRepository(
+		dbConn,
+	)
+	refundRepository := paymentRepo.NewRefundRepository(
+		dbConn,
+	)
 	sessionRepository := authRepo.NewSessionRepository(
 		dbConn,
 	)
@@ -169,15 +208,74 @@ func main() {
 	smsProvider := sms.NewKavenegarProvider(
 		cfg.Auth.KavenegarAPIKey,
 	)
+	mailProvider, err := mail.NewProvider(mail.Config{
+		Provider: cfg.Mail.Provider,
+		From:     cfg.Mail.From,
+		SMTP: mail.SMTPConfig{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+		},
+		FileDir: cfg.Mail.FileDir,
+	}, log)
+	if err != nil {
+		log.Fatal(
+			"Failed to configure mail provider",
+			zap.Error(err),
+		)
+	}
+
+	storageBaseURL := cfg.Storage.BaseURL
+	if storageBaseURL == "" {
+		storageBaseURL = cfg.App.Domain + "/media"
+	}
+	mediaStorage, err := storage.NewStorage(storage.Config{
+		Driver:   cfg.Storage.Driver,
+		LocalDir: cfg.Storage.LocalDir,
+		BaseURL:  storageBaseURL,
+	})
+	if err != nil {
+		log.Fatal(
+			"Failed to configure storage",
+			zap.Error(err),
+		)
+	}
+
+	emailTokenSecret := []byte(cfg.Auth.EmailTokenSecret)
+	if len(emailTokenSecret) == 0 {
+		log.Warn(
+			"No email token secret configured, using a random one; " +
+				"verification links will not survive a restart",
+		)
+		emailTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(emailTokenSecret); err != nil {
+			log.Fatal(
+				"Failed to generate email token secret",
+				zap.Error(err),
+			)
+		}
+	}
+	emailTokenTTL := time.Duration(cfg.Auth.EmailTokenTTL) * time.Second
+
 	otpRepository := authRepo.NewRedisOTPRepository(
 		redisClient, log,
 	)
 
+	inventoryService := productUseCase.NewInventoryService(
+		transactor,
+		variantRepository,
+		inventoryMovementRepository,
+	)
+
 	ordersProductAdapter := productAdapter.NewOrdersProductAdapter(
 		productRepository,
+		variantRepository,
+		inventoryService,
 	)
 	ordersUserAdapter := usersAdapter.NewOrdersUserAdapter(
 		userRepository,
+		addressRepository,
 	)
 
 	listProductsUseCase := productUseCase.NewListProductsUseCase(
@@ -186,45 +284,286 @@ func main() {
 	getProductUseCase := productUseCase.NewGetProductUseCase(
 		productRepository,
 	)
+	createProductUseCase := productUseCase.NewCreateProductUseCase(
+		transactor,
+		productRepository,
+		variantRepository,
+		inventoryService,
+	)
+	updateProductUseCase := productUseCase.NewUpdateProductUseCase(
+		productRepository,
+	)
+	adjustStockUseCase := productUseCase.NewAdjustStockUseCase(
+		variantRepository,
+		inventoryService,
+		log,
+	)
+	archiveProductUseCase := productUseCase.NewArchiveProductUseCase(
+		productRepository,
+	)
+	createVariantUseCase := productUseCase.NewCreateVariantUseCase(
+		transactor,
+		productRepository,
+		variantRepository,
+		inventoryService,
+	)
+	updateVariantUseCase := productUseCase.NewUpdateVariantUseCase(
+		transactor,
+		variantRepository,
+	)
+	archiveVariantUseCase := productUseCase.NewArchiveVariantUseCase(
+		variantRepository,
+	)
+	uploadImageUseCase := productUseCase.NewUploadImageUseCase(
+		productRepository,
+		imageRepository,
+		mediaStorage,
+		productUseCase.ImageConfig{
+			MaxSize:       cfg.Products.MaxImageSize,
+			ThumbnailSize: cfg.Products.ThumbnailSize,
+			MaxImages:     cfg.Products.MaxImages,
+		},
+		log,
+	)
+	deleteImageUseCase := productUseCase.NewDeleteImageUseCase(
+		transactor,
+		imageRepository,
+		mediaStorage,
+		log,
+	)
+	reorderImagesUseCase := productUseCase.NewReorderImagesUseCase(
+		transactor,
+		imageRepository,
+	)
+	listMovementsUseCase := productUseCase.NewListMovementsUseCase(
+		productRepository,
+		inventoryMovementRepository,
+	)
+	checkDriftUseCase := productUseCase.NewCheckDriftUseCase(
+		inventoryMovementRepository,
+		log,
+	)
 
-	zibalClient := payment.NewZibalClient(payment.ZibalConfig{
-		MerchantID: cfg.Payment.Zibal.MerchantID,
-		BaseURL:    cfg.Payment.Zibal.BaseURL,
-		Timeout:    time.Duration(cfg.Payment.Zibal.Timeout) * time.Second,
-		APIToken:   cfg.Payment.Zibal.APIToken,
-	})
+	gateways := payment.NewRegistry()
+	if cfg.Payment.Zibal.Enabled {
+		gateways.Register(
+			payments.PaymentMethodZibal.String(),
+			payment.NewZibalClient(payment.ZibalConfig{
+				MerchantID: cfg.Payment.Zibal.MerchantID,
+				BaseURL:    cfg.Payment.Zibal.BaseURL,
+				RefundURL:  cfg.Payment.Zibal.RefundURL,
+				Timeout: time.Duration(
+					cfg.Payment.Zibal.Timeout,
+				) * time.Second,
+				APIToken: cfg.Payment.Zibal.APIToken,
+			}),
+		)
+	}
+	if cfg.Payment.ZarinPal.Enabled {
+		gateways.Register(
+			payments.PaymentMethodZarinPal.String(),
+			payment.NewZarinPalClient(payment.ZarinPalConfig{
+				MerchantID: cfg.Payment.ZarinPal.MerchantID,
+				BaseURL:    cfg.Payment.ZarinPal.BaseURL,
+				Timeout: time.Duration(
+					cfg.Payment.ZarinPal.Timeout,
+				) * time.Second,
+			}),
+		)
+	}
 
 	paymentsOrderAdapter := orderAdapter.NewPaymentsOrderAdapter(
+		transactor,
 		saleRepository,
+		cartReservationRepository,
+		ordersProductAdapter,
 	)
 
 	initiatePaymentUseCase := paymentUseCase.NewInitiatePaymentUseCase(
+		transactor,
 		paymentsOrderAdapter,
-		zibalClient,
+		paymentRepository,
+		gateways,
 		log,
 		cfg,
+		cfg.App.Domain+"/api/v1/payments/callback",
 	)
 	verifyPaymentUseCase := paymentUseCase.NewVerifyPaymentUseCase(
+		transactor,
 		paymentsOrderAdapter,
-		zibalClient,
+		paymentRepository,
+		fraudAlertRepository,
+		gateways,
+		log,
 	)
 	handleCallbackUseCase := paymentUseCase.NewHandleCallbackUseCase(
+		transactor,
 		paymentsOrderAdapter,
+		paymentRepository,
+		paymentCallbackRepository,
+		fraudAlertRepository,
+		gateways,
+		log,
 	)
 	getPaymentStatusUseCase := paymentUseCase.NewGetPaymentStatusUseCase(
 		paymentsOrderAdapter,
+		paymentRepository,
+	)
+	listPaymentCallbacksUseCase := paymentUseCase.NewListPaymentCallbacksUseCase(
+		paymentsOrderAdapter,
+		paymentCallbackRepository,
+	)
+
+	reconcilePaymentsUseCase := paymentUseCase.NewReconcilePaymentsUseCase(
+		transactor,
+		paymentsOrderAdapter,
+		paymentRepository,
+		fraudAlertRepository,
+		reconciliationRepository,
+		gateways,
+		paymentUseCase.ReconcileConfig{
+			MinAge: time.Duration(
+				cfg.Payment.Reconciliation.MinAge,
+			) * time.Second,
+			Lookback: time.Duration(
+				cfg.Payment.Reconciliation.Lookback,
+			) * time.Second,
+			BatchSize: cfg.Payment.Reconciliation.BatchSize,
+		},
+		log,
+	)
+	listReconciliationsUseCase := paymentUseCase.NewListReconciliationsUseCase(
+		reconciliationRepository,
+	)
+	getReconciliationUseCase := paymentUseCase.NewGetReconciliationUseCase(
+		reconciliationRepository,
+	)
+
+	cancelPendingPaymentsUseCase := paymentUseCase.NewCancelPendingPaymentsUseCase(
+		paymentRepository,
+	)
+	refundPaymentUseCase := paymentUseCase.NewRefundPaymentUseCase(
+		transactor,
+		paymentRepository,
+		refundRepository,
+		gateways,
+		log,
+	)
+	completeRefundUseCase := paymentUseCase.NewCompleteRefundUseCase(
+		transactor,
+		refundRepository,
+	)
+	voidRefundUseCase := paymentUseCase.NewVoidRefundUseCase(
+		transactor,
+		paymentRepository,
+		refundRepository,
+	)
+	listRefundsUseCase := paymentUseCase.NewListRefundsUseCase(
+		refundRepository,
+	)
+
+	ordersPaymentAdapter := paymentAdapter.NewOrdersPaymentAdapter(
+		initiatePaymentUseCase,
+		verifyPaymentUseCase,
+		cancelPendingPaymentsUseCase,
+		refundPaymentUseCase,
+		listRefundsUseCase,
+		paymentRepository,
 	)
 
 	createOrderUseCase := orderUseCase.NewCreateOrderUseCase(
+		transactor,
 		saleRepository,
 		saleItemRepository,
+		saleShippingRepository,
 		cartReservationRepository,
 		ordersProductAdapter,
 		ordersUserAdapter,
-		paymentAdapter.NewOrdersPaymentAdapter(
-			initiatePaymentUseCase,
-			verifyPaymentUseCase,
-		),
+		ordersPaymentAdapter,
+		time.Duration(cfg.Orders.ReservationTTL)*time.Second,
+		&cfg.App,
+	)
+	getOrderUseCase := orderUseCase.NewGetOrderUseCase(
+		saleRepository,
+		saleItemRepository,
+		saleShippingRepository,
+		ordersPaymentAdapter,
+	)
+	listOrdersUseCase := orderUseCase.NewListOrdersUseCase(
+		saleRepository,
+		saleItemRepository,
+		saleShippingRepository,
+	)
+	cancelOrderUseCase := orderUseCase.NewCancelOrderUseCase(
+		transactor,
+		saleRepository,
+		saleItemRepository,
+		cartReservationRepository,
+		ordersProductAdapter,
+		ordersPaymentAdapter,
+	)
+	updateOrderStatusUseCase := orderUseCase.NewUpdateOrderStatusUseCase(
+		transactor,
+		saleRepository,
+	)
+	refundOrderUseCase := orderUseCase.NewRefundOrderUseCase(
+		transactor,
+		saleRepository,
+		saleItemRepository,
+		ordersProductAdapter,
+		ordersPaymentAdapter,
+	)
+	listOrderRefundsUseCase := orderUseCase.NewListOrderRefundsUseCase(
+		saleRepository,
+		ordersPaymentAdapter,
+	)
+	expireReservationsUseCase := orderUseCase.NewExpireReservationsUseCase(
+		transactor,
+		saleRepository,
+		cartReservationRepository,
+		ordersProductAdapter,
+		ordersPaymentAdapter,
+		log,
+	)
+
+	getProfileUseCase := userUseCase.NewGetProfileUseCase(
+		userRepository,
+	)
+	updateProfileUseCase := userUseCase.NewUpdateProfileUseCase(
+		userRepository,
+	)
+	requestEmailVerificationUseCase := userUseCase.NewRequestEmailVerificationUseCase(
+		userRepository,
+		mailProvider,
+		emailTokenSecret,
+		emailTokenTTL,
+		cfg.App.Domain+"/api/v1/verify-email",
+		log,
+	)
+	verifyEmailUseCase := userUseCase.NewVerifyEmailUseCase(
+		userRepository,
+		emailTokenSecret,
+		emailTokenTTL,
+	)
+	listAddressesUseCase := userUseCase.NewListAddressesUseCase(
+		addressRepository,
+	)
+	createAddressUseCase := userUseCase.NewCreateAddressUseCase(
+		transactor,
+		userRepository,
+		addressRepository,
+	)
+	updateAddressUseCase := userUseCase.NewUpdateAddressUseCase(
+		transactor,
+		addressRepository,
+	)
+	deleteAddressUseCase := userUseCase.NewDeleteAddressUseCase(
+		transactor,
+		addressRepository,
+	)
+
+	updateRoleUseCase := userUseCase.NewUpdateRoleUseCase(
+		userRepository,
 	)
 
 	authUserAdapter := usersAdapter.NewAuthUserAdapter(
@@ -252,15 +591,50 @@ func main() {
 	productHTTPHandler := productHandler.NewProductHandler(
 		listProductsUseCase,
 		getProductUseCase,
+		createProductUseCase,
+		updateProductUseCase,
+		adjustStockUseCase,
+		archiveProductUseCase,
+		createVariantUseCase,
+		updateVariantUseCase,
+		archiveVariantUseCase,
+		uploadImageUseCase,
+		deleteImageUseCase,
+		reorderImagesUseCase,
+		listMovementsUseCase,
+		checkDriftUseCase,
 	)
 	orderHTTPHandler := orderHandler.NewOrderHandler(
 		createOrderUseCase,
+		getOrderUseCase,
+		listOrdersUseCase,
+		cancelOrderUseCase,
+		updateOrderStatusUseCase,
+		refundOrderUseCase,
+		listOrderRefundsUseCase,
 	)
 	paymentHTTPHandler := paymentHandler.NewPaymentHandler(
 		initiatePaymentUseCase,
 		verifyPaymentUseCase,
 		handleCallbackUseCase,
 		getPaymentStatusUseCase,
+		listPaymentCallbacksUseCase,
+		reconcilePaymentsUseCase,
+		listReconciliationsUseCase,
+		getReconciliationUseCase,
+		completeRefundUseCase,
+		voidRefundUseCase,
+	)
+	userHTTPHandler := userHandler.NewUserHandler(
+		getProfileUseCase,
+		updateProfileUseCase,
+		requestEmailVerificationUseCase,
+		verifyEmailUseCase,
+		listAddressesUseCase,
+		createAddressUseCase,
+		updateAddressUseCase,
+		deleteAddressUseCase,
+		updateRoleUseCase,
 	)
 	authHTTPHandler := authHandler.NewAuthHandler(
 		requestOTPUseCase,
@@ -277,6 +651,40 @@ func main() {
 		middlewareUserAdapter,
 	)
 
+	jobScheduler := scheduler.New(log)
+	if err := jobScheduler.Register(
+		"expire-cart-reservations",
+		time.Duration(cfg.Scheduler.ReservationSweepInterval)*time.Second,
+		func(ctx context.Context) error {
+			_, err := expireReservationsUseCase.Execute(ctx)
+			return err
+		},
+	); err != nil {
+		log.Fatal("Failed to register scheduled job", zap.Error(err))
+	}
+	if err := jobScheduler.Register(
+		"check-inventory-drift",
+		time.Duration(cfg.Scheduler.InventoryCheckInterval)*time.Second,
+		func(ctx context.Context) error {
+			_, err := checkDriftUseCase.Execute(ctx)
+			return err
+		},
+	); err != nil {
+		log.Fatal("Failed to register scheduled job", zap.Error(err))
+	}
+	if err := jobScheduler.Register(
+		"reconcile-payments",
+		time.Duration(
+			cfg.Scheduler.PaymentReconciliationInterval,
+		)*time.Second,
+		func(ctx context.Context) error {
+			_, err := reconcilePaymentsUseCase.Execute(ctx)
+			return err
+		},
+	); err != nil {
+		log.Fatal("Failed to register scheduled job", zap.Error(err))
+	}
+
 	version := Version
 
 	log.Info("Application version", zap.String("version", version))
@@ -284,6 +692,11 @@ func main() {
 	routerConfig := &router.FiberConfig{
 		AppEnv: cfg.Env,
 		CORS:   &cfg.CORS,
+		// Room for an image upload and the rest of its multipart form
+		BodyLimit: int(cfg.Products.MaxImageSize) + 1<<20,
+	}
+	if cfg.Storage.Driver == "local" || cfg.Storage.Driver == "" {
+		routerConfig.MediaDir = cfg.Storage.LocalDir
 	}
 	fiberRouter := router.NewFiberRouter(
 		log,
@@ -291,6 +704,7 @@ func main() {
 		productHTTPHandler,
 		orderHTTPHandler,
 		paymentHTTPHandler,
+		userHTTPHandler,
 		authHTTPHandler,
 		authMiddleware,
 		version,
@@ -322,6 +736,8 @@ func main() {
 		}
 	}()
 
+	jobScheduler.Start(context.Background())
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -333,11 +749,19 @@ func main() {
 	)
 	defer cancel()
 
+	// The scheduler is stopped even when the server does not shut down
+	// cleanly, so that running jobs are not cut off by the exit
 	if err := fiberRouter.Shutdown(ctx); err != nil {
-		log.Fatal(
+		log.Error(
 			"Server forced to shutdown", zap.Error(err),
 		)
 	}
 
+	if err := jobScheduler.Stop(ctx); err != nil {
+		log.Error(
+			"Scheduler forced to stop", zap.Error(err),
+		)
+	}
+
 	log.Info("Server shutdown completed successfully")
 }
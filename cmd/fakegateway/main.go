@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment/fakegateway"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// fakegateway serves a fake Zibal gateway for local development. Point
+// payment.zibal.base_url at it and enable only Zibal.
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	merchant := flag.String(
+		"merchant", "", "only accept this merchant ID (any if empty)",
+	)
+	flag.Parse()
+
+	log := logger.New(logger.EnvDevelopment, "info", uuid.New())
+
+	server := &http.Server{
+		Addr: *addr,
+		Handler: fakegateway.NewServer(fakegateway.Config{
+			Merchant: *merchant,
+		}, log),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Info("Fake Zibal gateway listening", zap.String("addr", *addr))
+		if err := server.ListenAndServe(); err != nil &&
+			!errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Fake gateway stopped", zap.Error(err))
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Failed to shut down fake gateway", zap.Error(err))
+	}
+}
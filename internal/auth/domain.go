@@ -15,6 +15,13 @@ const (
 	OTPStatusFailed   OTPStatus = "failed"
 )
 
+// Roles a user may hold, mirroring the role column of the users table
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
 type OTP struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Phone     string    `json:"phone" gorm:"not null"`
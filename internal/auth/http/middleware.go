@@ -1,6 +1,7 @@
 package http
 
 import (
+	"slices"
 	"strings"
 	"time"
 
@@ -158,6 +159,37 @@ func (m *AuthMiddleware) Authenticate() fiber.Handler {
 		c.Locals("user", user)
 		c.Locals("session", session)
 		c.Locals("userID", user.ID)
+		c.Locals("role", user.Role)
+
+		return c.Next()
+	}
+}
+
+// RequireRole admits only users holding one of roles. It must run after
+// Authenticate.
+func (m *AuthMiddleware) RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := GetUserIDFromContext(c)
+		if !ok {
+			return c.Status(
+				fiber.StatusUnauthorized,
+			).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		role, _ := GetRoleFromContext(c)
+		if !slices.Contains(roles, role) {
+			m.logger.Warn(
+				"Request rejected: insufficient role",
+				zap.String("method", c.Method()),
+				zap.String("path", c.Path()),
+				zap.String("userID", userID.String()),
+				zap.String("role", role),
+				zap.Strings("required", roles),
+			)
+			return c.Status(
+				fiber.StatusForbidden,
+			).JSON(fiber.Map{"error": "Insufficient permissions"})
+		}
 
 		return c.Next()
 	}
@@ -173,6 +205,11 @@ func GetUserIDFromContext(c *fiber.Ctx) (uuid.UUID, bool) {
 	return userID, ok
 }
 
+func GetRoleFromContext(c *fiber.Ctx) (string, bool) {
+	role, ok := c.Locals("role").(string)
+	return role, ok
+}
+
 func GetSessionFromContext(c *fiber.Ctx) (*auth.Session, bool) {
 	session, ok := c.Locals("session").(*auth.Session)
 	return session, ok
@@ -13,6 +13,7 @@ type User struct {
 	Phone     string    `json:"phone"`
 	Email     *string   `json:"email,omitempty"`
 	Verified  int       `json:"verified"`
+	Role      string    `json:"role"`
 	LastLogin *string   `json:"last_login,omitempty"`
 }
 
@@ -21,12 +22,16 @@ type Address struct {
 	UserID     uuid.UUID `json:"user_id"`
 	Address    string    `json:"address"`
 	PostalCode string    `json:"postal_code"`
+	IsDefault  bool      `json:"is_default"`
 }
 
 type UserPort interface {
 	FindUserByPhone(ctx context.Context, phone string) (*User, error)
 	CreateUser(ctx context.Context, user *User) error
 	UpdateUserLastLogin(ctx context.Context, userID uuid.UUID) error
+	// MarkPhoneVerified records that the user proved ownership of their
+	// phone and returns their resulting verification level
+	MarkPhoneVerified(ctx context.Context, userID uuid.UUID) (int, error)
 	CreateAddress(ctx context.Context, address *Address) error
 	GetUserAddresses(ctx context.Context, userID uuid.UUID) ([]Address, error)
 }
@@ -81,6 +81,15 @@ func (uc *verifyOTPUseCase) Execute(
 		return nil, fmt.Errorf("failed to get or create user: %w", err)
 	}
 
+	// A correct OTP proves the user owns the phone number.
+	if user.Verified, err = uc.userPort.MarkPhoneVerified(
+		ctx, user.ID,
+	); err != nil {
+		return nil, fmt.Errorf(
+			"failed to mark phone as verified: %w", err,
+		)
+	}
+
 	session, err := uc.createSession(ctx, user.ID)
 	if err != nil {
 		uc.logger.Error("Failed to create session", zap.Error(err))
@@ -2,23 +2,36 @@ package adapter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"dunhayat-api/internal/orders"
+	orderPort "dunhayat-api/internal/orders/port"
 	"dunhayat-api/internal/orders/repository"
 	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/pkg/database"
 
 	"github.com/google/uuid"
 )
 
 type PaymentsOrderAdapter struct {
-	saleRepo repository.SaleRepository
+	transactor          database.Transactor
+	saleRepo            repository.SaleRepository
+	cartReservationRepo repository.CartReservationRepository
+	productPort         orderPort.ProductPort
 }
 
 func NewPaymentsOrderAdapter(
+	transactor database.Transactor,
 	saleRepo repository.SaleRepository,
+	cartReservationRepo repository.CartReservationRepository,
+	productPort orderPort.ProductPort,
 ) port.OrderPort {
 	return &PaymentsOrderAdapter{
-		saleRepo: saleRepo,
+		transactor:          transactor,
+		saleRepo:            saleRepo,
+		cartReservationRepo: cartReservationRepo,
+		productPort:         productPort,
 	}
 }
 
@@ -30,31 +43,23 @@ func (s *PaymentsOrderAdapter) GetSaleByID(
 	if err != nil {
 		return nil, err
 	}
-	if sale == nil {
-		return nil, nil
-	}
-
-	return &port.Sale{
-		ID:           sale.ID,
-		UserID:       sale.UserID,
-		Status:       port.OrderStatus(sale.Status),
-		TrackingCode: sale.TrackingCode,
-		TotalPrice:   sale.TotalPrice,
-		CreatedAt:    sale.CreatedAt,
-		UpdatedAt:    sale.UpdatedAt,
-	}, nil
+	return toPortSale(sale), nil
 }
 
-func (s *PaymentsOrderAdapter) GetSaleByTrackingCode(
+func (s *PaymentsOrderAdapter) LockSale(
 	ctx context.Context,
-	trackingCode string,
+	saleID uuid.UUID,
 ) (*port.Sale, error) {
-	sale, err := s.saleRepo.GetByTrackingCode(ctx, trackingCode)
+	sale, err := s.saleRepo.GetByIDForUpdate(ctx, saleID)
 	if err != nil {
 		return nil, err
 	}
+	return toPortSale(sale), nil
+}
+
+func toPortSale(sale *orders.Sale) *port.Sale {
 	if sale == nil {
-		return nil, nil
+		return nil
 	}
 
 	return &port.Sale{
@@ -65,21 +70,105 @@ func (s *PaymentsOrderAdapter) GetSaleByTrackingCode(
 		TotalPrice:   sale.TotalPrice,
 		CreatedAt:    sale.CreatedAt,
 		UpdatedAt:    sale.UpdatedAt,
-	}, nil
+	}
 }
 
 func (s *PaymentsOrderAdapter) UpdateSaleStatus(
 	ctx context.Context,
 	saleID uuid.UUID,
 	status port.OrderStatus,
+	reason string,
 ) error {
-	return s.saleRepo.UpdateStatus(ctx, saleID, orders.OrderStatus(status))
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.saleRepo.UpdateStatus(
+			ctx,
+			saleID,
+			orders.OrderStatus(status),
+			orders.StatusChange{
+				Actor:  orders.ActorPayments,
+				Reason: reason,
+			},
+		); err != nil {
+			return err
+		}
+
+		// A paid sale keeps its stock for good, so its reservations must
+		// not be released once they expire.
+		if status == port.OrderStatusPaid {
+			return s.cartReservationRepo.DeleteBySaleID(ctx, saleID)
+		}
+		return nil
+	})
+	if errors.Is(err, orders.ErrInvalidTransition) {
+		return fmt.Errorf("%w: %v", port.ErrInvalidStatusTransition, err)
+	}
+	return err
 }
 
-func (s *PaymentsOrderAdapter) SetSaleTrackingCode(
+func (s *PaymentsOrderAdapter) ReleaseSale(
 	ctx context.Context,
 	saleID uuid.UUID,
-	trackingCode string,
-) error {
-	return s.saleRepo.SetTrackingCode(ctx, saleID, trackingCode)
+	reason string,
+) (bool, error) {
+	var released bool
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := s.saleRepo.GetByIDForUpdate(ctx, saleID)
+		if err != nil {
+			return fmt.Errorf("failed to get sale: %w", err)
+		}
+		if sale == nil || (sale.Status != orders.OrderStatusPending &&
+			sale.Status != orders.OrderStatusFailed) {
+			return nil
+		}
+
+		reservations, err := s.cartReservationRepo.GetBySaleID(ctx, saleID)
+		if err != nil {
+			return fmt.Errorf("failed to get cart reservations: %w", err)
+		}
+
+		for _, reservation := range reservations {
+			if err := s.productPort.UpdateStock(
+				ctx,
+				reservation.VariantID,
+				reservation.Quantity,
+				orderPort.StockChange{
+					Reason: orderPort.StockReasonOrderCancellation,
+					SaleID: saleID,
+					Actor:  orders.ActorPayments,
+				},
+			); err != nil {
+				return fmt.Errorf(
+					"failed to restore stock for variant %s: %w",
+					reservation.VariantID, err,
+				)
+			}
+		}
+
+		if err := s.cartReservationRepo.DeleteBySaleID(
+			ctx, saleID,
+		); err != nil {
+			return fmt.Errorf("failed to delete cart reservations: %w", err)
+		}
+
+		if err := s.saleRepo.UpdateStatus(
+			ctx,
+			saleID,
+			orders.OrderStatusCancelled,
+			orders.StatusChange{
+				Actor:  orders.ActorPayments,
+				Reason: reason,
+			},
+		); err != nil {
+			return fmt.Errorf("failed to update sale status: %w", err)
+		}
+
+		released = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return released, nil
 }
@@ -1,6 +1,8 @@
 package orders
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,12 +17,123 @@ const (
 	OrderStatusCancelled OrderStatus = "cancelled"
 	OrderStatusShipped   OrderStatus = "shipped"
 	OrderStatusDelivered OrderStatus = "delivered"
+	// OrderStatusRefunded marks an order whose payment was refunded in
+	// full after it was paid
+	OrderStatusRefunded OrderStatus = "refunded"
 )
 
 func (s OrderStatus) String() string {
 	return string(s)
 }
 
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderStatusPending,
+		OrderStatusPaid,
+		OrderStatusFailed,
+		OrderStatusCancelled,
+		OrderStatusShipped,
+		OrderStatusDelivered,
+		OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// orderTransitions lists, for every status, the statuses a sale may move to
+// next. Statuses missing from the map are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending: {
+		OrderStatusPaid,
+		OrderStatusFailed,
+		OrderStatusCancelled,
+	},
+	OrderStatusFailed: {
+		OrderStatusPending,
+		OrderStatusCancelled,
+	},
+	OrderStatusPaid: {
+		OrderStatusShipped,
+		OrderStatusCancelled,
+		OrderStatusRefunded,
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered,
+		OrderStatusRefunded,
+	},
+	OrderStatusDelivered: {
+		OrderStatusRefunded,
+	},
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrOrderNotFound = errors.New("order not found")
+
+var ErrShippingAddressRequired = errors.New(
+	"shipping address and postal code are required",
+)
+
+var ErrAddressNotFound = errors.New("address not found")
+
+var ErrInvalidReturnURL = errors.New("return URL is not allowed")
+
+var ErrOrderNotCancellable = errors.New("order cannot be cancelled")
+
+var ErrOrderNotRefundable = errors.New("order cannot be refunded")
+
+var ErrRefundReasonRequired = errors.New("refund reason is required")
+
+var ErrInvalidRestock = errors.New("invalid restock")
+
+var ErrStatusNotSettable = errors.New(
+	"status can only be set to shipped or delivered",
+)
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+type TransitionError struct {
+	From OrderStatus
+	To   OrderStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf(
+		"%s: cannot move order from %s to %s",
+		ErrInvalidTransition, e.From, e.To,
+	)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+const (
+	ActorSystem   = "system"
+	ActorPayments = "payments"
+)
+
+func CustomerActor(userID uuid.UUID) string {
+	return "customer:" + userID.String()
+}
+
+func StaffActor(userID uuid.UUID) string {
+	return "staff:" + userID.String()
+}
+
+// StatusChange describes who moved a sale to a new status and why.
+type StatusChange struct {
+	Actor  string
+	Reason string
+}
+
 type Sale struct {
 	ID           uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	UserID       uuid.UUID   `json:"user_id" gorm:"type:uuid;not null"`
@@ -31,49 +144,197 @@ type Sale struct {
 	UpdatedAt    time.Time   `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
+type SaleStatusHistory struct {
+	ID         uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SaleID     uuid.UUID   `json:"sale_id" gorm:"type:uuid;not null"`
+	FromStatus OrderStatus `json:"from_status" gorm:"type:varchar(50);not null"`
+	ToStatus   OrderStatus `json:"to_status" gorm:"type:varchar(50);not null"`
+	Actor      string      `json:"actor" gorm:"type:varchar(100);not null"`
+	Reason     string      `json:"reason" gorm:"type:text"`
+	CreatedAt  time.Time   `json:"created_at" gorm:"autoCreateTime"`
+}
+
 type SaleItem struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	SaleID    uuid.UUID `json:"sale_id" gorm:"type:uuid;not null"`
 	ProductID string    `json:"product_id" gorm:"type:varchar(100);not null"`
+	VariantID uuid.UUID `json:"variant_id" gorm:"type:uuid;not null"`
 	Quantity  int       `json:"quantity" gorm:"not null;check:quantity > 0"`
 	Price     int       `json:"price" gorm:"not null;check:price > 0"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	// RestockedQuantity is how much of the item refunds put back into
+	// stock, so that a later refund or cancellation does not restock it
+	// again
+	RestockedQuantity int       `json:"restocked_quantity" gorm:"not null;default:0"`
+	CreatedAt         time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// SaleShipping is the destination of a sale as it was when the order was
+// placed; later edits to the user's saved address do not change it.
+type SaleShipping struct {
+	ID            uuid.UUID  `json:"-" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SaleID        uuid.UUID  `json:"-" gorm:"type:uuid;not null;uniqueIndex"`
+	AddressID     *uuid.UUID `json:"address_id,omitempty" gorm:"type:uuid"`
+	RecipientName string     `json:"recipient_name" gorm:"type:varchar(200);not null"`
+	Phone         string     `json:"phone" gorm:"type:varchar(20);not null"`
+	Address       string     `json:"address" gorm:"type:text;not null"`
+	PostalCode    string     `json:"postal_code" gorm:"type:varchar(20);not null"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime"`
 }
 
 type CartReservation struct {
-	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
-	ProductID string    `json:"product_id" gorm:"type:varchar(100);not null"`
-	Quantity  int       `json:"quantity" gorm:"not null;check:quantity > 0"`
-	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
+	SaleID    *uuid.UUID `json:"sale_id,omitempty" gorm:"type:uuid"`
+	ProductID string     `json:"product_id" gorm:"type:varchar(100);not null"`
+	VariantID uuid.UUID  `json:"variant_id" gorm:"type:uuid;not null"`
+	Quantity  int        `json:"quantity" gorm:"not null;check:quantity > 0"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
 }
 
 type CreateOrderRequest struct {
-	Items       []OrderItemRequest `json:"items" binding:"required,min=1"`
-	Address     string             `json:"address" binding:"required"`
-	PostalCode  string             `json:"postal_code" binding:"required"`
-	CallbackURL string             `json:"callback_url" binding:"required"`
-	ReturnURL   string             `json:"return_url" binding:"required"`
+	Items []OrderItemRequest `json:"items" binding:"required,min=1"`
+	// AddressID picks a saved address of the user instead of Address and
+	// PostalCode
+	AddressID  *uuid.UUID `json:"address_id,omitempty"`
+	Address    string     `json:"address"`
+	PostalCode string     `json:"postal_code"`
+	// RecipientName and Phone default to the name and phone of the user
+	RecipientName string `json:"recipient_name,omitempty"`
+	Phone         string `json:"phone,omitempty"`
+	ReturnURL     string `json:"return_url" binding:"required"`
+	// PaymentMethod picks the gateway, such as "zibal" or "zarinpal"; the
+	// default gateway is used without it
+	PaymentMethod string `json:"payment_method,omitempty"`
 }
 
 type OrderItemRequest struct {
 	ProductID string `json:"product_id" binding:"required"`
-	Quantity  int    `json:"quantity" binding:"required,min=1"`
+	// VariantID picks a bag size and grind; the product's default variant
+	// is used without it
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Quantity  int        `json:"quantity" binding:"required,min=1"`
 }
 
 type OrderResponse struct {
-	ID           uuid.UUID    `json:"id"`
-	UserID       uuid.UUID    `json:"user_id"`
-	Status       OrderStatus  `json:"status"`
-	TrackingCode *string      `json:"tracking_code,omitempty"`
-	TotalPrice   int          `json:"total_price"`
-	Items        []SaleItem   `json:"items"`
-	Address      string       `json:"address"`
-	PostalCode   string       `json:"postal_code"`
-	Payment      *PaymentInfo `json:"payment,omitempty"`
-	CreatedAt    time.Time    `json:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
+	ID           uuid.UUID     `json:"id"`
+	UserID       uuid.UUID     `json:"user_id"`
+	Status       OrderStatus   `json:"status"`
+	TrackingCode *string       `json:"tracking_code,omitempty"`
+	TotalPrice   int           `json:"total_price"`
+	Items        []SaleItem    `json:"items"`
+	Address      string        `json:"address"`
+	PostalCode   string        `json:"postal_code"`
+	Shipping     *SaleShipping `json:"shipping,omitempty"`
+	Payment      *PaymentInfo  `json:"payment,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// SaleFilter selects a page of a user's sales ordered by creation time.
+// After, when set, is the position of the last sale of the previous page.
+type SaleFilter struct {
+	UserID    uuid.UUID
+	Status    *OrderStatus
+	From      *time.Time
+	To        *time.Time
+	Ascending bool
+	After     *SaleCursor
+	Limit     int
+}
+
+type SaleCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type ListOrdersRequest struct {
+	Status    *OrderStatus
+	From      *time.Time
+	To        *time.Time
+	Ascending bool
+	Cursor    string
+	Limit     int
+}
+
+type ListOrdersResponse struct {
+	Orders     []OrderSummary `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type OrderSummary struct {
+	ID           uuid.UUID          `json:"id"`
+	Status       OrderStatus        `json:"status"`
+	TrackingCode *string            `json:"tracking_code,omitempty"`
+	TotalPrice   int                `json:"total_price"`
+	Items        []OrderItemSummary `json:"items"`
+	Shipping     *SaleShipping      `json:"shipping,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+}
+
+type OrderItemSummary struct {
+	ProductID string    `json:"product_id"`
+	VariantID uuid.UUID `json:"variant_id"`
+	Quantity  int       `json:"quantity"`
+	Price     int       `json:"price"`
+}
+
+type CancelOrderResponse struct {
+	ID     uuid.UUID   `json:"id"`
+	Status OrderStatus `json:"status"`
+	Refund *RefundInfo `json:"refund,omitempty"`
+}
+
+// UpdateOrderStatusRequest is a back-office status change. Only the
+// fulfilment statuses can be set by hand; payment and cancellation have
+// their own flows.
+type UpdateOrderStatusRequest struct {
+	Status OrderStatus `json:"status"`
+	Reason string      `json:"reason"`
+}
+
+type UpdateOrderStatusResponse struct {
+	ID     uuid.UUID   `json:"id"`
+	Status OrderStatus `json:"status"`
+}
+
+// RefundOrderRequest is a back-office refund of part or all of what was
+// paid for an order. Restock lists the items that go back into stock.
+type RefundOrderRequest struct {
+	// Amount is in Rials; without it whatever is left of the payment is
+	// refunded
+	Amount  int           `json:"amount,omitempty"`
+	Reason  string        `json:"reason"`
+	Restock []RestockItem `json:"restock,omitempty"`
+}
+
+type RestockItem struct {
+	VariantID uuid.UUID `json:"variant_id"`
+	Quantity  int       `json:"quantity"`
+}
+
+type RefundOrderResponse struct {
+	ID     uuid.UUID   `json:"id"`
+	Status OrderStatus `json:"status"`
+	Refund RefundInfo  `json:"refund"`
+	// Refundable is what is left of the payment after the refund
+	Refundable int           `json:"refundable"`
+	Restocked  []RestockItem `json:"restocked,omitempty"`
+}
+
+type RefundInfo struct {
+	ID        uuid.UUID `json:"id"`
+	PaymentID uuid.UUID `json:"payment_id"`
+	Amount    int       `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	// Channel is "gateway" or "manual_bank_transfer"; manual refunds stay
+	// pending until staff record the bank transfer
+	Channel   string    `json:"channel"`
+	Status    string    `json:"status"`
+	Reference *string   `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type PaymentInfo struct {
@@ -89,10 +350,18 @@ func (Sale) TableName() string {
 	return "sales"
 }
 
+func (SaleStatusHistory) TableName() string {
+	return "sale_status_history"
+}
+
 func (SaleItem) TableName() string {
 	return "sale_items"
 }
 
+func (SaleShipping) TableName() string {
+	return "sale_shipping_addresses"
+}
+
 func (CartReservation) TableName() string {
 	return "cart_reservations"
 }
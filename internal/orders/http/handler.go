@@ -1,22 +1,46 @@
 package http
 
 import (
+	"errors"
+	"time"
+
 	"dunhayat-api/internal/auth/http"
 	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
 	"dunhayat-api/internal/orders/usecase"
+	"dunhayat-api/pkg/pagination"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type OrderHandler struct {
-	createOrderUseCase usecase.CreateOrderUseCase
+	createOrderUseCase  usecase.CreateOrderUseCase
+	getOrderUseCase     usecase.GetOrderUseCase
+	listOrdersUseCase   usecase.ListOrdersUseCase
+	cancelOrderUseCase  usecase.CancelOrderUseCase
+	updateStatusUseCase usecase.UpdateOrderStatusUseCase
+	refundOrderUseCase  usecase.RefundOrderUseCase
+	listRefundsUseCase  usecase.ListOrderRefundsUseCase
 }
 
 func NewOrderHandler(
 	createOrderUseCase usecase.CreateOrderUseCase,
+	getOrderUseCase usecase.GetOrderUseCase,
+	listOrdersUseCase usecase.ListOrdersUseCase,
+	cancelOrderUseCase usecase.CancelOrderUseCase,
+	updateStatusUseCase usecase.UpdateOrderStatusUseCase,
+	refundOrderUseCase usecase.RefundOrderUseCase,
+	listRefundsUseCase usecase.ListOrderRefundsUseCase,
 ) *OrderHandler {
 	return &OrderHandler{
-		createOrderUseCase: createOrderUseCase,
+		createOrderUseCase:  createOrderUseCase,
+		getOrderUseCase:     getOrderUseCase,
+		listOrdersUseCase:   listOrdersUseCase,
+		cancelOrderUseCase:  cancelOrderUseCase,
+		updateStatusUseCase: updateStatusUseCase,
+		refundOrderUseCase:  refundOrderUseCase,
+		listRefundsUseCase:  listRefundsUseCase,
 	}
 }
 
@@ -38,6 +62,29 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 
 	order, err := h.createOrderUseCase.Execute(c.Context(), userID, &req)
 	if err != nil {
+		var stockErr *port.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":      err.Error(),
+				"product_id": stockErr.ProductID,
+				"variant_id": stockErr.VariantID,
+				"requested":  stockErr.Requested,
+				"available":  stockErr.Available,
+			})
+		}
+		if errors.Is(err, orders.ErrShippingAddressRequired) ||
+			errors.Is(err, orders.ErrAddressNotFound) ||
+			errors.Is(err, orders.ErrInvalidReturnURL) ||
+			errors.Is(err, port.ErrUnsupportedPaymentMethod) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, orders.ErrInvalidTransition) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(
 			fiber.Map{
 				"error": err.Error(),
@@ -53,37 +100,322 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(response)
 }
 
+func (h *OrderHandler) ListOrders(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	req := orders.ListOrdersRequest{
+		Cursor: c.Query("cursor"),
+		Limit:  c.QueryInt("limit"),
+	}
+
+	if status := c.Query("status"); status != "" {
+		orderStatus := orders.OrderStatus(status)
+		if !orderStatus.IsValid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid status: " + status,
+			})
+		}
+		req.Status = &orderStatus
+	}
+
+	switch c.Query("sort", "desc") {
+	case "desc":
+	case "asc":
+		req.Ascending = true
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid sort: must be asc or desc",
+		})
+	}
+
+	var err error
+	if req.From, err = parseDateQuery(c.Query("from"), false); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid from date: " + err.Error(),
+		})
+	}
+	if req.To, err = parseDateQuery(c.Query("to"), true); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid to date: " + err.Error(),
+		})
+	}
+
+	result, err := h.listOrdersUseCase.Execute(c.Context(), userID, &req)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	response := fiber.Map{
+		"data":        result.Orders,
+		"count":       len(result.Orders),
+		"next_cursor": result.NextCursor,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// parseDateQuery accepts either an RFC 3339 timestamp or a plain date. A
+// plain date used as an upper bound covers the whole day.
+func parseDateQuery(value string, upper bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, errors.New("expected YYYY-MM-DD or RFC 3339")
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
 func (h *OrderHandler) GetOrder(c *fiber.Ctx) error {
-	orderID := c.Params("id")
-	if orderID == "" {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Order ID is required",
+			"error": "Invalid order ID",
 		})
 	}
 
-	// TODO: Implement GetOrderUseCase
+	order, err := h.getOrderUseCase.Execute(c.Context(), userID, orderID)
+	if err != nil {
+		if errors.Is(err, orders.ErrOrderNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
 
 	response := fiber.Map{
-		"message":  "Get order endpoint - to be implemented",
-		"order_id": orderID,
+		"message": "Order retrieved successfully",
+		"data":    order,
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
 func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
-	orderID := c.Params("id")
-	if orderID == "" {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	result, err := h.cancelOrderUseCase.Execute(
+		c.Context(), userID, orderID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, orders.ErrOrderNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, orders.ErrOrderNotCancellable),
+			errors.Is(err, orders.ErrInvalidTransition):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(
+				fiber.Map{
+					"error": err.Error(),
+				},
+			)
+		}
+	}
+
+	response := fiber.Map{
+		"message": "Order cancelled successfully",
+		"data":    result,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
+	staffID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	var req orders.UpdateOrderStatusRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	result, err := h.updateStatusUseCase.Execute(
+		c.Context(), staffID, orderID, &req,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, orders.ErrOrderNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, orders.ErrStatusNotSettable):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, orders.ErrInvalidTransition):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(
+				fiber.Map{
+					"error": err.Error(),
+				},
+			)
+		}
+	}
+
+	response := fiber.Map{
+		"message": "Order status updated successfully",
+		"data":    result,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *OrderHandler) RefundOrder(c *fiber.Ctx) error {
+	staffID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	var req orders.RefundOrderRequest
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Order ID is required",
+			"error": "Invalid request body: " + err.Error(),
 		})
 	}
 
-	// TODO: Implement CancelOrderUseCase
+	result, err := h.refundOrderUseCase.Execute(
+		c.Context(), staffID, orderID, &req,
+	)
+	if err != nil {
+		var stockErr *port.InsufficientStockError
+		switch {
+		case errors.Is(err, orders.ErrOrderNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, orders.ErrRefundReasonRequired),
+			errors.Is(err, orders.ErrInvalidRestock),
+			errors.Is(err, port.ErrInvalidRefundAmount):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, orders.ErrOrderNotRefundable),
+			errors.Is(err, orders.ErrInvalidTransition),
+			errors.Is(err, port.ErrNothingToRefund),
+			errors.Is(err, port.ErrRefundExceedsPayment),
+			errors.As(err, &stockErr):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(
+				fiber.Map{
+					"error": err.Error(),
+				},
+			)
+		}
+	}
+
+	response := fiber.Map{
+		"message": "Order refunded successfully",
+		"data":    result,
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *OrderHandler) ListRefunds(c *fiber.Ctx) error {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	refunds, err := h.listRefundsUseCase.Execute(c.Context(), orderID)
+	if err != nil {
+		if errors.Is(err, orders.ErrOrderNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
 
 	response := fiber.Map{
-		"message":  "Cancel order endpoint - to be implemented",
-		"order_id": orderID,
+		"data":  refunds,
+		"count": len(refunds),
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
@@ -2,6 +2,7 @@ package port
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,14 +10,79 @@ import (
 
 type PaymentPort interface {
 	InitiatePayment(ctx context.Context, req *InitiatePaymentRequest) (*InitiatePaymentResponse, error)
-	VerifyPayment(ctx context.Context, paymentID uuid.UUID) (*VerifyPaymentResponse, error)
+	// VerifyPayment settles a payment of the user with its gateway
+	VerifyPayment(ctx context.Context, userID uuid.UUID, paymentID uuid.UUID) (*VerifyPaymentResponse, error)
+	// GetLatestPayment returns the most recent payment attempt of an order,
+	// or nil if none was made
+	GetLatestPayment(ctx context.Context, orderID uuid.UUID) (*Payment, error)
+	CancelPendingPayments(ctx context.Context, orderID uuid.UUID) error
+	// RefundPayment returns part or all of the paid amount of an order,
+	// through the gateway or by a manual bank transfer. It reaches the
+	// gateway, so it must not be called inside a transaction.
+	RefundPayment(ctx context.Context, req *RefundPaymentRequest) (*RefundPaymentResponse, error)
+	ListRefunds(ctx context.Context, orderID uuid.UUID) ([]Refund, error)
 }
 
+// RefundPaymentRequest refunds Amount of the paid payment of an order, or
+// whatever is left of it without an Amount.
+type RefundPaymentRequest struct {
+	OrderID uuid.UUID
+	Amount  int
+	Reason  string
+	Actor   string
+}
+
+type RefundPaymentResponse struct {
+	Refund Refund
+	// Refundable is what is left of the payment after the refund
+	Refundable int
+}
+
+type Refund struct {
+	ID        uuid.UUID `json:"id"`
+	PaymentID uuid.UUID `json:"payment_id"`
+	Amount    int       `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	Channel   string    `json:"channel"`
+	Status    string    `json:"status"`
+	Reference *string   `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PaymentStatusPending is the Status of a payment the customer may still
+// complete at its gateway, until it expires
+const PaymentStatusPending = "pending"
+
+type Payment struct {
+	ID           uuid.UUID `json:"id"`
+	GatewayURL   string    `json:"gateway_url"`
+	GatewayRefID string    `json:"gateway_ref_id"`
+	Status       string    `json:"status"`
+	Amount       int       `json:"amount"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ErrUnsupportedPaymentMethod is returned for a payment method that is not
+// an enabled gateway.
+var ErrUnsupportedPaymentMethod = errors.New("unsupported payment method")
+
+var (
+	// ErrNothingToRefund is returned when an order has no paid payment or
+	// it was already refunded in full
+	ErrNothingToRefund = errors.New("nothing left to refund")
+	// ErrRefundExceedsPayment is returned for a refund larger than what is
+	// left of the payment
+	ErrRefundExceedsPayment = errors.New("refund exceeds the refundable amount")
+	ErrInvalidRefundAmount  = errors.New("refund amount must be positive")
+)
+
 type InitiatePaymentRequest struct {
-	OrderID     uuid.UUID              `json:"order_id"`
-	UserID      uuid.UUID              `json:"user_id"`
-	Amount      int                    `json:"amount"`
-	CallbackURL string                 `json:"callback_url"`
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Amount  int       `json:"amount"`
+	// Method is the gateway to pay with, or empty for the default one
+	Method      string                 `json:"method,omitempty"`
 	ReturnURL   string                 `json:"return_url"`
 	Description string                 `json:"description"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
@@ -1,15 +1,72 @@
 package port
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
 
-type Product struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Price   int    `json:"price"`
-	InStock int    `json:"in_stock"`
+	"github.com/google/uuid"
+)
+
+// Variant is a sellable variant of a product
+type Variant struct {
+	ID        uuid.UUID `json:"id"`
+	ProductID string    `json:"product_id"`
+	SKU       string    `json:"sku"`
+	Price     int       `json:"price"`
+	InStock   int       `json:"in_stock"`
+}
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+type InsufficientStockError struct {
+	ProductID string
+	VariantID uuid.UUID
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf(
+		"insufficient stock for product %s variant %s: requested %d, available %d",
+		e.ProductID, e.VariantID, e.Requested, e.Available,
+	)
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// Reasons an order moves stock, as recorded in the inventory ledger
+const (
+	StockReasonOrderReservation  = "order_reservation"
+	StockReasonReservationExpiry = "reservation_expiry"
+	StockReasonOrderCancellation = "order_cancellation"
+	StockReasonOrderRefund       = "order_refund"
+)
+
+// StockChange tells the products slice why stock moved and on whose behalf
+type StockChange struct {
+	Reason string
+	SaleID uuid.UUID
+	Actor  string
 }
 
 type ProductPort interface {
-	GetProductByID(ctx context.Context, productID string) (*Product, error)
-	UpdateStock(ctx context.Context, productID string, quantity int) error
+	// GetVariant returns a variant that is for sale, or nil. Without a
+	// variantID it returns the default variant of the product; with one,
+	// productID may be empty.
+	GetVariant(
+		ctx context.Context,
+		productID string,
+		variantID *uuid.UUID,
+	) (*Variant, error)
+	// UpdateStock returns an *InsufficientStockError when a negative
+	// quantity exceeds the stock left
+	UpdateStock(
+		ctx context.Context,
+		variantID uuid.UUID,
+		quantity int,
+		change StockChange,
+	) error
 }
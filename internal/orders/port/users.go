@@ -14,6 +14,14 @@ type User struct {
 	Email     *string   `json:"email,omitempty"`
 }
 
+type Address struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Address    string    `json:"address"`
+	PostalCode string    `json:"postal_code"`
+}
+
 type UserPort interface {
 	GetUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
+	GetAddressByID(ctx context.Context, addressID uuid.UUID) (*Address, error)
 }
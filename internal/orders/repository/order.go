@@ -6,18 +6,30 @@ import (
 	"time"
 
 	"dunhayat-api/internal/orders"
+	"dunhayat-api/pkg/database"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SaleRepository interface {
 	Create(ctx context.Context, sale *orders.Sale) error
 	GetByID(ctx context.Context, id uuid.UUID) (*orders.Sale, error)
+	// GetByIDForUpdate loads a sale and locks its row until the surrounding
+	// transaction ends
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*orders.Sale, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]orders.Sale, error)
+	// List returns a page of sales matching the filter using keyset
+	// pagination on (created_at, id)
+	List(ctx context.Context, filter orders.SaleFilter) ([]orders.Sale, error)
+	// Update saves a sale without touching its status; status changes must
+	// go through UpdateStatus
 	Update(ctx context.Context, sale *orders.Sale) error
-	// UpdateStatus updates only the status field of a sale
-	UpdateStatus(ctx context.Context, id uuid.UUID, status orders.OrderStatus) error
+	// UpdateStatus moves a sale to a new status if the transition is allowed
+	// and records it in the sale status history
+	UpdateStatus(ctx context.Context, id uuid.UUID, status orders.OrderStatus, change orders.StatusChange) error
+	GetStatusHistory(ctx context.Context, id uuid.UUID) ([]orders.SaleStatusHistory, error)
 	// SetTrackingCode sets the gateway tracking code (e.g., Zibal trackId) on the sale
 	SetTrackingCode(ctx context.Context, id uuid.UUID, trackingCode string) error
 	// GetByTrackingCode finds a sale by its tracking code
@@ -28,13 +40,30 @@ type SaleRepository interface {
 type SaleItemRepository interface {
 	Create(ctx context.Context, item *orders.SaleItem) error
 	GetBySaleID(ctx context.Context, saleID uuid.UUID) ([]orders.SaleItem, error)
+	GetBySaleIDs(ctx context.Context, saleIDs []uuid.UUID) ([]orders.SaleItem, error)
+	// AddRestocked records quantity more of an item as back in stock
+	AddRestocked(ctx context.Context, id uuid.UUID, quantity int) error
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
+type SaleShippingRepository interface {
+	Create(ctx context.Context, shipping *orders.SaleShipping) error
+	GetBySaleID(ctx context.Context, saleID uuid.UUID) (*orders.SaleShipping, error)
+	GetBySaleIDs(ctx context.Context, saleIDs []uuid.UUID) ([]orders.SaleShipping, error)
+}
+
 type CartReservationRepository interface {
 	Create(ctx context.Context, reservation *orders.CartReservation) error
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]orders.CartReservation, error)
+	GetBySaleID(ctx context.Context, saleID uuid.UUID) ([]orders.CartReservation, error)
+	// GetExpiredSaleIDs returns up to limit sales that hold at least one
+	// reservation expired before the given time
+	GetExpiredSaleIDs(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error)
+	// ExtendBySaleID moves the expiry of the reservations of a sale to
+	// expiresAt, unless they already last longer
+	ExtendBySaleID(ctx context.Context, saleID uuid.UUID, expiresAt time.Time) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	DeleteBySaleID(ctx context.Context, saleID uuid.UUID) error
 	CleanExpired(ctx context.Context) error
 }
 
@@ -46,6 +75,10 @@ type postgresSaleItemRepository struct {
 	db *gorm.DB
 }
 
+type postgresSaleShippingRepository struct {
+	db *gorm.DB
+}
+
 type postgresCartReservationRepository struct {
 	db *gorm.DB
 }
@@ -58,6 +91,10 @@ func NewSaleItemRepository(db *gorm.DB) SaleItemRepository {
 	return &postgresSaleItemRepository{db: db}
 }
 
+func NewSaleShippingRepository(db *gorm.DB) SaleShippingRepository {
+	return &postgresSaleShippingRepository{db: db}
+}
+
 func NewCartReservationRepository(db *gorm.DB) CartReservationRepository {
 	return &postgresCartReservationRepository{db: db}
 }
@@ -66,7 +103,7 @@ func (r *postgresSaleRepository) Create(
 	ctx context.Context,
 	sale *orders.Sale,
 ) error {
-	return r.db.WithContext(ctx).Create(sale).Error
+	return database.Conn(ctx, r.db).Create(sale).Error
 }
 
 func (r *postgresSaleRepository) GetByID(
@@ -74,7 +111,25 @@ func (r *postgresSaleRepository) GetByID(
 	id uuid.UUID,
 ) (*orders.Sale, error) {
 	var sale orders.Sale
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&sale).Error
+	err := database.Conn(ctx, r.db).Where("id = ?", id).First(&sale).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &sale, nil
+}
+
+func (r *postgresSaleRepository) GetByIDForUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+) (*orders.Sale, error) {
+	var sale orders.Sale
+	err := database.Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		First(&sale).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -89,7 +144,7 @@ func (r *postgresSaleRepository) GetByUserID(
 	userID uuid.UUID,
 ) ([]orders.Sale, error) {
 	var sales []orders.Sale
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"user_id = ?",
 		userID,
 	).Find(&sales).Error
@@ -99,22 +154,105 @@ func (r *postgresSaleRepository) GetByUserID(
 	return sales, nil
 }
 
+func (r *postgresSaleRepository) List(
+	ctx context.Context,
+	filter orders.SaleFilter,
+) ([]orders.Sale, error) {
+	query := database.Conn(ctx, r.db).Where("user_id = ?", filter.UserID)
+
+	if filter.Status != nil {
+		query = query.Where("status = ?", *filter.Status)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	direction := "DESC"
+	comparison := "<"
+	if filter.Ascending {
+		direction = "ASC"
+		comparison = ">"
+	}
+
+	if filter.After != nil {
+		query = query.Where(
+			"(created_at, id) "+comparison+" (?, ?)",
+			filter.After.CreatedAt, filter.After.ID,
+		)
+	}
+
+	var sales []orders.Sale
+	err := query.
+		Order("created_at " + direction).
+		Order("id " + direction).
+		Limit(filter.Limit).
+		Find(&sales).Error
+	if err != nil {
+		return nil, err
+	}
+	return sales, nil
+}
+
 func (r *postgresSaleRepository) Update(
 	ctx context.Context,
 	sale *orders.Sale,
 ) error {
-	return r.db.WithContext(ctx).Save(sale).Error
+	return database.Conn(ctx, r.db).Omit("status").Save(sale).Error
 }
 
 func (r *postgresSaleRepository) UpdateStatus(
 	ctx context.Context,
 	id uuid.UUID,
 	status orders.OrderStatus,
+	change orders.StatusChange,
 ) error {
-	return r.db.WithContext(ctx).
-		Model(&orders.Sale{}).
-		Where("id = ?", id).
-		Update("status", status).Error
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var sale orders.Sale
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			First(&sale).Error
+		if err != nil {
+			return err
+		}
+
+		if !sale.Status.CanTransitionTo(status) {
+			return &orders.TransitionError{
+				From: sale.Status,
+				To:   status,
+			}
+		}
+
+		if err := tx.Model(&orders.Sale{}).
+			Where("id = ?", id).
+			Update("status", status).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&orders.SaleStatusHistory{
+			SaleID:     id,
+			FromStatus: sale.Status,
+			ToStatus:   status,
+			Actor:      change.Actor,
+			Reason:     change.Reason,
+		}).Error
+	})
+}
+
+func (r *postgresSaleRepository) GetStatusHistory(
+	ctx context.Context,
+	id uuid.UUID,
+) ([]orders.SaleStatusHistory, error) {
+	var history []orders.SaleStatusHistory
+	err := database.Conn(ctx, r.db).Where(
+		"sale_id = ?", id,
+	).Order("created_at ASC").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
 }
 
 func (r *postgresSaleRepository) SetTrackingCode(
@@ -122,7 +260,7 @@ func (r *postgresSaleRepository) SetTrackingCode(
 	id uuid.UUID,
 	trackingCode string,
 ) error {
-	return r.db.WithContext(ctx).
+	return database.Conn(ctx, r.db).
 		Model(&orders.Sale{}).
 		Where("id = ?", id).
 		Update("tracking_code", trackingCode).Error
@@ -133,7 +271,7 @@ func (r *postgresSaleRepository) GetByTrackingCode(
 	trackingCode string,
 ) (*orders.Sale, error) {
 	var sale orders.Sale
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"tracking_code = ?",
 		trackingCode,
 	).First(&sale).Error
@@ -149,20 +287,20 @@ func (r *postgresSaleRepository) GetByTrackingCode(
 func (r *postgresSaleRepository) Delete(
 	ctx context.Context, id uuid.UUID,
 ) error {
-	return r.db.WithContext(ctx).Delete(&orders.Sale{}, id).Error
+	return database.Conn(ctx, r.db).Delete(&orders.Sale{}, id).Error
 }
 
 func (r *postgresSaleItemRepository) Create(
 	ctx context.Context, item *orders.SaleItem,
 ) error {
-	return r.db.WithContext(ctx).Create(item).Error
+	return database.Conn(ctx, r.db).Create(item).Error
 }
 
 func (r *postgresSaleItemRepository) GetBySaleID(
 	ctx context.Context, saleID uuid.UUID,
 ) ([]orders.SaleItem, error) {
 	var items []orders.SaleItem
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"sale_id = ?", saleID,
 	).Find(&items).Error
 	if err != nil {
@@ -171,23 +309,90 @@ func (r *postgresSaleItemRepository) GetBySaleID(
 	return items, nil
 }
 
+func (r *postgresSaleItemRepository) GetBySaleIDs(
+	ctx context.Context, saleIDs []uuid.UUID,
+) ([]orders.SaleItem, error) {
+	if len(saleIDs) == 0 {
+		return nil, nil
+	}
+
+	var items []orders.SaleItem
+	err := database.Conn(ctx, r.db).Where(
+		"sale_id IN ?", saleIDs,
+	).Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *postgresSaleItemRepository) AddRestocked(
+	ctx context.Context, id uuid.UUID, quantity int,
+) error {
+	return database.Conn(ctx, r.db).Model(&orders.SaleItem{}).
+		Where("id = ?", id).
+		Update(
+			"restocked_quantity",
+			gorm.Expr("restocked_quantity + ?", quantity),
+		).Error
+}
+
 func (r *postgresSaleItemRepository) Delete(
 	ctx context.Context, id uuid.UUID,
 ) error {
-	return r.db.WithContext(ctx).Delete(&orders.SaleItem{}, id).Error
+	return database.Conn(ctx, r.db).Delete(&orders.SaleItem{}, id).Error
+}
+
+func (r *postgresSaleShippingRepository) Create(
+	ctx context.Context, shipping *orders.SaleShipping,
+) error {
+	return database.Conn(ctx, r.db).Create(shipping).Error
+}
+
+func (r *postgresSaleShippingRepository) GetBySaleID(
+	ctx context.Context, saleID uuid.UUID,
+) (*orders.SaleShipping, error) {
+	var shipping orders.SaleShipping
+	err := database.Conn(ctx, r.db).Where(
+		"sale_id = ?", saleID,
+	).First(&shipping).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &shipping, nil
+}
+
+func (r *postgresSaleShippingRepository) GetBySaleIDs(
+	ctx context.Context, saleIDs []uuid.UUID,
+) ([]orders.SaleShipping, error) {
+	if len(saleIDs) == 0 {
+		return nil, nil
+	}
+
+	var shippings []orders.SaleShipping
+	err := database.Conn(ctx, r.db).Where(
+		"sale_id IN ?", saleIDs,
+	).Find(&shippings).Error
+	if err != nil {
+		return nil, err
+	}
+	return shippings, nil
 }
 
 func (r *postgresCartReservationRepository) Create(
 	ctx context.Context, reservation *orders.CartReservation,
 ) error {
-	return r.db.WithContext(ctx).Create(reservation).Error
+	return database.Conn(ctx, r.db).Create(reservation).Error
 }
 
 func (r *postgresCartReservationRepository) GetByUserID(
 	ctx context.Context, userID uuid.UUID,
 ) ([]orders.CartReservation, error) {
 	var reservations []orders.CartReservation
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"user_id = ?", userID,
 	).Find(&reservations).Error
 	if err != nil {
@@ -196,18 +401,64 @@ func (r *postgresCartReservationRepository) GetByUserID(
 	return reservations, nil
 }
 
+func (r *postgresCartReservationRepository) GetBySaleID(
+	ctx context.Context, saleID uuid.UUID,
+) ([]orders.CartReservation, error) {
+	var reservations []orders.CartReservation
+	err := database.Conn(ctx, r.db).Where(
+		"sale_id = ?", saleID,
+	).Find(&reservations).Error
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (r *postgresCartReservationRepository) GetExpiredSaleIDs(
+	ctx context.Context, before time.Time, limit int,
+) ([]uuid.UUID, error) {
+	var saleIDs []uuid.UUID
+	err := database.Conn(ctx, r.db).
+		Model(&orders.CartReservation{}).
+		Where("sale_id IS NOT NULL AND expires_at < ?", before).
+		Group("sale_id").
+		Order("MIN(expires_at) ASC").
+		Limit(limit).
+		Pluck("sale_id", &saleIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return saleIDs, nil
+}
+
 func (r *postgresCartReservationRepository) Delete(
 	ctx context.Context, id uuid.UUID,
 ) error {
-	return r.db.WithContext(ctx).Delete(
+	return database.Conn(ctx, r.db).Delete(
 		&orders.CartReservation{}, id,
 	).Error
 }
 
+func (r *postgresCartReservationRepository) ExtendBySaleID(
+	ctx context.Context, saleID uuid.UUID, expiresAt time.Time,
+) error {
+	return database.Conn(ctx, r.db).Model(&orders.CartReservation{}).
+		Where("sale_id = ? AND expires_at < ?", saleID, expiresAt).
+		Update("expires_at", expiresAt).Error
+}
+
+func (r *postgresCartReservationRepository) DeleteBySaleID(
+	ctx context.Context, saleID uuid.UUID,
+) error {
+	return database.Conn(ctx, r.db).Where(
+		"sale_id = ?", saleID,
+	).Delete(&orders.CartReservation{}).Error
+}
+
 func (r *postgresCartReservationRepository) CleanExpired(
 	ctx context.Context,
 ) error {
-	return r.db.WithContext(ctx).Where(
+	return database.Conn(ctx, r.db).Where(
 		"expires_at < ?", time.Now(),
 	).Delete(&orders.CartReservation{}).Error
 }
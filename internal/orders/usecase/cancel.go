@@ -0,0 +1,210 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type CancelOrderUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		orderID uuid.UUID,
+	) (*orders.CancelOrderResponse, error)
+}
+
+type cancelOrderUseCase struct {
+	transactor          database.Transactor
+	saleRepo            repository.SaleRepository
+	saleItemRepo        repository.SaleItemRepository
+	cartReservationRepo repository.CartReservationRepository
+	productPort         port.ProductPort
+	paymentPort         port.PaymentPort
+}
+
+func NewCancelOrderUseCase(
+	transactor database.Transactor,
+	saleRepo repository.SaleRepository,
+	saleItemRepo repository.SaleItemRepository,
+	cartReservationRepo repository.CartReservationRepository,
+	productPort port.ProductPort,
+	paymentPort port.PaymentPort,
+) CancelOrderUseCase {
+	return &cancelOrderUseCase{
+		transactor:          transactor,
+		saleRepo:            saleRepo,
+		saleItemRepo:        saleItemRepo,
+		cartReservationRepo: cartReservationRepo,
+		productPort:         productPort,
+		paymentPort:         paymentPort,
+	}
+}
+
+func (uc *cancelOrderUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	orderID uuid.UUID,
+) (*orders.CancelOrderResponse, error) {
+	// A paid order is refunded before it is cancelled, outside of any
+	// transaction, as the payments slice calls the gateway, which cannot be
+	// rolled back. Cancelling again after a failure finds nothing left to
+	// refund and carries on.
+	var status orders.OrderStatus
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.cancellableSale(ctx, userID, orderID)
+		if err != nil {
+			return err
+		}
+		status = sale.Status
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var refund *port.Refund
+	if status == orders.OrderStatusPaid {
+		result, err := uc.paymentPort.RefundPayment(
+			ctx,
+			&port.RefundPaymentRequest{
+				OrderID: orderID,
+				Reason:  "order cancelled by customer",
+				Actor:   orders.CustomerActor(userID),
+			},
+		)
+		switch {
+		case err == nil:
+			refund = &result.Refund
+		case !errors.Is(err, port.ErrNothingToRefund):
+			return nil, fmt.Errorf("failed to refund payment: %w", err)
+		}
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.cancellableSale(ctx, userID, orderID)
+		if err != nil {
+			return err
+		}
+		// Paid meanwhile, and so not refunded above
+		if sale.Status == orders.OrderStatusPaid &&
+			status != orders.OrderStatusPaid {
+			return fmt.Errorf(
+				"%w: order was paid meanwhile", orders.ErrOrderNotCancellable,
+			)
+		}
+
+		if sale.Status != orders.OrderStatusPaid {
+			if err := uc.paymentPort.CancelPendingPayments(
+				ctx, sale.ID,
+			); err != nil {
+				return fmt.Errorf(
+					"failed to cancel pending payments: %w", err,
+				)
+			}
+		}
+
+		items, err := uc.saleItemRepo.GetBySaleID(ctx, sale.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get sale items: %w", err)
+		}
+
+		for _, item := range items {
+			// Refunds may have restocked part of the item already
+			quantity := item.Quantity - item.RestockedQuantity
+			if quantity <= 0 {
+				continue
+			}
+			if err := uc.productPort.UpdateStock(
+				ctx,
+				item.VariantID,
+				quantity,
+				port.StockChange{
+					Reason: port.StockReasonOrderCancellation,
+					SaleID: sale.ID,
+					Actor:  orders.CustomerActor(userID),
+				},
+			); err != nil {
+				return fmt.Errorf(
+					"failed to restore stock for variant %s: %w",
+					item.VariantID, err,
+				)
+			}
+		}
+
+		if err := uc.cartReservationRepo.DeleteBySaleID(
+			ctx, sale.ID,
+		); err != nil {
+			return fmt.Errorf(
+				"failed to delete cart reservations: %w", err,
+			)
+		}
+
+		if err := uc.saleRepo.UpdateStatus(
+			ctx,
+			sale.ID,
+			orders.OrderStatusCancelled,
+			orders.StatusChange{
+				Actor:  orders.CustomerActor(userID),
+				Reason: "cancelled by customer",
+			},
+		); err != nil {
+			return fmt.Errorf("failed to update sale status: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		if refund != nil {
+			return nil, fmt.Errorf(
+				"refund %s was made, but the order could not be "+
+					"cancelled: %w", refund.ID, err,
+			)
+		}
+		return nil, err
+	}
+
+	response := &orders.CancelOrderResponse{
+		ID:     orderID,
+		Status: orders.OrderStatusCancelled,
+	}
+	if refund != nil {
+		info := toRefundInfo(refund)
+		response.Refund = &info
+	}
+
+	return response, nil
+}
+
+// cancellableSale locks a sale of the user that can still be cancelled.
+func (uc *cancelOrderUseCase) cancellableSale(
+	ctx context.Context,
+	userID uuid.UUID,
+	orderID uuid.UUID,
+) (*orders.Sale, error) {
+	sale, err := uc.saleRepo.GetByIDForUpdate(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil || sale.UserID != userID {
+		return nil, orders.ErrOrderNotFound
+	}
+
+	switch sale.Status {
+	case orders.OrderStatusPending,
+		orders.OrderStatusFailed,
+		orders.OrderStatusPaid:
+		return sale, nil
+	default:
+		return nil, fmt.Errorf(
+			"%w: order is %s", orders.ErrOrderNotCancellable, sale.Status,
+		)
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"dunhayat-api/internal/orders"
 	"dunhayat-api/internal/orders/port"
 	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/config"
+	"dunhayat-api/pkg/database"
 
 	"github.com/google/uuid"
 )
@@ -22,29 +26,42 @@ type CreateOrderUseCase interface {
 }
 
 type createOrderUseCase struct {
+	transactor          database.Transactor
 	saleRepo            repository.SaleRepository
 	saleItemRepo        repository.SaleItemRepository
+	saleShippingRepo    repository.SaleShippingRepository
 	cartReservationRepo repository.CartReservationRepository
 	productPort         port.ProductPort
 	userPort            port.UserPort
 	paymentPort         port.PaymentPort
+	reservationTTL      time.Duration
+	// app lists where customers may return to once paying is over
+	app *config.AppConfig
 }
 
 func NewCreateOrderUseCase(
+	transactor database.Transactor,
 	saleRepo repository.SaleRepository,
 	saleItemRepo repository.SaleItemRepository,
+	saleShippingRepo repository.SaleShippingRepository,
 	cartReservationRepo repository.CartReservationRepository,
 	productPort port.ProductPort,
 	userPort port.UserPort,
 	paymentPort port.PaymentPort,
+	reservationTTL time.Duration,
+	app *config.AppConfig,
 ) CreateOrderUseCase {
 	return &createOrderUseCase{
+		transactor:          transactor,
 		saleRepo:            saleRepo,
 		saleItemRepo:        saleItemRepo,
+		saleShippingRepo:    saleShippingRepo,
 		cartReservationRepo: cartReservationRepo,
 		productPort:         productPort,
 		userPort:            userPort,
 		paymentPort:         paymentPort,
+		reservationTTL:      reservationTTL,
+		app:                 app,
 	}
 }
 
@@ -53,6 +70,12 @@ func (uc *createOrderUseCase) Execute(
 	userID uuid.UUID,
 	req *orders.CreateOrderRequest,
 ) (*orders.OrderResponse, error) {
+	if !uc.app.AllowsReturnURL(req.ReturnURL) {
+		return nil, fmt.Errorf(
+			"%w: %s", orders.ErrInvalidReturnURL, req.ReturnURL,
+		)
+	}
+
 	user, err := uc.userPort.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
@@ -61,118 +84,161 @@ func (uc *createOrderUseCase) Execute(
 		return nil, errors.New("user not found")
 	}
 
-	var totalPrice int
-	var saleItems []orders.SaleItem
+	shipping, err := uc.shippingFor(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sale         *orders.Sale
+		saleItems    []orders.SaleItem
+		reservations []orders.CartReservation
+	)
 
-	for _, item := range req.Items {
-		product, err := uc.productPort.GetProductByID(
-			ctx, item.ProductID,
-		)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"failed to get product %s: %w",
-				item.ProductID, err,
+	// Reservations, stock and the sale itself either all commit or all
+	// roll back, so a failure midway cannot leak stock.
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var totalPrice int
+		lines := make([]orderLine, 0, len(req.Items))
+
+		for _, item := range req.Items {
+			variant, err := uc.productPort.GetVariant(
+				ctx, item.ProductID, item.VariantID,
 			)
+			if err != nil {
+				return fmt.Errorf(
+					"failed to get product %s: %w",
+					item.ProductID, err,
+				)
+			}
+			if variant == nil {
+				return fmt.Errorf(
+					"product not found: %s",
+					item.ProductID,
+				)
+			}
+			if variant.InStock < item.Quantity {
+				return &port.InsufficientStockError{
+					ProductID: item.ProductID,
+					VariantID: variant.ID,
+					Requested: item.Quantity,
+					Available: variant.InStock,
+				}
+			}
+
+			lines = append(lines, orderLine{
+				variant:  variant,
+				quantity: item.Quantity,
+			})
+			totalPrice += variant.Price * item.Quantity
 		}
-		if product == nil {
-			return nil, fmt.Errorf(
-				"product not found: %s",
-				item.ProductID,
-			)
+
+		sale = &orders.Sale{
+			UserID:     userID,
+			Status:     orders.OrderStatusPending,
+			TotalPrice: totalPrice,
 		}
-		if product.InStock < item.Quantity {
-			return nil, fmt.Errorf(
-				"insufficient stock for product %s: requested %d, available %d",
-				item.ProductID, item.Quantity, product.InStock,
-			)
+		if err := uc.saleRepo.Create(ctx, sale); err != nil {
+			return fmt.Errorf("failed to create sale: %w", err)
 		}
 
-		itemPrice := product.Price * item.Quantity
-		totalPrice += itemPrice
-
-		reservation := &orders.CartReservation{
-			UserID:    userID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			ExpiresAt: time.Now().Add(10 * time.Minute),
-		}
-		if err := uc.cartReservationRepo.Create(
-			ctx, reservation,
-		); err != nil {
-			return nil, fmt.Errorf(
-				"failed to create cart reservation for product %s: %w",
-				item.ProductID, err,
-			)
+		shipping.SaleID = sale.ID
+		if err := uc.saleShippingRepo.Create(ctx, shipping); err != nil {
+			return fmt.Errorf("failed to store shipping address: %w", err)
 		}
 
-		if err := uc.productPort.UpdateStock(
-			ctx, item.ProductID, -item.Quantity,
-		); err != nil {
-			return nil, fmt.Errorf(
-				"failed to update product stock for %s: %w",
-				item.ProductID, err,
+		// Stock is taken in variant order so that concurrent orders lock
+		// variant rows in the same sequence and cannot deadlock.
+		slices.SortFunc(lines, func(a, b orderLine) int {
+			return strings.Compare(
+				a.variant.ID.String(), b.variant.ID.String(),
 			)
-		}
-	}
+		})
 
-	sale := &orders.Sale{
-		UserID:     userID,
-		Status:     orders.OrderStatusPending,
-		TotalPrice: totalPrice,
-	}
-	if err := uc.saleRepo.Create(ctx, sale); err != nil {
-		return nil, fmt.Errorf("failed to create sale: %w", err)
-	}
+		for _, line := range lines {
+			reservation := orders.CartReservation{
+				UserID:    userID,
+				SaleID:    &sale.ID,
+				ProductID: line.variant.ProductID,
+				VariantID: line.variant.ID,
+				Quantity:  line.quantity,
+				ExpiresAt: time.Now().Add(uc.reservationTTL),
+			}
+			if err := uc.cartReservationRepo.Create(
+				ctx, &reservation,
+			); err != nil {
+				return fmt.Errorf(
+					"failed to create cart reservation for variant %s: %w",
+					line.variant.SKU, err,
+				)
+			}
+			reservations = append(reservations, reservation)
 
-	for _, item := range req.Items {
-		product, err := uc.productPort.GetProductByID(
-			ctx, item.ProductID,
-		)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"failed to get product for sale item %s: %w",
-				item.ProductID, err,
-			)
-		}
-		saleItem := &orders.SaleItem{
-			SaleID:    sale.ID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     product.Price,
-		}
-		if err := uc.saleItemRepo.Create(ctx, saleItem); err != nil {
-			return nil, fmt.Errorf(
-				"failed to create sale item for product %s: %w",
-				item.ProductID, err,
-			)
+			if err := uc.productPort.UpdateStock(
+				ctx,
+				line.variant.ID,
+				-line.quantity,
+				port.StockChange{
+					Reason: port.StockReasonOrderReservation,
+					SaleID: sale.ID,
+					Actor:  orders.CustomerActor(userID),
+				},
+			); err != nil {
+				if errors.Is(err, port.ErrInsufficientStock) {
+					return err
+				}
+				return fmt.Errorf(
+					"failed to update stock for variant %s: %w",
+					line.variant.SKU, err,
+				)
+			}
+
+			saleItem := orders.SaleItem{
+				SaleID:    sale.ID,
+				ProductID: line.variant.ProductID,
+				VariantID: line.variant.ID,
+				Quantity:  line.quantity,
+				Price:     line.variant.Price,
+			}
+			if err := uc.saleItemRepo.Create(ctx, &saleItem); err != nil {
+				return fmt.Errorf(
+					"failed to create sale item for variant %s: %w",
+					line.variant.SKU, err,
+				)
+			}
+			saleItems = append(saleItems, saleItem)
 		}
-		saleItems = append(saleItems, *saleItem)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	paymentReq := &port.InitiatePaymentRequest{
-		OrderID:     sale.ID,
-		UserID:      userID,
-		Amount:      totalPrice,
-		CallbackURL: req.CallbackURL,
-		ReturnURL:   req.ReturnURL,
+		OrderID:   sale.ID,
+		UserID:    userID,
+		Amount:    sale.TotalPrice,
+		Method:    req.PaymentMethod,
+		ReturnURL: req.ReturnURL,
 		Description: fmt.Sprintf(
 			"Payment for order %s", sale.ID.String(),
 		),
 		Metadata: map[string]any{
 			"order_id":    sale.ID.String(),
-			"address":     req.Address,
-			"postal_code": req.PostalCode,
+			"address":     shipping.Address,
+			"postal_code": shipping.PostalCode,
 		},
 	}
 
 	paymentResp, err := uc.paymentPort.InitiatePayment(ctx, paymentReq)
 	if err != nil {
-		if err := uc.saleRepo.UpdateStatus(
-			ctx, sale.ID, orders.OrderStatusCancelled,
-		); err != nil {
+		if releaseErr := uc.release(
+			ctx, sale, saleItems, reservations,
+		); releaseErr != nil {
 			return nil, fmt.Errorf(
-				"failed to update sale status: %w",
-				err,
+				"failed to release order after payment failure: %w",
+				releaseErr,
 			)
 		}
 		return nil, fmt.Errorf("failed to initiate payment: %w", err)
@@ -185,8 +251,9 @@ func (uc *createOrderUseCase) Execute(
 		TrackingCode: sale.TrackingCode,
 		TotalPrice:   sale.TotalPrice,
 		Items:        saleItems,
-		Address:      req.Address,
-		PostalCode:   req.PostalCode,
+		Address:      shipping.Address,
+		PostalCode:   shipping.PostalCode,
+		Shipping:     shipping,
 		Payment: &orders.PaymentInfo{
 			PaymentID:    paymentResp.PaymentID,
 			GatewayURL:   paymentResp.GatewayURL,
@@ -199,3 +266,117 @@ func (uc *createOrderUseCase) Execute(
 		UpdatedAt: sale.UpdatedAt,
 	}, nil
 }
+
+// orderLine is an item of a new order with the variant it resolved to.
+type orderLine struct {
+	variant  *port.Variant
+	quantity int
+}
+
+// shippingFor builds the shipping snapshot of a new order from either a
+// saved address or the address in the request, filling the recipient from
+// the user's profile when the request leaves it out.
+func (uc *createOrderUseCase) shippingFor(
+	ctx context.Context,
+	user *port.User,
+	req *orders.CreateOrderRequest,
+) (*orders.SaleShipping, error) {
+	shipping := &orders.SaleShipping{
+		Address:    strings.TrimSpace(req.Address),
+		PostalCode: strings.TrimSpace(req.PostalCode),
+	}
+
+	if req.AddressID != nil {
+		saved, err := uc.userPort.GetAddressByID(ctx, *req.AddressID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get address: %w", err)
+		}
+		if saved == nil || saved.UserID != user.ID {
+			return nil, orders.ErrAddressNotFound
+		}
+
+		shipping.AddressID = &saved.ID
+		shipping.Address = saved.Address
+		shipping.PostalCode = saved.PostalCode
+	}
+
+	if shipping.Address == "" || shipping.PostalCode == "" {
+		return nil, orders.ErrShippingAddressRequired
+	}
+
+	recipientName := strings.TrimSpace(req.RecipientName)
+	if recipientName == "" {
+		var names []string
+		if user.FirstName != nil {
+			names = append(names, *user.FirstName)
+		}
+		if user.LastName != nil {
+			names = append(names, *user.LastName)
+		}
+		recipientName = strings.TrimSpace(strings.Join(names, " "))
+	}
+
+	phone := strings.TrimSpace(req.Phone)
+	if phone == "" {
+		phone = user.Phone
+	}
+
+	shipping.RecipientName = recipientName
+	shipping.Phone = phone
+
+	return shipping, nil
+}
+
+// release cancels a committed sale whose payment could not be initiated and
+// hands its stock back.
+func (uc *createOrderUseCase) release(
+	ctx context.Context,
+	sale *orders.Sale,
+	saleItems []orders.SaleItem,
+	reservations []orders.CartReservation,
+) error {
+	return uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.saleRepo.UpdateStatus(
+			ctx,
+			sale.ID,
+			orders.OrderStatusCancelled,
+			orders.StatusChange{
+				Actor:  orders.ActorSystem,
+				Reason: "payment initiation failed",
+			},
+		); err != nil {
+			return fmt.Errorf("failed to update sale status: %w", err)
+		}
+
+		for _, item := range saleItems {
+			if err := uc.productPort.UpdateStock(
+				ctx,
+				item.VariantID,
+				item.Quantity,
+				port.StockChange{
+					Reason: port.StockReasonOrderCancellation,
+					SaleID: sale.ID,
+					Actor:  orders.ActorSystem,
+				},
+			); err != nil {
+				return fmt.Errorf(
+					"failed to restore stock for variant %s: %w",
+					item.VariantID, err,
+				)
+			}
+		}
+
+		for _, reservation := range reservations {
+			if err := uc.cartReservationRepo.Delete(
+				ctx, reservation.ID,
+			); err != nil {
+				return fmt.Errorf(
+					"failed to delete cart reservation %s: %w",
+					reservation.ID, err,
+				)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,293 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/pkg/config"
+
+	"github.com/google/uuid"
+)
+
+type createOrderFixture struct {
+	user     port.User
+	address  port.Address
+	store    *fakeStore
+	variants []port.Variant
+	payments *fakePaymentPort
+	useCase  CreateOrderUseCase
+}
+
+// newCreateOrderFixture stocks two variants, 5 of the first and 3 of the
+// second.
+func newCreateOrderFixture() *createOrderFixture {
+	f := &createOrderFixture{
+		user:     port.User{ID: uuid.New(), Phone: "09120000000"},
+		store:    newFakeStore(),
+		payments: &fakePaymentPort{},
+	}
+	f.address = port.Address{
+		ID:         uuid.New(),
+		UserID:     f.user.ID,
+		Address:    "No. 7, Hafez St, Shiraz",
+		PostalCode: "7134567890",
+	}
+
+	products := fakeProductPort{
+		store:    f.store,
+		variants: make(map[string]port.Variant),
+	}
+	for i, productID := range []string{"ethiopia-guji", "colombia-huila"} {
+		variant := port.Variant{
+			ID:        uuid.New(),
+			ProductID: productID,
+			SKU:       productID + "-250g",
+			Price:     100_000 * (i + 1),
+		}
+		products.variants[productID] = variant
+		f.store.stock[variant.ID] = 5 - 2*i
+		f.variants = append(f.variants, variant)
+	}
+
+	f.useCase = NewCreateOrderUseCase(
+		fakeTransactor{store: f.store},
+		fakeSaleRepo{store: f.store},
+		fakeSaleItemRepo{store: f.store},
+		fakeSaleShippingRepo{store: f.store},
+		fakeCartReservationRepo{store: f.store},
+		products,
+		fakeUserPort{user: f.user, address: &f.address},
+		f.payments,
+		15*time.Minute,
+		&config.AppConfig{Domain: "https://shop.example"},
+	)
+	return f
+}
+
+// order buys 2 of the first variant and 1 of the second.
+func (f *createOrderFixture) order() (*orders.OrderResponse, error) {
+	return f.useCase.Execute(context.Background(), f.user.ID, f.request())
+}
+
+func (f *createOrderFixture) request() *orders.CreateOrderRequest {
+	return &orders.CreateOrderRequest{
+		Items: []orders.OrderItemRequest{
+			{ProductID: f.variants[0].ProductID, Quantity: 2},
+			{ProductID: f.variants[1].ProductID, Quantity: 1},
+		},
+		Address:    "No. 12, Valiasr St, Tehran",
+		PostalCode: "1234567890",
+		ReturnURL:  "https://shop.example/orders",
+	}
+}
+
+func (f *createOrderFixture) assertStock(t *testing.T, want ...int) {
+	t.Helper()
+
+	for i, variant := range f.variants {
+		if got := f.store.stockOf(variant.ID); got != want[i] {
+			t.Errorf("stock of %s = %d, want %d", variant.SKU, got, want[i])
+		}
+	}
+}
+
+// assertNothingStored checks that the order left no rows behind.
+func (f *createOrderFixture) assertNothingStored(t *testing.T) {
+	t.Helper()
+
+	snapshot := f.store.snapshot()
+	if len(snapshot.sales) != 0 ||
+		len(snapshot.items) != 0 ||
+		len(snapshot.shippings) != 0 ||
+		len(snapshot.reservations) != 0 {
+		t.Errorf(
+			"failed order left %d sales, %d items, %d shippings and %d reservations",
+			len(snapshot.sales), len(snapshot.items),
+			len(snapshot.shippings), len(snapshot.reservations),
+		)
+	}
+}
+
+func TestCreateOrderTakesStock(t *testing.T) {
+	f := newCreateOrderFixture()
+
+	response, err := f.order()
+	if err != nil {
+		t.Fatalf("order failed: %v", err)
+	}
+	if response.TotalPrice != 400_000 {
+		t.Errorf("total price = %d, want 400000", response.TotalPrice)
+	}
+	if response.Payment == nil {
+		t.Error("order has no payment")
+	}
+	f.assertStock(t, 3, 2)
+
+	snapshot := f.store.snapshot()
+	if len(snapshot.items) != 2 || len(snapshot.reservations) != 2 {
+		t.Errorf(
+			"order stored %d items and %d reservations, want 2 of each",
+			len(snapshot.items), len(snapshot.reservations),
+		)
+	}
+}
+
+// A failure at any step of the order rolls the whole of it back, leaving
+// stock as it was. Failing the second call of a step fails the order after
+// the stock of its first line was taken.
+func TestCreateOrderFailureLeavesStockUntouched(t *testing.T) {
+	tests := []struct {
+		step string
+		call int
+	}{
+		{"productPort.GetVariant", 1},
+		{"productPort.GetVariant", 2},
+		{"saleRepo.Create", 1},
+		{"saleShippingRepo.Create", 1},
+		{"cartReservationRepo.Create", 1},
+		{"cartReservationRepo.Create", 2},
+		{"productPort.UpdateStock", 1},
+		{"productPort.UpdateStock", 2},
+		{"saleItemRepo.Create", 1},
+		{"saleItemRepo.Create", 2},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s call %d", tt.step, tt.call)
+		t.Run(name, func(t *testing.T) {
+			f := newCreateOrderFixture()
+			f.store.failOn(tt.step, tt.call)
+
+			if _, err := f.order(); !errors.Is(err, errInjected) {
+				t.Fatalf("order error = %v, want the injected failure", err)
+			}
+			f.assertStock(t, 5, 3)
+			f.assertNothingStored(t)
+		})
+	}
+}
+
+func TestCreateOrderInsufficientStockLeavesStockUntouched(t *testing.T) {
+	f := newCreateOrderFixture()
+	f.store.stock[f.variants[1].ID] = 0
+
+	_, err := f.order()
+	var stockErr *port.InsufficientStockError
+	if !errors.As(err, &stockErr) {
+		t.Fatalf("order error = %v, want insufficient stock", err)
+	}
+	if stockErr.VariantID != f.variants[1].ID {
+		t.Errorf("insufficient stock reported for %s", stockErr.VariantID)
+	}
+	f.assertStock(t, 5, 0)
+	f.assertNothingStored(t)
+}
+
+// An order to a saved address sends that address with its payment, not
+// the empty one of the request.
+func TestCreateOrderSavedAddressReachesPayment(t *testing.T) {
+	f := newCreateOrderFixture()
+	req := f.request()
+	req.AddressID = &f.address.ID
+	req.Address = ""
+	req.PostalCode = ""
+
+	if _, err := f.useCase.Execute(context.Background(), f.user.ID, req); err != nil {
+		t.Fatalf("order failed: %v", err)
+	}
+
+	if len(f.payments.requests) != 1 {
+		t.Fatalf("%d payments initiated, want 1", len(f.payments.requests))
+	}
+	metadata := f.payments.requests[0].Metadata
+	if metadata["address"] != f.address.Address ||
+		metadata["postal_code"] != f.address.PostalCode {
+		t.Errorf(
+			"payment metadata = %v, want the saved address %q, %q",
+			metadata, f.address.Address, f.address.PostalCode,
+		)
+	}
+}
+
+// Customers are only sent back over https to the shop's own domain, so an
+// order cannot be used to redirect them elsewhere.
+func TestCreateOrderRejectsForeignReturnURL(t *testing.T) {
+	tests := []string{
+		"",
+		"http://shop.example/orders",
+		"https://evil.example/orders",
+		"https://shop.example.evil.example/orders",
+		"https://shop.example@evil.example/orders",
+		"//evil.example/orders",
+		"javascript:alert(1)",
+	}
+
+	for _, returnURL := range tests {
+		t.Run(returnURL, func(t *testing.T) {
+			f := newCreateOrderFixture()
+			req := f.request()
+			req.ReturnURL = returnURL
+
+			_, err := f.useCase.Execute(context.Background(), f.user.ID, req)
+			if !errors.Is(err, orders.ErrInvalidReturnURL) {
+				t.Fatalf("order error = %v, want invalid return URL", err)
+			}
+			f.assertStock(t, 5, 3)
+			f.assertNothingStored(t)
+		})
+	}
+}
+
+// A sale whose payment cannot be initiated is cancelled and gives its stock
+// back.
+func TestCreateOrderPaymentFailureReleasesStock(t *testing.T) {
+	f := newCreateOrderFixture()
+	f.payments.err = errors.New("gateway unavailable")
+
+	if _, err := f.order(); err == nil {
+		t.Fatal("order succeeded without a payment")
+	}
+	f.assertStock(t, 5, 3)
+
+	snapshot := f.store.snapshot()
+	if len(snapshot.reservations) != 0 {
+		t.Errorf("%d reservations left behind", len(snapshot.reservations))
+	}
+	for _, sale := range snapshot.sales {
+		if sale.Status != orders.OrderStatusCancelled {
+			t.Errorf("sale is %s, want cancelled", sale.Status)
+		}
+	}
+}
+
+// A release that fails midway rolls back as a whole, so the stock it had
+// already given back is taken again and stays with the pending sale until
+// its reservations expire.
+func TestCreateOrderFailedReleaseKeepsStockWithSale(t *testing.T) {
+	f := newCreateOrderFixture()
+	f.payments.err = errors.New("gateway unavailable")
+	f.store.failOn("productPort.UpdateStock", 4)
+
+	if _, err := f.order(); !errors.Is(err, errInjected) {
+		t.Fatalf("order error = %v, want the injected failure", err)
+	}
+	f.assertStock(t, 3, 2)
+
+	snapshot := f.store.snapshot()
+	if len(snapshot.reservations) != 2 {
+		t.Errorf(
+			"%d reservations left, want 2 to expire the sale",
+			len(snapshot.reservations),
+		)
+	}
+	for _, sale := range snapshot.sales {
+		if sale.Status != orders.OrderStatusPending {
+			t.Errorf("sale is %s, want pending", sale.Status)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const expireReservationsBatchSize = 100
+
+// ExpireReservationsUseCase hands back the stock held by checkouts that were
+// abandoned before payment and cancels their sales.
+type ExpireReservationsUseCase interface {
+	// Execute returns the number of sales it released
+	Execute(ctx context.Context) (int, error)
+}
+
+type expireReservationsUseCase struct {
+	transactor          database.Transactor
+	saleRepo            repository.SaleRepository
+	cartReservationRepo repository.CartReservationRepository
+	productPort         port.ProductPort
+	paymentPort         port.PaymentPort
+	logger              logger.Interface
+}
+
+func NewExpireReservationsUseCase(
+	transactor database.Transactor,
+	saleRepo repository.SaleRepository,
+	cartReservationRepo repository.CartReservationRepository,
+	productPort port.ProductPort,
+	paymentPort port.PaymentPort,
+	logger logger.Interface,
+) ExpireReservationsUseCase {
+	return &expireReservationsUseCase{
+		transactor:          transactor,
+		saleRepo:            saleRepo,
+		cartReservationRepo: cartReservationRepo,
+		productPort:         productPort,
+		paymentPort:         paymentPort,
+		logger:              logger,
+	}
+}
+
+func (uc *expireReservationsUseCase) Execute(
+	ctx context.Context,
+) (int, error) {
+	saleIDs, err := uc.cartReservationRepo.GetExpiredSaleIDs(
+		ctx, time.Now(), expireReservationsBatchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"failed to get expired cart reservations: %w", err,
+		)
+	}
+
+	var released int
+	for _, saleID := range saleIDs {
+		if err := ctx.Err(); err != nil {
+			return released, err
+		}
+
+		ok, err := uc.expire(ctx, saleID)
+		if err != nil {
+			// One broken sale must not hold back the rest of the batch;
+			// it is retried on the next run.
+			uc.logger.Error("Failed to expire cart reservations",
+				zap.String("sale_id", saleID.String()),
+				zap.Error(err),
+			)
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+
+	if released > 0 {
+		uc.logger.Info("Expired cart reservations released",
+			zap.Int("sales", released),
+		)
+	}
+
+	return released, nil
+}
+
+// expire reports whether the sale was cancelled and its stock restored.
+// Reservations of sales that are no longer awaiting payment are dropped
+// without touching stock.
+func (uc *expireReservationsUseCase) expire(
+	ctx context.Context,
+	saleID uuid.UUID,
+) (bool, error) {
+	var released bool
+
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Locking the sale keeps a concurrent payment from marking it
+		// paid while its stock is being handed back.
+		sale, err := uc.saleRepo.GetByIDForUpdate(ctx, saleID)
+		if err != nil {
+			return fmt.Errorf("failed to get sale: %w", err)
+		}
+
+		if sale != nil && (sale.Status == orders.OrderStatusPending ||
+			sale.Status == orders.OrderStatusFailed) {
+			// A payment outlives the reservation, so the customer may
+			// still be paying at the gateway; the stock stays reserved
+			// until the payment expires.
+			pmt, err := uc.paymentPort.GetLatestPayment(ctx, saleID)
+			if err != nil {
+				return fmt.Errorf("failed to get payment: %w", err)
+			}
+			if pmt != nil && pmt.Status == port.PaymentStatusPending &&
+				time.Now().Before(pmt.ExpiresAt) {
+				if err := uc.cartReservationRepo.ExtendBySaleID(
+					ctx, saleID, pmt.ExpiresAt,
+				); err != nil {
+					return fmt.Errorf(
+						"failed to extend cart reservations: %w", err,
+					)
+				}
+				return nil
+			}
+
+			reservations, err := uc.cartReservationRepo.GetBySaleID(
+				ctx, saleID,
+			)
+			if err != nil {
+				return fmt.Errorf(
+					"failed to get cart reservations: %w", err,
+				)
+			}
+
+			for _, reservation := range reservations {
+				if err := uc.productPort.UpdateStock(
+					ctx,
+					reservation.VariantID,
+					reservation.Quantity,
+					port.StockChange{
+						Reason: port.StockReasonReservationExpiry,
+						SaleID: saleID,
+						Actor:  orders.ActorSystem,
+					},
+				); err != nil {
+					return fmt.Errorf(
+						"failed to restore stock for variant %s: %w",
+						reservation.VariantID, err,
+					)
+				}
+			}
+
+			if err := uc.paymentPort.CancelPendingPayments(
+				ctx, saleID,
+			); err != nil {
+				return fmt.Errorf(
+					"failed to cancel pending payments: %w", err,
+				)
+			}
+
+			if err := uc.saleRepo.UpdateStatus(
+				ctx,
+				saleID,
+				orders.OrderStatusCancelled,
+				orders.StatusChange{
+					Actor:  orders.ActorSystem,
+					Reason: "cart reservation expired",
+				},
+			); err != nil {
+				return fmt.Errorf("failed to update sale status: %w", err)
+			}
+
+			released = true
+		}
+
+		if err := uc.cartReservationRepo.DeleteBySaleID(
+			ctx, saleID,
+		); err != nil {
+			return fmt.Errorf(
+				"failed to delete cart reservations: %w", err,
+			)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return released, nil
+}
@@ -0,0 +1,326 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/orders/repository"
+
+	"github.com/google/uuid"
+)
+
+var errInjected = errors.New("injected failure")
+
+// fakeStore keeps the rows the fake repositories write to in memory. It
+// fails the call of a step chosen with failOn, and counts the calls of
+// every step.
+type fakeStore struct {
+	mu           sync.Mutex
+	stock        map[uuid.UUID]int
+	sales        map[uuid.UUID]orders.Sale
+	items        map[uuid.UUID]orders.SaleItem
+	shippings    map[uuid.UUID]orders.SaleShipping
+	reservations map[uuid.UUID]orders.CartReservation
+	failures     map[string]int
+	calls        map[string]int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		stock:        make(map[uuid.UUID]int),
+		sales:        make(map[uuid.UUID]orders.Sale),
+		items:        make(map[uuid.UUID]orders.SaleItem),
+		shippings:    make(map[uuid.UUID]orders.SaleShipping),
+		reservations: make(map[uuid.UUID]orders.CartReservation),
+		failures:     make(map[string]int),
+		calls:        make(map[string]int),
+	}
+}
+
+// failOn makes the n-th call of step fail.
+func (s *fakeStore) failOn(step string, n int) {
+	s.failures[step] = n
+}
+
+// call records a call of step, returning errInjected if it must fail. The
+// caller holds s.mu.
+func (s *fakeStore) call(step string) error {
+	s.calls[step]++
+	if n, ok := s.failures[step]; ok && n == s.calls[step] {
+		return fmt.Errorf("%s: %w", step, errInjected)
+	}
+	return nil
+}
+
+// snapshot copies the rows, and restore puts a copy back; together they
+// stand in for a transaction rolling back.
+func (s *fakeStore) snapshot() *fakeStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &fakeStore{
+		stock:        maps.Clone(s.stock),
+		sales:        maps.Clone(s.sales),
+		items:        maps.Clone(s.items),
+		shippings:    maps.Clone(s.shippings),
+		reservations: maps.Clone(s.reservations),
+	}
+}
+
+func (s *fakeStore) restore(snapshot *fakeStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stock = snapshot.stock
+	s.sales = snapshot.sales
+	s.items = snapshot.items
+	s.shippings = snapshot.shippings
+	s.reservations = snapshot.reservations
+}
+
+func (s *fakeStore) stockOf(variantID uuid.UUID) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stock[variantID]
+}
+
+type fakeTxKey struct{}
+
+// fakeTransactor runs fn directly and puts the store back as it was before
+// the outermost call when fn fails, like a rolled back transaction.
+type fakeTransactor struct {
+	store *fakeStore
+}
+
+func (t fakeTransactor) WithinTransaction(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	if ctx.Value(fakeTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	snapshot := t.store.snapshot()
+	if err := fn(context.WithValue(ctx, fakeTxKey{}, true)); err != nil {
+		t.store.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+type fakeSaleRepo struct {
+	repository.SaleRepository
+	store *fakeStore
+}
+
+func (r fakeSaleRepo) Create(_ context.Context, sale *orders.Sale) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("saleRepo.Create"); err != nil {
+		return err
+	}
+	sale.ID = uuid.New()
+	sale.CreatedAt = time.Now()
+	sale.UpdatedAt = sale.CreatedAt
+	r.store.sales[sale.ID] = *sale
+	return nil
+}
+
+func (r fakeSaleRepo) UpdateStatus(
+	_ context.Context,
+	id uuid.UUID,
+	status orders.OrderStatus,
+	_ orders.StatusChange,
+) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("saleRepo.UpdateStatus"); err != nil {
+		return err
+	}
+	sale, ok := r.store.sales[id]
+	if !ok {
+		return errors.New("sale not found")
+	}
+	sale.Status = status
+	r.store.sales[id] = sale
+	return nil
+}
+
+type fakeSaleItemRepo struct {
+	repository.SaleItemRepository
+	store *fakeStore
+}
+
+func (r fakeSaleItemRepo) Create(_ context.Context, item *orders.SaleItem) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("saleItemRepo.Create"); err != nil {
+		return err
+	}
+	item.ID = uuid.New()
+	r.store.items[item.ID] = *item
+	return nil
+}
+
+type fakeSaleShippingRepo struct {
+	repository.SaleShippingRepository
+	store *fakeStore
+}
+
+func (r fakeSaleShippingRepo) Create(
+	_ context.Context,
+	shipping *orders.SaleShipping,
+) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("saleShippingRepo.Create"); err != nil {
+		return err
+	}
+	r.store.shippings[shipping.SaleID] = *shipping
+	return nil
+}
+
+type fakeCartReservationRepo struct {
+	repository.CartReservationRepository
+	store *fakeStore
+}
+
+func (r fakeCartReservationRepo) Create(
+	_ context.Context,
+	reservation *orders.CartReservation,
+) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("cartReservationRepo.Create"); err != nil {
+		return err
+	}
+	reservation.ID = uuid.New()
+	r.store.reservations[reservation.ID] = *reservation
+	return nil
+}
+
+func (r fakeCartReservationRepo) Delete(_ context.Context, id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if err := r.store.call("cartReservationRepo.Delete"); err != nil {
+		return err
+	}
+	delete(r.store.reservations, id)
+	return nil
+}
+
+// fakeProductPort serves variants whose stock lives in the store, and
+// refuses to take more stock than there is, like the products slice.
+type fakeProductPort struct {
+	store    *fakeStore
+	variants map[string]port.Variant
+}
+
+func (p fakeProductPort) GetVariant(
+	_ context.Context,
+	productID string,
+	_ *uuid.UUID,
+) (*port.Variant, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	if err := p.store.call("productPort.GetVariant"); err != nil {
+		return nil, err
+	}
+	variant, ok := p.variants[productID]
+	if !ok {
+		return nil, nil
+	}
+	variant.InStock = p.store.stock[variant.ID]
+	return &variant, nil
+}
+
+func (p fakeProductPort) UpdateStock(
+	_ context.Context,
+	variantID uuid.UUID,
+	quantity int,
+	_ port.StockChange,
+) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	if err := p.store.call("productPort.UpdateStock"); err != nil {
+		return err
+	}
+	available := p.store.stock[variantID]
+	if available+quantity < 0 {
+		return &port.InsufficientStockError{
+			VariantID: variantID,
+			Requested: -quantity,
+			Available: available,
+		}
+	}
+	p.store.stock[variantID] = available + quantity
+	return nil
+}
+
+type fakeUserPort struct {
+	user    port.User
+	address *port.Address
+}
+
+func (p fakeUserPort) GetUserByID(
+	_ context.Context,
+	userID uuid.UUID,
+) (*port.User, error) {
+	if userID != p.user.ID {
+		return nil, nil
+	}
+	user := p.user
+	return &user, nil
+}
+
+func (p fakeUserPort) GetAddressByID(
+	_ context.Context,
+	addressID uuid.UUID,
+) (*port.Address, error) {
+	if p.address == nil || p.address.ID != addressID {
+		return nil, nil
+	}
+	address := *p.address
+	return &address, nil
+}
+
+// fakePaymentPort initiates payments, unless err is set, and keeps the
+// requests it was sent.
+type fakePaymentPort struct {
+	port.PaymentPort
+	err      error
+	requests []port.InitiatePaymentRequest
+}
+
+func (p *fakePaymentPort) InitiatePayment(
+	_ context.Context,
+	req *port.InitiatePaymentRequest,
+) (*port.InitiatePaymentResponse, error) {
+	p.requests = append(p.requests, *req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &port.InitiatePaymentResponse{
+		PaymentID:    uuid.New(),
+		GatewayURL:   "https://gateway.example/start/3000000001",
+		GatewayRefID: "3000000001",
+		Status:       port.PaymentStatusPending,
+		Amount:       req.Amount,
+		ExpiresAt:    time.Now().Add(15 * time.Minute),
+	}, nil
+}
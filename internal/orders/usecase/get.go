@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/orders/repository"
+
+	"github.com/google/uuid"
+)
+
+type GetOrderUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		orderID uuid.UUID,
+	) (*orders.OrderResponse, error)
+}
+
+type getOrderUseCase struct {
+	saleRepo         repository.SaleRepository
+	saleItemRepo     repository.SaleItemRepository
+	saleShippingRepo repository.SaleShippingRepository
+	paymentPort      port.PaymentPort
+}
+
+func NewGetOrderUseCase(
+	saleRepo repository.SaleRepository,
+	saleItemRepo repository.SaleItemRepository,
+	saleShippingRepo repository.SaleShippingRepository,
+	paymentPort port.PaymentPort,
+) GetOrderUseCase {
+	return &getOrderUseCase{
+		saleRepo:         saleRepo,
+		saleItemRepo:     saleItemRepo,
+		saleShippingRepo: saleShippingRepo,
+		paymentPort:      paymentPort,
+	}
+}
+
+func (uc *getOrderUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	orderID uuid.UUID,
+) (*orders.OrderResponse, error) {
+	sale, err := uc.saleRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	// Orders of other users are reported as missing so that order IDs
+	// cannot be probed.
+	if sale == nil || sale.UserID != userID {
+		return nil, orders.ErrOrderNotFound
+	}
+
+	items, err := uc.saleItemRepo.GetBySaleID(ctx, sale.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale items: %w", err)
+	}
+
+	shipping, err := uc.saleShippingRepo.GetBySaleID(ctx, sale.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shipping address: %w", err)
+	}
+
+	pmt, err := uc.paymentPort.GetLatestPayment(ctx, sale.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	response := &orders.OrderResponse{
+		ID:           sale.ID,
+		UserID:       sale.UserID,
+		Status:       sale.Status,
+		TrackingCode: sale.TrackingCode,
+		TotalPrice:   sale.TotalPrice,
+		Items:        items,
+		Shipping:     shipping,
+		CreatedAt:    sale.CreatedAt,
+		UpdatedAt:    sale.UpdatedAt,
+	}
+
+	if shipping != nil {
+		response.Address = shipping.Address
+		response.PostalCode = shipping.PostalCode
+	}
+
+	if pmt != nil {
+		response.Payment = &orders.PaymentInfo{
+			PaymentID:    pmt.ID,
+			GatewayURL:   pmt.GatewayURL,
+			GatewayRefID: pmt.GatewayRefID,
+			Status:       pmt.Status,
+			Amount:       pmt.Amount,
+			ExpiresAt:    pmt.ExpiresAt,
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/pagination"
+
+	"github.com/google/uuid"
+)
+
+type ListOrdersUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		req *orders.ListOrdersRequest,
+	) (*orders.ListOrdersResponse, error)
+}
+
+type listOrdersUseCase struct {
+	saleRepo         repository.SaleRepository
+	saleItemRepo     repository.SaleItemRepository
+	saleShippingRepo repository.SaleShippingRepository
+}
+
+func NewListOrdersUseCase(
+	saleRepo repository.SaleRepository,
+	saleItemRepo repository.SaleItemRepository,
+	saleShippingRepo repository.SaleShippingRepository,
+) ListOrdersUseCase {
+	return &listOrdersUseCase{
+		saleRepo:         saleRepo,
+		saleItemRepo:     saleItemRepo,
+		saleShippingRepo: saleShippingRepo,
+	}
+}
+
+func (uc *listOrdersUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	req *orders.ListOrdersRequest,
+) (*orders.ListOrdersResponse, error) {
+	limit := pagination.Limit(req.Limit)
+
+	filter := orders.SaleFilter{
+		UserID:    userID,
+		Status:    req.Status,
+		From:      req.From,
+		To:        req.To,
+		Ascending: req.Ascending,
+		// One extra sale tells whether there is a next page.
+		Limit: limit + 1,
+	}
+
+	if req.Cursor != "" {
+		cursor, err := decodeSaleCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = cursor
+	}
+
+	sales, err := uc.saleRepo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sales: %w", err)
+	}
+
+	var nextCursor string
+	if len(sales) > limit {
+		sales = sales[:limit]
+		last := sales[len(sales)-1]
+		nextCursor = pagination.EncodeCursor(
+			last.CreatedAt.Format(time.RFC3339Nano),
+			last.ID.String(),
+		)
+	}
+
+	saleIDs := make([]uuid.UUID, len(sales))
+	for i, sale := range sales {
+		saleIDs[i] = sale.ID
+	}
+
+	items, err := uc.saleItemRepo.GetBySaleIDs(ctx, saleIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale items: %w", err)
+	}
+
+	itemsBySale := make(map[uuid.UUID][]orders.OrderItemSummary, len(sales))
+	for _, item := range items {
+		itemsBySale[item.SaleID] = append(
+			itemsBySale[item.SaleID],
+			orders.OrderItemSummary{
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			},
+		)
+	}
+
+	shippings, err := uc.saleShippingRepo.GetBySaleIDs(ctx, saleIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shipping addresses: %w", err)
+	}
+
+	shippingBySale := make(map[uuid.UUID]*orders.SaleShipping, len(shippings))
+	for i := range shippings {
+		shippingBySale[shippings[i].SaleID] = &shippings[i]
+	}
+
+	summaries := make([]orders.OrderSummary, len(sales))
+	for i, sale := range sales {
+		summaries[i] = orders.OrderSummary{
+			ID:           sale.ID,
+			Status:       sale.Status,
+			TrackingCode: sale.TrackingCode,
+			TotalPrice:   sale.TotalPrice,
+			Items:        itemsBySale[sale.ID],
+			Shipping:     shippingBySale[sale.ID],
+			CreatedAt:    sale.CreatedAt,
+			UpdatedAt:    sale.UpdatedAt,
+		}
+	}
+
+	return &orders.ListOrdersResponse{
+		Orders:     summaries,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func decodeSaleCursor(cursor string) (*orders.SaleCursor, error) {
+	values, err := pagination.DecodeCursor(cursor, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, values[0])
+	if err != nil {
+		return nil, pagination.ErrInvalidCursor
+	}
+	id, err := uuid.Parse(values[1])
+	if err != nil {
+		return nil, pagination.ErrInvalidCursor
+	}
+
+	return &orders.SaleCursor{CreatedAt: createdAt, ID: id}, nil
+}
@@ -0,0 +1,356 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+// RefundOrderUseCase refunds part or all of what was paid for an order on
+// behalf of staff, optionally putting some of its items back into stock.
+// Once nothing is left to refund the order becomes refunded. Callers must
+// not hold a transaction around it, as the refund reaches the gateway.
+type RefundOrderUseCase interface {
+	Execute(
+		ctx context.Context,
+		staffID uuid.UUID,
+		orderID uuid.UUID,
+		req *orders.RefundOrderRequest,
+	) (*orders.RefundOrderResponse, error)
+}
+
+type refundOrderUseCase struct {
+	transactor   database.Transactor
+	saleRepo     repository.SaleRepository
+	saleItemRepo repository.SaleItemRepository
+	productPort  port.ProductPort
+	paymentPort  port.PaymentPort
+}
+
+func NewRefundOrderUseCase(
+	transactor database.Transactor,
+	saleRepo repository.SaleRepository,
+	saleItemRepo repository.SaleItemRepository,
+	productPort port.ProductPort,
+	paymentPort port.PaymentPort,
+) RefundOrderUseCase {
+	return &refundOrderUseCase{
+		transactor:   transactor,
+		saleRepo:     saleRepo,
+		saleItemRepo: saleItemRepo,
+		productPort:  productPort,
+		paymentPort:  paymentPort,
+	}
+}
+
+func (uc *refundOrderUseCase) Execute(
+	ctx context.Context,
+	staffID uuid.UUID,
+	orderID uuid.UUID,
+	req *orders.RefundOrderRequest,
+) (*orders.RefundOrderResponse, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, orders.ErrRefundReasonRequired
+	}
+
+	restock, err := mergeRestock(req.Restock)
+	if err != nil {
+		return nil, err
+	}
+
+	actor := orders.StaffActor(staffID)
+	response := &orders.RefundOrderResponse{
+		ID:        orderID,
+		Restocked: restock,
+	}
+
+	// The refund is checked first and made outside of any transaction, as
+	// the payments slice calls the gateway, which cannot be rolled back.
+	// The stock and the order status only follow once the money is back.
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.refundableSale(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		response.Status = sale.Status
+
+		items, err := uc.saleItemRepo.GetBySaleID(ctx, sale.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get sale items: %w", err)
+		}
+		_, err = planRestock(items, restock)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := uc.paymentPort.RefundPayment(
+		ctx,
+		&port.RefundPaymentRequest{
+			OrderID: orderID,
+			Amount:  req.Amount,
+			Reason:  reason,
+			Actor:   actor,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+	response.Refund = toRefundInfo(&result.Refund)
+	response.Refundable = result.Refundable
+
+	if len(restock) == 0 && result.Refundable > 0 {
+		return response, nil
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.refundableSale(ctx, orderID)
+		if err != nil {
+			return err
+		}
+
+		if len(restock) > 0 {
+			if err := uc.restock(ctx, sale.ID, restock, actor); err != nil {
+				return err
+			}
+		}
+
+		if result.Refundable > 0 {
+			return nil
+		}
+
+		if err := uc.saleRepo.UpdateStatus(
+			ctx,
+			sale.ID,
+			orders.OrderStatusRefunded,
+			orders.StatusChange{
+				Actor:  actor,
+				Reason: reason,
+			},
+		); err != nil {
+			return fmt.Errorf("failed to update sale status: %w", err)
+		}
+		response.Status = orders.OrderStatusRefunded
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"refund %s was made, but the order could not follow: %w",
+			result.Refund.ID, err,
+		)
+	}
+
+	return response, nil
+}
+
+// refundableSale locks a sale that can be refunded.
+func (uc *refundOrderUseCase) refundableSale(
+	ctx context.Context,
+	orderID uuid.UUID,
+) (*orders.Sale, error) {
+	sale, err := uc.saleRepo.GetByIDForUpdate(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil {
+		return nil, orders.ErrOrderNotFound
+	}
+
+	switch sale.Status {
+	case orders.OrderStatusPaid,
+		orders.OrderStatusShipped,
+		orders.OrderStatusDelivered:
+		return sale, nil
+	default:
+		return nil, fmt.Errorf(
+			"%w: order is %s", orders.ErrOrderNotRefundable, sale.Status,
+		)
+	}
+}
+
+// restock puts the given quantities of the sale's items back into stock.
+// An item is never restocked beyond the quantity that was sold.
+func (uc *refundOrderUseCase) restock(
+	ctx context.Context,
+	saleID uuid.UUID,
+	restock []orders.RestockItem,
+	actor string,
+) error {
+	items, err := uc.saleItemRepo.GetBySaleID(ctx, saleID)
+	if err != nil {
+		return fmt.Errorf("failed to get sale items: %w", err)
+	}
+
+	plan, err := planRestock(items, restock)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range restock {
+		if err := uc.productPort.UpdateStock(
+			ctx,
+			line.VariantID,
+			line.Quantity,
+			port.StockChange{
+				Reason: port.StockReasonOrderRefund,
+				SaleID: saleID,
+				Actor:  actor,
+			},
+		); err != nil {
+			return fmt.Errorf(
+				"failed to restore stock for variant %s: %w",
+				line.VariantID, err,
+			)
+		}
+
+		for _, part := range plan[i] {
+			if err := uc.saleItemRepo.AddRestocked(
+				ctx, part.itemID, part.quantity,
+			); err != nil {
+				return fmt.Errorf(
+					"failed to record restocked item: %w", err,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// restockPart is how much of a sale item a restock line puts back.
+type restockPart struct {
+	itemID   uuid.UUID
+	quantity int
+}
+
+// planRestock spreads each restock line over the items of its variant, as
+// an order may hold the same variant on several items. It fails if a line
+// asks for more than is left to restock.
+func planRestock(
+	items []orders.SaleItem,
+	restock []orders.RestockItem,
+) ([][]restockPart, error) {
+	plan := make([][]restockPart, len(restock))
+	for i, line := range restock {
+		found := false
+		left := line.Quantity
+		for _, item := range items {
+			if item.VariantID != line.VariantID {
+				continue
+			}
+			found = true
+			quantity := min(left, item.Quantity-item.RestockedQuantity)
+			if quantity <= 0 {
+				continue
+			}
+			plan[i] = append(plan[i], restockPart{
+				itemID:   item.ID,
+				quantity: quantity,
+			})
+			left -= quantity
+		}
+		if !found {
+			return nil, fmt.Errorf(
+				"%w: variant %s is not in the order",
+				orders.ErrInvalidRestock, line.VariantID,
+			)
+		}
+		if left > 0 {
+			return nil, fmt.Errorf(
+				"%w: only %d of variant %s can be restocked",
+				orders.ErrInvalidRestock, line.Quantity-left, line.VariantID,
+			)
+		}
+	}
+	return plan, nil
+}
+
+// mergeRestock adds up the restock lines of each variant, keeping the
+// order in which the variants were first listed.
+func mergeRestock(lines []orders.RestockItem) ([]orders.RestockItem, error) {
+	var merged []orders.RestockItem
+	index := make(map[uuid.UUID]int, len(lines))
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf(
+				"%w: quantity of variant %s must be positive",
+				orders.ErrInvalidRestock, line.VariantID,
+			)
+		}
+		if i, ok := index[line.VariantID]; ok {
+			merged[i].Quantity += line.Quantity
+			continue
+		}
+		index[line.VariantID] = len(merged)
+		merged = append(merged, line)
+	}
+	return merged, nil
+}
+
+// ListOrderRefundsUseCase returns the refunds of an order, oldest first.
+type ListOrderRefundsUseCase interface {
+	Execute(ctx context.Context, orderID uuid.UUID) ([]orders.RefundInfo, error)
+}
+
+type listOrderRefundsUseCase struct {
+	saleRepo    repository.SaleRepository
+	paymentPort port.PaymentPort
+}
+
+func NewListOrderRefundsUseCase(
+	saleRepo repository.SaleRepository,
+	paymentPort port.PaymentPort,
+) ListOrderRefundsUseCase {
+	return &listOrderRefundsUseCase{
+		saleRepo:    saleRepo,
+		paymentPort: paymentPort,
+	}
+}
+
+func (uc *listOrderRefundsUseCase) Execute(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]orders.RefundInfo, error) {
+	sale, err := uc.saleRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil {
+		return nil, orders.ErrOrderNotFound
+	}
+
+	refunds, err := uc.paymentPort.ListRefunds(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %w", err)
+	}
+
+	result := make([]orders.RefundInfo, 0, len(refunds))
+	for i := range refunds {
+		result = append(result, toRefundInfo(&refunds[i]))
+	}
+	return result, nil
+}
+
+func toRefundInfo(refund *port.Refund) orders.RefundInfo {
+	return orders.RefundInfo{
+		ID:        refund.ID,
+		PaymentID: refund.PaymentID,
+		Amount:    refund.Amount,
+		Reason:    refund.Reason,
+		Actor:     refund.Actor,
+		Channel:   refund.Channel,
+		Status:    refund.Status,
+		Reference: refund.Reference,
+		CreatedAt: refund.CreatedAt,
+	}
+}
@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/orders"
+	"dunhayat-api/internal/orders/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type UpdateOrderStatusUseCase interface {
+	Execute(
+		ctx context.Context,
+		staffID uuid.UUID,
+		orderID uuid.UUID,
+		req *orders.UpdateOrderStatusRequest,
+	) (*orders.UpdateOrderStatusResponse, error)
+}
+
+type updateOrderStatusUseCase struct {
+	transactor database.Transactor
+	saleRepo   repository.SaleRepository
+}
+
+func NewUpdateOrderStatusUseCase(
+	transactor database.Transactor,
+	saleRepo repository.SaleRepository,
+) UpdateOrderStatusUseCase {
+	return &updateOrderStatusUseCase{
+		transactor: transactor,
+		saleRepo:   saleRepo,
+	}
+}
+
+func (uc *updateOrderStatusUseCase) Execute(
+	ctx context.Context,
+	staffID uuid.UUID,
+	orderID uuid.UUID,
+	req *orders.UpdateOrderStatusRequest,
+) (*orders.UpdateOrderStatusResponse, error) {
+	switch req.Status {
+	case orders.OrderStatusShipped, orders.OrderStatusDelivered:
+	default:
+		return nil, orders.ErrStatusNotSettable
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "marked " + req.Status.String() + " by staff"
+	}
+
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.saleRepo.GetByIDForUpdate(ctx, orderID)
+		if err != nil {
+			return fmt.Errorf("failed to get sale: %w", err)
+		}
+		if sale == nil {
+			return orders.ErrOrderNotFound
+		}
+
+		if err := uc.saleRepo.UpdateStatus(
+			ctx,
+			sale.ID,
+			req.Status,
+			orders.StatusChange{
+				Actor:  orders.StaffActor(staffID),
+				Reason: reason,
+			},
+		); err != nil {
+			return fmt.Errorf("failed to update sale status: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &orders.UpdateOrderStatusResponse{
+		ID:     orderID,
+		Status: req.Status,
+	}, nil
+}
@@ -2,26 +2,41 @@ package adapter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"dunhayat-api/internal/orders/port"
 	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/repository"
 	"dunhayat-api/internal/payments/usecase"
 
 	"github.com/google/uuid"
 )
 
 type OrdersPaymentAdapter struct {
-	initiatePaymentUseCase usecase.InitiatePaymentUseCase
-	verifyPaymentUseCase   usecase.VerifyPaymentUseCase
+	initiatePaymentUseCase       usecase.InitiatePaymentUseCase
+	verifyPaymentUseCase         usecase.VerifyPaymentUseCase
+	cancelPendingPaymentsUseCase usecase.CancelPendingPaymentsUseCase
+	refundPaymentUseCase         usecase.RefundPaymentUseCase
+	listRefundsUseCase           usecase.ListRefundsUseCase
+	paymentRepo                  repository.PaymentRepository
 }
 
 func NewOrdersPaymentAdapter(
 	initiatePaymentUseCase usecase.InitiatePaymentUseCase,
 	verifyPaymentUseCase usecase.VerifyPaymentUseCase,
+	cancelPendingPaymentsUseCase usecase.CancelPendingPaymentsUseCase,
+	refundPaymentUseCase usecase.RefundPaymentUseCase,
+	listRefundsUseCase usecase.ListRefundsUseCase,
+	paymentRepo repository.PaymentRepository,
 ) port.PaymentPort {
 	return &OrdersPaymentAdapter{
-		initiatePaymentUseCase: initiatePaymentUseCase,
-		verifyPaymentUseCase:   verifyPaymentUseCase,
+		initiatePaymentUseCase:       initiatePaymentUseCase,
+		verifyPaymentUseCase:         verifyPaymentUseCase,
+		cancelPendingPaymentsUseCase: cancelPendingPaymentsUseCase,
+		refundPaymentUseCase:         refundPaymentUseCase,
+		listRefundsUseCase:           listRefundsUseCase,
+		paymentRepo:                  paymentRepo,
 	}
 }
 
@@ -33,8 +48,7 @@ func (s *OrdersPaymentAdapter) InitiatePayment(
 		OrderID:     req.OrderID,
 		UserID:      req.UserID,
 		Amount:      req.Amount,
-		Method:      payments.PaymentMethodZibal, // TODO: Shall be configurable
-		CallbackURL: req.CallbackURL,
+		Method:      payments.PaymentMethod(req.Method),
 		ReturnURL:   req.ReturnURL,
 		Description: req.Description,
 		Metadata:    req.Metadata,
@@ -42,6 +56,12 @@ func (s *OrdersPaymentAdapter) InitiatePayment(
 
 	paymentResp, err := s.initiatePaymentUseCase.Execute(ctx, paymentReq)
 	if err != nil {
+		if req.Method != "" &&
+			errors.Is(err, payments.ErrUnsupportedPaymentMethod) {
+			return nil, fmt.Errorf(
+				"%w: %s", port.ErrUnsupportedPaymentMethod, req.Method,
+			)
+		}
 		return nil, err
 	}
 
@@ -57,10 +77,12 @@ func (s *OrdersPaymentAdapter) InitiatePayment(
 
 func (s *OrdersPaymentAdapter) VerifyPayment(
 	ctx context.Context,
+	userID uuid.UUID,
 	paymentID uuid.UUID,
 ) (*port.VerifyPaymentResponse, error) {
 	paymentReq := &payments.VerifyPaymentRequest{
 		PaymentID: paymentID,
+		UserID:    userID,
 	}
 
 	paymentResp, err := s.verifyPaymentUseCase.Execute(ctx, paymentReq)
@@ -77,3 +99,104 @@ func (s *OrdersPaymentAdapter) VerifyPayment(
 		FailedAt:     paymentResp.FailedAt,
 	}, nil
 }
+
+func (s *OrdersPaymentAdapter) GetLatestPayment(
+	ctx context.Context,
+	orderID uuid.UUID,
+) (*port.Payment, error) {
+	pmt, err := s.paymentRepo.GetLatestByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if pmt == nil {
+		return nil, nil
+	}
+
+	result := &port.Payment{
+		ID:        pmt.ID,
+		Status:    pmt.Status.String(),
+		Amount:    pmt.Amount,
+		ExpiresAt: pmt.CreatedAt.Add(payments.PaymentExpiry),
+	}
+	if pmt.GatewayURL != nil {
+		result.GatewayURL = *pmt.GatewayURL
+	}
+	if pmt.GatewayRefID != nil {
+		result.GatewayRefID = *pmt.GatewayRefID
+	}
+
+	return result, nil
+}
+
+func (s *OrdersPaymentAdapter) CancelPendingPayments(
+	ctx context.Context,
+	orderID uuid.UUID,
+) error {
+	return s.cancelPendingPaymentsUseCase.Execute(ctx, orderID)
+}
+
+func (s *OrdersPaymentAdapter) RefundPayment(
+	ctx context.Context,
+	req *port.RefundPaymentRequest,
+) (*port.RefundPaymentResponse, error) {
+	result, err := s.refundPaymentUseCase.Execute(
+		ctx,
+		&payments.RefundPaymentRequest{
+			OrderID: req.OrderID,
+			Amount:  req.Amount,
+			Reason:  req.Reason,
+			Actor:   req.Actor,
+		},
+	)
+	if err != nil {
+		var exceeds *payments.RefundExceedsError
+		switch {
+		case errors.As(err, &exceeds):
+			return nil, fmt.Errorf(
+				"%w: requested %d, %d of %d Rials left to refund",
+				port.ErrRefundExceedsPayment,
+				exceeds.Requested, exceeds.Refundable, exceeds.Paid,
+			)
+		case errors.Is(err, payments.ErrNoRefundablePayment):
+			return nil, port.ErrNothingToRefund
+		case errors.Is(err, payments.ErrInvalidRefundAmount):
+			return nil, port.ErrInvalidRefundAmount
+		}
+		return nil, err
+	}
+
+	return &port.RefundPaymentResponse{
+		Refund:     toPortRefund(result.Refund),
+		Refundable: result.Refundable,
+	}, nil
+}
+
+func (s *OrdersPaymentAdapter) ListRefunds(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]port.Refund, error) {
+	refunds, err := s.listRefundsUseCase.Execute(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]port.Refund, 0, len(refunds))
+	for i := range refunds {
+		result = append(result, toPortRefund(&refunds[i]))
+	}
+	return result, nil
+}
+
+func toPortRefund(refund *payments.Refund) port.Refund {
+	return port.Refund{
+		ID:        refund.ID,
+		PaymentID: refund.PaymentID,
+		Amount:    refund.Amount,
+		Reason:    refund.Reason,
+		Actor:     refund.Actor,
+		Channel:   refund.Channel.String(),
+		Status:    refund.Status.String(),
+		Reference: refund.Reference,
+		CreatedAt: refund.CreatedAt,
+	}
+}
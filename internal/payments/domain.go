@@ -1,6 +1,8 @@
 package payments
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,23 +15,122 @@ const (
 	PaymentStatusPaid      PaymentStatus = "paid"
 	PaymentStatusFailed    PaymentStatus = "failed"
 	PaymentStatusCancelled PaymentStatus = "cancelled"
-	PaymentStatusRefunded  PaymentStatus = "refunded"
+	// PaymentStatusRefunded marks a paid payment whose whole amount was
+	// refunded; partly refunded payments stay paid
+	PaymentStatusRefunded PaymentStatus = "refunded"
 )
 
 func (s PaymentStatus) String() string {
 	return string(s)
 }
 
+// PaymentExpiry is how long a payment link stays usable at the gateway
+const PaymentExpiry = 30 * time.Minute
+
 type PaymentMethod string
 
+// A payment method is the name of the gateway that takes the payment
 const (
-	PaymentMethodZibal PaymentMethod = "zibal"
+	PaymentMethodZibal    PaymentMethod = "zibal"
+	PaymentMethodZarinPal PaymentMethod = "zarinpal"
 )
 
 func (m PaymentMethod) String() string {
 	return string(m)
 }
 
+type CallbackOutcome string
+
+const (
+	CallbackOutcomeProcessed CallbackOutcome = "processed"
+	CallbackOutcomeUnmatched CallbackOutcome = "unmatched"
+	CallbackOutcomeFailed    CallbackOutcome = "failed"
+	CallbackOutcomeFraud     CallbackOutcome = "fraud_alert"
+	// CallbackOutcomeDuplicate is a replay of a callback already processed
+	CallbackOutcomeDuplicate CallbackOutcome = "duplicate"
+	// CallbackOutcomeIgnored is a callback for a payment that was already
+	// settled, such as a late "failed" after a successful verification
+	CallbackOutcomeIgnored CallbackOutcome = "ignored"
+)
+
+func (o CallbackOutcome) String() string {
+	return string(o)
+}
+
+// ReconciliationAction is what reconciliation did about a payment whose
+// state differed from the gateway's.
+type ReconciliationAction string
+
+const (
+	// ReconciliationSettled means the gateway had the payment paid and it
+	// was verified
+	ReconciliationSettled ReconciliationAction = "settled"
+	// ReconciliationFailed means the gateway had the payment failed
+	ReconciliationFailed ReconciliationAction = "failed"
+	// ReconciliationReleased means the payment was abandoned: it was
+	// cancelled and the stock of its order released
+	ReconciliationReleased ReconciliationAction = "released"
+	// ReconciliationFlagged means the difference needs a person, such as
+	// money taken for an order that was cancelled meanwhile
+	ReconciliationFlagged ReconciliationAction = "flagged"
+	// ReconciliationError means the gateway could not be asked; the
+	// payment is checked again on the next run
+	ReconciliationError ReconciliationAction = "error"
+)
+
+func (a ReconciliationAction) String() string {
+	return string(a)
+}
+
+// RefundChannel is how the money of a refund goes back to the customer.
+type RefundChannel string
+
+const (
+	// RefundChannelGateway means the gateway returned the money to the
+	// card the payment was made with
+	RefundChannelGateway RefundChannel = "gateway"
+	// RefundChannelManual means the gateway has no refund API, so staff
+	// return the money by bank transfer
+	RefundChannelManual RefundChannel = "manual_bank_transfer"
+)
+
+func (c RefundChannel) String() string {
+	return string(c)
+}
+
+type RefundStatus string
+
+const (
+	// RefundStatusPending is a refund whose money has not gone back yet:
+	// a manual refund awaiting its bank transfer, or a gateway refund
+	// still being made
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusCompleted RefundStatus = "completed"
+	// RefundStatusFailed is a gateway refund the gateway refused; it does
+	// not count towards what was refunded
+	RefundStatusFailed RefundStatus = "failed"
+)
+
+func (s RefundStatus) String() string {
+	return string(s)
+}
+
+var (
+	ErrUnsupportedPaymentMethod = errors.New("unsupported payment method")
+	ErrPaymentNotFound          = errors.New("payment not found")
+	ErrPaymentInProgress        = errors.New("order already has a pending payment")
+	ErrInvalidReturnURL         = errors.New("return URL is not allowed")
+	ErrOrderNotFound            = errors.New("order not found")
+	ErrReconciliationNotFound   = errors.New("reconciliation not found")
+	ErrNoRefundablePayment      = errors.New("no paid payment to refund")
+	ErrRefundExceedsPayment     = errors.New("refund exceeds the refundable amount")
+	ErrInvalidRefundAmount      = errors.New("refund amount must be positive")
+	ErrRefundNotFound           = errors.New("refund not found")
+	ErrRefundNotPending         = errors.New("refund is not pending")
+	ErrRefundUnconfirmed        = errors.New("gateway refund outcome is unknown")
+	ErrRefundReferenceRequired  = errors.New("bank transfer reference is required")
+)
+
 type Payment struct {
 	ID           uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	OrderID      uuid.UUID     `json:"order_id" gorm:"type:uuid;not null"`
@@ -45,25 +146,125 @@ type Payment struct {
 	Metadata     *string       `json:"metadata,omitempty" gorm:"type:jsonb"`
 	PaidAt       *time.Time    `json:"paid_at,omitempty"`
 	FailedAt     *time.Time    `json:"failed_at,omitempty"`
-	CreatedAt    time.Time     `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt    time.Time     `json:"updated_at" gorm:"autoUpdateTime"`
+	// ReconciledAt is when the payment was last found to agree with the
+	// gateway, after which reconciliation leaves it alone
+	ReconciledAt *time.Time `json:"reconciled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
 type PaymentCallback struct {
-	ID          uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	PaymentID   uuid.UUID     `json:"payment_id" gorm:"type:uuid;not null"`
-	GatewayData string        `json:"gateway_data" gorm:"type:jsonb;not null"`
-	Status      PaymentStatus `json:"status" gorm:"type:varchar(50);not null"`
-	ProcessedAt time.Time     `json:"processed_at" gorm:"autoCreateTime"`
-	CreatedAt   time.Time     `json:"created_at" gorm:"autoCreateTime"`
+	ID        uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PaymentID *uuid.UUID    `json:"payment_id,omitempty" gorm:"type:uuid"`
+	Method    PaymentMethod `json:"method" gorm:"type:varchar(50);not null"`
+	TrackID   string        `json:"track_id" gorm:"type:varchar(255);not null"`
+	// GatewayStatus is the status the gateway reported in the callback
+	GatewayStatus string          `json:"gateway_status" gorm:"type:varchar(50);not null"`
+	GatewayData   string          `json:"gateway_data" gorm:"type:jsonb;not null"`
+	Status        PaymentStatus   `json:"status" gorm:"type:varchar(50);not null"`
+	Outcome       CallbackOutcome `json:"outcome" gorm:"type:varchar(50);not null"`
+	Error         *string         `json:"error,omitempty" gorm:"type:text"`
+	ProcessedAt   time.Time       `json:"processed_at" gorm:"autoCreateTime"`
+	CreatedAt     time.Time       `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// FraudAlert records a gateway verification whose amount or order does not
+// match the sale it claims to pay for.
+type FraudAlert struct {
+	ID              uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PaymentID       uuid.UUID `json:"payment_id" gorm:"type:uuid;not null"`
+	OrderID         uuid.UUID `json:"order_id" gorm:"type:uuid;not null"`
+	TrackID         string    `json:"track_id" gorm:"type:varchar(255);not null"`
+	ExpectedAmount  int       `json:"expected_amount" gorm:"not null"`
+	VerifiedAmount  int       `json:"verified_amount" gorm:"not null"`
+	ExpectedOrderID string    `json:"expected_order_id" gorm:"type:varchar(255);not null"`
+	VerifiedOrderID string    `json:"verified_order_id" gorm:"type:varchar(255);not null"`
+	Reason          string    `json:"reason" gorm:"type:text;not null"`
+	CreatedAt       time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// RefundExceedsError is returned for a refund larger than what is left of
+// the payment.
+type RefundExceedsError struct {
+	Requested  int
+	Refundable int
+	Paid       int
+}
+
+func (e *RefundExceedsError) Error() string {
+	return fmt.Sprintf(
+		"%s: requested %d, %d of %d Rials left to refund",
+		ErrRefundExceedsPayment, e.Requested, e.Refundable, e.Paid,
+	)
+}
+
+func (e *RefundExceedsError) Is(target error) bool {
+	return target == ErrRefundExceedsPayment
+}
+
+// Refund returns part or all of a paid payment. The refunds of a payment
+// never add up to more than its amount.
+type Refund struct {
+	ID        uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PaymentID uuid.UUID     `json:"payment_id" gorm:"type:uuid;not null"`
+	OrderID   uuid.UUID     `json:"order_id" gorm:"type:uuid;not null"`
+	Amount    int           `json:"amount" gorm:"not null;check:amount > 0"`
+	Reason    string        `json:"reason" gorm:"type:text;not null"`
+	Actor     string        `json:"actor" gorm:"type:varchar(100);not null"`
+	Channel   RefundChannel `json:"channel" gorm:"type:varchar(50);not null"`
+	Status    RefundStatus  `json:"status" gorm:"type:varchar(50);not null"`
+	// Reference is the gateway's refund ID, or the bank transfer's
+	// reference once a manual refund is completed
+	Reference   *string    `json:"reference,omitempty" gorm:"type:varchar(255)"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Reconciliation is the report of one run comparing payments with their
+// gateways. Items lists the payments that differed.
+type Reconciliation struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StartedAt  time.Time  `json:"started_at" gorm:"not null"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Checked counts the payments asked about, and the other counts the
+	// items by action
+	Checked   int                  `json:"checked" gorm:"not null"`
+	Settled   int                  `json:"settled" gorm:"not null"`
+	Failed    int                  `json:"failed" gorm:"not null"`
+	Released  int                  `json:"released" gorm:"not null"`
+	Flagged   int                  `json:"flagged" gorm:"not null"`
+	Errors    int                  `json:"errors" gorm:"not null"`
+	Items     []ReconciliationItem `json:"items,omitempty" gorm:"foreignKey:ReconciliationID"`
+	CreatedAt time.Time            `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ReconciliationItem is a payment whose state differed from the gateway's.
+type ReconciliationItem struct {
+	ID               uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReconciliationID uuid.UUID     `json:"reconciliation_id" gorm:"type:uuid;not null"`
+	PaymentID        uuid.UUID     `json:"payment_id" gorm:"type:uuid;not null"`
+	OrderID          uuid.UUID     `json:"order_id" gorm:"type:uuid;not null"`
+	Method           PaymentMethod `json:"method" gorm:"type:varchar(50);not null"`
+	GatewayRefID     *string       `json:"gateway_ref_id,omitempty" gorm:"type:varchar(255)"`
+	// LocalStatus is the status of the payment before reconciliation, and
+	// GatewayStatus what the gateway reported
+	LocalStatus   PaymentStatus        `json:"local_status" gorm:"type:varchar(50);not null"`
+	GatewayStatus string               `json:"gateway_status" gorm:"type:varchar(50);not null"`
+	Amount        int                  `json:"amount" gorm:"not null"`
+	GatewayAmount *int                 `json:"gateway_amount,omitempty"`
+	Action        ReconciliationAction `json:"action" gorm:"type:varchar(50);not null"`
+	Detail        string               `json:"detail" gorm:"type:text;not null"`
+	CreatedAt     time.Time            `json:"created_at" gorm:"autoCreateTime"`
 }
 
 type InitiatePaymentRequest struct {
-	OrderID     uuid.UUID      `json:"order_id" binding:"required"`
-	UserID      uuid.UUID      `json:"user_id" binding:"required"`
-	Amount      int            `json:"amount" binding:"required,min=1"`
-	Method      PaymentMethod  `json:"method" binding:"required"`
-	CallbackURL string         `json:"callback_url" binding:"required"`
+	OrderID uuid.UUID `json:"order_id" binding:"required"`
+	UserID  uuid.UUID `json:"user_id" binding:"required"`
+	Amount  int       `json:"amount" binding:"required,min=1"`
+	// Method picks the gateway. Without it the default gateway is used,
+	// falling over to the other enabled gateways when it is down.
+	Method      PaymentMethod  `json:"method,omitempty"`
 	ReturnURL   string         `json:"return_url" binding:"required"`
 	Description string         `json:"description"`
 	Metadata    map[string]any `json:"metadata,omitempty"`
@@ -80,6 +281,8 @@ type InitiatePaymentResponse struct {
 
 type VerifyPaymentRequest struct {
 	PaymentID uuid.UUID `json:"payment_id" binding:"required"`
+	// UserID is the caller; payments of other users are not found
+	UserID uuid.UUID `json:"-"`
 }
 
 type VerifyPaymentResponse struct {
@@ -91,14 +294,23 @@ type VerifyPaymentResponse struct {
 	FailedAt     *time.Time    `json:"failed_at,omitempty"`
 }
 
+// PaymentCallbackRequest is a gateway callback as it arrived: the query
+// and body parameters together, read by the gateway of Method.
 type PaymentCallbackRequest struct {
-	Success          bool   `json:"success"`
-	Status           int    `json:"status"`
-	TrackID          string `json:"trackId"`
-	OrderID          string `json:"orderId"`
-	Amount           int    `json:"amount"`
-	CardNumber       string `json:"cardNumber"`
-	HashedCardNumber string `json:"hashedCardNumber"`
+	Method PaymentMethod
+	Params map[string]string
+	// Raw holds the callback body exactly as the gateway sent it, if any
+	Raw []byte
+}
+
+// PaymentCallbackResponse is the payment a callback was matched to, as it
+// stands after the callback was handled.
+type PaymentCallbackResponse struct {
+	PaymentID uuid.UUID     `json:"payment_id"`
+	OrderID   uuid.UUID     `json:"order_id"`
+	Status    PaymentStatus `json:"status"`
+	// ReturnURL is where the customer is sent back to once paying is over
+	ReturnURL string `json:"-"`
 }
 
 type GetPaymentStatusRequest struct {
@@ -116,6 +328,26 @@ type GetPaymentStatusResponse struct {
 	UpdatedAt    time.Time     `json:"updated_at"`
 }
 
+// RefundPaymentRequest refunds the paid payment of an order. Without an
+// Amount whatever is left of the payment is refunded.
+type RefundPaymentRequest struct {
+	OrderID uuid.UUID `json:"order_id"`
+	Amount  int       `json:"amount,omitempty"`
+	Reason  string    `json:"reason"`
+	Actor   string    `json:"actor"`
+}
+
+type RefundPaymentResponse struct {
+	Refund *Refund `json:"refund"`
+	// Refundable is what is left of the payment after this refund
+	Refundable int `json:"refundable"`
+}
+
+// CompleteRefundRequest records the bank transfer of a manual refund.
+type CompleteRefundRequest struct {
+	Reference string `json:"reference"`
+}
+
 func (Payment) TableName() string {
 	return "payments"
 }
@@ -123,3 +355,19 @@ func (Payment) TableName() string {
 func (PaymentCallback) TableName() string {
 	return "payment_callbacks"
 }
+
+func (FraudAlert) TableName() string {
+	return "payment_fraud_alerts"
+}
+
+func (Refund) TableName() string {
+	return "payment_refunds"
+}
+
+func (Reconciliation) TableName() string {
+	return "payment_reconciliations"
+}
+
+func (ReconciliationItem) TableName() string {
+	return "payment_reconciliation_items"
+}
@@ -1,10 +1,21 @@
 package http
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"dunhayat-api/internal/auth/http"
 	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
 	"dunhayat-api/internal/payments/usecase"
+	"dunhayat-api/pkg/payment"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type PaymentHandler struct {
@@ -12,6 +23,12 @@ type PaymentHandler struct {
 	verifyPaymentUseCase    usecase.VerifyPaymentUseCase
 	handleCallbackUseCase   usecase.HandleCallbackUseCase
 	getPaymentStatusUseCase usecase.GetPaymentStatusUseCase
+	listCallbacksUseCase    usecase.ListPaymentCallbacksUseCase
+	reconcileUseCase        usecase.ReconcilePaymentsUseCase
+	listReconciliations     usecase.ListReconciliationsUseCase
+	getReconciliation       usecase.GetReconciliationUseCase
+	completeRefundUseCase   usecase.CompleteRefundUseCase
+	voidRefundUseCase       usecase.VoidRefundUseCase
 }
 
 func NewPaymentHandler(
@@ -19,25 +36,56 @@ func NewPaymentHandler(
 	verifyPaymentUseCase usecase.VerifyPaymentUseCase,
 	handleCallbackUseCase usecase.HandleCallbackUseCase,
 	getPaymentStatusUseCase usecase.GetPaymentStatusUseCase,
+	listCallbacksUseCase usecase.ListPaymentCallbacksUseCase,
+	reconcileUseCase usecase.ReconcilePaymentsUseCase,
+	listReconciliations usecase.ListReconciliationsUseCase,
+	getReconciliation usecase.GetReconciliationUseCase,
+	completeRefundUseCase usecase.CompleteRefundUseCase,
+	voidRefundUseCase usecase.VoidRefundUseCase,
 ) *PaymentHandler {
 	return &PaymentHandler{
 		initiatePaymentUseCase:  initiatePaymentUseCase,
 		verifyPaymentUseCase:    verifyPaymentUseCase,
 		handleCallbackUseCase:   handleCallbackUseCase,
 		getPaymentStatusUseCase: getPaymentStatusUseCase,
+		listCallbacksUseCase:    listCallbacksUseCase,
+		reconcileUseCase:        reconcileUseCase,
+		listReconciliations:     listReconciliations,
+		getReconciliation:       getReconciliation,
+		completeRefundUseCase:   completeRefundUseCase,
+		voidRefundUseCase:       voidRefundUseCase,
 	}
 }
 
 func (h *PaymentHandler) InitiatePayment(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
 	var req payments.InitiatePaymentRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body: " + err.Error(),
 		})
 	}
+	req.UserID = userID
 
 	response, err := h.initiatePaymentUseCase.Execute(c.Context(), &req)
 	if err != nil {
+		if errors.Is(err, payments.ErrUnsupportedPaymentMethod) ||
+			errors.Is(err, payments.ErrInvalidReturnURL) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, payments.ErrPaymentInProgress) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
@@ -50,15 +98,33 @@ func (h *PaymentHandler) InitiatePayment(c *fiber.Ctx) error {
 }
 
 func (h *PaymentHandler) VerifyPayment(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
 	var req payments.VerifyPaymentRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body: " + err.Error(),
 		})
 	}
+	req.UserID = userID
 
 	response, err := h.verifyPaymentUseCase.Execute(c.Context(), &req)
 	if err != nil {
+		if errors.Is(err, payments.ErrPaymentNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, port.ErrInvalidStatusTransition) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
@@ -71,15 +137,49 @@ func (h *PaymentHandler) VerifyPayment(c *fiber.Ctx) error {
 }
 
 func (h *PaymentHandler) HandleCallback(c *fiber.Ctx) error {
-	var callbackData payments.PaymentCallbackRequest
-	if err := c.BodyParser(&callbackData); err != nil {
+	params, err := callbackParams(c)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid callback data: " + err.Error(),
 		})
 	}
 
-	err := h.handleCallbackUseCase.Execute(c.Context(), callbackData)
+	callbackData := payments.PaymentCallbackRequest{
+		Method: payments.PaymentMethod(
+			c.Params("method", payments.PaymentMethodZibal.String()),
+		),
+		Params: params,
+		Raw:    append([]byte(nil), c.Body()...),
+	}
+
+	response, err := h.handleCallbackUseCase.Execute(
+		c.Context(), callbackData,
+	)
+
+	// GET callbacks are the customer's browser coming back from the
+	// gateway, so it is sent back to the shop with the outcome, even when
+	// settling failed and the payment is still pending
+	if c.Method() == fiber.MethodGet && response != nil {
+		if target, ok := returnURL(response); ok {
+			return c.Redirect(target, fiber.StatusSeeOther)
+		}
+	}
+
 	if err != nil {
+		switch {
+		case errors.Is(err, port.ErrInvalidStatusTransition):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, payments.ErrUnsupportedPaymentMethod):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, payment.ErrInvalidCallback):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
@@ -87,7 +187,61 @@ func (h *PaymentHandler) HandleCallback(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Callback processed successfully",
+		"data":    response,
+	})
+}
+
+// returnURL is the payment's return URL with the order and the payment
+// status added to its query.
+func returnURL(response *payments.PaymentCallbackResponse) (string, bool) {
+	if response.ReturnURL == "" {
+		return "", false
+	}
+
+	target, err := url.Parse(response.ReturnURL)
+	if err != nil {
+		return "", false
+	}
+
+	query := target.Query()
+	query.Set("order_id", response.OrderID.String())
+	query.Set("status", response.Status.String())
+	target.RawQuery = query.Encode()
+
+	return target.String(), true
+}
+
+// callbackParams gathers the query parameters and the JSON or form body of
+// a callback, as gateways use either; body values win over query ones.
+func callbackParams(c *fiber.Ctx) (map[string]string, error) {
+	params := c.Queries()
+
+	body := c.Body()
+	if len(bytes.TrimSpace(body)) == 0 {
+		return params, nil
+	}
+
+	if strings.HasPrefix(
+		c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON,
+	) {
+		var values map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if value != nil {
+				params[key] = fmt.Sprint(value)
+			}
+		}
+		return params, nil
+	}
+
+	c.Request().PostArgs().VisitAll(func(key, value []byte) {
+		params[string(key)] = string(value)
 	})
+	return params, nil
 }
 
 func (h *PaymentHandler) GetPaymentStatus(c *fiber.Ctx) error {
@@ -121,3 +275,172 @@ func (h *PaymentHandler) GetPaymentStatus(c *fiber.Ctx) error {
 		"data":    response,
 	})
 }
+
+func (h *PaymentHandler) ListCallbacks(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	callbacks, err := h.listCallbacksUseCase.Execute(
+		c.Context(), userID, orderID,
+	)
+	if err != nil {
+		if errors.Is(err, payments.ErrOrderNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get payment callbacks",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data":  callbacks,
+		"count": len(callbacks),
+	})
+}
+
+func (h *PaymentHandler) RunReconciliation(c *fiber.Ctx) error {
+	reconciliation, err := h.reconcileUseCase.Execute(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Payments reconciled",
+		"data":    reconciliation,
+	})
+}
+
+func (h *PaymentHandler) ListReconciliations(c *fiber.Ctx) error {
+	reconciliations, err := h.listReconciliations.Execute(
+		c.Context(), c.QueryInt("limit"),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data":  reconciliations,
+		"count": len(reconciliations),
+	})
+}
+
+func (h *PaymentHandler) GetReconciliation(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid reconciliation ID",
+		})
+	}
+
+	reconciliation, err := h.getReconciliation.Execute(c.Context(), id)
+	if err != nil {
+		if errors.Is(err, payments.ErrReconciliationNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": reconciliation,
+	})
+}
+
+// CompleteRefund records the bank transfer of a refund the gateway could
+// not make, or the reference of a gateway refund whose outcome was unknown.
+func (h *PaymentHandler) CompleteRefund(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid refund ID",
+		})
+	}
+
+	var req payments.CompleteRefundRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	refund, err := h.completeRefundUseCase.Execute(c.Context(), id, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrRefundNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, payments.ErrRefundReferenceRequired):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, payments.ErrRefundNotPending):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Refund completed",
+		"data":    refund,
+	})
+}
+
+// VoidRefund records that a pending refund was never made, so that its
+// amount can be refunded again.
+func (h *PaymentHandler) VoidRefund(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid refund ID",
+		})
+	}
+
+	refund, err := h.voidRefundUseCase.Execute(c.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrRefundNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, payments.ErrRefundNotPending):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Refund voided",
+		"data":    refund,
+	})
+}
@@ -2,6 +2,7 @@ package port
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,6 +21,8 @@ func (s OrderStatus) String() string {
 	return string(s)
 }
 
+var ErrInvalidStatusTransition = errors.New("invalid order status transition")
+
 type Sale struct {
 	ID           uuid.UUID   `json:"id"`
 	UserID       uuid.UUID   `json:"user_id"`
@@ -32,7 +35,15 @@ type Sale struct {
 
 type OrderPort interface {
 	GetSaleByID(ctx context.Context, saleID uuid.UUID) (*Sale, error)
-	GetSaleByTrackingCode(ctx context.Context, trackingCode string) (*Sale, error)
-	UpdateSaleStatus(ctx context.Context, saleID uuid.UUID, status OrderStatus) error
-	SetSaleTrackingCode(ctx context.Context, saleID uuid.UUID, trackingCode string) error
+	// LockSale loads a sale and locks its row until the surrounding
+	// transaction ends. The orders slice locks a sale before the payments
+	// of its order, so payments must lock the sale first as well.
+	LockSale(ctx context.Context, saleID uuid.UUID) (*Sale, error)
+	// UpdateSaleStatus returns ErrInvalidStatusTransition when the sale
+	// cannot move from its current status to the given one
+	UpdateSaleStatus(ctx context.Context, saleID uuid.UUID, status OrderStatus, reason string) error
+	// ReleaseSale cancels a sale still awaiting payment and hands its
+	// reserved stock back. It reports whether the sale was cancelled;
+	// sales in any other status are left alone.
+	ReleaseSale(ctx context.Context, saleID uuid.UUID, reason string) (bool, error)
 }
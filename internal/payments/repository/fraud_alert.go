@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type FraudAlertRepository interface {
+	Create(ctx context.Context, alert *payments.FraudAlert) error
+	GetByPaymentID(ctx context.Context, paymentID uuid.UUID) ([]payments.FraudAlert, error)
+}
+
+type postgresFraudAlertRepository struct {
+	db *gorm.DB
+}
+
+func NewFraudAlertRepository(db *gorm.DB) FraudAlertRepository {
+	return &postgresFraudAlertRepository{db: db}
+}
+
+func (r *postgresFraudAlertRepository) Create(
+	ctx context.Context,
+	alert *payments.FraudAlert,
+) error {
+	return database.Conn(ctx, r.db).Create(alert).Error
+}
+
+func (r *postgresFraudAlertRepository) GetByPaymentID(
+	ctx context.Context,
+	paymentID uuid.UUID,
+) ([]payments.FraudAlert, error) {
+	var alerts []payments.FraudAlert
+	err := database.Conn(ctx, r.db).Where(
+		"payment_id = ?", paymentID,
+	).Order("created_at ASC").Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PaymentRepository interface {
+	Create(ctx context.Context, payment *payments.Payment) error
+	GetByID(ctx context.Context, id uuid.UUID) (*payments.Payment, error)
+	// GetByIDForUpdate returns a payment and locks it until the transaction
+	// ends
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*payments.Payment, error)
+	// GetByGatewayRefID finds a payment by the gateway reference (e.g.,
+	// Zibal trackId) of the gateway of method; an empty method matches any
+	// gateway
+	GetByGatewayRefID(ctx context.Context, method payments.PaymentMethod, gatewayRefID string) (*payments.Payment, error)
+	GetByOrderID(ctx context.Context, orderID uuid.UUID) ([]payments.Payment, error)
+	// GetLatestByOrderID returns the most recent payment attempt of an order
+	GetLatestByOrderID(ctx context.Context, orderID uuid.UUID) (*payments.Payment, error)
+	// GetPaidByOrderIDForUpdate returns the latest paid payment of an order
+	// and locks it until the transaction ends, or nil if there is none
+	GetPaidByOrderIDForUpdate(ctx context.Context, orderID uuid.UUID) (*payments.Payment, error)
+	// GetUnreconciled returns, oldest first, the payments created before
+	// createdBefore that reconciliation has not settled: pending ones, and
+	// cancelled or failed ones created after checkedSince
+	GetUnreconciled(ctx context.Context, createdBefore, checkedSince time.Time, limit int) ([]payments.Payment, error)
+	Update(ctx context.Context, payment *payments.Payment) error
+}
+
+type PaymentCallbackRepository interface {
+	Create(ctx context.Context, callback *payments.PaymentCallback) error
+	GetByPaymentID(ctx context.Context, paymentID uuid.UUID) ([]payments.PaymentCallback, error)
+	// GetByOrderID returns the callbacks of every payment attempt of an order
+	GetByOrderID(ctx context.Context, orderID uuid.UUID) ([]payments.PaymentCallback, error)
+	// HasProcessed reports whether a callback with the same track ID and
+	// gateway status was already processed
+	HasProcessed(ctx context.Context, method payments.PaymentMethod, trackID string, gatewayStatus string) (bool, error)
+}
+
+type postgresPaymentRepository struct {
+	db *gorm.DB
+}
+
+type postgresPaymentCallbackRepository struct {
+	db *gorm.DB
+}
+
+func NewPaymentRepository(db *gorm.DB) PaymentRepository {
+	return &postgresPaymentRepository{db: db}
+}
+
+func NewPaymentCallbackRepository(db *gorm.DB) PaymentCallbackRepository {
+	return &postgresPaymentCallbackRepository{db: db}
+}
+
+func (r *postgresPaymentRepository) Create(
+	ctx context.Context,
+	payment *payments.Payment,
+) error {
+	return database.Conn(ctx, r.db).Create(payment).Error
+}
+
+func (r *postgresPaymentRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Payment, error) {
+	var payment payments.Payment
+	err := database.Conn(ctx, r.db).Where("id = ?", id).First(&payment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *postgresPaymentRepository) GetByIDForUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Payment, error) {
+	var payment payments.Payment
+	err := database.Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		First(&payment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *postgresPaymentRepository) GetByGatewayRefID(
+	ctx context.Context,
+	method payments.PaymentMethod,
+	gatewayRefID string,
+) (*payments.Payment, error) {
+	query := database.Conn(ctx, r.db).Where(
+		"gateway_ref_id = ?", gatewayRefID,
+	)
+	if method != "" {
+		query = query.Where("method = ?", method)
+	}
+
+	var payment payments.Payment
+	err := query.Order("created_at DESC").First(&payment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *postgresPaymentRepository) GetByOrderID(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]payments.Payment, error) {
+	var paymentList []payments.Payment
+	err := database.Conn(ctx, r.db).Where(
+		"order_id = ?", orderID,
+	).Order("created_at DESC").Find(&paymentList).Error
+	if err != nil {
+		return nil, err
+	}
+	return paymentList, nil
+}
+
+func (r *postgresPaymentRepository) GetLatestByOrderID(
+	ctx context.Context,
+	orderID uuid.UUID,
+) (*payments.Payment, error) {
+	var payment payments.Payment
+	err := database.Conn(ctx, r.db).Where(
+		"order_id = ?", orderID,
+	).Order("created_at DESC").First(&payment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *postgresPaymentRepository) GetPaidByOrderIDForUpdate(
+	ctx context.Context,
+	orderID uuid.UUID,
+) (*payments.Payment, error) {
+	var payment payments.Payment
+	err := database.Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", orderID, payments.PaymentStatusPaid).
+		Order("created_at DESC").
+		First(&payment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *postgresPaymentRepository) GetUnreconciled(
+	ctx context.Context,
+	createdBefore time.Time,
+	checkedSince time.Time,
+	limit int,
+) ([]payments.Payment, error) {
+	var paymentList []payments.Payment
+	err := database.Conn(ctx, r.db).
+		Where("reconciled_at IS NULL AND created_at < ?", createdBefore).
+		Where(
+			"status = ? OR (status IN ? AND created_at > ?)",
+			payments.PaymentStatusPending,
+			[]payments.PaymentStatus{
+				payments.PaymentStatusCancelled,
+				payments.PaymentStatusFailed,
+			},
+			checkedSince,
+		).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&paymentList).Error
+	if err != nil {
+		return nil, err
+	}
+	return paymentList, nil
+}
+
+func (r *postgresPaymentRepository) Update(
+	ctx context.Context,
+	payment *payments.Payment,
+) error {
+	return database.Conn(ctx, r.db).Save(payment).Error
+}
+
+func (r *postgresPaymentCallbackRepository) Create(
+	ctx context.Context,
+	callback *payments.PaymentCallback,
+) error {
+	return database.Conn(ctx, r.db).Create(callback).Error
+}
+
+func (r *postgresPaymentCallbackRepository) GetByPaymentID(
+	ctx context.Context,
+	paymentID uuid.UUID,
+) ([]payments.PaymentCallback, error) {
+	var callbacks []payments.PaymentCallback
+	err := database.Conn(ctx, r.db).Where(
+		"payment_id = ?", paymentID,
+	).Order("created_at ASC").Find(&callbacks).Error
+	if err != nil {
+		return nil, err
+	}
+	return callbacks, nil
+}
+
+func (r *postgresPaymentCallbackRepository) GetByOrderID(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]payments.PaymentCallback, error) {
+	var callbacks []payments.PaymentCallback
+	err := database.Conn(ctx, r.db).
+		Joins("JOIN payments ON payments.id = payment_callbacks.payment_id").
+		Where("payments.order_id = ?", orderID).
+		Order("payment_callbacks.created_at ASC").
+		Find(&callbacks).Error
+	if err != nil {
+		return nil, err
+	}
+	return callbacks, nil
+}
+
+func (r *postgresPaymentCallbackRepository) HasProcessed(
+	ctx context.Context,
+	method payments.PaymentMethod,
+	trackID string,
+	gatewayStatus string,
+) (bool, error) {
+	var count int64
+	err := database.Conn(ctx, r.db).
+		Model(&payments.PaymentCallback{}).
+		Where(
+			"method = ? AND track_id = ? AND gateway_status = ?",
+			method, trackID, gatewayStatus,
+		).
+		Where("outcome IN ?", []payments.CallbackOutcome{
+			payments.CallbackOutcomeProcessed,
+			payments.CallbackOutcomeFraud,
+		}).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/database/databasetest"
+)
+
+// Replays of a callback recorded at the same time are refused by
+// idx_payment_callbacks_processed once one of them is processed, even
+// though they all passed HasProcessed before any was stored.
+func TestPaymentCallbackProcessedOnceUnderConcurrentReplays(t *testing.T) {
+	db := databasetest.Open(t)
+	repo := NewPaymentCallbackRepository(db)
+	ctx := context.Background()
+
+	const replays = 10
+	for range replays {
+		processed, err := repo.HasProcessed(
+			ctx, payments.PaymentMethodZibal, "3000000001", "2",
+		)
+		if err != nil {
+			t.Fatalf("HasProcessed failed: %v", err)
+		}
+		if processed {
+			t.Fatal("callback reported processed before any was stored")
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, replays)
+	for range replays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Create(ctx, &payments.PaymentCallback{
+				Method:        payments.PaymentMethodZibal,
+				TrackID:       "3000000001",
+				GatewayStatus: "2",
+				GatewayData:   `{"trackId": 3000000001, "status": 2}`,
+				Status:        payments.PaymentStatusPaid,
+				Outcome:       payments.CallbackOutcomeProcessed,
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	stored := 0
+	for err := range errs {
+		if err == nil {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Fatalf("%d processed callbacks stored, want 1", stored)
+	}
+
+	processed, err := repo.HasProcessed(
+		ctx, payments.PaymentMethodZibal, "3000000001", "2",
+	)
+	if err != nil {
+		t.Fatalf("HasProcessed failed: %v", err)
+	}
+	if !processed {
+		t.Error("stored callback not reported as processed")
+	}
+
+	// Replays are still recorded under their own outcome
+	if err := repo.Create(ctx, &payments.PaymentCallback{
+		Method:        payments.PaymentMethodZibal,
+		TrackID:       "3000000001",
+		GatewayStatus: "2",
+		GatewayData:   `{"trackId": 3000000001, "status": 2}`,
+		Status:        payments.PaymentStatusPaid,
+		Outcome:       payments.CallbackOutcomeDuplicate,
+	}); err != nil {
+		t.Errorf("failed to record a duplicate callback: %v", err)
+	}
+}
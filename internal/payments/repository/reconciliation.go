@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ReconciliationRepository interface {
+	// Create stores a report together with its items
+	Create(ctx context.Context, reconciliation *payments.Reconciliation) error
+	// List returns the latest reports first, without their items
+	List(ctx context.Context, limit int) ([]payments.Reconciliation, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*payments.Reconciliation, error)
+}
+
+type postgresReconciliationRepository struct {
+	db *gorm.DB
+}
+
+func NewReconciliationRepository(db *gorm.DB) ReconciliationRepository {
+	return &postgresReconciliationRepository{db: db}
+}
+
+func (r *postgresReconciliationRepository) Create(
+	ctx context.Context,
+	reconciliation *payments.Reconciliation,
+) error {
+	return database.Conn(ctx, r.db).Create(reconciliation).Error
+}
+
+func (r *postgresReconciliationRepository) List(
+	ctx context.Context,
+	limit int,
+) ([]payments.Reconciliation, error) {
+	var reconciliations []payments.Reconciliation
+	err := database.Conn(ctx, r.db).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&reconciliations).Error
+	if err != nil {
+		return nil, err
+	}
+	return reconciliations, nil
+}
+
+func (r *postgresReconciliationRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Reconciliation, error) {
+	var reconciliation payments.Reconciliation
+	err := database.Conn(ctx, r.db).
+		Preload("Items", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Where("id = ?", id).
+		First(&reconciliation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reconciliation, nil
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RefundRepository interface {
+	Create(ctx context.Context, refund *payments.Refund) error
+	GetByID(ctx context.Context, id uuid.UUID) (*payments.Refund, error)
+	// GetByIDForUpdate returns a refund and locks it until the transaction
+	// ends
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*payments.Refund, error)
+	// GetByOrderID returns the refunds of an order, oldest first
+	GetByOrderID(ctx context.Context, orderID uuid.UUID) ([]payments.Refund, error)
+	// SumByPaymentID returns how much of a payment was refunded so far,
+	// pending refunds included and failed ones left out
+	SumByPaymentID(ctx context.Context, paymentID uuid.UUID) (int, error)
+	Update(ctx context.Context, refund *payments.Refund) error
+}
+
+type postgresRefundRepository struct {
+	db *gorm.DB
+}
+
+func NewRefundRepository(db *gorm.DB) RefundRepository {
+	return &postgresRefundRepository{db: db}
+}
+
+func (r *postgresRefundRepository) Create(
+	ctx context.Context,
+	refund *payments.Refund,
+) error {
+	return database.Conn(ctx, r.db).Create(refund).Error
+}
+
+func (r *postgresRefundRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Refund, error) {
+	var refund payments.Refund
+	err := database.Conn(ctx, r.db).Where("id = ?", id).First(&refund).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (r *postgresRefundRepository) GetByIDForUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Refund, error) {
+	var refund payments.Refund
+	err := database.Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		First(&refund).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (r *postgresRefundRepository) GetByOrderID(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]payments.Refund, error) {
+	var refunds []payments.Refund
+	err := database.Conn(ctx, r.db).Where(
+		"order_id = ?", orderID,
+	).Order("created_at ASC").Find(&refunds).Error
+	if err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (r *postgresRefundRepository) SumByPaymentID(
+	ctx context.Context,
+	paymentID uuid.UUID,
+) (int, error) {
+	var total int
+	err := database.Conn(ctx, r.db).
+		Model(&payments.Refund{}).
+		Where("payment_id = ? AND status <> ?",
+			paymentID, payments.RefundStatusFailed).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (r *postgresRefundRepository) Update(
+	ctx context.Context,
+	refund *payments.Refund,
+) error {
+	return database.Conn(ctx, r.db).Save(refund).Error
+}
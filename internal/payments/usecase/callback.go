@@ -5,69 +5,209 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"time"
 
 	"dunhayat-api/internal/payments"
 	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"go.uber.org/zap"
 )
 
+// HandleCallbackUseCase settles the payment a gateway callback is about.
+// Gateways retry callbacks, so replays of a callback already processed
+// and callbacks for payments that are no longer pending are recorded and
+// otherwise ignored; a late "failed" never undoes a verified payment.
+// The matched payment is returned whenever there is one, even along with
+// an error, so that the customer can be sent back to the shop.
 type HandleCallbackUseCase interface {
 	Execute(
 		ctx context.Context,
 		callbackData payments.PaymentCallbackRequest,
-	) error
+	) (*payments.PaymentCallbackResponse, error)
 }
 
 type handleCallbackUseCase struct {
-	orderPort port.OrderPort
+	paymentRepo  repository.PaymentRepository
+	callbackRepo repository.PaymentCallbackRepository
+	gateways     *payment.Registry
+	settler      *paymentSettler
+	logger       logger.Interface
 }
 
 func NewHandleCallbackUseCase(
+	transactor database.Transactor,
 	orderPort port.OrderPort,
+	paymentRepo repository.PaymentRepository,
+	callbackRepo repository.PaymentCallbackRepository,
+	fraudAlertRepo repository.FraudAlertRepository,
+	gateways *payment.Registry,
+	logger logger.Interface,
 ) HandleCallbackUseCase {
 	return &handleCallbackUseCase{
-		orderPort: orderPort,
+		paymentRepo:  paymentRepo,
+		callbackRepo: callbackRepo,
+		gateways:     gateways,
+		settler: newPaymentSettler(
+			transactor,
+			orderPort,
+			paymentRepo,
+			fraudAlertRepo,
+			gateways,
+			logger,
+		),
+		logger: logger,
 	}
 }
 
 func (uc *handleCallbackUseCase) Execute(
 	ctx context.Context,
 	callbackData payments.PaymentCallbackRequest,
-) error {
-	sale, err := uc.orderPort.GetSaleByTrackingCode(
-		ctx, callbackData.TrackID,
+) (*payments.PaymentCallbackResponse, error) {
+	gatewayData, err := callbackPayload(callbackData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode callback payload: %w", err)
+	}
+
+	gateway, err := uc.gateways.Get(callbackData.Method.String())
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%w: %s", payments.ErrUnsupportedPaymentMethod, callbackData.Method,
+		)
+	}
+
+	cb, err := gateway.ParseCallback(callbackData.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status claimed by the callback is only kept for the audit log;
+	// the payment itself is settled by verifying with the gateway.
+	claimedStatus := payments.PaymentStatusFailed
+	if cb.Success {
+		claimedStatus = payments.PaymentStatusPaid
+	}
+
+	callback := &payments.PaymentCallback{
+		Method:        callbackData.Method,
+		TrackID:       cb.Reference,
+		GatewayStatus: cb.Status,
+		GatewayData:   gatewayData,
+		Status:        claimedStatus,
+	}
+
+	pmt, err := uc.paymentRepo.GetByGatewayRefID(
+		ctx, callbackData.Method, cb.Reference,
 	)
 	if err != nil {
-		return fmt.Errorf(
-			"failed to get sale by tracking code: %w", err,
+		err = fmt.Errorf(
+			"failed to get payment by track ID: %w", err,
 		)
+		uc.record(ctx, callback, payments.CallbackOutcomeFailed, err)
+		return nil, err
 	}
-	if sale == nil {
-		return errors.New(
-			"sale not found for track ID: " + callbackData.TrackID,
+	if pmt == nil {
+		err = errors.New(
+			"payment not found for track ID: " + cb.Reference,
 		)
+		uc.record(ctx, callback, payments.CallbackOutcomeUnmatched, err)
+		return nil, err
 	}
+	callback.PaymentID = &pmt.ID
 
-	// XXX: Reserved for future audit store
-	_, _ = json.Marshal(callbackData)
+	processed, err := uc.callbackRepo.HasProcessed(
+		ctx, callbackData.Method, cb.Reference, cb.Status,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to check for duplicate callback: %w", err)
+		uc.record(ctx, callback, payments.CallbackOutcomeFailed, err)
+		return callbackResponse(pmt), err
+	}
+	if processed {
+		callback.Status = pmt.Status
+		uc.logger.Info("Duplicate payment callback ignored",
+			zap.String("method", callbackData.Method.String()),
+			zap.String("track_id", cb.Reference),
+			zap.String("gateway_status", cb.Status),
+			zap.String("payment_id", pmt.ID.String()),
+		)
+		uc.record(ctx, callback, payments.CallbackOutcomeDuplicate, nil)
+		return callbackResponse(pmt), nil
+	}
+
+	settled, fraud, err := uc.settler.settleLocked(ctx, pmt)
+	callback.Status = pmt.Status
+	if err == nil && !settled {
+		uc.logger.Info("Callback for settled payment ignored",
+			zap.String("method", callbackData.Method.String()),
+			zap.String("track_id", cb.Reference),
+			zap.String("gateway_status", cb.Status),
+			zap.Bool("success", cb.Success),
+			zap.String("payment_id", pmt.ID.String()),
+			zap.String("payment_status", pmt.Status.String()),
+		)
+		uc.record(ctx, callback, payments.CallbackOutcomeIgnored, nil)
+		return callbackResponse(pmt), nil
+	}
+	if err != nil {
+		uc.record(ctx, callback, payments.CallbackOutcomeFailed, err)
+		return callbackResponse(pmt), err
+	}
+	if fraud {
+		uc.record(ctx, callback, payments.CallbackOutcomeFraud, nil)
+		return callbackResponse(pmt), nil
+	}
+
+	uc.record(ctx, callback, payments.CallbackOutcomeProcessed, nil)
+	return callbackResponse(pmt), nil
+}
 
-	var newStatus port.OrderStatus
-	if callbackData.Success && callbackData.Status == 100 {
-		newStatus = port.OrderStatusPaid
-	} else {
-		newStatus = port.OrderStatusFailed
+// record stores the callback in the audit log. A failure to store it must
+// not change the outcome reported to the gateway, so it is only logged.
+func (uc *handleCallbackUseCase) record(
+	ctx context.Context,
+	callback *payments.PaymentCallback,
+	outcome payments.CallbackOutcome,
+	processingErr error,
+) {
+	callback.Outcome = outcome
+	if processingErr != nil {
+		msg := processingErr.Error()
+		callback.Error = &msg
 	}
 
-	if err := uc.orderPort.UpdateSaleStatus(
-		ctx, sale.ID, newStatus,
-	); err != nil {
-		return fmt.Errorf(
-			"failed to update sale status: %w", err,
+	if err := uc.callbackRepo.Create(ctx, callback); err != nil {
+		uc.logger.Error("Failed to store payment callback",
+			zap.String("track_id", callback.TrackID),
+			zap.String("outcome", outcome.String()),
+			zap.Error(err),
 		)
 	}
+}
 
-	// XXX: Reserved for future timestamps
-	_ = time.Now()
+func callbackResponse(
+	pmt *payments.Payment,
+) *payments.PaymentCallbackResponse {
+	return &payments.PaymentCallbackResponse{
+		PaymentID: pmt.ID,
+		OrderID:   pmt.OrderID,
+		Status:    pmt.Status,
+		ReturnURL: pmt.ReturnURL,
+	}
+}
 
-	return nil
+func callbackPayload(
+	callbackData payments.PaymentCallbackRequest,
+) (string, error) {
+	if len(callbackData.Raw) > 0 && json.Valid(callbackData.Raw) {
+		return string(callbackData.Raw), nil
+	}
+
+	raw, err := json.Marshal(callbackData.Params)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
 }
@@ -0,0 +1,360 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/pkg/payment"
+
+	"github.com/google/uuid"
+)
+
+const replays = 20
+
+type callbackFixture struct {
+	pmt       payments.Payment
+	orders    *fakeOrderPort
+	pmts      *fakePaymentRepo
+	callbacks *fakeCallbackRepo
+	gateway   *fakeGateway
+	useCase   HandleCallbackUseCase
+}
+
+func newCallbackFixture(callbacks *fakeCallbackRepo) *callbackFixture {
+	trackID := "3000000001"
+	sale := port.Sale{
+		ID:         uuid.New(),
+		UserID:     uuid.New(),
+		Status:     port.OrderStatusPending,
+		TotalPrice: 250_000,
+	}
+	pmt := payments.Payment{
+		ID:           uuid.New(),
+		OrderID:      sale.ID,
+		UserID:       sale.UserID,
+		Amount:       sale.TotalPrice,
+		Status:       payments.PaymentStatusPending,
+		Method:       payments.PaymentMethodZibal,
+		GatewayRefID: &trackID,
+		ReturnURL:    "https://shop.example/orders",
+	}
+
+	f := &callbackFixture{
+		pmt:       pmt,
+		orders:    newFakeOrderPort(sale),
+		pmts:      newFakePaymentRepo(pmt),
+		callbacks: callbacks,
+		gateway:   &fakeGateway{amount: sale.TotalPrice},
+	}
+
+	gateways := payment.NewRegistry()
+	gateways.Register(payments.PaymentMethodZibal.String(), f.gateway)
+
+	f.useCase = NewHandleCallbackUseCase(
+		fakeTransactor{},
+		f.orders,
+		f.pmts,
+		f.callbacks,
+		&fakeFraudAlertRepo{},
+		gateways,
+		nopLogger{},
+	)
+	return f
+}
+
+func (f *callbackFixture) request() payments.PaymentCallbackRequest {
+	return payments.PaymentCallbackRequest{
+		Method: payments.PaymentMethodZibal,
+		Params: map[string]string{
+			"trackId": *f.pmt.GatewayRefID,
+			"success": "1",
+			"status":  "2",
+		},
+	}
+}
+
+// replay sends the same callback n times at once and fails the test if
+// any of them errs.
+func (f *callbackFixture) replay(t *testing.T, n int) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			response, err := f.useCase.Execute(
+				context.Background(), f.request(),
+			)
+			if err == nil && response == nil {
+				t.Error("callback matched no payment")
+			}
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("callback failed: %v", err)
+		}
+	}
+}
+
+// assertSettledOnce checks that the payment and its sale were moved to
+// paid exactly once, and that a single callback was recorded as processed.
+func (f *callbackFixture) assertSettledOnce(t *testing.T, n int) {
+	t.Helper()
+
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want paid", pmt.Status)
+	}
+	if f.pmts.updates != 1 {
+		t.Errorf("payment was updated %d times, want once", f.pmts.updates)
+	}
+
+	changes := f.orders.statusChanges()
+	if len(changes) != 1 || changes[0] != port.OrderStatusPaid {
+		t.Errorf("sale status changes = %v, want [paid]", changes)
+	}
+
+	outcomes := f.callbacks.outcomes()
+	if outcomes[payments.CallbackOutcomeProcessed] != 1 {
+		t.Errorf(
+			"%d callbacks recorded as processed, want 1",
+			outcomes[payments.CallbackOutcomeProcessed],
+		)
+	}
+	total := 0
+	for _, count := range outcomes {
+		total += count
+	}
+	if total+f.callbacks.rejected != n {
+		t.Errorf(
+			"%d callbacks recorded and %d rejected, want %d in all",
+			total, f.callbacks.rejected, n,
+		)
+	}
+}
+
+func TestHandleCallbackConcurrentReplaysSettleOnce(t *testing.T) {
+	f := newCallbackFixture(&fakeCallbackRepo{})
+
+	f.replay(t, replays)
+	f.assertSettledOnce(t, replays)
+
+	outcomes := f.callbacks.outcomes()
+	ignored := outcomes[payments.CallbackOutcomeDuplicate] +
+		outcomes[payments.CallbackOutcomeIgnored]
+	if ignored != replays-1 {
+		t.Errorf(
+			"%d replays recorded as duplicate or ignored, want %d",
+			ignored, replays-1,
+		)
+	}
+}
+
+// Replays that all pass the HasProcessed pre-check before any of them is
+// recorded are still settled once: the row lock lets one through, and the
+// unique index refuses any second processed record.
+func TestHandleCallbackReplaysMissingPreCheckSettleOnce(t *testing.T) {
+	f := newCallbackFixture(&fakeCallbackRepo{skipPreCheck: true})
+
+	f.replay(t, replays)
+	f.assertSettledOnce(t, replays)
+
+	if outcomes := f.callbacks.outcomes(); outcomes[payments.CallbackOutcomeDuplicate] != 0 {
+		t.Errorf(
+			"%d replays recorded as duplicate with the pre-check skipped",
+			outcomes[payments.CallbackOutcomeDuplicate],
+		)
+	}
+}
+
+// A processed record that the pre-check did not see yet is refused by the
+// unique index; the callback still succeeds, as the audit log must not
+// change what the gateway is told.
+func TestHandleCallbackProcessedRecordRefusedByIndex(t *testing.T) {
+	callbacks := &fakeCallbackRepo{skipPreCheck: true}
+	f := newCallbackFixture(callbacks)
+	callbacks.callbacks = append(callbacks.callbacks, payments.PaymentCallback{
+		Method:        payments.PaymentMethodZibal,
+		TrackID:       *f.pmt.GatewayRefID,
+		GatewayStatus: "2",
+		Outcome:       payments.CallbackOutcomeProcessed,
+	})
+
+	response, err := f.useCase.Execute(context.Background(), f.request())
+	if err != nil {
+		t.Fatalf("callback failed: %v", err)
+	}
+	if response.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want paid", response.Status)
+	}
+	if callbacks.rejected != 1 {
+		t.Errorf(
+			"index refused %d records, want 1", callbacks.rejected,
+		)
+	}
+	if outcomes := callbacks.outcomes(); outcomes[payments.CallbackOutcomeProcessed] != 1 {
+		t.Errorf(
+			"%d callbacks recorded as processed, want 1",
+			outcomes[payments.CallbackOutcomeProcessed],
+		)
+	}
+}
+
+// The gateway is verified before the payment row is locked, so that a slow
+// gateway never keeps the row locked.
+func TestHandleCallbackVerifiesBeforeLocking(t *testing.T) {
+	f := newCallbackFixture(&fakeCallbackRepo{})
+	f.gateway.onVerify = func() {
+		row := f.pmts.rows[f.pmt.ID]
+		if !row.TryLock() {
+			t.Error("gateway verified with the payment row locked")
+			return
+		}
+		row.Unlock()
+	}
+
+	f.replay(t, 1)
+	f.assertSettledOnce(t, 1)
+}
+
+// expire cancels the order of the fixture the way the expiry job and a
+// customer cancel do: its sale is locked first, then its pending payment.
+// locked runs while only the sale is locked. The payment is locked
+// directly rather than through CancelPendingPayments, as the fake
+// repository lets it list writes a transaction has not committed yet.
+func (f *callbackFixture) expire(ctx context.Context, locked func()) error {
+	return fakeTransactor{}.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := f.orders.LockSale(ctx, f.pmt.OrderID); err != nil {
+			return err
+		}
+		locked()
+
+		pmt, err := f.pmts.GetByIDForUpdate(ctx, f.pmt.ID)
+		if err != nil {
+			return err
+		}
+		if pmt.Status != payments.PaymentStatusPending {
+			return nil
+		}
+		pmt.Status = payments.PaymentStatusCancelled
+		if err := f.pmts.Update(ctx, pmt); err != nil {
+			return err
+		}
+
+		_, err = f.orders.ReleaseSale(ctx, f.pmt.OrderID, "reservation expired")
+		return err
+	})
+}
+
+// A callback arriving while its order expires takes the sale and payment
+// locks in the same order as the expiry, so the two cannot deadlock. The
+// expiry locks the sale and gives the callback time to lock the payment
+// before it goes on to the payments of the order; a callback locking the
+// payment first would then wait on the sale the expiry holds, while the
+// expiry waits on the payment.
+func TestHandleCallbackDuringOrderExpiryDoesNotDeadlock(t *testing.T) {
+	f := newCallbackFixture(&fakeCallbackRepo{})
+
+	saleLocked := make(chan struct{})
+	paymentLocked := make(chan struct{})
+	var once sync.Once
+	f.gateway.onVerify = func() { <-saleLocked }
+	f.pmts.onLock = func() { once.Do(func() { close(paymentLocked) }) }
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := f.useCase.Execute(
+			context.Background(), f.request(),
+		); err != nil {
+			t.Errorf("callback failed: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := f.expire(context.Background(), func() {
+			close(saleLocked)
+			select {
+			case <-paymentLocked:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}); err != nil {
+			t.Errorf("expiry failed: %v", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback and expiry deadlocked")
+	}
+
+	// The expiry locked the sale first, so it cancelled the order
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusCancelled {
+		t.Errorf("payment is %s, want cancelled", pmt.Status)
+	}
+	if sale, _ := f.orders.GetSaleByID(
+		context.Background(), f.pmt.OrderID,
+	); sale.Status != port.OrderStatusCancelled {
+		t.Errorf("sale is %s, want cancelled", sale.Status)
+	}
+}
+
+// A failed attempt leaves its order alone while another attempt is
+// pending, so that the other attempt can still pay it.
+func TestHandleCallbackFailedAttemptLeavesOrderToPendingOne(t *testing.T) {
+	f := newCallbackFixture(&fakeCallbackRepo{})
+	f.gateway.declined = map[string]bool{*f.pmt.GatewayRefID: true}
+
+	retryTrackID := "3000000002"
+	retry := f.pmt
+	retry.GatewayRefID = &retryTrackID
+	if err := f.pmts.Create(context.Background(), &retry); err != nil {
+		t.Fatalf("failed to create second attempt: %v", err)
+	}
+
+	declined := f.request()
+	declined.Params["success"] = "0"
+	declined.Params["status"] = "3"
+	if _, err := f.useCase.Execute(context.Background(), declined); err != nil {
+		t.Fatalf("callback of the first attempt failed: %v", err)
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusFailed {
+		t.Errorf("first attempt is %s, want failed", pmt.Status)
+	}
+	if changes := f.orders.statusChanges(); len(changes) != 0 {
+		t.Errorf("sale status changes = %v, want none yet", changes)
+	}
+
+	paid := f.request()
+	paid.Params["trackId"] = retryTrackID
+	if _, err := f.useCase.Execute(context.Background(), paid); err != nil {
+		t.Fatalf("callback of the second attempt failed: %v", err)
+	}
+	if pmt := f.pmts.get(retry.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("second attempt is %s, want paid", pmt.Status)
+	}
+	changes := f.orders.statusChanges()
+	if len(changes) != 1 || changes[0] != port.OrderStatusPaid {
+		t.Errorf("sale status changes = %v, want [paid]", changes)
+	}
+}
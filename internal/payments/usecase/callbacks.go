@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+
+	"github.com/google/uuid"
+)
+
+type ListPaymentCallbacksUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		orderID uuid.UUID,
+	) ([]payments.PaymentCallback, error)
+}
+
+type listPaymentCallbacksUseCase struct {
+	orderPort    port.OrderPort
+	callbackRepo repository.PaymentCallbackRepository
+}
+
+func NewListPaymentCallbacksUseCase(
+	orderPort port.OrderPort,
+	callbackRepo repository.PaymentCallbackRepository,
+) ListPaymentCallbacksUseCase {
+	return &listPaymentCallbacksUseCase{
+		orderPort:    orderPort,
+		callbackRepo: callbackRepo,
+	}
+}
+
+func (uc *listPaymentCallbacksUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	orderID uuid.UUID,
+) ([]payments.PaymentCallback, error) {
+	sale, err := uc.orderPort.GetSaleByID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil || sale.UserID != userID {
+		return nil, payments.ErrOrderNotFound
+	}
+
+	callbacks, err := uc.callbackRepo.GetByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to get payment callbacks: %w", err,
+		)
+	}
+
+	return callbacks, nil
+}
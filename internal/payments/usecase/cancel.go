@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/repository"
+
+	"github.com/google/uuid"
+)
+
+// CancelPendingPaymentsUseCase cancels the payment attempts of an order that
+// have not been settled yet, so that they are not settled once the order is
+// gone.
+type CancelPendingPaymentsUseCase interface {
+	Execute(ctx context.Context, orderID uuid.UUID) error
+}
+
+type cancelPendingPaymentsUseCase struct {
+	paymentRepo repository.PaymentRepository
+}
+
+func NewCancelPendingPaymentsUseCase(
+	paymentRepo repository.PaymentRepository,
+) CancelPendingPaymentsUseCase {
+	return &cancelPendingPaymentsUseCase{
+		paymentRepo: paymentRepo,
+	}
+}
+
+func (uc *cancelPendingPaymentsUseCase) Execute(
+	ctx context.Context,
+	orderID uuid.UUID,
+) error {
+	paymentList, err := uc.paymentRepo.GetByOrderID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to get payments: %w", err)
+	}
+
+	for i := range paymentList {
+		if paymentList[i].Status != payments.PaymentStatusPending {
+			continue
+		}
+
+		// Locked and checked again, as a callback may be settling it
+		pmt, err := uc.paymentRepo.GetByIDForUpdate(ctx, paymentList[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to lock payment: %w", err)
+		}
+		if pmt == nil || pmt.Status != payments.PaymentStatusPending {
+			continue
+		}
+
+		pmt.Status = payments.PaymentStatusCancelled
+		if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+			return fmt.Errorf(
+				"failed to cancel payment %s: %w", pmt.ID, err,
+			)
+		}
+	}
+
+	return nil
+}
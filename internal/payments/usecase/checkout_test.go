@@ -0,0 +1,333 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/pkg/config"
+	"dunhayat-api/pkg/payment"
+	"dunhayat-api/pkg/payment/fakegateway"
+
+	"github.com/google/uuid"
+)
+
+// checkoutFixture runs a pending order through the real Zibal client
+// against the fake gateway, with the shop's callback endpoint served over
+// HTTP, all offline.
+type checkoutFixture struct {
+	sale      port.Sale
+	orders    *fakeOrderPort
+	pmts      *fakePaymentRepo
+	callbacks *fakeCallbackRepo
+	alerts    *fakeFraudAlertRepo
+	gateway   *fakegateway.Server
+
+	initiate       InitiatePaymentUseCase
+	handleCallback HandleCallbackUseCase
+}
+
+func newCheckoutFixture(t *testing.T) *checkoutFixture {
+	f := &checkoutFixture{
+		sale: port.Sale{
+			ID:         uuid.New(),
+			UserID:     uuid.New(),
+			Status:     port.OrderStatusPending,
+			TotalPrice: 250_000,
+		},
+		pmts:      newFakePaymentRepo(),
+		callbacks: &fakeCallbackRepo{},
+		alerts:    &fakeFraudAlertRepo{},
+		gateway: fakegateway.NewServer(fakegateway.Config{
+			Merchant:        "zibal-test",
+			CallbackTimeout: 5 * time.Second,
+		}, nopLogger{}),
+	}
+	f.orders = newFakeOrderPort(f.sale)
+
+	gatewayServer := httptest.NewServer(f.gateway)
+	t.Cleanup(gatewayServer.Close)
+
+	gateways := payment.NewRegistry()
+	gateways.Register(payments.PaymentMethodZibal.String(), payment.NewZibalClient(
+		payment.ZibalConfig{
+			MerchantID: "zibal-test",
+			BaseURL:    gatewayServer.URL,
+			Timeout:    500 * time.Millisecond,
+		},
+	))
+
+	f.handleCallback = NewHandleCallbackUseCase(
+		fakeTransactor{},
+		f.orders,
+		f.pmts,
+		f.callbacks,
+		f.alerts,
+		gateways,
+		nopLogger{},
+	)
+	shop := httptest.NewServer(callbackEndpoint(f.handleCallback))
+	t.Cleanup(shop.Close)
+
+	f.initiate = NewInitiatePaymentUseCase(
+		fakeTransactor{},
+		f.orders,
+		f.pmts,
+		gateways,
+		nopLogger{},
+		&config.Config{App: config.AppConfig{Domain: "https://shop.example"}},
+		shop.URL+"/api/v1/payments/callback",
+	)
+	return f
+}
+
+// callbackEndpoint answers gateway callbacks like the payments handler:
+// the method is the last path segment and the JSON body holds the params.
+func callbackEndpoint(useCase HandleCallbackUseCase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var body map[string]any
+		decoder := json.NewDecoder(strings.NewReader(string(raw)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params := make(map[string]string, len(body))
+		for key, value := range body {
+			params[key] = fmt.Sprint(value)
+		}
+
+		if _, err := useCase.Execute(r.Context(), payments.PaymentCallbackRequest{
+			Method: payments.PaymentMethod(path.Base(r.URL.Path)),
+			Params: params,
+			Raw:    raw,
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// order starts paying the sale and returns where the customer is sent.
+func (f *checkoutFixture) order(t *testing.T) *payments.InitiatePaymentResponse {
+	t.Helper()
+
+	response, err := f.initiate.Execute(
+		context.Background(), &payments.InitiatePaymentRequest{
+			OrderID:     f.sale.ID,
+			UserID:      f.sale.UserID,
+			Amount:      f.sale.TotalPrice,
+			Method:      payments.PaymentMethodZibal,
+			ReturnURL:   "https://shop.example/orders",
+			Description: "Payment for order " + f.sale.ID.String(),
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to initiate payment: %v", err)
+	}
+	return response
+}
+
+// pay presses a button on the gateway's payment page, which sends the
+// callback to the shop, and returns the page's status and message.
+func (f *checkoutFixture) pay(
+	t *testing.T,
+	gatewayURL string,
+	decision string,
+) (int, string) {
+	t.Helper()
+
+	resp, err := http.PostForm(gatewayURL, url.Values{"decision": {decision}})
+	if err != nil {
+		t.Fatalf("failed to %s on the payment page: %v", decision, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read the payment page: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func (f *checkoutFixture) assertPayment(
+	t *testing.T,
+	id uuid.UUID,
+	status payments.PaymentStatus,
+	saleChanges ...port.OrderStatus,
+) {
+	t.Helper()
+
+	if pmt := f.pmts.get(id); pmt == nil || pmt.Status != status {
+		t.Errorf("payment = %+v, want %s", pmt, status)
+	}
+	changes := f.orders.statusChanges()
+	if fmt.Sprint(changes) != fmt.Sprint(saleChanges) {
+		t.Errorf("sale status changes = %v, want %v", changes, saleChanges)
+	}
+}
+
+func TestCheckoutApprovedPaymentPaysOrder(t *testing.T) {
+	f := newCheckoutFixture(t)
+
+	response := f.order(t)
+	if response.Status != payments.PaymentStatusPending ||
+		!strings.HasSuffix(response.GatewayURL, "/start/"+response.GatewayRefID) {
+		t.Fatalf("initiated payment = %+v", response)
+	}
+
+	status, page := f.pay(t, response.GatewayURL, "approve")
+	if status != http.StatusOK || !strings.Contains(page, "status 200") {
+		t.Fatalf("payment page answered %d:\n%s", status, page)
+	}
+
+	f.assertPayment(
+		t, response.PaymentID, payments.PaymentStatusPaid, port.OrderStatusPaid,
+	)
+	if outcomes := f.callbacks.outcomes(); outcomes[payments.CallbackOutcomeProcessed] != 1 {
+		t.Errorf("callback outcomes = %v, want one processed", outcomes)
+	}
+}
+
+func TestCheckoutDeclinedPaymentFailsOrder(t *testing.T) {
+	f := newCheckoutFixture(t)
+	response := f.order(t)
+
+	status, page := f.pay(t, response.GatewayURL, "decline")
+	if status != http.StatusOK || !strings.Contains(page, "status 200") {
+		t.Fatalf("payment page answered %d:\n%s", status, page)
+	}
+
+	f.assertPayment(
+		t, response.PaymentID, payments.PaymentStatusFailed,
+		port.OrderStatusFailed,
+	)
+}
+
+// A gateway that verifies another amount than the order's raises a fraud
+// alert instead of paying the order.
+func TestCheckoutAmountMismatchRaisesFraudAlert(t *testing.T) {
+	f := newCheckoutFixture(t)
+	response := f.order(t)
+	f.gateway.Fail(fakegateway.EndpointVerify, fakegateway.Failure{
+		Amount: 1000,
+	})
+
+	if status, page := f.pay(t, response.GatewayURL, "approve"); status != http.StatusOK {
+		t.Fatalf("payment page answered %d:\n%s", status, page)
+	}
+
+	f.assertPayment(
+		t, response.PaymentID, payments.PaymentStatusFailed,
+		port.OrderStatusFailed,
+	)
+	if len(f.alerts.alerts) != 1 || f.alerts.alerts[0].VerifiedAmount != 1000 {
+		t.Errorf("fraud alerts = %+v, want one for 1000", f.alerts.alerts)
+	}
+}
+
+// A verify that times out fails the callback and leaves the payment
+// pending; a replay of the callback settles it once the gateway answers.
+func TestCheckoutVerifyTimeoutLeavesPaymentPending(t *testing.T) {
+	f := newCheckoutFixture(t)
+	response := f.order(t)
+	f.gateway.Fail(fakegateway.EndpointVerify, fakegateway.Failure{
+		Delay: time.Second,
+	})
+
+	status, page := f.pay(t, response.GatewayURL, "approve")
+	if status != http.StatusBadGateway {
+		t.Fatalf("payment page answered %d, want 502:\n%s", status, page)
+	}
+	f.assertPayment(t, response.PaymentID, payments.PaymentStatusPending)
+
+	// The gateway retries the callback
+	if _, err := f.handleCallback.Execute(
+		context.Background(), payments.PaymentCallbackRequest{
+			Method: payments.PaymentMethodZibal,
+			Params: map[string]string{
+				"trackId": response.GatewayRefID,
+				"success": "1",
+				"status":  "2",
+			},
+		},
+	); err != nil {
+		t.Fatalf("replayed callback failed: %v", err)
+	}
+	f.assertPayment(
+		t, response.PaymentID, payments.PaymentStatusPaid, port.OrderStatusPaid,
+	)
+}
+
+// A second attempt is refused while the first is pending, so the customer
+// cannot be charged twice; it opens once the first one has failed.
+func TestCheckoutSecondAttemptWaitsForPendingOne(t *testing.T) {
+	f := newCheckoutFixture(t)
+	first := f.order(t)
+
+	_, err := f.initiate.Execute(
+		context.Background(), &payments.InitiatePaymentRequest{
+			OrderID:   f.sale.ID,
+			UserID:    f.sale.UserID,
+			Amount:    f.sale.TotalPrice,
+			Method:    payments.PaymentMethodZibal,
+			ReturnURL: "https://shop.example/orders",
+		},
+	)
+	if !errors.Is(err, payments.ErrPaymentInProgress) {
+		t.Fatalf("second attempt error = %v, want payment in progress", err)
+	}
+	if attempts, _ := f.pmts.GetByOrderID(context.Background(), f.sale.ID); len(attempts) != 1 {
+		t.Fatalf("order has %d payments, want 1", len(attempts))
+	}
+
+	if status, page := f.pay(t, first.GatewayURL, "decline"); status != http.StatusOK {
+		t.Fatalf("payment page answered %d:\n%s", status, page)
+	}
+	second := f.order(t)
+	if second.PaymentID == first.PaymentID {
+		t.Fatalf("second attempt reused payment %s", first.PaymentID)
+	}
+	f.assertPayment(
+		t, second.PaymentID, payments.PaymentStatusPending,
+		port.OrderStatusFailed, port.OrderStatusPending,
+	)
+}
+
+// A payment cannot send the customer back anywhere but the shop's domain.
+func TestCheckoutRejectsForeignReturnURL(t *testing.T) {
+	f := newCheckoutFixture(t)
+
+	_, err := f.initiate.Execute(
+		context.Background(), &payments.InitiatePaymentRequest{
+			OrderID:   f.sale.ID,
+			UserID:    f.sale.UserID,
+			Amount:    f.sale.TotalPrice,
+			Method:    payments.PaymentMethodZibal,
+			ReturnURL: "https://evil.example/orders",
+		},
+	)
+	if !errors.Is(err, payments.ErrInvalidReturnURL) {
+		t.Fatalf("initiate error = %v, want invalid return URL", err)
+	}
+	if attempts, _ := f.pmts.GetByOrderID(context.Background(), f.sale.ID); len(attempts) != 0 {
+		t.Errorf("order has %d payments, want none", len(attempts))
+	}
+}
@@ -0,0 +1,602 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type fakeTxKey struct{}
+
+// fakeTx collects the row locks taken during a fake transaction.
+type fakeTx struct {
+	held    map[*sync.Mutex]bool
+	unlocks []func()
+}
+
+// fakeTransactor runs fn directly and releases the row locks taken by the
+// fake repositories when the outermost call returns, like a transaction
+// releases FOR UPDATE locks when it ends.
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	if _, ok := ctx.Value(fakeTxKey{}).(*fakeTx); ok {
+		return fn(ctx)
+	}
+
+	tx := &fakeTx{held: make(map[*sync.Mutex]bool)}
+	defer func() {
+		for _, unlock := range tx.unlocks {
+			unlock()
+		}
+	}()
+	return fn(context.WithValue(ctx, fakeTxKey{}, tx))
+}
+
+// lockRow locks row until the fake transaction of ctx ends. Like a row
+// lock, a transaction that already holds it takes it again at once.
+func lockRow(ctx context.Context, row *sync.Mutex) error {
+	tx, ok := ctx.Value(fakeTxKey{}).(*fakeTx)
+	if !ok {
+		return errors.New("row lock taken outside a transaction")
+	}
+	if tx.held[row] {
+		return nil
+	}
+
+	row.Lock()
+	tx.held[row] = true
+	tx.unlocks = append(tx.unlocks, row.Unlock)
+	return nil
+}
+
+// fakePaymentRepo keeps payments in memory. GetByIDForUpdate holds a lock
+// per payment until the surrounding fake transaction ends.
+type fakePaymentRepo struct {
+	repository.PaymentRepository
+
+	mu       sync.Mutex
+	payments map[uuid.UUID]payments.Payment
+	rows     map[uuid.UUID]*sync.Mutex
+	updates  int
+	// onLock, if set, runs whenever a payment row lock is taken
+	onLock func()
+}
+
+func newFakePaymentRepo(pmts ...payments.Payment) *fakePaymentRepo {
+	r := &fakePaymentRepo{
+		payments: make(map[uuid.UUID]payments.Payment),
+		rows:     make(map[uuid.UUID]*sync.Mutex),
+	}
+	for _, pmt := range pmts {
+		r.payments[pmt.ID] = pmt
+		r.rows[pmt.ID] = &sync.Mutex{}
+	}
+	return r
+}
+
+func (r *fakePaymentRepo) get(id uuid.UUID) *payments.Payment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pmt, ok := r.payments[id]
+	if !ok {
+		return nil
+	}
+	return &pmt
+}
+
+func (r *fakePaymentRepo) Create(
+	_ context.Context,
+	pmt *payments.Payment,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pmt.ID = uuid.New()
+	pmt.CreatedAt = time.Now()
+	r.payments[pmt.ID] = *pmt
+	r.rows[pmt.ID] = &sync.Mutex{}
+	return nil
+}
+
+func (r *fakePaymentRepo) GetByID(
+	_ context.Context,
+	id uuid.UUID,
+) (*payments.Payment, error) {
+	return r.get(id), nil
+}
+
+func (r *fakePaymentRepo) GetByIDForUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Payment, error) {
+	r.mu.Lock()
+	row, ok := r.rows[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	if err := lockRow(ctx, row); err != nil {
+		return nil, err
+	}
+	if r.onLock != nil {
+		r.onLock()
+	}
+	return r.get(id), nil
+}
+
+func (r *fakePaymentRepo) GetByGatewayRefID(
+	_ context.Context,
+	method payments.PaymentMethod,
+	gatewayRefID string,
+) (*payments.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, pmt := range r.payments {
+		if pmt.Method == method &&
+			pmt.GatewayRefID != nil && *pmt.GatewayRefID == gatewayRefID {
+			return &pmt, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakePaymentRepo) GetPaidByOrderIDForUpdate(
+	ctx context.Context,
+	orderID uuid.UUID,
+) (*payments.Payment, error) {
+	r.mu.Lock()
+	var id uuid.UUID
+	for _, pmt := range r.payments {
+		if pmt.OrderID == orderID && pmt.Status == payments.PaymentStatusPaid {
+			id = pmt.ID
+		}
+	}
+	r.mu.Unlock()
+	if id == uuid.Nil {
+		return nil, nil
+	}
+
+	pmt, err := r.GetByIDForUpdate(ctx, id)
+	if err != nil || pmt == nil || pmt.Status != payments.PaymentStatusPaid {
+		return nil, err
+	}
+	return pmt, nil
+}
+
+func (r *fakePaymentRepo) GetByOrderID(
+	_ context.Context,
+	orderID uuid.UUID,
+) ([]payments.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []payments.Payment
+	for _, pmt := range r.payments {
+		if pmt.OrderID == orderID {
+			result = append(result, pmt)
+		}
+	}
+	return result, nil
+}
+
+// GetUnreconciled returns every pending payment, ignoring the bounds.
+func (r *fakePaymentRepo) GetUnreconciled(
+	_ context.Context,
+	_, _ time.Time,
+	_ int,
+) ([]payments.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []payments.Payment
+	for _, pmt := range r.payments {
+		if pmt.Status == payments.PaymentStatusPending {
+			result = append(result, pmt)
+		}
+	}
+	return result, nil
+}
+
+func (r *fakePaymentRepo) Update(
+	_ context.Context,
+	pmt *payments.Payment,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.payments[pmt.ID] = *pmt
+	r.updates++
+	return nil
+}
+
+// fakeCallbackRepo keeps the callback audit log in memory and, like the
+// idx_payment_callbacks_processed index, refuses a second processed or
+// fraud_alert callback with the same method, track ID and gateway status.
+type fakeCallbackRepo struct {
+	repository.PaymentCallbackRepository
+
+	// skipPreCheck makes HasProcessed always miss, as when replays check
+	// before any of them has been recorded
+	skipPreCheck bool
+
+	mu        sync.Mutex
+	callbacks []payments.PaymentCallback
+	rejected  int
+}
+
+func (r *fakeCallbackRepo) Create(
+	_ context.Context,
+	callback *payments.PaymentCallback,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if isProcessedOutcome(callback.Outcome) && r.hasProcessed(
+		callback.Method, callback.TrackID, callback.GatewayStatus,
+	) {
+		r.rejected++
+		return errors.New(
+			"duplicate key value violates unique constraint " +
+				`"idx_payment_callbacks_processed"`,
+		)
+	}
+	r.callbacks = append(r.callbacks, *callback)
+	return nil
+}
+
+func (r *fakeCallbackRepo) HasProcessed(
+	_ context.Context,
+	method payments.PaymentMethod,
+	trackID string,
+	gatewayStatus string,
+) (bool, error) {
+	if r.skipPreCheck {
+		return false, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hasProcessed(method, trackID, gatewayStatus), nil
+}
+
+func (r *fakeCallbackRepo) hasProcessed(
+	method payments.PaymentMethod,
+	trackID string,
+	gatewayStatus string,
+) bool {
+	for _, cb := range r.callbacks {
+		if cb.Method == method && cb.TrackID == trackID &&
+			cb.GatewayStatus == gatewayStatus &&
+			isProcessedOutcome(cb.Outcome) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeCallbackRepo) outcomes() map[payments.CallbackOutcome]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[payments.CallbackOutcome]int)
+	for _, cb := range r.callbacks {
+		counts[cb.Outcome]++
+	}
+	return counts
+}
+
+func isProcessedOutcome(outcome payments.CallbackOutcome) bool {
+	return outcome == payments.CallbackOutcomeProcessed ||
+		outcome == payments.CallbackOutcomeFraud
+}
+
+type fakeFraudAlertRepo struct {
+	repository.FraudAlertRepository
+
+	mu     sync.Mutex
+	alerts []payments.FraudAlert
+}
+
+func (r *fakeFraudAlertRepo) Create(
+	_ context.Context,
+	alert *payments.FraudAlert,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.alerts = append(r.alerts, *alert)
+	return nil
+}
+
+// fakeOrderPort holds sales in memory and records every status change.
+// Like the orders slice, it locks the row of a sale for the rest of the
+// transaction when it changes it.
+type fakeOrderPort struct {
+	mu       sync.Mutex
+	sales    map[uuid.UUID]port.Sale
+	rows     map[uuid.UUID]*sync.Mutex
+	changes  []port.OrderStatus
+	releases int
+}
+
+func newFakeOrderPort(sales ...port.Sale) *fakeOrderPort {
+	p := &fakeOrderPort{
+		sales: make(map[uuid.UUID]port.Sale),
+		rows:  make(map[uuid.UUID]*sync.Mutex),
+	}
+	for _, sale := range sales {
+		p.add(sale)
+	}
+	return p
+}
+
+func (p *fakeOrderPort) add(sale port.Sale) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sales[sale.ID] = sale
+	p.rows[sale.ID] = &sync.Mutex{}
+}
+
+func (p *fakeOrderPort) GetSaleByID(
+	_ context.Context,
+	saleID uuid.UUID,
+) (*port.Sale, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sale, ok := p.sales[saleID]
+	if !ok {
+		return nil, nil
+	}
+	return &sale, nil
+}
+
+func (p *fakeOrderPort) LockSale(
+	ctx context.Context,
+	saleID uuid.UUID,
+) (*port.Sale, error) {
+	p.mu.Lock()
+	row, ok := p.rows[saleID]
+	p.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	if err := lockRow(ctx, row); err != nil {
+		return nil, err
+	}
+	return p.GetSaleByID(ctx, saleID)
+}
+
+func (p *fakeOrderPort) UpdateSaleStatus(
+	ctx context.Context,
+	saleID uuid.UUID,
+	status port.OrderStatus,
+	_ string,
+) error {
+	return fakeTransactor{}.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := p.LockSale(ctx, saleID)
+		if err != nil {
+			return err
+		}
+		if sale == nil {
+			return errors.New("sale not found")
+		}
+		reopen := sale.Status == port.OrderStatusFailed &&
+			status == port.OrderStatusPending
+		if sale.Status != port.OrderStatusPending && !reopen {
+			return port.ErrInvalidStatusTransition
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		sale.Status = status
+		p.sales[saleID] = *sale
+		p.changes = append(p.changes, status)
+		return nil
+	})
+}
+
+func (p *fakeOrderPort) ReleaseSale(
+	ctx context.Context,
+	saleID uuid.UUID,
+	_ string,
+) (bool, error) {
+	var released bool
+	err := fakeTransactor{}.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := p.LockSale(ctx, saleID)
+		if err != nil || sale == nil || sale.Status != port.OrderStatusPending {
+			return err
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		sale.Status = port.OrderStatusCancelled
+		p.sales[saleID] = *sale
+		p.releases++
+		released = true
+		return nil
+	})
+	return released, err
+}
+
+func (p *fakeOrderPort) statusChanges() []port.OrderStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]port.OrderStatus(nil), p.changes...)
+}
+
+// fakeGateway verifies every payment for amount and reads Zibal-style
+// callback parameters.
+type fakeGateway struct {
+	payment.Gateway
+
+	amount int
+	// declined holds the references the gateway does not verify
+	declined map[string]bool
+	// onVerify, if set, runs at the start of every Verify
+	onVerify func()
+	// inquiry is what Inquire reports for every payment
+	inquiry payment.Inquiry
+	// refund, if set, answers Refund
+	refund func(ctx context.Context) (*payment.RefundResult, error)
+}
+
+func (g *fakeGateway) Verify(
+	_ context.Context,
+	req payment.VerifyRequest,
+) (*payment.Verification, error) {
+	if g.onVerify != nil {
+		g.onVerify()
+	}
+	if g.declined[req.Reference] {
+		return &payment.Verification{Code: 202}, nil
+	}
+
+	return &payment.Verification{
+		Verified: true,
+		Amount:   g.amount,
+		Code:     100,
+	}, nil
+}
+
+func (g *fakeGateway) Inquire(
+	_ context.Context,
+	_ string,
+) (*payment.Inquiry, error) {
+	inquiry := g.inquiry
+	return &inquiry, nil
+}
+
+func (g *fakeGateway) Refund(
+	ctx context.Context,
+	_ payment.RefundRequest,
+) (*payment.RefundResult, error) {
+	if g.refund == nil {
+		return nil, payment.ErrRefundNotSupported
+	}
+	return g.refund(ctx)
+}
+
+func (g *fakeGateway) ParseCallback(
+	params map[string]string,
+) (*payment.Callback, error) {
+	if params["trackId"] == "" {
+		return nil, payment.ErrInvalidCallback
+	}
+	success, _ := strconv.Atoi(params["success"])
+	return &payment.Callback{
+		Reference: params["trackId"],
+		Success:   success == 1,
+		Status:    params["status"],
+	}, nil
+}
+
+type fakeRefundRepo struct {
+	repository.RefundRepository
+
+	mu      sync.Mutex
+	refunds map[uuid.UUID]payments.Refund
+}
+
+func (r *fakeRefundRepo) Create(
+	_ context.Context,
+	refund *payments.Refund,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.refunds == nil {
+		r.refunds = make(map[uuid.UUID]payments.Refund)
+	}
+	refund.ID = uuid.New()
+	r.refunds[refund.ID] = *refund
+	return nil
+}
+
+// GetByIDForUpdate does not lock; refunds of a payment are serialized by
+// the payment's row lock.
+func (r *fakeRefundRepo) GetByIDForUpdate(
+	_ context.Context,
+	id uuid.UUID,
+) (*payments.Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refund, ok := r.refunds[id]
+	if !ok {
+		return nil, nil
+	}
+	return &refund, nil
+}
+
+func (r *fakeRefundRepo) SumByPaymentID(
+	_ context.Context,
+	paymentID uuid.UUID,
+) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID &&
+			refund.Status != payments.RefundStatusFailed {
+			total += refund.Amount
+		}
+	}
+	return total, nil
+}
+
+func (r *fakeRefundRepo) Update(
+	_ context.Context,
+	refund *payments.Refund,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refunds[refund.ID] = *refund
+	return nil
+}
+
+type fakeReconciliationRepo struct {
+	repository.ReconciliationRepository
+}
+
+func (fakeReconciliationRepo) Create(
+	_ context.Context,
+	_ *payments.Reconciliation,
+) error {
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...zap.Field)  {}
+func (nopLogger) Error(string, ...zap.Field) {}
+func (nopLogger) Warn(string, ...zap.Field)  {}
+func (nopLogger) Debug(string, ...zap.Field) {}
+
+func (nopLogger) WithContext(context.Context) *logger.Logger {
+	return nil
+}
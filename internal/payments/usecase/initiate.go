@@ -2,13 +2,16 @@ package usecase
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
 	"time"
 
 	"dunhayat-api/internal/payments"
 	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
 	"dunhayat-api/pkg/config"
+	"dunhayat-api/pkg/database"
 	"dunhayat-api/pkg/logger"
 	"dunhayat-api/pkg/payment"
 
@@ -23,23 +26,33 @@ type InitiatePaymentUseCase interface {
 }
 
 type initiatePaymentUseCase struct {
+	transactor  database.Transactor
 	orderPort   port.OrderPort
-	zibalClient *payment.ZibalClient
+	paymentRepo repository.PaymentRepository
+	gateways    *payment.Registry
 	logger      logger.Interface
 	config      *config.Config
+	// callbackURL is where gateways call back, followed by the method
+	callbackURL string
 }
 
 func NewInitiatePaymentUseCase(
+	transactor database.Transactor,
 	orderPort port.OrderPort,
-	zibalClient *payment.ZibalClient,
+	paymentRepo repository.PaymentRepository,
+	gateways *payment.Registry,
 	logger logger.Interface,
 	config *config.Config,
+	callbackURL string,
 ) InitiatePaymentUseCase {
 	return &initiatePaymentUseCase{
+		transactor:  transactor,
 		orderPort:   orderPort,
-		zibalClient: zibalClient,
+		paymentRepo: paymentRepo,
+		gateways:    gateways,
 		logger:      logger,
 		config:      config,
+		callbackURL: callbackURL,
 	}
 }
 
@@ -47,54 +60,229 @@ func (uc *initiatePaymentUseCase) Execute(
 	ctx context.Context,
 	req *payments.InitiatePaymentRequest,
 ) (*payments.InitiatePaymentResponse, error) {
-	callbackURL := uc.config.App.Domain + req.CallbackURL
+	if !uc.config.App.AllowsReturnURL(req.ReturnURL) {
+		return nil, fmt.Errorf(
+			"%w: %s", payments.ErrInvalidReturnURL, req.ReturnURL,
+		)
+	}
+
+	methods, err := uc.methodsFor(req.Method)
+	if err != nil {
+		return nil, err
+	}
 
-	zibalReq := payment.ZibalPaymentRequest{
-		Amount:      req.Amount,
-		OrderID:     req.OrderID.String(),
-		CallbackURL: callbackURL,
-		Description: req.Description,
+	var metadata *string
+	if len(req.Metadata) > 0 {
+		raw, err := json.Marshal(req.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to marshal payment metadata: %w", err,
+			)
+		}
+		encoded := string(raw)
+		metadata = &encoded
 	}
 
-	uc.logger.Info("Initiating Zibal payment request",
-		zap.String("order_id", req.OrderID.String()),
-		zap.Int("amount", req.Amount),
-		zap.String("callback_url", callbackURL),
+	// Each gateway tried is a payment attempt of its own, so a gateway
+	// that failed leaves a failed payment behind
+	var attemptErr error
+	for _, method := range methods {
+		pmt := &payments.Payment{
+			OrderID:     req.OrderID,
+			UserID:      req.UserID,
+			Amount:      req.Amount,
+			Status:      payments.PaymentStatusPending,
+			Method:      method,
+			ReturnURL:   req.ReturnURL,
+			CallbackURL: uc.callbackURL + "/" + method.String(),
+			Description: req.Description,
+			Metadata:    metadata,
+		}
+
+		if err := uc.open(ctx, pmt); err != nil {
+			return nil, err
+		}
+
+		attemptErr = uc.attempt(ctx, pmt)
+		if attemptErr == nil {
+			return &payments.InitiatePaymentResponse{
+				PaymentID:    pmt.ID,
+				GatewayURL:   *pmt.GatewayURL,
+				GatewayRefID: *pmt.GatewayRefID,
+				Status:       pmt.Status,
+				Amount:       pmt.Amount,
+				ExpiresAt:    pmt.CreatedAt.Add(payments.PaymentExpiry),
+			}, nil
+		}
+	}
+
+	return nil, attemptErr
+}
+
+// methodsFor returns the gateways to try in order: the requested one, or
+// the default one followed by the other enabled gateways when failover is
+// on.
+func (uc *initiatePaymentUseCase) methodsFor(
+	requested payments.PaymentMethod,
+) ([]payments.PaymentMethod, error) {
+	if requested != "" {
+		if _, err := uc.gateways.Get(requested.String()); err != nil {
+			return nil, fmt.Errorf(
+				"%w: %s", payments.ErrUnsupportedPaymentMethod, requested,
+			)
+		}
+		return []payments.PaymentMethod{requested}, nil
+	}
+
+	names := uc.gateways.Names()
+	if len(names) == 0 {
+		return nil, fmt.Errorf(
+			"%w: no payment gateway is enabled",
+			payments.ErrUnsupportedPaymentMethod,
+		)
+	}
+
+	methods := []payments.PaymentMethod{
+		payments.PaymentMethod(uc.config.Payment.DefaultMethod),
+	}
+	if _, err := uc.gateways.Get(uc.config.Payment.DefaultMethod); err != nil {
+		methods[0] = payments.PaymentMethod(names[0])
+	}
+	if uc.config.Payment.Failover {
+		for _, name := range names {
+			if method := payments.PaymentMethod(name); method != methods[0] {
+				methods = append(methods, method)
+			}
+		}
+	}
+
+	return methods, nil
+}
+
+// open creates a pending payment for the sale with the sale's row locked,
+// so that two requests cannot both start paying the same order: while an
+// attempt is pending the order may still be charged through it, and a new
+// one is refused until it is paid, fails or expires.
+func (uc *initiatePaymentUseCase) open(
+	ctx context.Context,
+	pmt *payments.Payment,
+) error {
+	return uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		sale, err := uc.orderPort.LockSale(ctx, pmt.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to lock sale: %w", err)
+		}
+		if sale == nil || sale.UserID != pmt.UserID {
+			return errors.New("sale not found")
+		}
+		if sale.Status != port.OrderStatusPending &&
+			sale.Status != port.OrderStatusFailed {
+			return fmt.Errorf(
+				"sale is not awaiting payment (status: %s)", sale.Status,
+			)
+		}
+		if pmt.Amount != sale.TotalPrice {
+			return fmt.Errorf(
+				"payment amount %d does not match order total %d",
+				pmt.Amount, sale.TotalPrice,
+			)
+		}
+
+		attempts, err := uc.paymentRepo.GetByOrderID(ctx, sale.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get payments of order: %w", err)
+		}
+		for _, attempt := range attempts {
+			if attempt.Status == payments.PaymentStatusPending {
+				return fmt.Errorf(
+					"%w: %s", payments.ErrPaymentInProgress, attempt.ID,
+				)
+			}
+		}
+
+		if sale.Status == port.OrderStatusFailed {
+			if err := uc.orderPort.UpdateSaleStatus(
+				ctx, sale.ID, port.OrderStatusPending, "new payment attempt",
+			); err != nil {
+				return fmt.Errorf(
+					"failed to reopen sale for payment: %w", err,
+				)
+			}
+		}
+
+		if err := uc.paymentRepo.Create(ctx, pmt); err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+		return nil
+	})
+}
+
+// attempt registers a pending payment with its gateway. A payment the
+// gateway refused is kept as failed, which lets the next attempt open.
+func (uc *initiatePaymentUseCase) attempt(
+	ctx context.Context,
+	pmt *payments.Payment,
+) error {
+	gateway, err := uc.gateways.Get(pmt.Method.String())
+	if err != nil {
+		return fmt.Errorf(
+			"%w: %s", payments.ErrUnsupportedPaymentMethod, pmt.Method,
+		)
+	}
+
+	uc.logger.Info("Initiating gateway payment request",
+		zap.String("method", pmt.Method.String()),
+		zap.String("order_id", pmt.OrderID.String()),
+		zap.String("payment_id", pmt.ID.String()),
+		zap.Int("amount", pmt.Amount),
+		zap.String("callback_url", pmt.CallbackURL),
 	)
 
-	zibalResp, err := uc.zibalClient.CreatePaymentRequest(zibalReq)
+	reference, err := gateway.CreatePayment(ctx, payment.CreateRequest{
+		Amount:      pmt.Amount,
+		OrderID:     pmt.OrderID.String(),
+		CallbackURL: pmt.CallbackURL,
+		Description: pmt.Description,
+	})
 	if err != nil {
-		uc.logger.Error("Zibal payment request failed",
-			zap.String("order_id", req.OrderID.String()),
+		uc.logger.Error("Gateway payment request failed",
+			zap.String("method", pmt.Method.String()),
+			zap.String("order_id", pmt.OrderID.String()),
+			zap.String("payment_id", pmt.ID.String()),
 			zap.Error(err),
 		)
-		return nil, fmt.Errorf(
-			"failed to create zibal payment request: %w", err,
+
+		now := time.Now()
+		pmt.Status = payments.PaymentStatusFailed
+		pmt.FailedAt = &now
+		if updateErr := uc.paymentRepo.Update(ctx, pmt); updateErr != nil {
+			uc.logger.Error("Failed to mark payment as failed",
+				zap.String("payment_id", pmt.ID.String()),
+				zap.Error(updateErr),
+			)
+		}
+
+		return fmt.Errorf(
+			"failed to create %s payment request: %w", pmt.Method, err,
 		)
 	}
 
-	trackIDStr := strconv.FormatInt(zibalResp.TrackID, 10)
-	uc.logger.Info("Zibal payment request successful",
-		zap.String("order_id", req.OrderID.String()),
-		zap.String("track_id", trackIDStr),
+	uc.logger.Info("Gateway payment request successful",
+		zap.String("method", pmt.Method.String()),
+		zap.String("order_id", pmt.OrderID.String()),
+		zap.String("payment_id", pmt.ID.String()),
+		zap.String("track_id", reference),
 	)
 
-	if err := uc.orderPort.SetSaleTrackingCode(
-		ctx, req.OrderID, trackIDStr,
-	); err != nil {
-		return nil, fmt.Errorf(
-			"failed to set tracking code on sale: %w", err,
+	gatewayURL := gateway.PaymentURL(reference)
+
+	pmt.GatewayRefID = &reference
+	pmt.GatewayURL = &gatewayURL
+	if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+		return fmt.Errorf(
+			"failed to store gateway reference on payment: %w", err,
 		)
 	}
 
-	gatewayURL := uc.zibalClient.GetPaymentURL(zibalResp.TrackID)
-
-	return &payments.InitiatePaymentResponse{
-		PaymentID:    req.OrderID,
-		GatewayURL:   gatewayURL,
-		GatewayRefID: trackIDStr,
-		Status:       payments.PaymentStatusPending,
-		Amount:       req.Amount,
-		ExpiresAt:    time.Now().Add(30 * time.Minute),
-	}, nil
+	return nil
 }
@@ -0,0 +1,430 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"go.uber.org/zap"
+)
+
+// ReconcileConfig bounds what a reconciliation run looks at.
+type ReconcileConfig struct {
+	// MinAge leaves recent payments to their callback
+	MinAge time.Duration
+	// Lookback is how far back cancelled and failed payments are checked
+	// for money the gateway took anyway
+	Lookback time.Duration
+	// BatchSize is the most payments checked per run
+	BatchSize int
+}
+
+// ReconcilePaymentsUseCase asks the gateways about payments that never
+// heard back from them, such as when the customer closed the browser after
+// paying or the callback could not reach us. Pending payments are settled,
+// failed or, once abandoned, cancelled with the stock of their order
+// released; cancelled and failed payments the gateway took money for are
+// flagged. Every run stores a report of the payments that differed.
+type ReconcilePaymentsUseCase interface {
+	Execute(ctx context.Context) (*payments.Reconciliation, error)
+}
+
+type reconcilePaymentsUseCase struct {
+	orderPort          port.OrderPort
+	paymentRepo        repository.PaymentRepository
+	reconciliationRepo repository.ReconciliationRepository
+	gateways           *payment.Registry
+	settler            *paymentSettler
+	cfg                ReconcileConfig
+	logger             logger.Interface
+}
+
+func NewReconcilePaymentsUseCase(
+	transactor database.Transactor,
+	orderPort port.OrderPort,
+	paymentRepo repository.PaymentRepository,
+	fraudAlertRepo repository.FraudAlertRepository,
+	reconciliationRepo repository.ReconciliationRepository,
+	gateways *payment.Registry,
+	cfg ReconcileConfig,
+	logger logger.Interface,
+) ReconcilePaymentsUseCase {
+	return &reconcilePaymentsUseCase{
+		orderPort:          orderPort,
+		paymentRepo:        paymentRepo,
+		reconciliationRepo: reconciliationRepo,
+		gateways:           gateways,
+		settler: newPaymentSettler(
+			transactor,
+			orderPort,
+			paymentRepo,
+			fraudAlertRepo,
+			gateways,
+			logger,
+		),
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (uc *reconcilePaymentsUseCase) Execute(
+	ctx context.Context,
+) (*payments.Reconciliation, error) {
+	now := time.Now()
+	report := &payments.Reconciliation{
+		StartedAt: now,
+	}
+
+	paymentList, err := uc.paymentRepo.GetUnreconciled(
+		ctx, now.Add(-uc.cfg.MinAge), now.Add(-uc.cfg.Lookback),
+		uc.cfg.BatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unreconciled payments: %w", err)
+	}
+
+	for i := range paymentList {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		pmt := &paymentList[i]
+		report.Checked++
+
+		item := uc.reconcile(ctx, pmt)
+		if item == nil {
+			continue
+		}
+
+		switch item.Action {
+		case payments.ReconciliationSettled:
+			report.Settled++
+		case payments.ReconciliationFailed:
+			report.Failed++
+		case payments.ReconciliationReleased:
+			report.Released++
+		case payments.ReconciliationFlagged:
+			report.Flagged++
+		case payments.ReconciliationError:
+			report.Errors++
+		}
+		report.Items = append(report.Items, *item)
+
+		uc.logger.Info("Payment reconciled",
+			zap.String("payment_id", pmt.ID.String()),
+			zap.String("order_id", pmt.OrderID.String()),
+			zap.String("local_status", item.LocalStatus.String()),
+			zap.String("gateway_status", item.GatewayStatus),
+			zap.String("action", item.Action.String()),
+			zap.String("detail", item.Detail),
+		)
+	}
+
+	finished := time.Now()
+	report.FinishedAt = &finished
+
+	if err := uc.reconciliationRepo.Create(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to store reconciliation: %w", err)
+	}
+
+	if len(report.Items) > 0 {
+		uc.logger.Info("Payment reconciliation finished",
+			zap.String("reconciliation_id", report.ID.String()),
+			zap.Int("checked", report.Checked),
+			zap.Int("settled", report.Settled),
+			zap.Int("failed", report.Failed),
+			zap.Int("released", report.Released),
+			zap.Int("flagged", report.Flagged),
+			zap.Int("errors", report.Errors),
+		)
+	}
+
+	return report, nil
+}
+
+// reconcile brings a payment in line with its gateway and returns what it
+// did, or nil when the payment agreed with the gateway.
+func (uc *reconcilePaymentsUseCase) reconcile(
+	ctx context.Context,
+	pmt *payments.Payment,
+) *payments.ReconciliationItem {
+	item := &payments.ReconciliationItem{
+		PaymentID:    pmt.ID,
+		OrderID:      pmt.OrderID,
+		Method:       pmt.Method,
+		GatewayRefID: pmt.GatewayRefID,
+		LocalStatus:  pmt.Status,
+		Amount:       pmt.Amount,
+	}
+
+	if pmt.GatewayRefID == nil || *pmt.GatewayRefID == "" {
+		// The gateway never registered the payment, so nobody can pay
+		// it. Its order is left alone, as another attempt may pay it.
+		if pmt.Status != payments.PaymentStatusPending {
+			return uc.markReconciled(ctx, pmt, nil)
+		}
+		item.GatewayStatus = payment.InquiryUndefined.String()
+		item.Action = payments.ReconciliationFailed
+		item.Detail = "payment was never registered with the gateway"
+		return uc.failUnregistered(ctx, pmt, item)
+	}
+
+	gateway, err := uc.gateways.Get(pmt.Method.String())
+	if err != nil {
+		item.GatewayStatus = payment.InquiryUndefined.String()
+		return uc.errorItem(item, fmt.Errorf(
+			"%w: %s", payments.ErrUnsupportedPaymentMethod, pmt.Method,
+		))
+	}
+
+	inquiry, err := gateway.Inquire(ctx, *pmt.GatewayRefID)
+	if err != nil {
+		item.GatewayStatus = payment.InquiryUndefined.String()
+		return uc.errorItem(item, fmt.Errorf("inquiry failed: %w", err))
+	}
+	item.GatewayStatus = inquiry.Status.String()
+	if inquiry.Amount != 0 {
+		amount := inquiry.Amount
+		item.GatewayAmount = &amount
+	}
+
+	abandoned := time.Since(pmt.CreatedAt) > payments.PaymentExpiry
+
+	if pmt.Status != payments.PaymentStatusPending {
+		switch inquiry.Status {
+		case payment.InquiryPaid:
+			item.Action = payments.ReconciliationFlagged
+			item.Detail = fmt.Sprintf(
+				"gateway took the money of a %s payment; it returns "+
+					"it to the customer unless the payment is verified",
+				pmt.Status,
+			)
+			return uc.markReconciled(ctx, pmt, item)
+		case payment.InquiryVerified:
+			item.Action = payments.ReconciliationFlagged
+			item.Detail = fmt.Sprintf(
+				"gateway verified a %s payment; the customer must be "+
+					"refunded", pmt.Status,
+			)
+			return uc.markReconciled(ctx, pmt, item)
+		case payment.InquiryPending:
+			if !abandoned {
+				return nil
+			}
+			return uc.markReconciled(ctx, pmt, nil)
+		case payment.InquiryUndefined:
+			item.Action = payments.ReconciliationFlagged
+			item.Detail = "gateway reported an unknown status: " +
+				inquiry.Message
+			return uc.markReconciled(ctx, pmt, item)
+		default:
+			return uc.markReconciled(ctx, pmt, nil)
+		}
+	}
+
+	switch inquiry.Status {
+	case payment.InquiryPaid, payment.InquiryVerified:
+		return uc.settle(ctx, pmt, item)
+	case payment.InquiryFailed, payment.InquiryRefunded:
+		return uc.fail(ctx, pmt, item, fmt.Sprintf(
+			"gateway reports the payment %s (status: %d)",
+			inquiry.Status, inquiry.Code,
+		))
+	case payment.InquiryPending:
+		if !abandoned {
+			return nil
+		}
+		return uc.release(ctx, pmt, item)
+	default:
+		// Left pending, so that it is asked about again
+		item.Action = payments.ReconciliationFlagged
+		item.Detail = "gateway reported an unknown status: " + inquiry.Message
+		return item
+	}
+}
+
+// settle verifies a payment the gateway has taken the money for. A
+// payment a callback settled meanwhile is left alone.
+func (uc *reconcilePaymentsUseCase) settle(
+	ctx context.Context,
+	pmt *payments.Payment,
+	item *payments.ReconciliationItem,
+) *payments.ReconciliationItem {
+	settled, fraud, err := uc.settler.settleLocked(ctx, pmt)
+	switch {
+	case err == nil && !settled:
+		return nil
+	case errors.Is(err, port.ErrInvalidStatusTransition):
+		// The payment was stored, but its order had moved on meanwhile
+		item.Action = payments.ReconciliationFlagged
+		item.Detail = fmt.Sprintf(
+			"payment is now %s but its order could not follow: %v",
+			pmt.Status, err,
+		)
+		return uc.markReconciled(ctx, pmt, item)
+	case err != nil:
+		return uc.errorItem(item, err)
+	case fraud:
+		item.Action = payments.ReconciliationFlagged
+		item.Detail = "verification did not match the order; " +
+			"a fraud alert was raised"
+	case pmt.Status == payments.PaymentStatusPaid:
+		item.Action = payments.ReconciliationSettled
+		item.Detail = "payment verified with the gateway"
+	default:
+		item.Action = payments.ReconciliationFailed
+		item.Detail = "gateway did not confirm the payment"
+	}
+	return uc.markReconciled(ctx, pmt, item)
+}
+
+func (uc *reconcilePaymentsUseCase) fail(
+	ctx context.Context,
+	pmt *payments.Payment,
+	item *payments.ReconciliationItem,
+	reason string,
+) *payments.ReconciliationItem {
+	item.Action = payments.ReconciliationFailed
+	item.Detail = reason
+
+	// Like settle, the payment is kept failed even if its sale cannot
+	// follow
+	var failErr error
+	failed, err := uc.settler.whilePending(ctx, pmt, func(ctx context.Context) error {
+		failErr = uc.settler.markFailed(ctx, pmt, reason)
+		return nil
+	})
+	if err != nil {
+		return uc.errorItem(item, err)
+	}
+	if !failed {
+		return nil
+	}
+
+	if err := failErr; err != nil {
+		if !errors.Is(err, port.ErrInvalidStatusTransition) {
+			return uc.errorItem(item, err)
+		}
+		item.Action = payments.ReconciliationFlagged
+		item.Detail = fmt.Sprintf(
+			"%s; its order could not follow: %v", reason, err,
+		)
+	}
+	return uc.markReconciled(ctx, pmt, item)
+}
+
+// release cancels a payment the customer never completed and releases the
+// stock of its order.
+func (uc *reconcilePaymentsUseCase) release(
+	ctx context.Context,
+	pmt *payments.Payment,
+	item *payments.ReconciliationItem,
+) *payments.ReconciliationItem {
+	var released, superseded bool
+	pending, err := uc.settler.whilePending(ctx, pmt, func(ctx context.Context) error {
+		now := time.Now()
+		pmt.Status = payments.PaymentStatusCancelled
+		pmt.ReconciledAt = &now
+		if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		// The customer may be paying for the order with another attempt,
+		// whose reservation must stay
+		var err error
+		superseded, err = uc.settler.hasOtherPending(ctx, pmt)
+		if err != nil {
+			return err
+		}
+		if superseded {
+			return nil
+		}
+
+		released, err = uc.orderPort.ReleaseSale(
+			ctx, pmt.OrderID, "payment abandoned at the gateway",
+		)
+		if err != nil {
+			return fmt.Errorf("failed to release sale: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		pmt.Status = item.LocalStatus
+		pmt.ReconciledAt = nil
+		return uc.errorItem(item, err)
+	}
+	if !pending {
+		return nil
+	}
+
+	item.Action = payments.ReconciliationReleased
+	item.Detail = "payment abandoned; cancelled"
+	switch {
+	case superseded:
+		item.Detail += ", leaving its order to another pending payment"
+	case released:
+		item.Detail += " and the stock of its order released"
+	}
+	return item
+}
+
+// failUnregistered fails a payment the gateway never registered, under
+// its row lock like every other settlement. A payment settled meanwhile
+// is left alone.
+func (uc *reconcilePaymentsUseCase) failUnregistered(
+	ctx context.Context,
+	pmt *payments.Payment,
+	item *payments.ReconciliationItem,
+) *payments.ReconciliationItem {
+	failed, err := uc.settler.whilePending(ctx, pmt, func(ctx context.Context) error {
+		now := time.Now()
+		pmt.Status = payments.PaymentStatusFailed
+		pmt.FailedAt = &now
+		pmt.ReconciledAt = &now
+		if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return uc.errorItem(item, err)
+	}
+	if !failed {
+		return nil
+	}
+	return item
+}
+
+// markReconciled records that the payment needs no more checking.
+func (uc *reconcilePaymentsUseCase) markReconciled(
+	ctx context.Context,
+	pmt *payments.Payment,
+	item *payments.ReconciliationItem,
+) *payments.ReconciliationItem {
+	now := time.Now()
+	pmt.ReconciledAt = &now
+	if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+		uc.logger.Error("Failed to mark payment reconciled",
+			zap.String("payment_id", pmt.ID.String()),
+			zap.Error(err),
+		)
+	}
+	return item
+}
+
+func (uc *reconcilePaymentsUseCase) errorItem(
+	item *payments.ReconciliationItem,
+	err error,
+) *payments.ReconciliationItem {
+	item.Action = payments.ReconciliationError
+	item.Detail = err.Error()
+	return item
+}
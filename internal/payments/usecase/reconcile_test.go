@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/pkg/payment"
+
+	"github.com/google/uuid"
+)
+
+type reconcileFixture struct {
+	sale    port.Sale
+	orders  *fakeOrderPort
+	pmts    *fakePaymentRepo
+	gateway *fakeGateway
+	useCase ReconcilePaymentsUseCase
+}
+
+func newReconcileFixture(
+	inquiry payment.Inquiry,
+	pmts ...payments.Payment,
+) *reconcileFixture {
+	f := &reconcileFixture{
+		orders:  newFakeOrderPort(),
+		pmts:    newFakePaymentRepo(pmts...),
+		gateway: &fakeGateway{inquiry: inquiry},
+	}
+	for _, pmt := range pmts {
+		f.orders.add(port.Sale{
+			ID:         pmt.OrderID,
+			UserID:     pmt.UserID,
+			Status:     port.OrderStatusPending,
+			TotalPrice: pmt.Amount,
+		})
+	}
+
+	gateways := payment.NewRegistry()
+	gateways.Register(payments.PaymentMethodZibal.String(), f.gateway)
+
+	f.useCase = NewReconcilePaymentsUseCase(
+		fakeTransactor{},
+		f.orders,
+		f.pmts,
+		&fakeFraudAlertRepo{},
+		fakeReconciliationRepo{},
+		gateways,
+		ReconcileConfig{BatchSize: 10},
+		nopLogger{},
+	)
+	return f
+}
+
+func pendingPayment(
+	orderID uuid.UUID,
+	trackID string,
+	age time.Duration,
+) payments.Payment {
+	pmt := payments.Payment{
+		ID:        uuid.New(),
+		OrderID:   orderID,
+		UserID:    uuid.New(),
+		Amount:    250_000,
+		Status:    payments.PaymentStatusPending,
+		Method:    payments.PaymentMethodZibal,
+		CreatedAt: time.Now().Add(-age),
+	}
+	if trackID != "" {
+		pmt.GatewayRefID = &trackID
+	}
+	return pmt
+}
+
+// An abandoned payment is cancelled, but the stock of its order stays
+// reserved while the customer pays with a newer attempt.
+func TestReconcileKeepsOrderOfAnotherPendingPayment(t *testing.T) {
+	orderID := uuid.New()
+	abandoned := pendingPayment(
+		orderID, "3000000001", payments.PaymentExpiry+time.Minute,
+	)
+	retry := pendingPayment(orderID, "3000000002", time.Minute)
+	f := newReconcileFixture(
+		payment.Inquiry{Status: payment.InquiryPending}, abandoned, retry,
+	)
+
+	report, err := f.useCase.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+
+	if pmt := f.pmts.get(abandoned.ID); pmt.Status != payments.PaymentStatusCancelled {
+		t.Errorf("abandoned payment is %s, want cancelled", pmt.Status)
+	}
+	if pmt := f.pmts.get(retry.ID); pmt.Status != payments.PaymentStatusPending {
+		t.Errorf("newer payment is %s, want pending", pmt.Status)
+	}
+	if f.orders.releases != 0 {
+		t.Errorf("order released %d times, want never", f.orders.releases)
+	}
+	if report.Released != 1 {
+		t.Errorf("report counts %d released, want 1", report.Released)
+	}
+}
+
+func TestReconcileReleasesOrderOfAbandonedPayment(t *testing.T) {
+	abandoned := pendingPayment(
+		uuid.New(), "3000000001", payments.PaymentExpiry+time.Minute,
+	)
+	f := newReconcileFixture(
+		payment.Inquiry{Status: payment.InquiryPending}, abandoned,
+	)
+
+	if _, err := f.useCase.Execute(context.Background()); err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+
+	if pmt := f.pmts.get(abandoned.ID); pmt.Status != payments.PaymentStatusCancelled {
+		t.Errorf("abandoned payment is %s, want cancelled", pmt.Status)
+	}
+	if f.orders.releases != 1 {
+		t.Errorf("order released %d times, want once", f.orders.releases)
+	}
+}
+
+// A payment the gateway never registered is failed under its row lock, so
+// that a callback settling it meanwhile is not overwritten.
+func TestReconcileFailsUnregisteredPaymentUnderLock(t *testing.T) {
+	unregistered := pendingPayment(uuid.New(), "", time.Hour)
+	f := newReconcileFixture(payment.Inquiry{}, unregistered)
+
+	// Settle the payment between the listing and the reconciliation
+	listed, err := f.pmts.GetUnreconciled(
+		context.Background(), time.Now(), time.Now(), 10,
+	)
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("GetUnreconciled = %v, %v", listed, err)
+	}
+	paid := listed[0]
+	paid.Status = payments.PaymentStatusPaid
+	if err := f.pmts.Update(context.Background(), &paid); err != nil {
+		t.Fatal(err)
+	}
+
+	uc := f.useCase.(*reconcilePaymentsUseCase)
+	item := uc.reconcile(context.Background(), &listed[0])
+	if item != nil {
+		t.Errorf("reconcile reported %s for a settled payment", item.Action)
+	}
+	if pmt := f.pmts.get(unregistered.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want it left paid", pmt.Status)
+	}
+
+	// Still pending, it is failed
+	f = newReconcileFixture(payment.Inquiry{}, unregistered)
+	report, err := f.useCase.Execute(context.Background())
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+	if pmt := f.pmts.get(unregistered.ID); pmt.Status != payments.PaymentStatusFailed {
+		t.Errorf("payment is %s, want failed", pmt.Status)
+	}
+	if report.Failed != 1 {
+		t.Errorf("report counts %d failed, want 1", report.Failed)
+	}
+}
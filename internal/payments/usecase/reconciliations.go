@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/pagination"
+
+	"github.com/google/uuid"
+)
+
+// ListReconciliationsUseCase returns the latest reconciliation reports,
+// without their items.
+type ListReconciliationsUseCase interface {
+	Execute(ctx context.Context, limit int) ([]payments.Reconciliation, error)
+}
+
+type listReconciliationsUseCase struct {
+	reconciliationRepo repository.ReconciliationRepository
+}
+
+func NewListReconciliationsUseCase(
+	reconciliationRepo repository.ReconciliationRepository,
+) ListReconciliationsUseCase {
+	return &listReconciliationsUseCase{
+		reconciliationRepo: reconciliationRepo,
+	}
+}
+
+func (uc *listReconciliationsUseCase) Execute(
+	ctx context.Context,
+	limit int,
+) ([]payments.Reconciliation, error) {
+	reconciliations, err := uc.reconciliationRepo.List(
+		ctx, pagination.Limit(limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reconciliations: %w", err)
+	}
+	return reconciliations, nil
+}
+
+// GetReconciliationUseCase returns a reconciliation report with the
+// payments that differed from their gateway.
+type GetReconciliationUseCase interface {
+	Execute(ctx context.Context, id uuid.UUID) (*payments.Reconciliation, error)
+}
+
+type getReconciliationUseCase struct {
+	reconciliationRepo repository.ReconciliationRepository
+}
+
+func NewGetReconciliationUseCase(
+	reconciliationRepo repository.ReconciliationRepository,
+) GetReconciliationUseCase {
+	return &getReconciliationUseCase{
+		reconciliationRepo: reconciliationRepo,
+	}
+}
+
+func (uc *getReconciliationUseCase) Execute(
+	ctx context.Context,
+	id uuid.UUID,
+) (*payments.Reconciliation, error) {
+	reconciliation, err := uc.reconciliationRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reconciliation: %w", err)
+	}
+	if reconciliation == nil {
+		return nil, payments.ErrReconciliationNotFound
+	}
+	return reconciliation, nil
+}
@@ -0,0 +1,477 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RefundPaymentUseCase refunds part or all of the paid payment of an order
+// through its gateway. When the gateway has no refund API the refund is
+// recorded as a manual bank transfer that stays pending until staff
+// complete it. The payment is locked while its refunds are summed, so
+// concurrent refunds never add up to more than was paid; once the whole
+// amount is refunded the payment becomes refunded.
+//
+// The gateway is called outside of any transaction: the refund is stored
+// as pending first, then completed, or failed if the gateway rejects it,
+// so that no refund the gateway made goes unrecorded. When the gateway
+// cannot be reached or its answer is unclear the refund stays pending,
+// still counting towards what was refunded, until staff complete or void
+// it. Callers must not hold a transaction of their own around it.
+type RefundPaymentUseCase interface {
+	Execute(
+		ctx context.Context,
+		req *payments.RefundPaymentRequest,
+	) (*payments.RefundPaymentResponse, error)
+}
+
+type refundPaymentUseCase struct {
+	transactor  database.Transactor
+	paymentRepo repository.PaymentRepository
+	refundRepo  repository.RefundRepository
+	gateways    *payment.Registry
+	logger      logger.Interface
+}
+
+func NewRefundPaymentUseCase(
+	transactor database.Transactor,
+	paymentRepo repository.PaymentRepository,
+	refundRepo repository.RefundRepository,
+	gateways *payment.Registry,
+	logger logger.Interface,
+) RefundPaymentUseCase {
+	return &refundPaymentUseCase{
+		transactor:  transactor,
+		paymentRepo: paymentRepo,
+		refundRepo:  refundRepo,
+		gateways:    gateways,
+		logger:      logger,
+	}
+}
+
+func (uc *refundPaymentUseCase) Execute(
+	ctx context.Context,
+	req *payments.RefundPaymentRequest,
+) (*payments.RefundPaymentResponse, error) {
+	if req.Amount < 0 {
+		return nil, payments.ErrInvalidRefundAmount
+	}
+
+	pmt, refund, refundable, err := uc.record(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if refund.Channel == payments.RefundChannelGateway {
+		if err := uc.refund(ctx, pmt, refund); err != nil {
+			return nil, err
+		}
+	}
+
+	uc.logger.Info("Payment refunded",
+		zap.String("order_id", req.OrderID.String()),
+		zap.String("payment_id", refund.PaymentID.String()),
+		zap.String("refund_id", refund.ID.String()),
+		zap.Int("amount", refund.Amount),
+		zap.String("channel", refund.Channel.String()),
+		zap.String("status", refund.Status.String()),
+		zap.String("actor", req.Actor),
+		zap.String("reason", req.Reason),
+	)
+
+	return &payments.RefundPaymentResponse{
+		Refund:     refund,
+		Refundable: refundable,
+	}, nil
+}
+
+// record stores the refund as pending, through the gateway of the payment
+// when it can make refunds, and marks the payment refunded once nothing is
+// left of it. It returns the payment, the refund and what is left to
+// refund.
+func (uc *refundPaymentUseCase) record(
+	ctx context.Context,
+	req *payments.RefundPaymentRequest,
+) (*payments.Payment, *payments.Refund, int, error) {
+	var (
+		pmt        *payments.Payment
+		refund     *payments.Refund
+		refundable int
+	)
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		pmt, err = uc.paymentRepo.GetPaidByOrderIDForUpdate(ctx, req.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+		if pmt == nil {
+			return payments.ErrNoRefundablePayment
+		}
+
+		refunded, err := uc.refundRepo.SumByPaymentID(ctx, pmt.ID)
+		if err != nil {
+			return fmt.Errorf("failed to sum refunds: %w", err)
+		}
+		refundable = pmt.Amount - refunded
+
+		amount := req.Amount
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount <= 0 {
+			return payments.ErrNoRefundablePayment
+		}
+		if amount > refundable {
+			return &payments.RefundExceedsError{
+				Requested:  amount,
+				Refundable: refundable,
+				Paid:       pmt.Amount,
+			}
+		}
+
+		refund = &payments.Refund{
+			PaymentID: pmt.ID,
+			OrderID:   pmt.OrderID,
+			Amount:    amount,
+			Reason:    req.Reason,
+			Actor:     req.Actor,
+			Channel:   payments.RefundChannelManual,
+			Status:    payments.RefundStatusPending,
+		}
+		if uc.hasGateway(pmt) {
+			refund.Channel = payments.RefundChannelGateway
+		}
+		if err := uc.refundRepo.Create(ctx, refund); err != nil {
+			return fmt.Errorf("failed to create refund: %w", err)
+		}
+
+		refundable -= amount
+		if refundable == 0 {
+			pmt.Status = payments.PaymentStatusRefunded
+			if err := uc.paymentRepo.Update(ctx, pmt); err != nil {
+				return fmt.Errorf("failed to update payment: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if refund.Channel == payments.RefundChannelManual {
+		uc.logRefundManual(pmt, refund)
+	}
+	return pmt, refund, refundable, nil
+}
+
+func (uc *refundPaymentUseCase) hasGateway(pmt *payments.Payment) bool {
+	if pmt.GatewayRefID == nil || *pmt.GatewayRefID == "" {
+		return false
+	}
+	_, err := uc.gateways.Get(pmt.Method.String())
+	return err == nil
+}
+
+// refund asks the gateway of the payment to return the money of a pending
+// refund and stores the outcome. A gateway without a refund API turns the
+// refund into a manual bank transfer; a refund the gateway rejects fails
+// and the payment can be refunded again. Any other error may have come
+// after the gateway sent the money, so the refund is left pending.
+func (uc *refundPaymentUseCase) refund(
+	ctx context.Context,
+	pmt *payments.Payment,
+	refund *payments.Refund,
+) error {
+	gateway, err := uc.gateways.Get(pmt.Method.String())
+	if err != nil {
+		return fmt.Errorf(
+			"%w: %s", payments.ErrUnsupportedPaymentMethod, pmt.Method,
+		)
+	}
+
+	result, refundErr := gateway.Refund(ctx, payment.RefundRequest{
+		Reference:   *pmt.GatewayRefID,
+		Amount:      refund.Amount,
+		Description: refund.Reason,
+	})
+
+	update := *refund
+	switch {
+	case refundErr == nil:
+		now := time.Now()
+		update.Status = payments.RefundStatusCompleted
+		update.Reference = &result.RefundID
+		update.CompletedAt = &now
+	case errors.Is(refundErr, payment.ErrRefundNotSupported):
+		update.Channel = payments.RefundChannelManual
+	case errors.Is(refundErr, payment.ErrRefundRejected):
+		update.Status = payments.RefundStatusFailed
+	default:
+		uc.logger.Error("Gateway refund outcome unknown, left pending",
+			zap.String("payment_id", pmt.ID.String()),
+			zap.String("refund_id", refund.ID.String()),
+			zap.Int("amount", refund.Amount),
+			zap.Error(refundErr),
+		)
+		return fmt.Errorf(
+			"%w: refund %s with %s: %w",
+			payments.ErrRefundUnconfirmed, refund.ID, pmt.Method, refundErr,
+		)
+	}
+
+	if err := uc.settle(ctx, &update); err != nil {
+		if refundErr == nil {
+			// Staff complete it with this reference by hand
+			uc.logger.Error("Gateway refund could not be recorded",
+				zap.String("payment_id", pmt.ID.String()),
+				zap.String("refund_id", refund.ID.String()),
+				zap.Int("amount", refund.Amount),
+				zap.String("refund_reference", result.RefundID),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+	*refund = update
+
+	if refund.Status == payments.RefundStatusFailed {
+		return fmt.Errorf(
+			"failed to refund payment with %s: %w", pmt.Method, refundErr,
+		)
+	}
+	if refund.Channel == payments.RefundChannelManual {
+		uc.logRefundManual(pmt, refund)
+	}
+	return nil
+}
+
+// settle stores the outcome of a pending gateway refund. A failed refund
+// gives its amount back to the payment, which is paid again if the refund
+// had marked it refunded.
+func (uc *refundPaymentUseCase) settle(
+	ctx context.Context,
+	refund *payments.Refund,
+) error {
+	return uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		stored, err := uc.refundRepo.GetByIDForUpdate(ctx, refund.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get refund: %w", err)
+		}
+		if stored == nil {
+			return payments.ErrRefundNotFound
+		}
+		if stored.Status != payments.RefundStatusPending {
+			// Completed by staff meanwhile
+			*refund = *stored
+			return nil
+		}
+
+		if err := uc.refundRepo.Update(ctx, refund); err != nil {
+			return fmt.Errorf("failed to update refund: %w", err)
+		}
+
+		if refund.Status != payments.RefundStatusFailed {
+			return nil
+		}
+		return reopenRefundedPayment(ctx, uc.paymentRepo, refund.PaymentID)
+	})
+}
+
+// reopenRefundedPayment marks a refunded payment paid again once one of
+// its refunds failed, as part of its amount is refundable again.
+func reopenRefundedPayment(
+	ctx context.Context,
+	paymentRepo repository.PaymentRepository,
+	paymentID uuid.UUID,
+) error {
+	pmt, err := paymentRepo.GetByIDForUpdate(ctx, paymentID)
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+	if pmt == nil || pmt.Status != payments.PaymentStatusRefunded {
+		return nil
+	}
+	pmt.Status = payments.PaymentStatusPaid
+	if err := paymentRepo.Update(ctx, pmt); err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	return nil
+}
+
+func (uc *refundPaymentUseCase) logRefundManual(
+	pmt *payments.Payment,
+	refund *payments.Refund,
+) {
+	uc.logger.Warn("Refund awaiting manual bank transfer",
+		zap.String("payment_id", pmt.ID.String()),
+		zap.String("method", pmt.Method.String()),
+		zap.Int("amount", refund.Amount),
+	)
+}
+
+// CompleteRefundUseCase records that the bank transfer of a manual refund
+// was made, or that a gateway refund whose outcome was unknown went
+// through.
+type CompleteRefundUseCase interface {
+	Execute(
+		ctx context.Context,
+		refundID uuid.UUID,
+		req *payments.CompleteRefundRequest,
+	) (*payments.Refund, error)
+}
+
+type completeRefundUseCase struct {
+	transactor database.Transactor
+	refundRepo repository.RefundRepository
+}
+
+func NewCompleteRefundUseCase(
+	transactor database.Transactor,
+	refundRepo repository.RefundRepository,
+) CompleteRefundUseCase {
+	return &completeRefundUseCase{
+		transactor: transactor,
+		refundRepo: refundRepo,
+	}
+}
+
+func (uc *completeRefundUseCase) Execute(
+	ctx context.Context,
+	refundID uuid.UUID,
+	req *payments.CompleteRefundRequest,
+) (*payments.Refund, error) {
+	reference := strings.TrimSpace(req.Reference)
+	if reference == "" {
+		return nil, payments.ErrRefundReferenceRequired
+	}
+
+	var refund *payments.Refund
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		refund, err = uc.refundRepo.GetByIDForUpdate(ctx, refundID)
+		if err != nil {
+			return fmt.Errorf("failed to get refund: %w", err)
+		}
+		if refund == nil {
+			return payments.ErrRefundNotFound
+		}
+		if refund.Status != payments.RefundStatusPending {
+			return fmt.Errorf(
+				"%w: refund is %s", payments.ErrRefundNotPending, refund.Status,
+			)
+		}
+
+		now := time.Now()
+		refund.Status = payments.RefundStatusCompleted
+		refund.Reference = &reference
+		refund.CompletedAt = &now
+		if err := uc.refundRepo.Update(ctx, refund); err != nil {
+			return fmt.Errorf("failed to update refund: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// VoidRefundUseCase records that a pending refund was never made, such as
+// a gateway refund whose outcome was unknown and that the gateway's panel
+// shows was not sent. The refund fails and its amount can be refunded
+// again.
+type VoidRefundUseCase interface {
+	Execute(ctx context.Context, refundID uuid.UUID) (*payments.Refund, error)
+}
+
+type voidRefundUseCase struct {
+	transactor  database.Transactor
+	paymentRepo repository.PaymentRepository
+	refundRepo  repository.RefundRepository
+}
+
+func NewVoidRefundUseCase(
+	transactor database.Transactor,
+	paymentRepo repository.PaymentRepository,
+	refundRepo repository.RefundRepository,
+) VoidRefundUseCase {
+	return &voidRefundUseCase{
+		transactor:  transactor,
+		paymentRepo: paymentRepo,
+		refundRepo:  refundRepo,
+	}
+}
+
+func (uc *voidRefundUseCase) Execute(
+	ctx context.Context,
+	refundID uuid.UUID,
+) (*payments.Refund, error) {
+	var refund *payments.Refund
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		refund, err = uc.refundRepo.GetByIDForUpdate(ctx, refundID)
+		if err != nil {
+			return fmt.Errorf("failed to get refund: %w", err)
+		}
+		if refund == nil {
+			return payments.ErrRefundNotFound
+		}
+		if refund.Status != payments.RefundStatusPending {
+			return fmt.Errorf(
+				"%w: refund is %s", payments.ErrRefundNotPending, refund.Status,
+			)
+		}
+
+		refund.Status = payments.RefundStatusFailed
+		if err := uc.refundRepo.Update(ctx, refund); err != nil {
+			return fmt.Errorf("failed to update refund: %w", err)
+		}
+		return reopenRefundedPayment(ctx, uc.paymentRepo, refund.PaymentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// ListRefundsUseCase returns the refunds of an order, oldest first.
+type ListRefundsUseCase interface {
+	Execute(ctx context.Context, orderID uuid.UUID) ([]payments.Refund, error)
+}
+
+type listRefundsUseCase struct {
+	refundRepo repository.RefundRepository
+}
+
+func NewListRefundsUseCase(
+	refundRepo repository.RefundRepository,
+) ListRefundsUseCase {
+	return &listRefundsUseCase{
+		refundRepo: refundRepo,
+	}
+}
+
+func (uc *listRefundsUseCase) Execute(
+	ctx context.Context,
+	orderID uuid.UUID,
+) ([]payments.Refund, error) {
+	refunds, err := uc.refundRepo.GetByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %w", err)
+	}
+	return refunds, nil
+}
@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/pkg/payment"
+
+	"github.com/google/uuid"
+)
+
+type refundFixture struct {
+	pmt     payments.Payment
+	pmts    *fakePaymentRepo
+	refunds *fakeRefundRepo
+	gateway *fakeGateway
+	useCase RefundPaymentUseCase
+}
+
+func newRefundFixture(
+	refund func(ctx context.Context) (*payment.RefundResult, error),
+) *refundFixture {
+	trackID := "3000000001"
+	pmt := payments.Payment{
+		ID:           uuid.New(),
+		OrderID:      uuid.New(),
+		UserID:       uuid.New(),
+		Amount:       250_000,
+		Status:       payments.PaymentStatusPaid,
+		Method:       payments.PaymentMethodZibal,
+		GatewayRefID: &trackID,
+	}
+
+	f := &refundFixture{
+		pmt:     pmt,
+		pmts:    newFakePaymentRepo(pmt),
+		refunds: &fakeRefundRepo{},
+		gateway: &fakeGateway{refund: refund},
+	}
+
+	gateways := payment.NewRegistry()
+	gateways.Register(payments.PaymentMethodZibal.String(), f.gateway)
+
+	f.useCase = NewRefundPaymentUseCase(
+		fakeTransactor{},
+		f.pmts,
+		f.refunds,
+		gateways,
+		nopLogger{},
+	)
+	return f
+}
+
+func (f *refundFixture) execute(
+	amount int,
+) (*payments.RefundPaymentResponse, error) {
+	return f.useCase.Execute(
+		context.Background(),
+		&payments.RefundPaymentRequest{
+			OrderID: f.pmt.OrderID,
+			Amount:  amount,
+			Reason:  "damaged in transit",
+			Actor:   "staff:test",
+		},
+	)
+}
+
+// The gateway is asked for the refund outside of any transaction, once
+// the refund is stored as pending.
+func TestRefundPaymentCallsGatewayOutsideTransaction(t *testing.T) {
+	var f *refundFixture
+	f = newRefundFixture(func(ctx context.Context) (*payment.RefundResult, error) {
+		if _, ok := ctx.Value(fakeTxKey{}).(*fakeTx); ok {
+			t.Error("gateway refund called inside a transaction")
+		}
+		if len(f.refunds.refunds) != 1 {
+			t.Errorf(
+				"%d refunds stored before the gateway call, want 1",
+				len(f.refunds.refunds),
+			)
+		}
+		for _, refund := range f.refunds.refunds {
+			if refund.Status != payments.RefundStatusPending {
+				t.Errorf(
+					"refund is %s during the gateway call, want pending",
+					refund.Status,
+				)
+			}
+		}
+		return &payment.RefundResult{RefundID: "R-1"}, nil
+	})
+
+	response, err := f.execute(0)
+	if err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+
+	if response.Refund.Status != payments.RefundStatusCompleted ||
+		response.Refund.Channel != payments.RefundChannelGateway {
+		t.Errorf(
+			"refund is %s through %s, want completed through gateway",
+			response.Refund.Status, response.Refund.Channel,
+		)
+	}
+	stored := f.refunds.refunds[response.Refund.ID]
+	if stored.Reference == nil || *stored.Reference != "R-1" {
+		t.Errorf("stored refund reference = %v, want R-1", stored.Reference)
+	}
+	if response.Refundable != 0 {
+		t.Errorf("refundable = %d, want 0", response.Refundable)
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusRefunded {
+		t.Errorf("payment is %s, want refunded", pmt.Status)
+	}
+}
+
+// A refund the gateway rejects is kept as failed, and the payment can be
+// refunded again.
+func TestRefundPaymentRefusedByGatewayFails(t *testing.T) {
+	refused := fmt.Errorf("%w: insufficient balance", payment.ErrRefundRejected)
+	f := newRefundFixture(func(context.Context) (*payment.RefundResult, error) {
+		return nil, refused
+	})
+
+	if _, err := f.execute(0); !errors.Is(err, refused) {
+		t.Fatalf("refund error = %v, want %v", err, refused)
+	}
+
+	if len(f.refunds.refunds) != 1 {
+		t.Fatalf("%d refunds stored, want 1", len(f.refunds.refunds))
+	}
+	for _, refund := range f.refunds.refunds {
+		if refund.Status != payments.RefundStatusFailed {
+			t.Errorf("refund is %s, want failed", refund.Status)
+		}
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want paid again", pmt.Status)
+	}
+
+	f.gateway.refund = func(context.Context) (*payment.RefundResult, error) {
+		return &payment.RefundResult{RefundID: "R-2"}, nil
+	}
+	response, err := f.execute(100_000)
+	if err != nil {
+		t.Fatalf("second refund failed: %v", err)
+	}
+	if response.Refundable != f.pmt.Amount-100_000 {
+		t.Errorf(
+			"refundable = %d, want %d",
+			response.Refundable, f.pmt.Amount-100_000,
+		)
+	}
+}
+
+// A gateway without a refund API leaves the refund pending for a manual
+// bank transfer.
+func TestRefundPaymentWithoutRefundAPIIsManual(t *testing.T) {
+	f := newRefundFixture(nil)
+
+	response, err := f.execute(50_000)
+	if err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+
+	stored := f.refunds.refunds[response.Refund.ID]
+	if stored.Channel != payments.RefundChannelManual ||
+		stored.Status != payments.RefundStatusPending {
+		t.Errorf(
+			"refund is %s through %s, want pending manual bank transfer",
+			stored.Status, stored.Channel,
+		)
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want paid", pmt.Status)
+	}
+}
+
+// A refund the gateway could not be asked about, or whose answer is
+// unclear, may have been made: it stays pending and counts as refunded, so
+// a retry cannot refund twice, until staff void it.
+func TestRefundPaymentUnknownOutcomeStaysPending(t *testing.T) {
+	timeout := errors.New("zibal refund request failed: context deadline exceeded")
+	f := newRefundFixture(func(context.Context) (*payment.RefundResult, error) {
+		return nil, timeout
+	})
+
+	if _, err := f.execute(0); !errors.Is(err, payments.ErrRefundUnconfirmed) ||
+		!errors.Is(err, timeout) {
+		t.Fatalf("refund error = %v, want unconfirmed %v", err, timeout)
+	}
+
+	if len(f.refunds.refunds) != 1 {
+		t.Fatalf("%d refunds stored, want 1", len(f.refunds.refunds))
+	}
+	var refundID uuid.UUID
+	for id, refund := range f.refunds.refunds {
+		refundID = id
+		if refund.Status != payments.RefundStatusPending ||
+			refund.Channel != payments.RefundChannelGateway {
+			t.Errorf(
+				"refund is %s through %s, want pending through gateway",
+				refund.Status, refund.Channel,
+			)
+		}
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusRefunded {
+		t.Errorf("payment is %s, want refunded", pmt.Status)
+	}
+
+	if _, err := f.execute(0); !errors.Is(err, payments.ErrNoRefundablePayment) {
+		t.Fatalf("retried refund error = %v, want nothing to refund", err)
+	}
+
+	void := NewVoidRefundUseCase(fakeTransactor{}, f.pmts, f.refunds)
+	refund, err := void.Execute(context.Background(), refundID)
+	if err != nil {
+		t.Fatalf("void failed: %v", err)
+	}
+	if refund.Status != payments.RefundStatusFailed {
+		t.Errorf("voided refund is %s, want failed", refund.Status)
+	}
+	if pmt := f.pmts.get(f.pmt.ID); pmt.Status != payments.PaymentStatusPaid {
+		t.Errorf("payment is %s, want paid again", pmt.Status)
+	}
+	if _, err := void.Execute(context.Background(), refundID); !errors.Is(
+		err, payments.ErrRefundNotPending,
+	) {
+		t.Errorf("second void error = %v, want refund not pending", err)
+	}
+
+	f.gateway.refund = func(context.Context) (*payment.RefundResult, error) {
+		return &payment.RefundResult{RefundID: "R-2"}, nil
+	}
+	if _, err := f.execute(0); err != nil {
+		t.Fatalf("refund after void failed: %v", err)
+	}
+}
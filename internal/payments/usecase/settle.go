@@ -0,0 +1,330 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dunhayat-api/internal/payments"
+	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"go.uber.org/zap"
+)
+
+// paymentSettler verifies a pending payment with the gateway and moves the
+// payment and its sale to their final state. Both the verify endpoint and
+// the gateway callback settle payments through it, so neither trusts what
+// the client or the callback body claims.
+type paymentSettler struct {
+	transactor     database.Transactor
+	orderPort      port.OrderPort
+	paymentRepo    repository.PaymentRepository
+	fraudAlertRepo repository.FraudAlertRepository
+	gateways       *payment.Registry
+	logger         logger.Interface
+}
+
+func newPaymentSettler(
+	transactor database.Transactor,
+	orderPort port.OrderPort,
+	paymentRepo repository.PaymentRepository,
+	fraudAlertRepo repository.FraudAlertRepository,
+	gateways *payment.Registry,
+	logger logger.Interface,
+) *paymentSettler {
+	return &paymentSettler{
+		transactor:     transactor,
+		orderPort:      orderPort,
+		paymentRepo:    paymentRepo,
+		fraudAlertRepo: fraudAlertRepo,
+		gateways:       gateways,
+		logger:         logger,
+	}
+}
+
+// whilePending runs fn in a transaction holding the row lock of the
+// payment, so that callbacks, the verify endpoint and reconciliation racing
+// for the same payment settle it only once. The payment is reloaded under
+// the lock, and fn only runs if it is still pending; whilePending reports
+// whether it ran.
+//
+// The sale of the payment is locked first, in the order the orders slice
+// locks a sale and then its payments when it expires or cancels an order,
+// so that the two never wait on each other.
+func (s *paymentSettler) whilePending(
+	ctx context.Context,
+	pmt *payments.Payment,
+	fn func(ctx context.Context) error,
+) (bool, error) {
+	var ran bool
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.orderPort.LockSale(ctx, pmt.OrderID); err != nil {
+			return fmt.Errorf("failed to lock sale: %w", err)
+		}
+
+		locked, err := s.paymentRepo.GetByIDForUpdate(ctx, pmt.ID)
+		if err != nil {
+			return fmt.Errorf("failed to lock payment: %w", err)
+		}
+		if locked == nil {
+			return payments.ErrPaymentNotFound
+		}
+		*pmt = *locked
+		if pmt.Status != payments.PaymentStatusPending {
+			return nil
+		}
+
+		ran = true
+		return fn(ctx)
+	})
+	return ran, err
+}
+
+// settleLocked verifies a pending payment with the gateway and settles it
+// under its row lock, reporting whether it was still pending by then. The
+// gateway is called before the lock is taken, so that a slow gateway never
+// keeps the row locked and a transaction open; a payment settled meanwhile
+// is left alone. Like apply, it keeps the payment updated even when its
+// sale cannot follow.
+func (s *paymentSettler) settleLocked(
+	ctx context.Context,
+	pmt *payments.Payment,
+) (settled bool, fraud bool, err error) {
+	if pmt.Status != payments.PaymentStatusPending {
+		return false, false, nil
+	}
+
+	sale, verification, err := s.verify(ctx, pmt)
+	if err != nil {
+		return false, false, err
+	}
+
+	var settleErr error
+	settled, err = s.whilePending(ctx, pmt, func(ctx context.Context) error {
+		fraud, settleErr = s.settle(ctx, pmt, sale, verification)
+		return nil
+	})
+	if err != nil {
+		return false, false, err
+	}
+	return settled, fraud, settleErr
+}
+
+// verify asks the gateway to verify the payment. When the gateway cannot
+// be reached the payment is left pending so that it can be settled again
+// later.
+func (s *paymentSettler) verify(
+	ctx context.Context,
+	pmt *payments.Payment,
+) (*port.Sale, *payment.Verification, error) {
+	if pmt.GatewayRefID == nil || *pmt.GatewayRefID == "" {
+		return nil, nil, errors.New("payment has no gateway reference")
+	}
+
+	gateway, err := s.gateways.Get(pmt.Method.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf(
+			"%w: %s", payments.ErrUnsupportedPaymentMethod, pmt.Method,
+		)
+	}
+
+	sale, err := s.orderPort.GetSaleByID(ctx, pmt.OrderID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil {
+		return nil, nil, errors.New("sale not found")
+	}
+
+	verification, err := gateway.Verify(ctx, payment.VerifyRequest{
+		Reference: *pmt.GatewayRefID,
+		Amount:    pmt.Amount,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf(
+			"failed to verify payment with %s: %w", pmt.Method, err,
+		)
+	}
+
+	return sale, verification, nil
+}
+
+// settle moves the payment to what the gateway verified, and reports
+// whether the verification raised a fraud alert.
+func (s *paymentSettler) settle(
+	ctx context.Context,
+	pmt *payments.Payment,
+	sale *port.Sale,
+	verification *payment.Verification,
+) (bool, error) {
+	if !verification.Verified {
+		s.logger.Info("Gateway did not confirm payment",
+			zap.String("method", pmt.Method.String()),
+			zap.String("payment_id", pmt.ID.String()),
+			zap.String("track_id", *pmt.GatewayRefID),
+			zap.Int("result", verification.Code),
+			zap.String("message", verification.Message),
+		)
+		return false, s.markFailed(ctx, pmt, fmt.Sprintf(
+			"payment not confirmed by gateway (result: %d)",
+			verification.Code,
+		))
+	}
+
+	if reason := verificationMismatch(verification, sale); reason != "" {
+		if err := s.raiseFraudAlert(
+			ctx, pmt, sale, verification, reason,
+		); err != nil {
+			return false, err
+		}
+		return true, s.markFailed(
+			ctx, pmt, "payment verification mismatch: "+reason,
+		)
+	}
+	return false, s.markPaid(ctx, pmt)
+}
+
+func (s *paymentSettler) markPaid(
+	ctx context.Context,
+	pmt *payments.Payment,
+) error {
+	now := time.Now()
+	pmt.Status = payments.PaymentStatusPaid
+	pmt.PaidAt = &now
+	return s.apply(
+		ctx, pmt, port.OrderStatusPaid, "payment verified by gateway",
+	)
+}
+
+// markFailed fails the payment and its sale. The sale is left alone while
+// another attempt to pay it is pending, as that attempt may still pay it.
+func (s *paymentSettler) markFailed(
+	ctx context.Context,
+	pmt *payments.Payment,
+	reason string,
+) error {
+	now := time.Now()
+	pmt.Status = payments.PaymentStatusFailed
+	pmt.FailedAt = &now
+
+	superseded, err := s.hasOtherPending(ctx, pmt)
+	if err != nil {
+		return err
+	}
+	if !superseded {
+		return s.apply(ctx, pmt, port.OrderStatusFailed, reason)
+	}
+
+	if err := s.paymentRepo.Update(ctx, pmt); err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	s.logger.Info("Payment failed, leaving its order to another attempt",
+		zap.String("payment_id", pmt.ID.String()),
+		zap.String("order_id", pmt.OrderID.String()),
+		zap.String("reason", reason),
+	)
+	return nil
+}
+
+// hasOtherPending reports whether another payment of the same order is
+// still pending.
+func (s *paymentSettler) hasOtherPending(
+	ctx context.Context,
+	pmt *payments.Payment,
+) (bool, error) {
+	attempts, err := s.paymentRepo.GetByOrderID(ctx, pmt.OrderID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get payments of order: %w", err)
+	}
+	for _, attempt := range attempts {
+		if attempt.ID != pmt.ID &&
+			attempt.Status == payments.PaymentStatusPending {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply stores the payment before moving the sale: the payment reflects
+// what the gateway reported even if the sale cannot follow.
+func (s *paymentSettler) apply(
+	ctx context.Context,
+	pmt *payments.Payment,
+	orderStatus port.OrderStatus,
+	reason string,
+) error {
+	if err := s.paymentRepo.Update(ctx, pmt); err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	if err := s.orderPort.UpdateSaleStatus(
+		ctx, pmt.OrderID, orderStatus, reason,
+	); err != nil {
+		return fmt.Errorf(
+			"failed to update sale status: %w", err,
+		)
+	}
+
+	return nil
+}
+
+func (s *paymentSettler) raiseFraudAlert(
+	ctx context.Context,
+	pmt *payments.Payment,
+	sale *port.Sale,
+	verification *payment.Verification,
+	reason string,
+) error {
+	alert := &payments.FraudAlert{
+		PaymentID:       pmt.ID,
+		OrderID:         sale.ID,
+		TrackID:         *pmt.GatewayRefID,
+		ExpectedAmount:  sale.TotalPrice,
+		VerifiedAmount:  verification.Amount,
+		ExpectedOrderID: sale.ID.String(),
+		VerifiedOrderID: verification.OrderID,
+		Reason:          reason,
+	}
+
+	s.logger.Warn("Payment verification mismatch, raising fraud alert",
+		zap.String("payment_id", pmt.ID.String()),
+		zap.String("order_id", sale.ID.String()),
+		zap.String("track_id", alert.TrackID),
+		zap.String("reason", reason),
+	)
+
+	if err := s.fraudAlertRepo.Create(ctx, alert); err != nil {
+		return fmt.Errorf("failed to store fraud alert: %w", err)
+	}
+
+	return nil
+}
+
+// verificationMismatch compares what the gateway verified with the sale.
+// The order is only compared by gateways that report it back.
+func verificationMismatch(
+	verification *payment.Verification,
+	sale *port.Sale,
+) string {
+	var reasons []string
+	if verification.Amount != sale.TotalPrice {
+		reasons = append(reasons, fmt.Sprintf(
+			"verified amount %d does not match order total %d",
+			verification.Amount, sale.TotalPrice,
+		))
+	}
+	if verification.OrderID != "" &&
+		verification.OrderID != sale.ID.String() {
+		reasons = append(reasons, fmt.Sprintf(
+			"verified order %q does not match order %s",
+			verification.OrderID, sale.ID,
+		))
+	}
+	return strings.Join(reasons, "; ")
+}
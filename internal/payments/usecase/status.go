@@ -7,6 +7,7 @@ import (
 
 	"dunhayat-api/internal/payments"
 	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
 
 	"github.com/google/uuid"
 )
@@ -19,14 +20,17 @@ type GetPaymentStatusUseCase interface {
 }
 
 type getPaymentStatusUseCase struct {
-	orderPort port.OrderPort
+	orderPort   port.OrderPort
+	paymentRepo repository.PaymentRepository
 }
 
 func NewGetPaymentStatusUseCase(
 	orderPort port.OrderPort,
+	paymentRepo repository.PaymentRepository,
 ) GetPaymentStatusUseCase {
 	return &getPaymentStatusUseCase{
-		orderPort: orderPort,
+		orderPort:   orderPort,
+		paymentRepo: paymentRepo,
 	}
 }
 
@@ -34,16 +38,16 @@ func (uc *getPaymentStatusUseCase) Execute(
 	ctx context.Context,
 	req *payments.GetPaymentStatusRequest,
 ) (*payments.GetPaymentStatusResponse, error) {
-	var sale *port.Sale
+	var pmt *payments.Payment
 	var err error
 
 	if req.TrackingCode != "" {
-		sale, err = uc.orderPort.GetSaleByTrackingCode(
-			ctx, req.TrackingCode,
+		pmt, err = uc.paymentRepo.GetByGatewayRefID(
+			ctx, "", req.TrackingCode,
 		)
 		if err != nil {
 			return nil, fmt.Errorf(
-				"failed to get sale by tracking code: %w", err,
+				"failed to get payment by tracking code: %w", err,
 			)
 		}
 	} else if req.OrderID != "" {
@@ -53,10 +57,10 @@ func (uc *getPaymentStatusUseCase) Execute(
 				"invalid order ID format: %w", parseErr,
 			)
 		}
-		sale, err = uc.orderPort.GetSaleByID(ctx, orderID)
+		pmt, err = uc.paymentRepo.GetLatestByOrderID(ctx, orderID)
 		if err != nil {
 			return nil, fmt.Errorf(
-				"failed to get sale by ID: %w", err,
+				"failed to get payment by order ID: %w", err,
 			)
 		}
 	} else {
@@ -65,33 +69,31 @@ func (uc *getPaymentStatusUseCase) Execute(
 		)
 	}
 
-	if sale == nil {
+	if pmt == nil {
 		return nil, errors.New(
-			"sale not found",
+			"payment not found",
 		)
 	}
 
-	var paymentStatus payments.PaymentStatus
-	switch sale.Status {
-	case port.OrderStatusPending:
-		paymentStatus = payments.PaymentStatusPending
-	case port.OrderStatusPaid:
-		paymentStatus = payments.PaymentStatusPaid
-	case port.OrderStatusFailed:
-		paymentStatus = payments.PaymentStatusFailed
-	case port.OrderStatusCancelled:
-		paymentStatus = payments.PaymentStatusCancelled
-	default:
-		paymentStatus = payments.PaymentStatusPending
+	sale, err := uc.orderPort.GetSaleByID(ctx, pmt.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to get sale by ID: %w", err,
+		)
+	}
+	if sale == nil {
+		return nil, errors.New(
+			"sale not found",
+		)
 	}
 
 	return &payments.GetPaymentStatusResponse{
-		PaymentID:    sale.ID,
-		TrackingCode: sale.TrackingCode,
-		Status:       paymentStatus,
-		Amount:       sale.TotalPrice,
+		PaymentID:    pmt.ID,
+		TrackingCode: pmt.GatewayRefID,
+		Status:       pmt.Status,
+		Amount:       pmt.Amount,
 		OrderStatus:  string(sale.Status),
-		CreatedAt:    sale.CreatedAt,
-		UpdatedAt:    sale.UpdatedAt,
+		CreatedAt:    pmt.CreatedAt,
+		UpdatedAt:    pmt.UpdatedAt,
 	}, nil
 }
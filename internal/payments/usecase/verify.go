@@ -2,13 +2,13 @@ package usecase
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"strconv"
-	"time"
 
 	"dunhayat-api/internal/payments"
 	"dunhayat-api/internal/payments/port"
+	"dunhayat-api/internal/payments/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
 	"dunhayat-api/pkg/payment"
 )
 
@@ -20,17 +20,28 @@ type VerifyPaymentUseCase interface {
 }
 
 type verifyPaymentUseCase struct {
-	orderPort   port.OrderPort
-	zibalClient *payment.ZibalClient
+	paymentRepo repository.PaymentRepository
+	settler     *paymentSettler
 }
 
 func NewVerifyPaymentUseCase(
+	transactor database.Transactor,
 	orderPort port.OrderPort,
-	zibalClient *payment.ZibalClient,
+	paymentRepo repository.PaymentRepository,
+	fraudAlertRepo repository.FraudAlertRepository,
+	gateways *payment.Registry,
+	logger logger.Interface,
 ) VerifyPaymentUseCase {
 	return &verifyPaymentUseCase{
-		orderPort:   orderPort,
-		zibalClient: zibalClient,
+		paymentRepo: paymentRepo,
+		settler: newPaymentSettler(
+			transactor,
+			orderPort,
+			paymentRepo,
+			fraudAlertRepo,
+			gateways,
+			logger,
+		),
 	}
 }
 
@@ -38,65 +49,42 @@ func (uc *verifyPaymentUseCase) Execute(
 	ctx context.Context,
 	req *payments.VerifyPaymentRequest,
 ) (*payments.VerifyPaymentResponse, error) {
-	sale, err := uc.orderPort.GetSaleByID(ctx, req.PaymentID)
+	pmt, err := uc.paymentRepo.GetByID(ctx, req.PaymentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get sale: %w", err)
+		return nil, fmt.Errorf("failed to get payment: %w", err)
 	}
-	if sale == nil {
-		return nil, errors.New("sale not found")
-	}
-	if sale.TrackingCode == nil || *sale.TrackingCode == "" {
-		return nil, errors.New("sale has no tracking code")
+	// Payments of other users are reported as missing, so that nobody can
+	// settle a payment before its owner has paid it
+	if pmt == nil || pmt.UserID != req.UserID {
+		return nil, payments.ErrPaymentNotFound
 	}
 
-	trackID, err := strconv.ParseInt(*sale.TrackingCode, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid tracking code: %w", err)
-	}
-
-	zibalReq := payment.ZibalVerifyRequest{TrackID: trackID}
-	zibalResp, err := uc.zibalClient.VerifyPayment(zibalReq)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to verify payment with zibal: %w", err,
-		)
+	if pmt.Status != payments.PaymentStatusPending {
+		return toVerifyPaymentResponse(pmt), nil
 	}
 
-	var newStatus port.OrderStatus
-	switch zibalResp.Result {
-	case 100:
-		newStatus = port.OrderStatusPaid
-	case 102:
-		newStatus = port.OrderStatusFailed
-	default:
-		newStatus = port.OrderStatusFailed
+	// A callback may have settled it meanwhile, which leaves it alone
+	if _, _, err := uc.settler.settleLocked(ctx, pmt); err != nil {
+		return nil, err
 	}
 
-	if err := uc.orderPort.UpdateSaleStatus(
-		ctx, sale.ID, newStatus,
-	); err != nil {
-		return nil, fmt.Errorf(
-			"failed to update sale status: %w", err,
-		)
-	}
-
-	var paidAt *time.Time
-	var failedAt *time.Time
-	now := time.Now()
+	return toVerifyPaymentResponse(pmt), nil
+}
 
-	switch newStatus {
-	case port.OrderStatusPaid:
-		paidAt = &now
-	case port.OrderStatusFailed:
-		failedAt = &now
+func toVerifyPaymentResponse(
+	pmt *payments.Payment,
+) *payments.VerifyPaymentResponse {
+	var gatewayRefID string
+	if pmt.GatewayRefID != nil {
+		gatewayRefID = *pmt.GatewayRefID
 	}
 
 	return &payments.VerifyPaymentResponse{
-		PaymentID:    sale.ID,
-		Status:       payments.PaymentStatus(newStatus),
-		Amount:       sale.TotalPrice,
-		GatewayRefID: *sale.TrackingCode,
-		PaidAt:       paidAt,
-		FailedAt:     failedAt,
-	}, nil
+		PaymentID:    pmt.ID,
+		Status:       pmt.Status,
+		Amount:       pmt.Amount,
+		GatewayRefID: gatewayRefID,
+		PaidAt:       pmt.PaidAt,
+		FailedAt:     pmt.FailedAt,
+	}
 }
@@ -2,47 +2,101 @@ package adapter
 
 import (
 	"context"
+	"errors"
 
 	"dunhayat-api/internal/orders/port"
+	"dunhayat-api/internal/products"
 	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/internal/products/usecase"
+
+	"github.com/google/uuid"
 )
 
 type OrdersProductAdapter struct {
 	productRepo repository.ProductRepository
+	variantRepo repository.VariantRepository
+	inventory   usecase.InventoryService
 }
 
 func NewOrdersProductAdapter(
 	productRepo repository.ProductRepository,
+	variantRepo repository.VariantRepository,
+	inventory usecase.InventoryService,
 ) port.ProductPort {
 	return &OrdersProductAdapter{
 		productRepo: productRepo,
+		variantRepo: variantRepo,
+		inventory:   inventory,
 	}
 }
 
-func (s *OrdersProductAdapter) GetProductByID(
+func (s *OrdersProductAdapter) GetVariant(
 	ctx context.Context,
 	productID string,
-) (*port.Product, error) {
-	product, err := s.productRepo.GetByID(ctx, productID)
+	variantID *uuid.UUID,
+) (*port.Variant, error) {
+	var (
+		variant *products.Variant
+		err     error
+	)
+	if variantID != nil {
+		variant, err = s.variantRepo.GetByID(ctx, *variantID)
+	} else {
+		variant, err = s.variantRepo.GetDefault(ctx, productID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if product == nil {
+	if variant == nil || variant.IsArchived() {
+		return nil, nil
+	}
+	if productID != "" && variant.ProductID != productID {
 		return nil, nil
 	}
 
-	return &port.Product{
-		ID:      product.ID,
-		Name:    product.Name,
-		Price:   product.Price,
-		InStock: product.InStock,
+	// Archived products are no longer for sale
+	product, err := s.productRepo.GetByID(ctx, variant.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil || product.IsArchived() {
+		return nil, nil
+	}
+
+	return &port.Variant{
+		ID:        variant.ID,
+		ProductID: variant.ProductID,
+		SKU:       variant.SKU,
+		Price:     variant.Price,
+		InStock:   variant.InStock,
 	}, nil
 }
 
 func (s *OrdersProductAdapter) UpdateStock(
 	ctx context.Context,
-	productID string,
+	variantID uuid.UUID,
 	quantity int,
+	change port.StockChange,
 ) error {
-	return s.productRepo.UpdateStock(ctx, productID, quantity)
+	err := s.inventory.Move(
+		ctx,
+		variantID,
+		quantity,
+		products.StockChange{
+			Reason: products.MovementReason(change.Reason),
+			SaleID: &change.SaleID,
+			Actor:  change.Actor,
+		},
+	)
+
+	var stockErr *products.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		return &port.InsufficientStockError{
+			ProductID: stockErr.ProductID,
+			VariantID: stockErr.VariantID,
+			Requested: stockErr.Requested,
+			Available: stockErr.Available,
+		}
+	}
+	return err
 }
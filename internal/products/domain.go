@@ -1,7 +1,12 @@
 package products
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Category int
@@ -34,6 +39,10 @@ func (c Category) String() string {
 	}
 }
 
+func (c Category) IsValid() bool {
+	return c >= CategoryArabica && c <= CategoryFilter
+}
+
 type RoastLevel string
 
 const (
@@ -43,26 +52,422 @@ const (
 	RoastLevelEspresso RoastLevel = "espresso"
 )
 
+func (r RoastLevel) IsValid() bool {
+	switch r {
+	case RoastLevelLight, RoastLevelMedium, RoastLevelDark, RoastLevelEspresso:
+		return true
+	}
+	return false
+}
+
+type Grind string
+
+const (
+	GrindWholeBean   Grind = "whole_bean"
+	GrindEspresso    Grind = "espresso"
+	GrindMokaPot     Grind = "moka_pot"
+	GrindFilter      Grind = "filter"
+	GrindFrenchPress Grind = "french_press"
+	GrindTurkish     Grind = "turkish"
+)
+
+func (g Grind) IsValid() bool {
+	switch g {
+	case GrindWholeBean,
+		GrindEspresso,
+		GrindMokaPot,
+		GrindFilter,
+		GrindFrenchPress,
+		GrindTurkish:
+		return true
+	}
+	return false
+}
+
+// Product is a coffee as shown in the catalogue. What is actually sold is
+// one of its variants; Price and InStock are maintained by the database as
+// the lowest price and the total stock of the active variants.
 type Product struct {
-	ID          string      `json:"id" gorm:"primaryKey;type:varchar(100)"`
-	Name        string      `json:"name" gorm:"not null"`
-	NameEn      *string     `json:"name_en,omitempty"`
-	Description *string     `json:"description,omitempty"`
-	Price       int         `json:"price" gorm:"not null;check:price > 0"`
-	ImageURL    *string     `json:"image_url,omitempty"`
-	Category    Category    `json:"category" gorm:"type:smallint;not null"`
-	InStock     int         `json:"in_stock" gorm:"default:0;check:in_stock >= 0"`
-	Weight      *float64    `json:"weight,omitempty"`
-	Origin      *string     `json:"origin,omitempty"`
-	RoastLevel  *RoastLevel `json:"roast_level,omitempty"`
-	Bitterness  *int        `json:"bitterness,omitempty" gorm:"check:bitterness >= 1 AND bitterness <= 5"`
-	Body        *int        `json:"body,omitempty" gorm:"check:body >= 1 AND body <= 5"`
-	Acidity     *int        `json:"acidity,omitempty" gorm:"check:acidity >= 1 AND acidity <= 5"`
-	Sweetness   *int        `json:"sweetness,omitempty" gorm:"check:sweetness >= 1 AND sweetness <= 5"`
-	CreatedAt   time.Time   `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt   time.Time   `json:"updated_at" gorm:"autoUpdateTime"`
+	ID          string  `json:"id" gorm:"primaryKey;type:varchar(100)"`
+	Name        string  `json:"name" gorm:"not null"`
+	NameEn      *string `json:"name_en,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Price       int     `json:"price" gorm:"not null;check:price > 0"`
+	// ImageURL is the cover, the first image of the gallery
+	ImageURL   *string        `json:"image_url,omitempty"`
+	Category   Category       `json:"category" gorm:"type:smallint;not null"`
+	InStock    int            `json:"in_stock" gorm:"default:0;check:in_stock >= 0"`
+	Variants   []Variant      `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	Images     []ProductImage `json:"images,omitempty" gorm:"foreignKey:ProductID"`
+	Weight     *float64       `json:"weight,omitempty"`
+	Origin     *string        `json:"origin,omitempty"`
+	RoastLevel *RoastLevel    `json:"roast_level,omitempty"`
+	Bitterness *int           `json:"bitterness,omitempty" gorm:"check:bitterness >= 1 AND bitterness <= 5"`
+	Body       *int           `json:"body,omitempty" gorm:"check:body >= 1 AND body <= 5"`
+	Acidity    *int           `json:"acidity,omitempty" gorm:"check:acidity >= 1 AND acidity <= 5"`
+	Sweetness  *int           `json:"sweetness,omitempty" gorm:"check:sweetness >= 1 AND sweetness <= 5"`
+	ArchivedAt *time.Time     `json:"archived_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time      `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Variant is a bag of a product in one size and grind, with its own SKU,
+// price and stock.
+type Variant struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID  string     `json:"product_id" gorm:"type:varchar(100);not null"`
+	SKU        string     `json:"sku" gorm:"column:sku;type:varchar(100);uniqueIndex;not null"`
+	Weight     int        `json:"weight" gorm:"not null;check:weight > 0"`
+	Grind      Grind      `json:"grind" gorm:"type:varchar(30);not null;default:'whole_bean'"`
+	Price      int        `json:"price" gorm:"not null;check:price > 0"`
+	InStock    int        `json:"in_stock" gorm:"not null;default:0;check:in_stock >= 0"`
+	IsDefault  bool       `json:"is_default" gorm:"not null;default:false"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func (v *Variant) IsArchived() bool {
+	return v.ArchivedAt != nil
+}
+
+// ProductImage is an image of a product's gallery, shown in Position
+// order. Images hosted elsewhere have no storage keys and no thumbnail.
+type ProductImage struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID    string    `json:"product_id" gorm:"type:varchar(100);not null"`
+	Position     int       `json:"position" gorm:"not null;check:position >= 0"`
+	URL          string    `json:"url" gorm:"type:text;not null"`
+	ThumbnailURL *string   `json:"thumbnail_url,omitempty" gorm:"type:text"`
+	StorageKey   *string   `json:"-" gorm:"type:text"`
+	ThumbnailKey *string   `json:"-" gorm:"type:text"`
+	ContentType  *string   `json:"content_type,omitempty" gorm:"type:varchar(50)"`
+	Width        *int      `json:"width,omitempty"`
+	Height       *int      `json:"height,omitempty"`
+	Size         *int64    `json:"size,omitempty"`
+	AltText      *string   `json:"alt_text,omitempty" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// NormalizeSearch folds the spelling variants of Persian text the same way
+// the normalize_search database function does, so that a query typed on an
+// Arabic keyboard, or with a zero-width non-joiner, still matches.
+func NormalizeSearch(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == 'ي' || r == 'ى':
+			return 'ی'
+		case r == 'ك':
+			return 'ک'
+		case r == 'ة' || r == 'ۀ':
+			return 'ه'
+		case r == 'أ' || r == 'إ':
+			return 'ا'
+		case r == '\u200c':
+			return ' '
+		case r >= '۰' && r <= '۹':
+			return '0' + r - '۰'
+		case r >= '٠' && r <= '٩':
+			return '0' + r - '٠'
+		case r >= '\u064b' && r <= '\u0652', r == '\u0670':
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+}
+
+// ProductSort orders the catalogue. Every order breaks ties by ID.
+type ProductSort string
+
+const (
+	SortNewest    ProductSort = "newest"
+	SortPriceAsc  ProductSort = "price_asc"
+	SortPriceDesc ProductSort = "price_desc"
+	SortName      ProductSort = "name"
+)
+
+func (s ProductSort) IsValid() bool {
+	switch s {
+	case SortNewest, SortPriceAsc, SortPriceDesc, SortName:
+		return true
+	}
+	return false
+}
+
+// Range bounds a value on either or both sides, inclusively
+type Range struct {
+	Min *int
+	Max *int
+}
+
+// ProductSearch holds the criteria a product must meet to be listed.
+// Query matches name, name_en and description; every word must appear.
+type ProductSearch struct {
+	Query       string
+	Category    *Category
+	RoastLevel  *RoastLevel
+	Origin      *string
+	Price       Range
+	InStockOnly bool
+	Bitterness  Range
+	Body        Range
+	Acidity     Range
+	Sweetness   Range
+}
+
+// ProductFilter selects a page of the catalogue. After, when set, is the
+// position of the last product of the previous page in the Sort order.
+type ProductFilter struct {
+	ProductSearch
+	Sort  ProductSort
+	After *ProductCursor
+	Limit int
+}
+
+// ProductCursor is a position in the catalogue: the value of the sort key
+// and the ID of a product.
+type ProductCursor struct {
+	Value any
+	ID    string
+}
+
+type ListProductsRequest struct {
+	ProductSearch
+	Sort   ProductSort
+	Cursor string
+	Limit  int
+}
+
+type ListProductsResponse struct {
+	Products   []Product `json:"products"`
+	Total      int64     `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+func (p *Product) IsArchived() bool {
+	return p.ArchivedAt != nil
+}
+
+// MovementReason says why the stock of a product changed
+type MovementReason string
+
+const (
+	MovementOpening           MovementReason = "opening"
+	MovementOrderReservation  MovementReason = "order_reservation"
+	MovementReservationExpiry MovementReason = "reservation_expiry"
+	MovementOrderCancellation MovementReason = "order_cancellation"
+	MovementOrderRefund       MovementReason = "order_refund"
+	MovementRestock           MovementReason = "restock"
+	MovementCorrection        MovementReason = "correction"
+)
+
+func (r MovementReason) String() string {
+	return string(r)
+}
+
+func (r MovementReason) IsValid() bool {
+	switch r {
+	case MovementOpening,
+		MovementOrderReservation,
+		MovementReservationExpiry,
+		MovementOrderCancellation,
+		MovementOrderRefund,
+		MovementRestock,
+		MovementCorrection:
+		return true
+	}
+	return false
+}
+
+const ActorSystem = "system"
+
+func StaffActor(userID uuid.UUID) string {
+	return "staff:" + userID.String()
+}
+
+// InventoryMovement is one signed entry of the inventory ledger. The
+// movements of a product always add up to its in_stock.
+type InventoryMovement struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID string         `json:"product_id" gorm:"type:varchar(100);not null"`
+	VariantID uuid.UUID      `json:"variant_id" gorm:"type:uuid;not null"`
+	Quantity  int            `json:"quantity" gorm:"not null"`
+	Reason    MovementReason `json:"reason" gorm:"type:varchar(50);not null"`
+	SaleID    *uuid.UUID     `json:"sale_id,omitempty" gorm:"type:uuid"`
+	Actor     string         `json:"actor" gorm:"type:varchar(100);not null"`
+	Note      *string        `json:"note,omitempty" gorm:"type:text"`
+	CreatedAt time.Time      `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// StockChange describes why, and on whose behalf, stock is being moved
+type StockChange struct {
+	Reason MovementReason
+	SaleID *uuid.UUID
+	Actor  string
+	Note   string
+}
+
+// StockDrift is a variant whose in_stock disagrees with its ledger
+type StockDrift struct {
+	ProductID   string    `json:"product_id"`
+	VariantID   uuid.UUID `json:"variant_id"`
+	SKU         string    `json:"sku"`
+	InStock     int       `json:"in_stock"`
+	LedgerStock int       `json:"ledger_stock"`
+	Drift       int       `json:"drift"`
+}
+
+// MovementFilter selects a page of a product's movements, newest first.
+// After, when set, is the position of the last movement of the previous
+// page.
+type MovementFilter struct {
+	ProductID string
+	After     *MovementCursor
+	Limit     int
+}
+
+type MovementCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type ListMovementsResponse struct {
+	Movements  []InventoryMovement `json:"movements"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrProductExists     = errors.New("a product with this ID already exists")
+	ErrInvalidProduct    = errors.New("invalid product")
+	ErrInvalidAdjustment = errors.New("invalid stock adjustment")
+
+	ErrVariantNotFound = errors.New("variant not found")
+	ErrVariantExists   = errors.New(
+		"a variant with this SKU, or this size and grind, already exists",
+	)
+	ErrInvalidVariant        = errors.New("invalid variant")
+	ErrDefaultVariantArchive = errors.New(
+		"the default variant cannot be archived; make another variant the default first",
+	)
+
+	ErrImageNotFound     = errors.New("image not found")
+	ErrInvalidImage      = errors.New("invalid image")
+	ErrImageTooLarge     = errors.New("image file is too large")
+	ErrImageLimitReached = errors.New("product image limit reached")
+	ErrInvalidImageOrder = errors.New(
+		"image_ids must list every image of the product exactly once",
+	)
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+type InsufficientStockError struct {
+	ProductID string
+	VariantID uuid.UUID
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf(
+		"insufficient stock for product %s variant %s: requested %d, available %d",
+		e.ProductID, e.VariantID, e.Requested, e.Available,
+	)
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// CreateProductRequest carries every field of a new product and at least
+// one variant; the first variant becomes the default. ID is the slug the
+// product is addressed by and cannot be changed later.
+type CreateProductRequest struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	NameEn      *string          `json:"name_en"`
+	Description *string          `json:"description"`
+	Category    Category         `json:"category"`
+	Variants    []VariantRequest `json:"variants"`
+	Weight      *float64         `json:"weight"`
+	Origin      *string          `json:"origin"`
+	RoastLevel  *RoastLevel      `json:"roast_level"`
+	Bitterness  *int             `json:"bitterness"`
+	Body        *int             `json:"body"`
+	Acidity     *int             `json:"acidity"`
+	Sweetness   *int             `json:"sweetness"`
+}
+
+// UpdateProductRequest changes only the fields that are present. An empty
+// string clears an optional text field and zero clears an optional number.
+// Prices and stock belong to the variants.
+type UpdateProductRequest struct {
+	Name        *string     `json:"name"`
+	NameEn      *string     `json:"name_en"`
+	Description *string     `json:"description"`
+	Category    *Category   `json:"category"`
+	Weight      *float64    `json:"weight"`
+	Origin      *string     `json:"origin"`
+	RoastLevel  *RoastLevel `json:"roast_level"`
+	Bitterness  *int        `json:"bitterness"`
+	Body        *int        `json:"body"`
+	Acidity     *int        `json:"acidity"`
+	Sweetness   *int        `json:"sweetness"`
+}
+
+// VariantRequest describes a new variant. InStock is its opening stock.
+type VariantRequest struct {
+	SKU     string `json:"sku"`
+	Weight  int    `json:"weight"`
+	Grind   Grind  `json:"grind"`
+	Price   int    `json:"price"`
+	InStock int    `json:"in_stock"`
+}
+
+// UpdateVariantRequest changes only the fields that are present. A variant
+// can be made the default but not unmade; another variant takes over
+// instead.
+type UpdateVariantRequest struct {
+	SKU       *string `json:"sku"`
+	Weight    *int    `json:"weight"`
+	Grind     *Grind  `json:"grind"`
+	Price     *int    `json:"price"`
+	IsDefault *bool   `json:"is_default"`
+}
+
+// ImageUpload is an image file uploaded for a product gallery.
+type ImageUpload struct {
+	Content []byte
+	AltText string
+}
+
+// ReorderImagesRequest lists the images of a product in their new order.
+type ReorderImagesRequest struct {
+	ImageIDs []uuid.UUID `json:"image_ids"`
+}
+
+// AdjustStockRequest adds Quantity, which may be negative, to the stock of
+// a variant. Reason is either restock or correction, and Note explains the
+// adjustment.
+type AdjustStockRequest struct {
+	Quantity int            `json:"quantity"`
+	Reason   MovementReason `json:"reason"`
+	Note     string         `json:"note"`
 }
 
 func (Product) TableName() string {
 	return "products"
 }
+
+func (Variant) TableName() string {
+	return "product_variants"
+}
+
+func (ProductImage) TableName() string {
+	return "product_images"
+}
+
+func (InventoryMovement) TableName() string {
+	return "inventory_movements"
+}
@@ -1,42 +1,139 @@
 package http
 
 import (
+	"errors"
+	"io"
 	"strconv"
 
+	"dunhayat-api/internal/auth/http"
 	"dunhayat-api/internal/products"
 	"dunhayat-api/internal/products/usecase"
+	"dunhayat-api/pkg/pagination"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type ProductHandler struct {
-	listProductsUseCase usecase.ListProductsUseCase
-	getProductUseCase   usecase.GetProductUseCase
+	listProductsUseCase   usecase.ListProductsUseCase
+	getProductUseCase     usecase.GetProductUseCase
+	createProductUseCase  usecase.CreateProductUseCase
+	updateProductUseCase  usecase.UpdateProductUseCase
+	adjustStockUseCase    usecase.AdjustStockUseCase
+	archiveProductUseCase usecase.ArchiveProductUseCase
+	createVariantUseCase  usecase.CreateVariantUseCase
+	updateVariantUseCase  usecase.UpdateVariantUseCase
+	archiveVariantUseCase usecase.ArchiveVariantUseCase
+	uploadImageUseCase    usecase.UploadImageUseCase
+	deleteImageUseCase    usecase.DeleteImageUseCase
+	reorderImagesUseCase  usecase.ReorderImagesUseCase
+	listMovementsUseCase  usecase.ListMovementsUseCase
+	checkDriftUseCase     usecase.CheckDriftUseCase
 }
 
 func NewProductHandler(
 	listProductsUseCase usecase.ListProductsUseCase,
 	getProductUseCase usecase.GetProductUseCase,
+	createProductUseCase usecase.CreateProductUseCase,
+	updateProductUseCase usecase.UpdateProductUseCase,
+	adjustStockUseCase usecase.AdjustStockUseCase,
+	archiveProductUseCase usecase.ArchiveProductUseCase,
+	createVariantUseCase usecase.CreateVariantUseCase,
+	updateVariantUseCase usecase.UpdateVariantUseCase,
+	archiveVariantUseCase usecase.ArchiveVariantUseCase,
+	uploadImageUseCase usecase.UploadImageUseCase,
+	deleteImageUseCase usecase.DeleteImageUseCase,
+	reorderImagesUseCase usecase.ReorderImagesUseCase,
+	listMovementsUseCase usecase.ListMovementsUseCase,
+	checkDriftUseCase usecase.CheckDriftUseCase,
 ) *ProductHandler {
 	return &ProductHandler{
-		listProductsUseCase: listProductsUseCase,
-		getProductUseCase:   getProductUseCase,
+		listProductsUseCase:   listProductsUseCase,
+		getProductUseCase:     getProductUseCase,
+		createProductUseCase:  createProductUseCase,
+		updateProductUseCase:  updateProductUseCase,
+		adjustStockUseCase:    adjustStockUseCase,
+		archiveProductUseCase: archiveProductUseCase,
+		createVariantUseCase:  createVariantUseCase,
+		updateVariantUseCase:  updateVariantUseCase,
+		archiveVariantUseCase: archiveVariantUseCase,
+		uploadImageUseCase:    uploadImageUseCase,
+		deleteImageUseCase:    deleteImageUseCase,
+		reorderImagesUseCase:  reorderImagesUseCase,
+		listMovementsUseCase:  listMovementsUseCase,
+		checkDriftUseCase:     checkDriftUseCase,
 	}
 }
 
+// ListProducts searches the catalogue. Ranges are given as min_<field> and
+// max_<field> for price, bitterness, body, acidity and sweetness.
 func (h *ProductHandler) ListProducts(c *fiber.Ctx) error {
-	categoryStr := c.Query("category")
-	var category *products.Category
+	req := products.ListProductsRequest{
+		Sort:   products.ProductSort(c.Query("sort")),
+		Cursor: c.Query("cursor"),
+		Limit:  c.QueryInt("limit"),
+	}
+	req.Query = c.Query("q")
+	req.InStockOnly = c.QueryBool("in_stock")
+
+	if req.Sort != "" && !req.Sort.IsValid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid sort: use newest, price_asc, price_desc or name",
+		})
+	}
 
-	if categoryStr != "" {
-		if cat, err := strconv.Atoi(categoryStr); err == nil {
-			catEnum := products.Category(cat)
-			category = &catEnum
+	if value := c.Query("category"); value != "" {
+		category, err := strconv.Atoi(value)
+		if err != nil || !products.Category(category).IsValid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid category",
+			})
 		}
+		req.Category = (*products.Category)(&category)
 	}
 
-	products, err := h.listProductsUseCase.Execute(c.Context(), category)
+	if value := c.Query("roast_level"); value != "" {
+		roastLevel := products.RoastLevel(value)
+		if !roastLevel.IsValid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid roast level",
+			})
+		}
+		req.RoastLevel = &roastLevel
+	}
+
+	if value := c.Query("origin"); value != "" {
+		req.Origin = &value
+	}
+
+	ranges := map[string]*products.Range{
+		"price":      &req.Price,
+		"bitterness": &req.Bitterness,
+		"body":       &req.Body,
+		"acidity":    &req.Acidity,
+		"sweetness":  &req.Sweetness,
+	}
+	for name, bounds := range ranges {
+		var err error
+		if bounds.Min, err = intQuery(c, "min_"+name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid min_" + name + ": " + err.Error(),
+			})
+		}
+		if bounds.Max, err = intQuery(c, "max_"+name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid max_" + name + ": " + err.Error(),
+			})
+		}
+	}
+
+	result, err := h.listProductsUseCase.Execute(c.Context(), &req)
 	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(
 			fiber.StatusInternalServerError,
 		).JSON(fiber.Map{
@@ -45,13 +142,28 @@ func (h *ProductHandler) ListProducts(c *fiber.Ctx) error {
 	}
 
 	response := fiber.Map{
-		"data":  products,
-		"count": len(products),
+		"data":        result.Products,
+		"count":       len(result.Products),
+		"total":       result.Total,
+		"next_cursor": result.NextCursor,
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+func intQuery(c *fiber.Ctx, name string) (*int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, errors.New("must be an integer")
+	}
+	return &n, nil
+}
+
 func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 	productID := c.Params("id")
 	if productID == "" {
@@ -62,8 +174,8 @@ func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 
 	product, err := h.getProductUseCase.Execute(c.Context(), productID)
 	if err != nil {
-		switch err.Error() {
-		case "product not found":
+		switch {
+		case errors.Is(err, products.ErrProductNotFound):
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Product not found",
 			})
@@ -82,3 +194,387 @@ func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(response)
 }
+
+func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
+	staffID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var req products.CreateProductRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	product, err := h.createProductUseCase.Execute(
+		c.Context(), staffID, &req,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Product created successfully",
+		"data":    product,
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
+	var req products.UpdateProductRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	product, err := h.updateProductUseCase.Execute(
+		c.Context(), c.Params("id"), &req,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Product updated successfully",
+		"data":    product,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) AdjustStock(c *fiber.Ctx) error {
+	staffID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	variantID, err := uuid.Parse(c.Params("variantID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid variant ID",
+		})
+	}
+
+	var req products.AdjustStockRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	variant, err := h.adjustStockUseCase.Execute(
+		c.Context(), staffID, c.Params("id"), variantID, &req,
+	)
+	if err != nil {
+		var stockErr *products.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":     err.Error(),
+				"available": stockErr.Available,
+			})
+		}
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Stock adjusted successfully",
+		"data":    variant,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) ArchiveProduct(c *fiber.Ctx) error {
+	product, err := h.archiveProductUseCase.Execute(
+		c.Context(), c.Params("id"), true,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Product archived successfully",
+		"data":    product,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) RestoreProduct(c *fiber.Ctx) error {
+	product, err := h.archiveProductUseCase.Execute(
+		c.Context(), c.Params("id"), false,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Product restored successfully",
+		"data":    product,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) CreateVariant(c *fiber.Ctx) error {
+	staffID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var req products.VariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	variant, err := h.createVariantUseCase.Execute(
+		c.Context(), staffID, c.Params("id"), &req,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Variant created successfully",
+		"data":    variant,
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *ProductHandler) UpdateVariant(c *fiber.Ctx) error {
+	variantID, err := uuid.Parse(c.Params("variantID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid variant ID",
+		})
+	}
+
+	var req products.UpdateVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	variant, err := h.updateVariantUseCase.Execute(
+		c.Context(), c.Params("id"), variantID, &req,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Variant updated successfully",
+		"data":    variant,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) ArchiveVariant(c *fiber.Ctx) error {
+	return h.setVariantArchived(c, true, "Variant archived successfully")
+}
+
+func (h *ProductHandler) RestoreVariant(c *fiber.Ctx) error {
+	return h.setVariantArchived(c, false, "Variant restored successfully")
+}
+
+func (h *ProductHandler) setVariantArchived(
+	c *fiber.Ctx,
+	archived bool,
+	message string,
+) error {
+	variantID, err := uuid.Parse(c.Params("variantID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid variant ID",
+		})
+	}
+
+	variant, err := h.archiveVariantUseCase.Execute(
+		c.Context(), c.Params("id"), variantID, archived,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": message,
+		"data":    variant,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// UploadImage takes a multipart form with the file in "image" and an
+// optional "alt_text".
+func (h *ProductHandler) UploadImage(c *fiber.Ctx) error {
+	file, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Image file is required",
+		})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read image file",
+		})
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read image file",
+		})
+	}
+
+	image, err := h.uploadImageUseCase.Execute(
+		c.Context(),
+		c.Params("id"),
+		&products.ImageUpload{
+			Content: content,
+			AltText: c.FormValue("alt_text"),
+		},
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Image uploaded successfully",
+		"data":    image,
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *ProductHandler) DeleteImage(c *fiber.Ctx) error {
+	imageID, err := uuid.Parse(c.Params("imageID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	if err := h.deleteImageUseCase.Execute(
+		c.Context(), c.Params("id"), imageID,
+	); err != nil {
+		return productError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *ProductHandler) ReorderImages(c *fiber.Ctx) error {
+	var req products.ReorderImagesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	images, err := h.reorderImagesUseCase.Execute(
+		c.Context(), c.Params("id"), &req,
+	)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"data":  images,
+		"count": len(images),
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) ListMovements(c *fiber.Ctx) error {
+	result, err := h.listMovementsUseCase.Execute(
+		c.Context(),
+		c.Params("id"),
+		c.Query("cursor"),
+		c.QueryInt("limit"),
+	)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return productError(c, err)
+	}
+
+	response := fiber.Map{
+		"data":        result.Movements,
+		"count":       len(result.Movements),
+		"next_cursor": result.NextCursor,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *ProductHandler) GetInventoryDrift(c *fiber.Ctx) error {
+	drift, err := h.checkDriftUseCase.Execute(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	response := fiber.Map{
+		"data":  drift,
+		"count": len(drift),
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func productError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, products.ErrProductNotFound),
+		errors.Is(err, products.ErrVariantNotFound),
+		errors.Is(err, products.ErrImageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, products.ErrInvalidProduct),
+		errors.Is(err, products.ErrInvalidVariant),
+		errors.Is(err, products.ErrInvalidImage),
+		errors.Is(err, products.ErrInvalidImageOrder),
+		errors.Is(err, products.ErrInvalidAdjustment):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, products.ErrProductExists),
+		errors.Is(err, products.ErrVariantExists),
+		errors.Is(err, products.ErrDefaultVariantArchive),
+		errors.Is(err, products.ErrImageLimitReached):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, products.ErrImageTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ImageRepository interface {
+	Create(ctx context.Context, image *products.ProductImage) error
+	GetByID(ctx context.Context, id uuid.UUID) (*products.ProductImage, error)
+	// ListByProduct returns the gallery of a product in display order
+	ListByProduct(
+		ctx context.Context,
+		productID string,
+	) ([]products.ProductImage, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	// SetPositions numbers the given images 0, 1, 2... in the order given
+	SetPositions(ctx context.Context, ids []uuid.UUID) error
+}
+
+type postgresImageRepository struct {
+	db *gorm.DB
+}
+
+func NewImageRepository(db *gorm.DB) ImageRepository {
+	return &postgresImageRepository{db: db}
+}
+
+func (r *postgresImageRepository) Create(
+	ctx context.Context,
+	image *products.ProductImage,
+) error {
+	return database.Conn(ctx, r.db).Create(image).Error
+}
+
+func (r *postgresImageRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*products.ProductImage, error) {
+	var image products.ProductImage
+	err := database.Conn(ctx, r.db).Where(
+		"id = ?", id,
+	).First(&image).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (r *postgresImageRepository) ListByProduct(
+	ctx context.Context,
+	productID string,
+) ([]products.ProductImage, error) {
+	var images []products.ProductImage
+	err := database.Conn(ctx, r.db).
+		Where("product_id = ?", productID).
+		Order("position, created_at").
+		Find(&images).Error
+	return images, err
+}
+
+func (r *postgresImageRepository) Delete(
+	ctx context.Context,
+	id uuid.UUID,
+) error {
+	result := database.Conn(ctx, r.db).Delete(
+		&products.ProductImage{}, "id = ?", id,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return products.ErrImageNotFound
+	}
+	return nil
+}
+
+func (r *postgresImageRepository) SetPositions(
+	ctx context.Context,
+	ids []uuid.UUID,
+) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		for position, id := range ids {
+			if err := tx.Model(&products.ProductImage{}).
+				Where("id = ? AND position <> ?", id, position).
+				Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
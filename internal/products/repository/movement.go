@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/pkg/database"
+
+	"gorm.io/gorm"
+)
+
+type InventoryMovementRepository interface {
+	Create(ctx context.Context, movement *products.InventoryMovement) error
+	List(
+		ctx context.Context,
+		filter products.MovementFilter,
+	) ([]products.InventoryMovement, error)
+	// GetDrift returns every variant whose in_stock differs from the sum
+	// of its movements
+	GetDrift(ctx context.Context) ([]products.StockDrift, error)
+}
+
+type postgresInventoryMovementRepository struct {
+	db *gorm.DB
+}
+
+func NewInventoryMovementRepository(
+	db *gorm.DB,
+) InventoryMovementRepository {
+	return &postgresInventoryMovementRepository{db: db}
+}
+
+func (r *postgresInventoryMovementRepository) Create(
+	ctx context.Context,
+	movement *products.InventoryMovement,
+) error {
+	return database.Conn(ctx, r.db).Create(movement).Error
+}
+
+func (r *postgresInventoryMovementRepository) List(
+	ctx context.Context,
+	filter products.MovementFilter,
+) ([]products.InventoryMovement, error) {
+	query := database.Conn(ctx, r.db).Where(
+		"product_id = ?", filter.ProductID,
+	)
+
+	if filter.After != nil {
+		query = query.Where(
+			"(created_at, id) < (?, ?)",
+			filter.After.CreatedAt, filter.After.ID,
+		)
+	}
+
+	var movements []products.InventoryMovement
+	err := query.
+		Order("created_at DESC").
+		Order("id DESC").
+		Limit(filter.Limit).
+		Find(&movements).Error
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+func (r *postgresInventoryMovementRepository) GetDrift(
+	ctx context.Context,
+) ([]products.StockDrift, error) {
+	var drift []products.StockDrift
+	err := database.Conn(ctx, r.db).Raw(`
+		SELECT
+			v.product_id,
+			v.id AS variant_id,
+			v.sku,
+			v.in_stock,
+			COALESCE(SUM(m.quantity), 0) AS ledger_stock,
+			v.in_stock - COALESCE(SUM(m.quantity), 0) AS drift
+		FROM product_variants v
+		LEFT JOIN inventory_movements m ON m.variant_id = v.id
+		GROUP BY v.id
+		HAVING v.in_stock <> COALESCE(SUM(m.quantity), 0)
+		ORDER BY v.product_id, v.sku
+	`).Scan(&drift).Error
+	if err != nil {
+		return nil, err
+	}
+	return drift, nil
+}
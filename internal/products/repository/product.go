@@ -3,20 +3,31 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
 
 	"dunhayat-api/internal/products"
+	"dunhayat-api/pkg/database"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductRepository interface {
+	// Create inserts the product alone; variants are created separately
 	Create(ctx context.Context, product *products.Product) error
+	// GetByID loads the product with its gallery and all its variants,
+	// archived ones included
 	GetByID(ctx context.Context, id string) (*products.Product, error)
-	GetAll(ctx context.Context) ([]products.Product, error)
-	GetByCategory(ctx context.Context, category products.Category) ([]products.Product, error)
+	// List and Count leave archived products out, and List loads only
+	// active variants
+	List(ctx context.Context, filter products.ProductFilter) ([]products.Product, error)
+	Count(ctx context.Context, search products.ProductSearch) (int64, error)
+	// Update saves every field but the price, the stock, the cover image
+	// and the archive state
 	Update(ctx context.Context, product *products.Product) error
-	Delete(ctx context.Context, id string) error
-	UpdateStock(ctx context.Context, id string, quantity int) error
+	// SetArchived archives or restores a product. Products are never
+	// deleted, as sales keep referring to them.
+	SetArchived(ctx context.Context, id string, archived bool) error
 }
 
 type postgresProductRepository struct {
@@ -31,7 +42,9 @@ func (r *postgresProductRepository) Create(
 	ctx context.Context,
 	product *products.Product,
 ) error {
-	return r.db.WithContext(ctx).Create(product).Error
+	return database.Conn(ctx, r.db).
+		Omit(clause.Associations).
+		Create(product).Error
 }
 
 func (r *postgresProductRepository) GetByID(
@@ -39,9 +52,15 @@ func (r *postgresProductRepository) GetByID(
 	id string,
 ) (*products.Product, error) {
 	var product products.Product
-	err := r.db.WithContext(ctx).Where(
-		"id = ?", id,
-	).First(&product).Error
+	err := database.Conn(ctx, r.db).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("weight, grind, id")
+		}).
+		Preload("Images", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position, created_at")
+		}).
+		Where("id = ?", id).
+		First(&product).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -51,55 +70,141 @@ func (r *postgresProductRepository) GetByID(
 	return &product, nil
 }
 
-func (r *postgresProductRepository) GetAll(
+func (r *postgresProductRepository) List(
 	ctx context.Context,
+	filter products.ProductFilter,
 ) ([]products.Product, error) {
+	query := applySearch(database.Conn(ctx, r.db), filter.ProductSearch)
+
+	column, direction := "created_at", "DESC"
+	switch filter.Sort {
+	case products.SortPriceAsc:
+		column, direction = "price", "ASC"
+	case products.SortPriceDesc:
+		column, direction = "price", "DESC"
+	case products.SortName:
+		column, direction = "name", "ASC"
+	}
+
+	if filter.After != nil {
+		comparison := "<"
+		if direction == "ASC" {
+			comparison = ">"
+		}
+		query = query.Where(
+			"("+column+", id) "+comparison+" (?, ?)",
+			filter.After.Value, filter.After.ID,
+		)
+	}
+
 	var productList []products.Product
-	err := r.db.WithContext(ctx).Find(&productList).Error
+	err := query.
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Where("archived_at IS NULL").Order("weight, grind, id")
+		}).
+		Order(column + " " + direction).
+		Order("id " + direction).
+		Limit(filter.Limit).
+		Find(&productList).Error
 	if err != nil {
 		return nil, err
 	}
 	return productList, nil
 }
 
-func (r *postgresProductRepository) GetByCategory(
+func (r *postgresProductRepository) Count(
 	ctx context.Context,
-	category products.Category,
-) ([]products.Product, error) {
-	var productList []products.Product
-	err := r.db.WithContext(ctx).Where(
-		"category = ?", category,
-	).Find(&productList).Error
-	if err != nil {
-		return nil, err
+	search products.ProductSearch,
+) (int64, error) {
+	var total int64
+	err := applySearch(
+		database.Conn(ctx, r.db).Model(&products.Product{}),
+		search,
+	).Count(&total).Error
+	return total, err
+}
+
+// likeEscaper escapes the wildcards of LIKE in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func applySearch(query *gorm.DB, search products.ProductSearch) *gorm.DB {
+	query = query.Where("archived_at IS NULL")
+
+	// search_text is normalized by the database; every word of the query
+	// must occur in it. The trigram index serves these LIKE patterns.
+	for _, word := range strings.Fields(
+		products.NormalizeSearch(search.Query),
+	) {
+		query = query.Where(
+			"search_text LIKE ?", "%"+likeEscaper.Replace(word)+"%",
+		)
 	}
-	return productList, nil
+
+	if search.Category != nil {
+		query = query.Where("category = ?", *search.Category)
+	}
+	if search.RoastLevel != nil {
+		query = query.Where("roast_level = ?", *search.RoastLevel)
+	}
+	if search.Origin != nil {
+		query = query.Where("lower(origin) = lower(?)", *search.Origin)
+	}
+	if search.InStockOnly {
+		query = query.Where("in_stock > 0")
+	}
+
+	ranges := []struct {
+		column string
+		bounds products.Range
+	}{
+		{"price", search.Price},
+		{"bitterness", search.Bitterness},
+		{"body", search.Body},
+		{"acidity", search.Acidity},
+		{"sweetness", search.Sweetness},
+	}
+	for _, rg := range ranges {
+		if rg.bounds.Min != nil {
+			query = query.Where(rg.column+" >= ?", *rg.bounds.Min)
+		}
+		if rg.bounds.Max != nil {
+			query = query.Where(rg.column+" <= ?", *rg.bounds.Max)
+		}
+	}
+
+	return query
 }
 
 func (r *postgresProductRepository) Update(
 	ctx context.Context,
 	product *products.Product,
 ) error {
-	return r.db.WithContext(ctx).Save(product).Error
+	return database.Conn(ctx, r.db).
+		Omit(
+			"price", "in_stock", "image_url", "archived_at",
+			clause.Associations,
+		).
+		Save(product).Error
 }
 
-func (r *postgresProductRepository) Delete(
+func (r *postgresProductRepository) SetArchived(
 	ctx context.Context,
 	id string,
+	archived bool,
 ) error {
-	return r.db.WithContext(ctx).Delete(&products.Product{}, id).Error
-}
+	var archivedAt any
+	if archived {
+		archivedAt = gorm.Expr("COALESCE(archived_at, CURRENT_TIMESTAMP)")
+	}
 
-func (r *postgresProductRepository) UpdateStock(
-	ctx context.Context,
-	id string,
-	quantity int,
-) error {
-	return r.db.WithContext(ctx).Model(&products.Product{}).
-		Where("id = ?", id).Update(
-			"in_stock",
-			gorm.Expr(
-				"in_stock + ?", quantity,
-			),
-		).Error
+	result := database.Conn(ctx, r.db).Model(&products.Product{}).
+		Where("id = ?", id).
+		Update("archived_at", archivedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return products.ErrProductNotFound
+	}
+	return nil
 }
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type VariantRepository interface {
+	Create(ctx context.Context, variant *products.Variant) error
+	GetByID(ctx context.Context, id uuid.UUID) (*products.Variant, error)
+	GetDefault(ctx context.Context, productID string) (*products.Variant, error)
+	// Exists reports whether another variant than excludeID already uses
+	// the SKU, or the size and grind within the product
+	Exists(
+		ctx context.Context,
+		variant *products.Variant,
+		excludeID *uuid.UUID,
+	) (bool, error)
+	// Update saves every field but the stock, the default flag and the
+	// archive state
+	Update(ctx context.Context, variant *products.Variant) error
+	// SetDefault makes a variant the default of its product in place of
+	// the previous one
+	SetDefault(ctx context.Context, variant *products.Variant) error
+	SetArchived(ctx context.Context, id uuid.UUID, archived bool) error
+	// UpdateStock atomically adds quantity (which may be negative) to the
+	// stock of a variant. It returns a *products.InsufficientStockError
+	// instead of letting the stock drop below zero. Stock changes go through
+	// the InventoryService so that the ledger records them.
+	UpdateStock(ctx context.Context, id uuid.UUID, quantity int) error
+}
+
+type postgresVariantRepository struct {
+	db *gorm.DB
+}
+
+func NewVariantRepository(db *gorm.DB) VariantRepository {
+	return &postgresVariantRepository{db: db}
+}
+
+func (r *postgresVariantRepository) Create(
+	ctx context.Context,
+	variant *products.Variant,
+) error {
+	return database.Conn(ctx, r.db).Create(variant).Error
+}
+
+func (r *postgresVariantRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*products.Variant, error) {
+	var variant products.Variant
+	err := database.Conn(ctx, r.db).Where(
+		"id = ?", id,
+	).First(&variant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func (r *postgresVariantRepository) GetDefault(
+	ctx context.Context,
+	productID string,
+) (*products.Variant, error) {
+	var variant products.Variant
+	err := database.Conn(ctx, r.db).Where(
+		"product_id = ? AND is_default", productID,
+	).First(&variant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func (r *postgresVariantRepository) Exists(
+	ctx context.Context,
+	variant *products.Variant,
+	excludeID *uuid.UUID,
+) (bool, error) {
+	query := database.Conn(ctx, r.db).Model(&products.Variant{}).Where(
+		"sku = ? OR (product_id = ? AND weight = ? AND grind = ?)",
+		variant.SKU, variant.ProductID, variant.Weight, variant.Grind,
+	)
+	if excludeID != nil {
+		query = query.Where("id <> ?", *excludeID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *postgresVariantRepository) Update(
+	ctx context.Context,
+	variant *products.Variant,
+) error {
+	return database.Conn(ctx, r.db).
+		Omit("in_stock", "is_default", "archived_at").
+		Save(variant).Error
+}
+
+func (r *postgresVariantRepository) SetDefault(
+	ctx context.Context,
+	variant *products.Variant,
+) error {
+	return database.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&products.Variant{}).
+			Where("product_id = ? AND is_default", variant.ProductID).
+			Update("is_default", false).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&products.Variant{}).
+			Where("id = ?", variant.ID).
+			Update("is_default", true).Error
+	})
+}
+
+func (r *postgresVariantRepository) SetArchived(
+	ctx context.Context,
+	id uuid.UUID,
+	archived bool,
+) error {
+	var archivedAt any
+	if archived {
+		archivedAt = gorm.Expr("COALESCE(archived_at, CURRENT_TIMESTAMP)")
+	}
+
+	result := database.Conn(ctx, r.db).Model(&products.Variant{}).
+		Where("id = ?", id).
+		Update("archived_at", archivedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return products.ErrVariantNotFound
+	}
+	return nil
+}
+
+func (r *postgresVariantRepository) UpdateStock(
+	ctx context.Context,
+	id uuid.UUID,
+	quantity int,
+) error {
+	result := database.Conn(ctx, r.db).Model(&products.Variant{}).
+		Where("id = ? AND in_stock + ? >= 0", id, quantity).
+		Update("in_stock", gorm.Expr("in_stock + ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	variant, err := r.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if variant == nil {
+		return products.ErrVariantNotFound
+	}
+
+	return &products.InsufficientStockError{
+		ProductID: variant.ProductID,
+		VariantID: id,
+		Requested: -quantity,
+		Available: variant.InStock,
+	}
+}
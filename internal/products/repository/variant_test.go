@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/database/databasetest"
+)
+
+// Orders taking the last bags of a variant at the same time never sell
+// more than is in stock: as many succeed as there are bags, and the rest
+// are told that stock ran out.
+func TestVariantUpdateStockDoesNotOversell(t *testing.T) {
+	db := databasetest.Open(t)
+	productRepo := NewProductRepository(db)
+	variantRepo := NewVariantRepository(db)
+	transactor := database.NewTransactor(db)
+	ctx := context.Background()
+
+	const (
+		stock  = 5
+		orders = 20
+	)
+
+	product := &products.Product{
+		ID:       "ethiopia-guji",
+		Name:     "Ethiopia Guji",
+		Price:    450_000,
+		Category: products.CategoryArabica,
+	}
+	if err := productRepo.Create(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	variant := &products.Variant{
+		ProductID: product.ID,
+		SKU:       "ethiopia-guji-250g",
+		Weight:    250,
+		Grind:     products.GrindWholeBean,
+		Price:     450_000,
+		InStock:   stock,
+		IsDefault: true,
+	}
+	if err := variantRepo.Create(ctx, variant); err != nil {
+		t.Fatalf("failed to create variant: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, orders)
+	start := make(chan struct{})
+	for range orders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- transactor.WithinTransaction(
+				ctx,
+				func(ctx context.Context) error {
+					return variantRepo.UpdateStock(ctx, variant.ID, -1)
+				},
+			)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	sold := 0
+	for err := range errs {
+		var stockErr *products.InsufficientStockError
+		switch {
+		case err == nil:
+			sold++
+		case errors.As(err, &stockErr):
+			if stockErr.Available != 0 {
+				t.Errorf(
+					"order refused with %d bags available",
+					stockErr.Available,
+				)
+			}
+		default:
+			t.Errorf("order failed: %v", err)
+		}
+	}
+	if sold != stock {
+		t.Errorf("%d bags sold, want %d", sold, stock)
+	}
+
+	stored, err := variantRepo.GetByID(ctx, variant.ID)
+	if err != nil {
+		t.Fatalf("failed to get variant: %v", err)
+	}
+	if stored.InStock != 0 {
+		t.Errorf("variant stock = %d, want 0", stored.InStock)
+	}
+
+	if err := variantRepo.UpdateStock(ctx, variant.ID, 2); err != nil {
+		t.Fatalf("failed to restock: %v", err)
+	}
+	if err := variantRepo.UpdateStock(ctx, variant.ID, -3); !errors.Is(
+		err, products.ErrInsufficientStock,
+	) {
+		t.Errorf("taking 3 of 2 bags: error = %v, want insufficient stock", err)
+	}
+}
@@ -0,0 +1,121 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/logger"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// AdjustStockUseCase changes the stock of a variant by hand, for example
+// after a delivery from the roastery or a stock count.
+type AdjustStockUseCase interface {
+	Execute(
+		ctx context.Context,
+		staffID uuid.UUID,
+		productID string,
+		variantID uuid.UUID,
+		req *products.AdjustStockRequest,
+	) (*products.Variant, error)
+}
+
+type adjustStockUseCase struct {
+	variantRepo repository.VariantRepository
+	inventory   InventoryService
+	logger      logger.Interface
+}
+
+func NewAdjustStockUseCase(
+	variantRepo repository.VariantRepository,
+	inventory InventoryService,
+	logger logger.Interface,
+) AdjustStockUseCase {
+	return &adjustStockUseCase{
+		variantRepo: variantRepo,
+		inventory:   inventory,
+		logger:      logger,
+	}
+}
+
+func (uc *adjustStockUseCase) Execute(
+	ctx context.Context,
+	staffID uuid.UUID,
+	productID string,
+	variantID uuid.UUID,
+	req *products.AdjustStockRequest,
+) (*products.Variant, error) {
+	note := strings.TrimSpace(req.Note)
+	switch {
+	case req.Quantity == 0:
+		return nil, fmt.Errorf(
+			"%w: quantity must not be zero", products.ErrInvalidAdjustment,
+		)
+	case req.Reason != products.MovementRestock &&
+		req.Reason != products.MovementCorrection:
+		return nil, fmt.Errorf(
+			"%w: reason must be restock or correction",
+			products.ErrInvalidAdjustment,
+		)
+	case req.Reason == products.MovementRestock && req.Quantity < 0:
+		return nil, fmt.Errorf(
+			"%w: a restock must add stock", products.ErrInvalidAdjustment,
+		)
+	case note == "":
+		return nil, fmt.Errorf(
+			"%w: note is required", products.ErrInvalidAdjustment,
+		)
+	}
+
+	variant, err := uc.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	if variant == nil || variant.ProductID != productID {
+		return nil, products.ErrVariantNotFound
+	}
+
+	if err := uc.inventory.Move(
+		ctx,
+		variantID,
+		req.Quantity,
+		products.StockChange{
+			Reason: req.Reason,
+			Actor:  products.StaffActor(staffID),
+			Note:   note,
+		},
+	); err != nil {
+		if errors.Is(err, products.ErrInsufficientStock) ||
+			errors.Is(err, products.ErrVariantNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update stock: %w", err)
+	}
+
+	variant, err = uc.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	if variant == nil {
+		return nil, products.ErrVariantNotFound
+	}
+
+	uc.logger.Info(
+		"Stock adjusted",
+		zap.String("productID", productID),
+		zap.String("variantID", variantID.String()),
+		zap.Int("quantity", req.Quantity),
+		zap.Int("inStock", variant.InStock),
+		zap.String("reason", req.Reason.String()),
+		zap.String("note", note),
+		zap.String("staffID", staffID.String()),
+	)
+
+	return variant, nil
+}
@@ -0,0 +1,57 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+)
+
+// ArchiveProductUseCase takes a product off the catalogue, or puts it back
+// when archived is false. Past orders keep referring to archived products.
+type ArchiveProductUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		archived bool,
+	) (*products.Product, error)
+}
+
+type archiveProductUseCase struct {
+	productRepo repository.ProductRepository
+}
+
+func NewArchiveProductUseCase(
+	productRepo repository.ProductRepository,
+) ArchiveProductUseCase {
+	return &archiveProductUseCase{
+		productRepo: productRepo,
+	}
+}
+
+func (uc *archiveProductUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	archived bool,
+) (*products.Product, error) {
+	if err := uc.productRepo.SetArchived(
+		ctx, productID, archived,
+	); err != nil {
+		if errors.Is(err, products.ErrProductNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to archive product: %w", err)
+	}
+
+	product, err := uc.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product == nil {
+		return nil, products.ErrProductNotFound
+	}
+
+	return product, nil
+}
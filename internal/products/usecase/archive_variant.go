@@ -0,0 +1,72 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveVariantUseCase stops selling a variant, or sells it again when
+// archived is false. Sales keep referring to archived variants.
+type ArchiveVariantUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		variantID uuid.UUID,
+		archived bool,
+	) (*products.Variant, error)
+}
+
+type archiveVariantUseCase struct {
+	variantRepo repository.VariantRepository
+}
+
+func NewArchiveVariantUseCase(
+	variantRepo repository.VariantRepository,
+) ArchiveVariantUseCase {
+	return &archiveVariantUseCase{
+		variantRepo: variantRepo,
+	}
+}
+
+func (uc *archiveVariantUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	variantID uuid.UUID,
+	archived bool,
+) (*products.Variant, error) {
+	variant, err := uc.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	if variant == nil || variant.ProductID != productID {
+		return nil, products.ErrVariantNotFound
+	}
+	if archived && variant.IsDefault {
+		return nil, products.ErrDefaultVariantArchive
+	}
+
+	if err := uc.variantRepo.SetArchived(
+		ctx, variantID, archived,
+	); err != nil {
+		if errors.Is(err, products.ErrVariantNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to archive variant: %w", err)
+	}
+
+	variant, err = uc.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	if variant == nil {
+		return nil, products.ErrVariantNotFound
+	}
+
+	return variant, nil
+}
@@ -0,0 +1,55 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// CheckDriftUseCase compares the stock of every product with its ledger.
+// Drift means in_stock was changed without going through the
+// InventoryService, typically by hand in the database.
+type CheckDriftUseCase interface {
+	Execute(ctx context.Context) ([]products.StockDrift, error)
+}
+
+type checkDriftUseCase struct {
+	movementRepo repository.InventoryMovementRepository
+	logger       logger.Interface
+}
+
+func NewCheckDriftUseCase(
+	movementRepo repository.InventoryMovementRepository,
+	logger logger.Interface,
+) CheckDriftUseCase {
+	return &checkDriftUseCase{
+		movementRepo: movementRepo,
+		logger:       logger,
+	}
+}
+
+func (uc *checkDriftUseCase) Execute(
+	ctx context.Context,
+) ([]products.StockDrift, error) {
+	drift, err := uc.movementRepo.GetDrift(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check inventory drift: %w", err)
+	}
+
+	for _, d := range drift {
+		uc.logger.Warn(
+			"Stock differs from inventory ledger",
+			zap.String("productID", d.ProductID),
+			zap.Int("inStock", d.InStock),
+			zap.Int("ledgerStock", d.LedgerStock),
+			zap.Int("drift", d.Drift),
+		)
+	}
+
+	return drift, nil
+}
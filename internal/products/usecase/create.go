@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxNameLength   = 255
+	maxOriginLength = 100
+)
+
+// productIDPattern matches the slugs products are addressed by, such as
+// "ethiopia-yirgacheffe-250"
+var productIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type CreateProductUseCase interface {
+	Execute(
+		ctx context.Context,
+		staffID uuid.UUID,
+		req *products.CreateProductRequest,
+	) (*products.Product, error)
+}
+
+type createProductUseCase struct {
+	transactor  database.Transactor
+	productRepo repository.ProductRepository
+	variantRepo repository.VariantRepository
+	inventory   InventoryService
+}
+
+func NewCreateProductUseCase(
+	transactor database.Transactor,
+	productRepo repository.ProductRepository,
+	variantRepo repository.VariantRepository,
+	inventory InventoryService,
+) CreateProductUseCase {
+	return &createProductUseCase{
+		transactor:  transactor,
+		productRepo: productRepo,
+		variantRepo: variantRepo,
+		inventory:   inventory,
+	}
+}
+
+func (uc *createProductUseCase) Execute(
+	ctx context.Context,
+	staffID uuid.UUID,
+	req *products.CreateProductRequest,
+) (*products.Product, error) {
+	id := strings.TrimSpace(req.ID)
+	if len(id) > 100 || !productIDPattern.MatchString(id) {
+		return nil, fmt.Errorf(
+			"%w: id must be a lowercase slug of at most 100 characters",
+			products.ErrInvalidProduct,
+		)
+	}
+	if len(req.Variants) == 0 {
+		return nil, fmt.Errorf(
+			"%w: at least one variant is required", products.ErrInvalidProduct,
+		)
+	}
+
+	// The database keeps the price at the lowest variant price from here on
+	var price int
+	for i, variant := range req.Variants {
+		if i == 0 || variant.Price < price {
+			price = variant.Price
+		}
+	}
+
+	product := &products.Product{
+		ID:          id,
+		Name:        strings.TrimSpace(req.Name),
+		NameEn:      optionalText(req.NameEn),
+		Description: optionalText(req.Description),
+		Price:       price,
+		Category:    req.Category,
+		Weight:      req.Weight,
+		Origin:      optionalText(req.Origin),
+		RoastLevel:  req.RoastLevel,
+		Bitterness:  req.Bitterness,
+		Body:        req.Body,
+		Acidity:     req.Acidity,
+		Sweetness:   req.Sweetness,
+	}
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+
+	existing, err := uc.productRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if existing != nil {
+		return nil, products.ErrProductExists
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.productRepo.Create(ctx, product); err != nil {
+			return fmt.Errorf("failed to create product: %w", err)
+		}
+
+		for i := range req.Variants {
+			if _, err := addVariant(
+				ctx,
+				uc.variantRepo,
+				uc.inventory,
+				staffID,
+				product.ID,
+				&req.Variants[i],
+				i == 0,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := uc.productRepo.GetByID(ctx, product.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if created == nil {
+		return nil, products.ErrProductNotFound
+	}
+
+	return created, nil
+}
+
+// validateProduct checks a product against the constraints of the products
+// table, so that bad input is reported as such rather than as a database
+// error.
+func validateProduct(p *products.Product) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", products.ErrInvalidProduct, reason)
+	}
+
+	if p.Name == "" || utf8.RuneCountInString(p.Name) > maxNameLength {
+		return invalid("name is required and must be at most 255 characters")
+	}
+	if p.NameEn != nil && utf8.RuneCountInString(*p.NameEn) > maxNameLength {
+		return invalid("name_en must be at most 255 characters")
+	}
+	if p.Price <= 0 {
+		return invalid("price must be positive")
+	}
+	if !p.Category.IsValid() {
+		return invalid("unknown category")
+	}
+	if p.Weight != nil && *p.Weight <= 0 {
+		return invalid("weight must be positive")
+	}
+	if p.Origin != nil && utf8.RuneCountInString(*p.Origin) > maxOriginLength {
+		return invalid("origin must be at most 100 characters")
+	}
+	if p.RoastLevel != nil && !p.RoastLevel.IsValid() {
+		return invalid("roast_level must be light, medium, dark or espresso")
+	}
+
+	scores := []struct {
+		name  string
+		value *int
+	}{
+		{"bitterness", p.Bitterness},
+		{"body", p.Body},
+		{"acidity", p.Acidity},
+		{"sweetness", p.Sweetness},
+	}
+	for _, score := range scores {
+		if score.value != nil && (*score.value < 1 || *score.value > 5) {
+			return invalid(score.name + " must be between 1 and 5")
+		}
+	}
+
+	return nil
+}
+
+// optionalText trims an optional text field and treats an empty value as
+// absent.
+func optionalText(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*value)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
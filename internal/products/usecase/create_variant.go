@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type CreateVariantUseCase interface {
+	Execute(
+		ctx context.Context,
+		staffID uuid.UUID,
+		productID string,
+		req *products.VariantRequest,
+	) (*products.Variant, error)
+}
+
+type createVariantUseCase struct {
+	transactor  database.Transactor
+	productRepo repository.ProductRepository
+	variantRepo repository.VariantRepository
+	inventory   InventoryService
+}
+
+func NewCreateVariantUseCase(
+	transactor database.Transactor,
+	productRepo repository.ProductRepository,
+	variantRepo repository.VariantRepository,
+	inventory InventoryService,
+) CreateVariantUseCase {
+	return &createVariantUseCase{
+		transactor:  transactor,
+		productRepo: productRepo,
+		variantRepo: variantRepo,
+		inventory:   inventory,
+	}
+}
+
+func (uc *createVariantUseCase) Execute(
+	ctx context.Context,
+	staffID uuid.UUID,
+	productID string,
+	req *products.VariantRequest,
+) (*products.Variant, error) {
+	product, err := uc.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product == nil {
+		return nil, products.ErrProductNotFound
+	}
+
+	var variant *products.Variant
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		variant, err = addVariant(
+			ctx,
+			uc.variantRepo,
+			uc.inventory,
+			staffID,
+			productID,
+			req,
+			len(product.Variants) == 0,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+// addVariant creates a variant with no stock and then books its opening
+// stock through the ledger like any other movement.
+func addVariant(
+	ctx context.Context,
+	variantRepo repository.VariantRepository,
+	inventory InventoryService,
+	staffID uuid.UUID,
+	productID string,
+	req *products.VariantRequest,
+	isDefault bool,
+) (*products.Variant, error) {
+	if req.InStock < 0 {
+		return nil, fmt.Errorf(
+			"%w: in_stock cannot be negative", products.ErrInvalidVariant,
+		)
+	}
+
+	grind := req.Grind
+	if grind == "" {
+		grind = products.GrindWholeBean
+	}
+
+	variant := &products.Variant{
+		ProductID: productID,
+		SKU:       strings.TrimSpace(req.SKU),
+		Weight:    req.Weight,
+		Grind:     grind,
+		Price:     req.Price,
+		IsDefault: isDefault,
+	}
+	if err := validateVariant(variant); err != nil {
+		return nil, err
+	}
+
+	exists, err := variantRepo.Exists(ctx, variant, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check variant: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", products.ErrVariantExists, variant.SKU)
+	}
+
+	if err := variantRepo.Create(ctx, variant); err != nil {
+		return nil, fmt.Errorf("failed to create variant: %w", err)
+	}
+
+	if err := inventory.Move(
+		ctx,
+		variant.ID,
+		req.InStock,
+		products.StockChange{
+			Reason: products.MovementOpening,
+			Actor:  products.StaffActor(staffID),
+		},
+	); err != nil {
+		if errors.Is(err, products.ErrInsufficientStock) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to record opening stock: %w", err)
+	}
+	variant.InStock = req.InStock
+
+	return variant, nil
+}
+
+// validateVariant checks a variant against the constraints of the
+// product_variants table.
+func validateVariant(v *products.Variant) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", products.ErrInvalidVariant, reason)
+	}
+
+	if len(v.SKU) > 100 || !skuPattern.MatchString(v.SKU) {
+		return invalid(
+			"sku is required and may hold letters, digits, '.', '_' and '-'",
+		)
+	}
+	if v.Weight <= 0 {
+		return invalid("weight must be a positive number of grams")
+	}
+	if !v.Grind.IsValid() {
+		return invalid("unknown grind")
+	}
+	if v.Price <= 0 {
+		return invalid("price must be positive")
+	}
+
+	return nil
+}
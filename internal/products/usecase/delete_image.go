@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/storage"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DeleteImageUseCase removes an image from the gallery of a product and
+// closes the gap it leaves in the order.
+type DeleteImageUseCase interface {
+	Execute(ctx context.Context, productID string, imageID uuid.UUID) error
+}
+
+type deleteImageUseCase struct {
+	transactor database.Transactor
+	imageRepo  repository.ImageRepository
+	storage    storage.Storage
+	logger     logger.Interface
+}
+
+func NewDeleteImageUseCase(
+	transactor database.Transactor,
+	imageRepo repository.ImageRepository,
+	storage storage.Storage,
+	logger logger.Interface,
+) DeleteImageUseCase {
+	return &deleteImageUseCase{
+		transactor: transactor,
+		imageRepo:  imageRepo,
+		storage:    storage,
+		logger:     logger,
+	}
+}
+
+func (uc *deleteImageUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	imageID uuid.UUID,
+) error {
+	image, err := uc.imageRepo.GetByID(ctx, imageID)
+	if err != nil {
+		return fmt.Errorf("failed to get image: %w", err)
+	}
+	if image == nil || image.ProductID != productID {
+		return products.ErrImageNotFound
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.imageRepo.Delete(ctx, imageID); err != nil {
+			if errors.Is(err, products.ErrImageNotFound) {
+				return err
+			}
+			return fmt.Errorf("failed to delete image: %w", err)
+		}
+
+		images, err := uc.imageRepo.ListByProduct(ctx, productID)
+		if err != nil {
+			return fmt.Errorf("failed to get images: %w", err)
+		}
+
+		ids := make([]uuid.UUID, len(images))
+		for i, remaining := range images {
+			ids[i] = remaining.ID
+		}
+		if err := uc.imageRepo.SetPositions(ctx, ids); err != nil {
+			return fmt.Errorf("failed to reorder images: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files go only once the row is gone, so a failed delete never leaves
+	// the gallery pointing at missing files
+	var keys []string
+	for _, key := range []*string{image.StorageKey, image.ThumbnailKey} {
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	removeFiles(ctx, uc.storage, uc.logger, keys...)
+
+	uc.logger.Info(
+		"Product image deleted",
+		zap.String("productID", productID),
+		zap.String("imageID", imageID.String()),
+	)
+
+	return nil
+}
@@ -3,6 +3,7 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"dunhayat-api/internal/products"
 	"dunhayat-api/internal/products/repository"
@@ -37,9 +38,15 @@ func (uc *getProductUseCase) Execute(
 		return nil, fmt.Errorf("failed to get product: %w", err)
 	}
 
-	if product == nil {
-		return nil, fmt.Errorf("product not found")
+	if product == nil || product.IsArchived() {
+		return nil, products.ErrProductNotFound
 	}
 
+	// Only variants that are for sale are shown
+	product.Variants = slices.DeleteFunc(
+		product.Variants,
+		func(v products.Variant) bool { return v.IsArchived() },
+	)
+
 	return product, nil
 }
@@ -0,0 +1,94 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+// InventoryService is the single way stock changes. Every change updates
+// in_stock and appends the matching movement to the ledger in the same
+// transaction, joining the caller's transaction when there is one.
+type InventoryService interface {
+	// Move adds quantity, which may be negative, to the stock of a variant.
+	// It returns a *products.InsufficientStockError instead of letting the
+	// stock drop below zero.
+	Move(
+		ctx context.Context,
+		variantID uuid.UUID,
+		quantity int,
+		change products.StockChange,
+	) error
+}
+
+type inventoryService struct {
+	transactor   database.Transactor
+	variantRepo  repository.VariantRepository
+	movementRepo repository.InventoryMovementRepository
+}
+
+func NewInventoryService(
+	transactor database.Transactor,
+	variantRepo repository.VariantRepository,
+	movementRepo repository.InventoryMovementRepository,
+) InventoryService {
+	return &inventoryService{
+		transactor:   transactor,
+		variantRepo:  variantRepo,
+		movementRepo: movementRepo,
+	}
+}
+
+func (s *inventoryService) Move(
+	ctx context.Context,
+	variantID uuid.UUID,
+	quantity int,
+	change products.StockChange,
+) error {
+	if quantity == 0 {
+		return nil
+	}
+	if !change.Reason.IsValid() {
+		return fmt.Errorf("unknown stock movement reason: %s", change.Reason)
+	}
+
+	movement := &products.InventoryMovement{
+		VariantID: variantID,
+		Quantity:  quantity,
+		Reason:    change.Reason,
+		SaleID:    change.SaleID,
+		Actor:     change.Actor,
+	}
+	if note := strings.TrimSpace(change.Note); note != "" {
+		movement.Note = &note
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		variant, err := s.variantRepo.GetByID(ctx, variantID)
+		if err != nil {
+			return fmt.Errorf("failed to get variant: %w", err)
+		}
+		if variant == nil {
+			return products.ErrVariantNotFound
+		}
+		movement.ProductID = variant.ProductID
+
+		if err := s.variantRepo.UpdateStock(
+			ctx, variantID, quantity,
+		); err != nil {
+			return err
+		}
+
+		if err := s.movementRepo.Create(ctx, movement); err != nil {
+			return fmt.Errorf("failed to record stock movement: %w", err)
+		}
+
+		return nil
+	})
+}
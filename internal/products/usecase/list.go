@@ -2,13 +2,20 @@ package usecase
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"dunhayat-api/internal/products"
 	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/pagination"
 )
 
 type ListProductsUseCase interface {
-	Execute(ctx context.Context, category *products.Category) ([]products.Product, error)
+	Execute(
+		ctx context.Context,
+		req *products.ListProductsRequest,
+	) (*products.ListProductsResponse, error)
 }
 
 type listProductsUseCase struct {
@@ -24,10 +31,100 @@ func NewListProductsUseCase(
 }
 
 func (uc *listProductsUseCase) Execute(
-	ctx context.Context, category *products.Category,
-) ([]products.Product, error) {
-	if category != nil {
-		return uc.productRepo.GetByCategory(ctx, *category)
+	ctx context.Context,
+	req *products.ListProductsRequest,
+) (*products.ListProductsResponse, error) {
+	limit := pagination.Limit(req.Limit)
+
+	sort := req.Sort
+	if sort == "" {
+		sort = products.SortNewest
+	}
+
+	filter := products.ProductFilter{
+		ProductSearch: req.ProductSearch,
+		Sort:          sort,
+		// One extra product tells whether there is a next page.
+		Limit: limit + 1,
+	}
+
+	if req.Cursor != "" {
+		cursor, err := decodeProductCursor(req.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = cursor
+	}
+
+	productList, err := uc.productRepo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+
+	total, err := uc.productRepo.Count(ctx, req.ProductSearch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count products: %w", err)
 	}
-	return uc.productRepo.GetAll(ctx)
+
+	var nextCursor string
+	if len(productList) > limit {
+		productList = productList[:limit]
+		nextCursor = encodeProductCursor(
+			productList[len(productList)-1], sort,
+		)
+	}
+
+	return &products.ListProductsResponse{
+		Products:   productList,
+		Total:      total,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// A product cursor carries the sort order it was made for, so that it is
+// rejected rather than misread when the client changes the order.
+func encodeProductCursor(
+	product products.Product,
+	sort products.ProductSort,
+) string {
+	var value string
+	switch sort {
+	case products.SortPriceAsc, products.SortPriceDesc:
+		value = strconv.Itoa(product.Price)
+	case products.SortName:
+		value = product.Name
+	default:
+		value = product.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	return pagination.EncodeCursor(string(sort), value, product.ID)
+}
+
+func decodeProductCursor(
+	cursor string,
+	sort products.ProductSort,
+) (*products.ProductCursor, error) {
+	values, err := pagination.DecodeCursor(cursor, 3)
+	if err != nil {
+		return nil, err
+	}
+	if products.ProductSort(values[0]) != sort || values[2] == "" {
+		return nil, pagination.ErrInvalidCursor
+	}
+
+	var value any
+	switch sort {
+	case products.SortPriceAsc, products.SortPriceDesc:
+		if value, err = strconv.Atoi(values[1]); err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+	case products.SortName:
+		value = values[1]
+	default:
+		if value, err = time.Parse(time.RFC3339Nano, values[1]); err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+	}
+
+	return &products.ProductCursor{Value: value, ID: values[2]}, nil
 }
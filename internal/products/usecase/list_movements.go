@@ -0,0 +1,106 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/pagination"
+
+	"github.com/google/uuid"
+)
+
+type ListMovementsUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		cursor string,
+		limit int,
+	) (*products.ListMovementsResponse, error)
+}
+
+type listMovementsUseCase struct {
+	productRepo  repository.ProductRepository
+	movementRepo repository.InventoryMovementRepository
+}
+
+func NewListMovementsUseCase(
+	productRepo repository.ProductRepository,
+	movementRepo repository.InventoryMovementRepository,
+) ListMovementsUseCase {
+	return &listMovementsUseCase{
+		productRepo:  productRepo,
+		movementRepo: movementRepo,
+	}
+}
+
+func (uc *listMovementsUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	cursor string,
+	limit int,
+) (*products.ListMovementsResponse, error) {
+	product, err := uc.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product == nil {
+		return nil, products.ErrProductNotFound
+	}
+
+	limit = pagination.Limit(limit)
+
+	filter := products.MovementFilter{
+		ProductID: productID,
+		// One extra movement tells whether there is a next page.
+		Limit: limit + 1,
+	}
+
+	if cursor != "" {
+		after, err := decodeMovementCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = after
+	}
+
+	movements, err := uc.movementRepo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stock movements: %w", err)
+	}
+
+	var nextCursor string
+	if len(movements) > limit {
+		movements = movements[:limit]
+		last := movements[len(movements)-1]
+		nextCursor = pagination.EncodeCursor(
+			last.CreatedAt.Format(time.RFC3339Nano),
+			last.ID.String(),
+		)
+	}
+
+	return &products.ListMovementsResponse{
+		Movements:  movements,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func decodeMovementCursor(cursor string) (*products.MovementCursor, error) {
+	values, err := pagination.DecodeCursor(cursor, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, values[0])
+	if err != nil {
+		return nil, pagination.ErrInvalidCursor
+	}
+	id, err := uuid.Parse(values[1])
+	if err != nil {
+		return nil, pagination.ErrInvalidCursor
+	}
+
+	return &products.MovementCursor{CreatedAt: createdAt, ID: id}, nil
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+// ReorderImagesUseCase puts the gallery of a product in a new order. The
+// first image becomes the cover of the product.
+type ReorderImagesUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		req *products.ReorderImagesRequest,
+	) ([]products.ProductImage, error)
+}
+
+type reorderImagesUseCase struct {
+	transactor database.Transactor
+	imageRepo  repository.ImageRepository
+}
+
+func NewReorderImagesUseCase(
+	transactor database.Transactor,
+	imageRepo repository.ImageRepository,
+) ReorderImagesUseCase {
+	return &reorderImagesUseCase{
+		transactor: transactor,
+		imageRepo:  imageRepo,
+	}
+}
+
+func (uc *reorderImagesUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	req *products.ReorderImagesRequest,
+) ([]products.ProductImage, error) {
+	var images []products.ProductImage
+
+	err := uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		current, err := uc.imageRepo.ListByProduct(ctx, productID)
+		if err != nil {
+			return fmt.Errorf("failed to get images: %w", err)
+		}
+
+		pending := make(map[uuid.UUID]bool, len(current))
+		for _, image := range current {
+			pending[image.ID] = true
+		}
+		for _, id := range req.ImageIDs {
+			if !pending[id] {
+				return products.ErrInvalidImageOrder
+			}
+			delete(pending, id)
+		}
+		if len(pending) > 0 {
+			return products.ErrInvalidImageOrder
+		}
+
+		if err := uc.imageRepo.SetPositions(ctx, req.ImageIDs); err != nil {
+			return fmt.Errorf("failed to reorder images: %w", err)
+		}
+
+		images, err = uc.imageRepo.ListByProduct(ctx, productID)
+		if err != nil {
+			return fmt.Errorf("failed to get images: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+)
+
+type UpdateProductUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		req *products.UpdateProductRequest,
+	) (*products.Product, error)
+}
+
+type updateProductUseCase struct {
+	productRepo repository.ProductRepository
+}
+
+func NewUpdateProductUseCase(
+	productRepo repository.ProductRepository,
+) UpdateProductUseCase {
+	return &updateProductUseCase{
+		productRepo: productRepo,
+	}
+}
+
+func (uc *updateProductUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	req *products.UpdateProductRequest,
+) (*products.Product, error) {
+	product, err := uc.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product == nil {
+		return nil, products.ErrProductNotFound
+	}
+
+	if req.Name != nil {
+		product.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.NameEn != nil {
+		product.NameEn = optionalText(req.NameEn)
+	}
+	if req.Description != nil {
+		product.Description = optionalText(req.Description)
+	}
+	if req.Category != nil {
+		product.Category = *req.Category
+	}
+	if req.Weight != nil {
+		product.Weight = optionalNumber(req.Weight)
+	}
+	if req.Origin != nil {
+		product.Origin = optionalText(req.Origin)
+	}
+	if req.RoastLevel != nil {
+		product.RoastLevel = req.RoastLevel
+		if *req.RoastLevel == "" {
+			product.RoastLevel = nil
+		}
+	}
+	if req.Bitterness != nil {
+		product.Bitterness = optionalNumber(req.Bitterness)
+	}
+	if req.Body != nil {
+		product.Body = optionalNumber(req.Body)
+	}
+	if req.Acidity != nil {
+		product.Acidity = optionalNumber(req.Acidity)
+	}
+	if req.Sweetness != nil {
+		product.Sweetness = optionalNumber(req.Sweetness)
+	}
+
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+
+	if err := uc.productRepo.Update(ctx, product); err != nil {
+		return nil, fmt.Errorf("failed to update product: %w", err)
+	}
+
+	return product, nil
+}
+
+// optionalNumber treats zero as clearing an optional numeric field
+func optionalNumber[T int | float64](value *T) *T {
+	if value == nil || *value == 0 {
+		return nil
+	}
+	return value
+}
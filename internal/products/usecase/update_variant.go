@@ -0,0 +1,109 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type UpdateVariantUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		variantID uuid.UUID,
+		req *products.UpdateVariantRequest,
+	) (*products.Variant, error)
+}
+
+type updateVariantUseCase struct {
+	transactor  database.Transactor
+	variantRepo repository.VariantRepository
+}
+
+func NewUpdateVariantUseCase(
+	transactor database.Transactor,
+	variantRepo repository.VariantRepository,
+) UpdateVariantUseCase {
+	return &updateVariantUseCase{
+		transactor:  transactor,
+		variantRepo: variantRepo,
+	}
+}
+
+func (uc *updateVariantUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	variantID uuid.UUID,
+	req *products.UpdateVariantRequest,
+) (*products.Variant, error) {
+	variant, err := uc.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	if variant == nil || variant.ProductID != productID {
+		return nil, products.ErrVariantNotFound
+	}
+
+	if req.SKU != nil {
+		variant.SKU = strings.TrimSpace(*req.SKU)
+	}
+	if req.Weight != nil {
+		variant.Weight = *req.Weight
+	}
+	if req.Grind != nil {
+		variant.Grind = *req.Grind
+	}
+	if req.Price != nil {
+		variant.Price = *req.Price
+	}
+
+	if err := validateVariant(variant); err != nil {
+		return nil, err
+	}
+	if req.IsDefault != nil && !*req.IsDefault {
+		return nil, fmt.Errorf(
+			"%w: make another variant the default instead",
+			products.ErrInvalidVariant,
+		)
+	}
+	if req.IsDefault != nil && variant.IsArchived() {
+		return nil, fmt.Errorf(
+			"%w: an archived variant cannot be the default",
+			products.ErrInvalidVariant,
+		)
+	}
+
+	exists, err := uc.variantRepo.Exists(ctx, variant, &variant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check variant: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", products.ErrVariantExists, variant.SKU)
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.variantRepo.Update(ctx, variant); err != nil {
+			return fmt.Errorf("failed to update variant: %w", err)
+		}
+
+		if req.IsDefault != nil && !variant.IsDefault {
+			if err := uc.variantRepo.SetDefault(ctx, variant); err != nil {
+				return fmt.Errorf("failed to set default variant: %w", err)
+			}
+			variant.IsDefault = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
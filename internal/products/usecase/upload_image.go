@@ -0,0 +1,184 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/products"
+	"dunhayat-api/internal/products/repository"
+	"dunhayat-api/pkg/imaging"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/storage"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ImageConfig limits the images uploaded to product galleries.
+type ImageConfig struct {
+	// MaxSize is the largest accepted file, in bytes
+	MaxSize int64
+	// ThumbnailSize is the longest side of a thumbnail, in pixels
+	ThumbnailSize int
+	// MaxImages is the most images a product can have
+	MaxImages int
+}
+
+// UploadImageUseCase stores an uploaded image and its thumbnail and appends
+// it to the gallery of a product.
+type UploadImageUseCase interface {
+	Execute(
+		ctx context.Context,
+		productID string,
+		upload *products.ImageUpload,
+	) (*products.ProductImage, error)
+}
+
+type uploadImageUseCase struct {
+	productRepo repository.ProductRepository
+	imageRepo   repository.ImageRepository
+	storage     storage.Storage
+	cfg         ImageConfig
+	logger      logger.Interface
+}
+
+func NewUploadImageUseCase(
+	productRepo repository.ProductRepository,
+	imageRepo repository.ImageRepository,
+	storage storage.Storage,
+	cfg ImageConfig,
+	logger logger.Interface,
+) UploadImageUseCase {
+	return &uploadImageUseCase{
+		productRepo: productRepo,
+		imageRepo:   imageRepo,
+		storage:     storage,
+		cfg:         cfg,
+		logger:      logger,
+	}
+}
+
+func (uc *uploadImageUseCase) Execute(
+	ctx context.Context,
+	productID string,
+	upload *products.ImageUpload,
+) (*products.ProductImage, error) {
+	size := int64(len(upload.Content))
+	if size == 0 {
+		return nil, fmt.Errorf("%w: file is empty", products.ErrInvalidImage)
+	}
+	if size > uc.cfg.MaxSize {
+		return nil, fmt.Errorf(
+			"%w: the limit is %d bytes",
+			products.ErrImageTooLarge, uc.cfg.MaxSize,
+		)
+	}
+
+	altText := strings.TrimSpace(upload.AltText)
+	if len([]rune(altText)) > 255 {
+		return nil, fmt.Errorf(
+			"%w: alt_text must be at most 255 characters",
+			products.ErrInvalidImage,
+		)
+	}
+
+	product, err := uc.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product == nil {
+		return nil, products.ErrProductNotFound
+	}
+	if len(product.Images) >= uc.cfg.MaxImages {
+		return nil, fmt.Errorf(
+			"%w: a product can have at most %d images",
+			products.ErrImageLimitReached, uc.cfg.MaxImages,
+		)
+	}
+
+	img, contentType, err := imaging.Decode(upload.Content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", products.ErrInvalidImage, err)
+	}
+
+	var thumbnail bytes.Buffer
+	if err := imaging.EncodeJPEG(
+		&thumbnail, imaging.Thumbnail(img, uc.cfg.ThumbnailSize), 85,
+	); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+
+	name := uuid.NewString()
+	key := fmt.Sprintf(
+		"products/%s/%s.%s", productID, name, imaging.Formats[contentType],
+	)
+	thumbnailKey := fmt.Sprintf("products/%s/%s-thumb.jpg", productID, name)
+
+	if err := uc.storage.Put(
+		ctx, key, bytes.NewReader(upload.Content), contentType,
+	); err != nil {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := uc.storage.Put(
+		ctx, thumbnailKey, &thumbnail, "image/jpeg",
+	); err != nil {
+		removeFiles(ctx, uc.storage, uc.logger, key)
+		return nil, fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	thumbnailURL := uc.storage.URL(thumbnailKey)
+
+	image := &products.ProductImage{
+		ProductID:    productID,
+		Position:     len(product.Images),
+		URL:          uc.storage.URL(key),
+		ThumbnailURL: &thumbnailURL,
+		StorageKey:   &key,
+		ThumbnailKey: &thumbnailKey,
+		ContentType:  &contentType,
+		Width:        &width,
+		Height:       &height,
+		Size:         &size,
+	}
+	if altText != "" {
+		image.AltText = &altText
+	}
+
+	if err := uc.imageRepo.Create(ctx, image); err != nil {
+		removeFiles(ctx, uc.storage, uc.logger, key, thumbnailKey)
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+
+	uc.logger.Info(
+		"Product image uploaded",
+		zap.String("productID", productID),
+		zap.String("imageID", image.ID.String()),
+		zap.String("contentType", contentType),
+		zap.Int64("size", size),
+	)
+
+	return image, nil
+}
+
+// removeFiles deletes stored files that nothing refers to any more.
+// Failures are only logged, as the files are unreachable either way.
+func removeFiles(
+	ctx context.Context,
+	storage storage.Storage,
+	logger logger.Interface,
+	keys ...string,
+) {
+	for _, key := range keys {
+		if err := storage.Delete(ctx, key); err != nil {
+			logger.Warn(
+				"Failed to delete orphaned image file",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -45,6 +45,7 @@ func (s *AuthUserAdapter) FindUserByPhone(
 		Phone:     user.Phone,
 		Email:     user.Email,
 		Verified:  int(user.Verified),
+		Role:      user.Role.String(),
 		LastLogin: nil,
 	}, nil
 }
@@ -60,6 +61,7 @@ func (s *AuthUserAdapter) CreateUser(
 		Phone:     user.Phone,
 		Email:     user.Email,
 		Verified:  users.VerificationLevel(user.Verified),
+		Role:      users.RoleCustomer,
 		LastLogin: nil,
 	}
 
@@ -84,6 +86,28 @@ func (s *AuthUserAdapter) UpdateUserLastLogin(
 	return s.userRepo.Update(ctx, user)
 }
 
+func (s *AuthUserAdapter) MarkPhoneVerified(
+	ctx context.Context,
+	userID uuid.UUID,
+) (int, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, users.ErrUserNotFound
+	}
+
+	if !user.Verified.Has(users.VerificationPhone) {
+		user.Verified = user.Verified.With(users.VerificationPhone)
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(user.Verified), nil
+}
+
 func (s *AuthUserAdapter) CreateAddress(
 	ctx context.Context,
 	address *port.Address,
@@ -93,6 +117,7 @@ func (s *AuthUserAdapter) CreateAddress(
 		UserID:     address.UserID,
 		Address:    address.Address,
 		PostalCode: address.PostalCode,
+		IsDefault:  address.IsDefault,
 	}
 
 	return s.addressRepo.Create(ctx, domainAddress)
@@ -114,6 +139,7 @@ func (s *AuthUserAdapter) GetUserAddresses(
 			UserID:     addr.UserID,
 			Address:    addr.Address,
 			PostalCode: addr.PostalCode,
+			IsDefault:  addr.IsDefault,
 		}
 	}
 
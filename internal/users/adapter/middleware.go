@@ -40,6 +40,7 @@ func (s *MiddlewareUserAdapter) GetUserByID(
 		Phone:     user.Phone,
 		Email:     user.Email,
 		Verified:  int(user.Verified),
+		Role:      user.Role.String(),
 		LastLogin: nil,
 	}, nil
 }
@@ -10,14 +10,17 @@ import (
 )
 
 type OrdersUserAdapter struct {
-	userRepo repository.UserRepository
+	userRepo    repository.UserRepository
+	addressRepo repository.AddressRepository
 }
 
 func NewOrdersUserAdapter(
 	userRepo repository.UserRepository,
+	addressRepo repository.AddressRepository,
 ) port.UserPort {
 	return &OrdersUserAdapter{
-		userRepo: userRepo,
+		userRepo:    userRepo,
+		addressRepo: addressRepo,
 	}
 }
 
@@ -41,3 +44,23 @@ func (s *OrdersUserAdapter) GetUserByID(
 		Email:     user.Email,
 	}, nil
 }
+
+func (s *OrdersUserAdapter) GetAddressByID(
+	ctx context.Context,
+	addressID uuid.UUID,
+) (*port.Address, error) {
+	address, err := s.addressRepo.GetByID(ctx, addressID)
+	if err != nil {
+		return nil, err
+	}
+	if address == nil {
+		return nil, nil
+	}
+
+	return &port.Address{
+		ID:         address.ID,
+		UserID:     address.UserID,
+		Address:    address.Address,
+		PostalCode: address.PostalCode,
+	}, nil
+}
@@ -1,6 +1,8 @@
 package users
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +17,22 @@ const (
 	VerificationBoth
 )
 
+// With returns the level after also verifying what other covers. The
+// levels are bit flags: Both is Phone and Email together.
+func (v VerificationLevel) With(other VerificationLevel) VerificationLevel {
+	return v | other
+}
+
+// Without returns the level after losing the verification of what other
+// covers.
+func (v VerificationLevel) Without(other VerificationLevel) VerificationLevel {
+	return v &^ other
+}
+
+func (v VerificationLevel) Has(other VerificationLevel) bool {
+	return v&other == other
+}
+
 func (v VerificationLevel) String() string {
 	switch v {
 	case VerificationNone:
@@ -30,6 +48,26 @@ func (v VerificationLevel) String() string {
 	}
 }
 
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleStaff    Role = "staff"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) String() string {
+	return string(r)
+}
+
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleCustomer, RoleStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID        uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	FirstName *string           `json:"first_name,omitempty"`
@@ -37,6 +75,7 @@ type User struct {
 	Phone     string            `json:"phone" gorm:"uniqueIndex;not null"`
 	Email     *string           `json:"email,omitempty"`
 	Verified  VerificationLevel `json:"verified" gorm:"type:smallint;default:0"`
+	Role      Role              `json:"role" gorm:"type:varchar(20);not null;default:'customer'"`
 	LastLogin *time.Time        `json:"last_login,omitempty"`
 	CreatedAt time.Time         `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time         `json:"updated_at" gorm:"autoUpdateTime"`
@@ -47,10 +86,104 @@ type Address struct {
 	UserID     uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
 	Address    string    `json:"address" gorm:"type:text;not null"`
 	PostalCode string    `json:"postal_code" gorm:"not null"`
+	IsDefault  bool      `json:"is_default" gorm:"not null;default:false"`
 	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt  time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
+// MaxAddressesPerUser caps the size of a user's address book
+const MaxAddressesPerUser = 10
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidName         = errors.New("names must be at most 100 characters")
+	ErrInvalidEmail        = errors.New("invalid email address")
+	ErrAddressNotFound     = errors.New("address not found")
+	ErrAddressRequired     = errors.New("address is required")
+	ErrInvalidPostalCode   = errors.New("postal code must be 10 digits")
+	ErrAddressLimitReached = errors.New("address book is full")
+
+	ErrInvalidRole         = errors.New("role must be customer, staff or admin")
+	ErrCannotChangeOwnRole = errors.New("cannot change your own role")
+
+	ErrEmailNotSet              = errors.New("no email address on profile")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+)
+
+// Profile is the view of a user returned to the user themselves
+type Profile struct {
+	ID           uuid.UUID  `json:"id"`
+	FirstName    *string    `json:"first_name,omitempty"`
+	LastName     *string    `json:"last_name,omitempty"`
+	Phone        string     `json:"phone"`
+	Email        *string    `json:"email,omitempty"`
+	Verification string     `json:"verification"`
+	Role         Role       `json:"role"`
+	LastLogin    *time.Time `json:"last_login,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (u *User) Profile() *Profile {
+	return &Profile{
+		ID:           u.ID,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		Phone:        u.Phone,
+		Email:        u.Email,
+		Verification: u.Verified.String(),
+		Role:         u.Role,
+		LastLogin:    u.LastLogin,
+		CreatedAt:    u.CreatedAt,
+		UpdatedAt:    u.UpdatedAt,
+	}
+}
+
+// UpdateProfileRequest changes only the fields that are present; an empty
+// string clears a field.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+}
+
+type UpdateRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+type AddressRequest struct {
+	Address    string `json:"address"`
+	PostalCode string `json:"postal_code"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+// NormalizePostalCode turns Persian and Arabic-Indic digits into ASCII,
+// drops spaces and dashes, and checks that an Iranian postal code of
+// 10 digits is left.
+func NormalizePostalCode(postalCode string) (string, error) {
+	var b strings.Builder
+	for _, r := range postalCode {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r >= '۰' && r <= '۹':
+			b.WriteRune('0' + r - '۰')
+		case r >= '٠' && r <= '٩':
+			b.WriteRune('0' + r - '٠')
+		case r == ' ' || r == '-':
+		default:
+			return "", ErrInvalidPostalCode
+		}
+	}
+
+	normalized := b.String()
+	if len(normalized) != 10 {
+		return "", ErrInvalidPostalCode
+	}
+	return normalized, nil
+}
+
 func (User) TableName() string {
 	return "users"
 }
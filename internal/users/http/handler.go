@@ -0,0 +1,357 @@
+package http
+
+import (
+	"errors"
+
+	"dunhayat-api/internal/auth/http"
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/usecase"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type UserHandler struct {
+	getProfileUseCase               usecase.GetProfileUseCase
+	updateProfileUseCase            usecase.UpdateProfileUseCase
+	requestEmailVerificationUseCase usecase.RequestEmailVerificationUseCase
+	verifyEmailUseCase              usecase.VerifyEmailUseCase
+	listAddressesUseCase            usecase.ListAddressesUseCase
+	createAddressUseCase            usecase.CreateAddressUseCase
+	updateAddressUseCase            usecase.UpdateAddressUseCase
+	deleteAddressUseCase            usecase.DeleteAddressUseCase
+	updateRoleUseCase               usecase.UpdateRoleUseCase
+}
+
+func NewUserHandler(
+	getProfileUseCase usecase.GetProfileUseCase,
+	updateProfileUseCase usecase.UpdateProfileUseCase,
+	requestEmailVerificationUseCase usecase.RequestEmailVerificationUseCase,
+	verifyEmailUseCase usecase.VerifyEmailUseCase,
+	listAddressesUseCase usecase.ListAddressesUseCase,
+	createAddressUseCase usecase.CreateAddressUseCase,
+	updateAddressUseCase usecase.UpdateAddressUseCase,
+	deleteAddressUseCase usecase.DeleteAddressUseCase,
+	updateRoleUseCase usecase.UpdateRoleUseCase,
+) *UserHandler {
+	return &UserHandler{
+		getProfileUseCase:               getProfileUseCase,
+		updateProfileUseCase:            updateProfileUseCase,
+		requestEmailVerificationUseCase: requestEmailVerificationUseCase,
+		verifyEmailUseCase:              verifyEmailUseCase,
+		listAddressesUseCase:            listAddressesUseCase,
+		createAddressUseCase:            createAddressUseCase,
+		updateAddressUseCase:            updateAddressUseCase,
+		deleteAddressUseCase:            deleteAddressUseCase,
+		updateRoleUseCase:               updateRoleUseCase,
+	}
+}
+
+func (h *UserHandler) GetProfile(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	profile, err := h.getProfileUseCase.Execute(c.Context(), userID)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	response := fiber.Map{
+		"data": profile,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *UserHandler) UpdateProfile(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var req users.UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	profile, err := h.updateProfileUseCase.Execute(
+		c.Context(), userID, &req,
+	)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Profile updated successfully",
+		"data":    profile,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *UserHandler) RequestEmailVerification(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	if err := h.requestEmailVerificationUseCase.Execute(
+		c.Context(), userID,
+	); err != nil {
+		return profileError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Verification email sent",
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+func (h *UserHandler) VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token is required",
+		})
+	}
+
+	profile, err := h.verifyEmailUseCase.Execute(c.Context(), token)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Email verified successfully",
+		"data":    profile,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *UserHandler) ListAddresses(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	addresses, err := h.listAddressesUseCase.Execute(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	response := fiber.Map{
+		"data":  addresses,
+		"count": len(addresses),
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *UserHandler) CreateAddress(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var req users.AddressRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	address, err := h.createAddressUseCase.Execute(
+		c.Context(), userID, &req,
+	)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Address created successfully",
+		"data":    address,
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *UserHandler) UpdateAddress(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	addressID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid address ID",
+		})
+	}
+
+	var req users.AddressRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	address, err := h.updateAddressUseCase.Execute(
+		c.Context(), userID, addressID, &req,
+	)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	response := fiber.Map{
+		"message": "Address updated successfully",
+		"data":    address,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *UserHandler) DeleteAddress(c *fiber.Ctx) error {
+	userID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	addressID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid address ID",
+		})
+	}
+
+	if err := h.deleteAddressUseCase.Execute(
+		c.Context(), userID, addressID,
+	); err != nil {
+		return addressError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *UserHandler) UpdateUserRole(c *fiber.Ctx) error {
+	adminID, ok := http.GetUserIDFromContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req users.UpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	profile, err := h.updateRoleUseCase.Execute(
+		c.Context(), adminID, userID, req.Role,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrUserNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, users.ErrInvalidRole):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, users.ErrCannotChangeOwnRole):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(
+				fiber.Map{
+					"error": err.Error(),
+				},
+			)
+		}
+	}
+
+	response := fiber.Map{
+		"message": "Role updated successfully",
+		"data":    profile,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func profileError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, users.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, users.ErrInvalidName),
+		errors.Is(err, users.ErrInvalidEmail),
+		errors.Is(err, users.ErrEmailNotSet),
+		errors.Is(err, users.ErrInvalidVerificationToken):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, users.ErrEmailAlreadyVerified):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+}
+
+func addressError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, users.ErrAddressNotFound),
+		errors.Is(err, users.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, users.ErrAddressRequired),
+		errors.Is(err, users.ErrInvalidPostalCode):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, users.ErrAddressLimitReached):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+}
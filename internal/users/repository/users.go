@@ -5,14 +5,19 @@ import (
 	"errors"
 
 	"dunhayat-api/internal/users"
+	"dunhayat-api/pkg/database"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	Create(ctx context.Context, user *users.User) error
 	GetByID(ctx context.Context, id uuid.UUID) (*users.User, error)
+	// GetByIDForUpdate loads a user and locks its row until the surrounding
+	// transaction ends
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*users.User, error)
 	GetByPhone(ctx context.Context, phone string) (*users.User, error)
 	Update(ctx context.Context, user *users.User) error
 	Delete(ctx context.Context, id uuid.UUID) error
@@ -20,8 +25,12 @@ type UserRepository interface {
 
 type AddressRepository interface {
 	Create(ctx context.Context, address *users.Address) error
+	GetByID(ctx context.Context, id uuid.UUID) (*users.Address, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]users.Address, error)
+	CountByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
 	Update(ctx context.Context, address *users.Address) error
+	// ClearDefault unsets the default flag on every address of a user
+	ClearDefault(ctx context.Context, userID uuid.UUID) error
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
@@ -45,7 +54,7 @@ func (r *postgresUserRepository) Create(
 	ctx context.Context,
 	user *users.User,
 ) error {
-	return r.db.WithContext(ctx).Create(user).Error
+	return database.Conn(ctx, r.db).Create(user).Error
 }
 
 func (r *postgresUserRepository) GetByID(
@@ -53,7 +62,7 @@ func (r *postgresUserRepository) GetByID(
 	id uuid.UUID,
 ) (*users.User, error) {
 	var user users.User
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"id = ?", id,
 	).First(&user).Error
 	if err != nil {
@@ -65,12 +74,30 @@ func (r *postgresUserRepository) GetByID(
 	return &user, nil
 }
 
+func (r *postgresUserRepository) GetByIDForUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+) (*users.User, error) {
+	var user users.User
+	err := database.Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *postgresUserRepository) GetByPhone(
 	ctx context.Context,
 	phone string,
 ) (*users.User, error) {
 	var user users.User
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"phone = ?", phone,
 	).First(&user).Error
 	if err != nil {
@@ -86,21 +113,38 @@ func (r *postgresUserRepository) Update(
 	ctx context.Context,
 	user *users.User,
 ) error {
-	return r.db.WithContext(ctx).Save(user).Error
+	return database.Conn(ctx, r.db).Save(user).Error
 }
 
 func (r *postgresUserRepository) Delete(
 	ctx context.Context,
 	id uuid.UUID,
 ) error {
-	return r.db.WithContext(ctx).Delete(&users.User{}, id).Error
+	return database.Conn(ctx, r.db).Delete(&users.User{}, id).Error
 }
 
 func (r *postgresAddressRepository) Create(
 	ctx context.Context,
 	address *users.Address,
 ) error {
-	return r.db.WithContext(ctx).Create(address).Error
+	return database.Conn(ctx, r.db).Create(address).Error
+}
+
+func (r *postgresAddressRepository) GetByID(
+	ctx context.Context,
+	id uuid.UUID,
+) (*users.Address, error) {
+	var address users.Address
+	err := database.Conn(ctx, r.db).Where(
+		"id = ?", id,
+	).First(&address).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &address, nil
 }
 
 func (r *postgresAddressRepository) GetByUserID(
@@ -108,10 +152,10 @@ func (r *postgresAddressRepository) GetByUserID(
 	userID uuid.UUID,
 ) ([]users.Address, error) {
 	var addresses []users.Address
-	err := r.db.WithContext(ctx).Where(
+	err := database.Conn(ctx, r.db).Where(
 		"user_id = ?",
 		userID,
-	).Find(&addresses).Error
+	).Order("created_at ASC").Find(&addresses).Error
 	if err != nil {
 		return nil, err
 	}
@@ -122,12 +166,32 @@ func (r *postgresAddressRepository) Update(
 	ctx context.Context,
 	address *users.Address,
 ) error {
-	return r.db.WithContext(ctx).Save(address).Error
+	return database.Conn(ctx, r.db).Save(address).Error
+}
+
+func (r *postgresAddressRepository) CountByUserID(
+	ctx context.Context,
+	userID uuid.UUID,
+) (int64, error) {
+	var count int64
+	err := database.Conn(ctx, r.db).Model(&users.Address{}).Where(
+		"user_id = ?", userID,
+	).Count(&count).Error
+	return count, err
+}
+
+func (r *postgresAddressRepository) ClearDefault(
+	ctx context.Context,
+	userID uuid.UUID,
+) error {
+	return database.Conn(ctx, r.db).Model(&users.Address{}).Where(
+		"user_id = ? AND is_default", userID,
+	).Update("is_default", false).Error
 }
 
 func (r *postgresAddressRepository) Delete(
 	ctx context.Context,
 	id uuid.UUID,
 ) error {
-	return r.db.WithContext(ctx).Delete(&users.Address{}, id).Error
+	return database.Conn(ctx, r.db).Delete(&users.Address{}, id).Error
 }
@@ -0,0 +1,115 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type CreateAddressUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		req *users.AddressRequest,
+	) (*users.Address, error)
+}
+
+type createAddressUseCase struct {
+	transactor  database.Transactor
+	userRepo    repository.UserRepository
+	addressRepo repository.AddressRepository
+}
+
+func NewCreateAddressUseCase(
+	transactor database.Transactor,
+	userRepo repository.UserRepository,
+	addressRepo repository.AddressRepository,
+) CreateAddressUseCase {
+	return &createAddressUseCase{
+		transactor:  transactor,
+		userRepo:    userRepo,
+		addressRepo: addressRepo,
+	}
+}
+
+func (uc *createAddressUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	req *users.AddressRequest,
+) (*users.Address, error) {
+	text, postalCode, err := validateAddressRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	address := &users.Address{
+		UserID:     userID,
+		Address:    text,
+		PostalCode: postalCode,
+		IsDefault:  req.IsDefault,
+	}
+
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Locking the user serializes concurrent creates, so that they
+		// cannot all pass the limit check below
+		user, err := uc.userRepo.GetByIDForUpdate(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if user == nil {
+			return users.ErrUserNotFound
+		}
+
+		count, err := uc.addressRepo.CountByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to count addresses: %w", err)
+		}
+		if count >= users.MaxAddressesPerUser {
+			return users.ErrAddressLimitReached
+		}
+
+		// The first address of a user is their default.
+		if count == 0 {
+			address.IsDefault = true
+		}
+		if address.IsDefault {
+			if err := uc.addressRepo.ClearDefault(ctx, userID); err != nil {
+				return fmt.Errorf(
+					"failed to clear default address: %w", err,
+				)
+			}
+		}
+
+		if err := uc.addressRepo.Create(ctx, address); err != nil {
+			return fmt.Errorf("failed to create address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+func validateAddressRequest(
+	req *users.AddressRequest,
+) (string, string, error) {
+	text := strings.TrimSpace(req.Address)
+	if text == "" {
+		return "", "", users.ErrAddressRequired
+	}
+
+	postalCode, err := users.NormalizePostalCode(req.PostalCode)
+	if err != nil {
+		return "", "", err
+	}
+
+	return text, postalCode, nil
+}
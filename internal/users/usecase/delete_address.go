@@ -0,0 +1,70 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type DeleteAddressUseCase interface {
+	Execute(ctx context.Context, userID uuid.UUID, addressID uuid.UUID) error
+}
+
+type deleteAddressUseCase struct {
+	transactor  database.Transactor
+	addressRepo repository.AddressRepository
+}
+
+func NewDeleteAddressUseCase(
+	transactor database.Transactor,
+	addressRepo repository.AddressRepository,
+) DeleteAddressUseCase {
+	return &deleteAddressUseCase{
+		transactor:  transactor,
+		addressRepo: addressRepo,
+	}
+}
+
+func (uc *deleteAddressUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	addressID uuid.UUID,
+) error {
+	return uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		address, err := uc.addressRepo.GetByID(ctx, addressID)
+		if err != nil {
+			return fmt.Errorf("failed to get address: %w", err)
+		}
+		if address == nil || address.UserID != userID {
+			return users.ErrAddressNotFound
+		}
+
+		if err := uc.addressRepo.Delete(ctx, address.ID); err != nil {
+			return fmt.Errorf("failed to delete address: %w", err)
+		}
+
+		if !address.IsDefault {
+			return nil
+		}
+
+		// Hand the default over to the oldest remaining address.
+		remaining, err := uc.addressRepo.GetByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get addresses: %w", err)
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		remaining[0].IsDefault = true
+		if err := uc.addressRepo.Update(ctx, &remaining[0]); err != nil {
+			return fmt.Errorf("failed to update default address: %w", err)
+		}
+		return nil
+	})
+}
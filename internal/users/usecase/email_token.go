@@ -0,0 +1,76 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"dunhayat-api/internal/users"
+
+	"github.com/google/uuid"
+)
+
+// emailToken is the payload of an email verification link. It is bound to
+// the address it was sent to, so changing the email on the profile voids
+// links sent earlier.
+type emailToken struct {
+	UserID    uuid.UUID `json:"uid"`
+	Email     string    `json:"email"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+type emailTokenSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newEmailTokenSigner(secret []byte, ttl time.Duration) *emailTokenSigner {
+	return &emailTokenSigner{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+func (s *emailTokenSigner) sign(userID uuid.UUID, email string) string {
+	payload, _ := json.Marshal(emailToken{
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(s.ttl).Unix(),
+	})
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signature(encoded)
+}
+
+func (s *emailTokenSigner) verify(token string) (*emailToken, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal(
+		[]byte(signature), []byte(s.signature(encoded)),
+	) {
+		return nil, users.ErrInvalidVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, users.ErrInvalidVerificationToken
+	}
+
+	var t emailToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, users.ErrInvalidVerificationToken
+	}
+	if time.Now().Unix() > t.ExpiresAt {
+		return nil, users.ErrInvalidVerificationToken
+	}
+
+	return &t, nil
+}
+
+func (s *emailTokenSigner) signature(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
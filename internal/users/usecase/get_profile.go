@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+
+	"github.com/google/uuid"
+)
+
+type GetProfileUseCase interface {
+	Execute(ctx context.Context, userID uuid.UUID) (*users.Profile, error)
+}
+
+type getProfileUseCase struct {
+	userRepo repository.UserRepository
+}
+
+func NewGetProfileUseCase(
+	userRepo repository.UserRepository,
+) GetProfileUseCase {
+	return &getProfileUseCase{
+		userRepo: userRepo,
+	}
+}
+
+func (uc *getProfileUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+) (*users.Profile, error) {
+	user, err := uc.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, users.ErrUserNotFound
+	}
+
+	return user.Profile(), nil
+}
@@ -0,0 +1,38 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+
+	"github.com/google/uuid"
+)
+
+type ListAddressesUseCase interface {
+	Execute(ctx context.Context, userID uuid.UUID) ([]users.Address, error)
+}
+
+type listAddressesUseCase struct {
+	addressRepo repository.AddressRepository
+}
+
+func NewListAddressesUseCase(
+	addressRepo repository.AddressRepository,
+) ListAddressesUseCase {
+	return &listAddressesUseCase{
+		addressRepo: addressRepo,
+	}
+}
+
+func (uc *listAddressesUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+) ([]users.Address, error) {
+	addresses, err := uc.addressRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+	return addresses, nil
+}
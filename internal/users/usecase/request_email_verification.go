@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/mail"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const emailVerificationBody = `Hello,
+
+Please confirm your email address for your Dunhayat account by opening the
+link below:
+
+%s
+
+The link expires on %s. If you did not ask for this, you can ignore this
+email.
+`
+
+type RequestEmailVerificationUseCase interface {
+	Execute(ctx context.Context, userID uuid.UUID) error
+}
+
+type requestEmailVerificationUseCase struct {
+	userRepo     repository.UserRepository
+	mailProvider mail.Provider
+	signer       *emailTokenSigner
+	verifyURL    string
+	logger       logger.Interface
+}
+
+// NewRequestEmailVerificationUseCase sends links pointing at verifyURL with
+// the signed token in its "token" query parameter.
+func NewRequestEmailVerificationUseCase(
+	userRepo repository.UserRepository,
+	mailProvider mail.Provider,
+	tokenSecret []byte,
+	tokenTTL time.Duration,
+	verifyURL string,
+	logger logger.Interface,
+) RequestEmailVerificationUseCase {
+	return &requestEmailVerificationUseCase{
+		userRepo:     userRepo,
+		mailProvider: mailProvider,
+		signer:       newEmailTokenSigner(tokenSecret, tokenTTL),
+		verifyURL:    verifyURL,
+		logger:       logger,
+	}
+}
+
+func (uc *requestEmailVerificationUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+) error {
+	user, err := uc.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return users.ErrUserNotFound
+	}
+	if user.Email == nil {
+		return users.ErrEmailNotSet
+	}
+	if user.Verified.Has(users.VerificationEmail) {
+		return users.ErrEmailAlreadyVerified
+	}
+
+	token := uc.signer.sign(user.ID, *user.Email)
+	link := uc.verifyURL + "?token=" + url.QueryEscape(token)
+	expiresAt := time.Now().Add(uc.signer.ttl)
+
+	if err := uc.mailProvider.Send(ctx, mail.Message{
+		To:      *user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			emailVerificationBody, link, expiresAt.Format(time.RFC1123),
+		),
+	}); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	uc.logger.Info("Email verification sent",
+		zap.String("user_id", user.ID.String()),
+	)
+
+	return nil
+}
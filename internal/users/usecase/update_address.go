@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+	"dunhayat-api/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+type UpdateAddressUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		addressID uuid.UUID,
+		req *users.AddressRequest,
+	) (*users.Address, error)
+}
+
+type updateAddressUseCase struct {
+	transactor  database.Transactor
+	addressRepo repository.AddressRepository
+}
+
+func NewUpdateAddressUseCase(
+	transactor database.Transactor,
+	addressRepo repository.AddressRepository,
+) UpdateAddressUseCase {
+	return &updateAddressUseCase{
+		transactor:  transactor,
+		addressRepo: addressRepo,
+	}
+}
+
+func (uc *updateAddressUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	addressID uuid.UUID,
+	req *users.AddressRequest,
+) (*users.Address, error) {
+	text, postalCode, err := validateAddressRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var address *users.Address
+	err = uc.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		address, err = uc.addressRepo.GetByID(ctx, addressID)
+		if err != nil {
+			return fmt.Errorf("failed to get address: %w", err)
+		}
+		if address == nil || address.UserID != userID {
+			return users.ErrAddressNotFound
+		}
+
+		address.Address = text
+		address.PostalCode = postalCode
+
+		// The default can only be moved to another address, never
+		// dropped, so that a user with addresses always has one.
+		if req.IsDefault && !address.IsDefault {
+			if err := uc.addressRepo.ClearDefault(ctx, userID); err != nil {
+				return fmt.Errorf(
+					"failed to clear default address: %w", err,
+				)
+			}
+			address.IsDefault = true
+		}
+
+		if err := uc.addressRepo.Update(ctx, address); err != nil {
+			return fmt.Errorf("failed to update address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
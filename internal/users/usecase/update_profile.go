@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxNameLength  = 100
+	maxEmailLength = 255
+)
+
+type UpdateProfileUseCase interface {
+	Execute(
+		ctx context.Context,
+		userID uuid.UUID,
+		req *users.UpdateProfileRequest,
+	) (*users.Profile, error)
+}
+
+type updateProfileUseCase struct {
+	userRepo repository.UserRepository
+}
+
+func NewUpdateProfileUseCase(
+	userRepo repository.UserRepository,
+) UpdateProfileUseCase {
+	return &updateProfileUseCase{
+		userRepo: userRepo,
+	}
+}
+
+func (uc *updateProfileUseCase) Execute(
+	ctx context.Context,
+	userID uuid.UUID,
+	req *users.UpdateProfileRequest,
+) (*users.Profile, error) {
+	user, err := uc.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, users.ErrUserNotFound
+	}
+
+	if req.FirstName != nil {
+		if user.FirstName, err = normalizeName(*req.FirstName); err != nil {
+			return nil, err
+		}
+	}
+	if req.LastName != nil {
+		if user.LastName, err = normalizeName(*req.LastName); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		// A new address has not been verified yet.
+		if !sameEmail(user.Email, email) {
+			user.Email = email
+			user.Verified = user.Verified.Without(users.VerificationEmail)
+		}
+	}
+
+	if err := uc.userRepo.Update(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return user.Profile(), nil
+}
+
+func normalizeName(name string) (*string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return nil, users.ErrInvalidName
+	}
+	return &name, nil
+}
+
+func normalizeEmail(email string) (*string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, nil
+	}
+	if len(email) > maxEmailLength {
+		return nil, users.ErrInvalidEmail
+	}
+
+	// Only a bare address is accepted, not "Name <address>".
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Address != email {
+		return nil, users.ErrInvalidEmail
+	}
+
+	email = strings.ToLower(email)
+	return &email, nil
+}
+
+func sameEmail(current, next *string) bool {
+	if current == nil || next == nil {
+		return current == next
+	}
+	return strings.EqualFold(*current, *next)
+}
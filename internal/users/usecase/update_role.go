@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+
+	"github.com/google/uuid"
+)
+
+type UpdateRoleUseCase interface {
+	Execute(
+		ctx context.Context,
+		adminID uuid.UUID,
+		userID uuid.UUID,
+		role users.Role,
+	) (*users.Profile, error)
+}
+
+type updateRoleUseCase struct {
+	userRepo repository.UserRepository
+}
+
+func NewUpdateRoleUseCase(
+	userRepo repository.UserRepository,
+) UpdateRoleUseCase {
+	return &updateRoleUseCase{
+		userRepo: userRepo,
+	}
+}
+
+func (uc *updateRoleUseCase) Execute(
+	ctx context.Context,
+	adminID uuid.UUID,
+	userID uuid.UUID,
+	role users.Role,
+) (*users.Profile, error) {
+	if !role.IsValid() {
+		return nil, users.ErrInvalidRole
+	}
+	// An admin demoting themselves could leave nobody able to undo it
+	if adminID == userID {
+		return nil, users.ErrCannotChangeOwnRole
+	}
+
+	user, err := uc.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, users.ErrUserNotFound
+	}
+
+	if user.Role != role {
+		user.Role = role
+		if err := uc.userRepo.Update(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+
+	return user.Profile(), nil
+}
@@ -0,0 +1,57 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dunhayat-api/internal/users"
+	"dunhayat-api/internal/users/repository"
+)
+
+type VerifyEmailUseCase interface {
+	Execute(ctx context.Context, token string) (*users.Profile, error)
+}
+
+type verifyEmailUseCase struct {
+	userRepo repository.UserRepository
+	signer   *emailTokenSigner
+}
+
+func NewVerifyEmailUseCase(
+	userRepo repository.UserRepository,
+	tokenSecret []byte,
+	tokenTTL time.Duration,
+) VerifyEmailUseCase {
+	return &verifyEmailUseCase{
+		userRepo: userRepo,
+		signer:   newEmailTokenSigner(tokenSecret, tokenTTL),
+	}
+}
+
+func (uc *verifyEmailUseCase) Execute(
+	ctx context.Context,
+	token string,
+) (*users.Profile, error) {
+	claims, err := uc.signer.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := uc.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || !sameEmail(user.Email, &claims.Email) {
+		return nil, users.ErrInvalidVerificationToken
+	}
+
+	if !user.Verified.Has(users.VerificationEmail) {
+		user.Verified = user.Verified.With(users.VerificationEmail)
+		if err := uc.userRepo.Update(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+
+	return user.Profile(), nil
+}
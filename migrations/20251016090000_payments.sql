@@ -0,0 +1,68 @@
+-- Payments and gateway callbacks
+-- Migration: 20251016090000_payments.sql
+
+-- Payments table (one row per payment attempt of a sale)
+CREATE TABLE payments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES sales(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    method VARCHAR(50) NOT NULL,
+    gateway_ref_id VARCHAR(255),
+    gateway_url TEXT,
+    callback_url TEXT NOT NULL,
+    return_url TEXT NOT NULL,
+    description TEXT,
+    metadata JSONB,
+    paid_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Payment callbacks table (raw gateway callbacks per payment)
+CREATE TABLE payment_callbacks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    payment_id UUID NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    gateway_data JSONB NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for performance
+CREATE INDEX idx_payments_order_id ON payments(order_id);
+CREATE INDEX idx_payments_user_id ON payments(user_id);
+CREATE INDEX idx_payments_status ON payments(status);
+CREATE UNIQUE INDEX idx_payments_gateway_ref_id
+    ON payments(method, gateway_ref_id)
+    WHERE gateway_ref_id IS NOT NULL;
+CREATE INDEX idx_payment_callbacks_payment_id ON payment_callbacks(payment_id);
+
+-- Triggers for updated_at
+CREATE TRIGGER update_payments_updated_at BEFORE UPDATE ON payments
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Backfill payments from gateway tracking codes stored on sales
+INSERT INTO payments (
+    order_id, user_id, amount, status, method, gateway_ref_id,
+    callback_url, return_url, created_at, updated_at
+)
+SELECT
+    id,
+    user_id,
+    total_price,
+    CASE
+        WHEN status IN ('paid', 'shipped', 'delivered') THEN 'paid'
+        WHEN status IN ('failed', 'cancelled') THEN status
+        ELSE 'pending'
+    END,
+    'zibal',
+    tracking_code,
+    '',
+    '',
+    created_at,
+    updated_at
+FROM sales
+WHERE tracking_code IS NOT NULL AND tracking_code <> '';
@@ -0,0 +1,14 @@
+-- Payment callback audit log
+-- Migration: 20251016093000_payment_callbacks_audit.sql
+
+-- Callbacks are stored even when their track ID matches no payment
+ALTER TABLE payment_callbacks ALTER COLUMN payment_id DROP NOT NULL;
+
+ALTER TABLE payment_callbacks
+    ADD COLUMN track_id VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN outcome VARCHAR(50) NOT NULL DEFAULT 'processed',
+    ADD COLUMN error TEXT;
+
+-- Indexes for performance
+CREATE INDEX idx_payment_callbacks_track_id ON payment_callbacks(track_id);
+CREATE INDEX idx_payment_callbacks_outcome ON payment_callbacks(outcome);
@@ -0,0 +1,19 @@
+-- Fraud alerts raised by server-side payment verification
+-- Migration: 20251016100000_payment_fraud_alerts.sql
+
+CREATE TABLE payment_fraud_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    payment_id UUID NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    order_id UUID NOT NULL REFERENCES sales(id) ON DELETE CASCADE,
+    track_id VARCHAR(255) NOT NULL,
+    expected_amount INTEGER NOT NULL,
+    verified_amount INTEGER NOT NULL,
+    expected_order_id VARCHAR(255) NOT NULL,
+    verified_order_id VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for performance
+CREATE INDEX idx_payment_fraud_alerts_payment_id ON payment_fraud_alerts(payment_id);
+CREATE INDEX idx_payment_fraud_alerts_order_id ON payment_fraud_alerts(order_id);
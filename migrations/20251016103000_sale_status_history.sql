@@ -0,0 +1,15 @@
+-- Sale status history for the order state machine
+-- Migration: 20251016103000_sale_status_history.sql
+
+CREATE TABLE sale_status_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    sale_id UUID NOT NULL REFERENCES sales(id) ON DELETE CASCADE,
+    from_status VARCHAR(50) NOT NULL,
+    to_status VARCHAR(50) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for performance
+CREATE INDEX idx_sale_status_history_sale_id ON sale_status_history(sale_id);
@@ -0,0 +1,23 @@
+-- Link cart reservations to the sale they hold stock for
+-- Migration: 20251016110000_cart_reservation_sale.sql
+
+ALTER TABLE cart_reservations
+    ADD COLUMN sale_id UUID REFERENCES sales(id) ON DELETE CASCADE;
+
+-- Attach existing reservations to the most recent matching sale of the
+-- same user created before the reservation
+UPDATE cart_reservations cr
+SET sale_id = (
+    SELECT s.id
+    FROM sales s
+    JOIN sale_items si ON si.sale_id = s.id
+    WHERE s.user_id = cr.user_id
+      AND si.product_id = cr.product_id
+      AND si.quantity = cr.quantity
+      AND s.created_at <= cr.created_at
+    ORDER BY s.created_at DESC
+    LIMIT 1
+);
+
+-- Indexes for performance
+CREATE INDEX idx_cart_reservations_sale_id ON cart_reservations(sale_id);
@@ -0,0 +1,4 @@
+-- Index backing the paginated order history of a user
+-- Migration: 20251016113000_sales_user_created_at_index.sql
+
+CREATE INDEX idx_sales_user_id_created_at ON sales(user_id, created_at, id);
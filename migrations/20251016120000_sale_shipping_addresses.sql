@@ -0,0 +1,35 @@
+-- Shipping destination snapshot of a sale
+-- Migration: 20251016120000_sale_shipping_addresses.sql
+
+CREATE TABLE sale_shipping_addresses (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    sale_id UUID NOT NULL UNIQUE REFERENCES sales(id) ON DELETE CASCADE,
+    address_id UUID REFERENCES addresses(id) ON DELETE SET NULL,
+    recipient_name VARCHAR(200) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    address TEXT NOT NULL,
+    postal_code VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Recover the destination of existing sales from the metadata of their
+-- first payment attempt, the only place it was kept
+INSERT INTO sale_shipping_addresses (
+    sale_id, recipient_name, phone, address, postal_code, created_at
+)
+SELECT DISTINCT ON (s.id)
+    s.id,
+    TRIM(CONCAT_WS(' ', u.first_name, u.last_name)),
+    u.phone,
+    p.metadata->>'address',
+    p.metadata->>'postal_code',
+    s.created_at
+FROM sales s
+JOIN users u ON u.id = s.user_id
+JOIN payments p ON p.order_id = s.id
+WHERE p.metadata->>'address' IS NOT NULL
+  AND p.metadata->>'postal_code' IS NOT NULL
+ORDER BY s.id, p.created_at ASC;
+
+-- Indexes for performance
+CREATE INDEX idx_sale_shipping_addresses_address_id ON sale_shipping_addresses(address_id);
@@ -0,0 +1,20 @@
+-- Default address flag for the saved address book
+-- Migration: 20251016123000_address_default.sql
+
+ALTER TABLE addresses ADD COLUMN is_default BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Make the oldest address of every user their default
+UPDATE addresses a
+SET is_default = TRUE
+WHERE a.id = (
+    SELECT id
+    FROM addresses
+    WHERE user_id = a.user_id
+    ORDER BY created_at ASC, id ASC
+    LIMIT 1
+);
+
+-- At most one default address per user
+CREATE UNIQUE INDEX idx_addresses_user_id_default
+    ON addresses(user_id)
+    WHERE is_default;
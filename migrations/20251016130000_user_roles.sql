@@ -0,0 +1,6 @@
+-- User roles for back-office access
+-- Migration: 20251016130000_user_roles.sql
+
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'customer'
+    CHECK (role IN ('customer', 'staff', 'admin'));
@@ -0,0 +1,18 @@
+-- Product archiving and protection of order history
+-- Migration: 20251016133000_product_archive.sql
+
+-- Archived products are hidden from the catalogue instead of deleted
+ALTER TABLE products ADD COLUMN archived_at TIMESTAMP;
+
+CREATE INDEX idx_products_archived_at ON products(archived_at);
+
+-- Deleting a product must never take its sales or reservations with it
+ALTER TABLE sale_items
+    DROP CONSTRAINT sale_items_product_id_fkey,
+    ADD CONSTRAINT sale_items_product_id_fkey
+        FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE RESTRICT;
+
+ALTER TABLE cart_reservations
+    DROP CONSTRAINT cart_reservations_product_id_fkey,
+    ADD CONSTRAINT cart_reservations_product_id_fkey
+        FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE RESTRICT;
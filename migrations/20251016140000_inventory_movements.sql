@@ -0,0 +1,31 @@
+-- Inventory ledger recording every stock movement
+-- Migration: 20251016140000_inventory_movements.sql
+
+CREATE TABLE inventory_movements (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id VARCHAR(100) NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity <> 0),
+    reason VARCHAR(50) NOT NULL CHECK (reason IN (
+        'opening',
+        'order_reservation',
+        'reservation_expiry',
+        'order_cancellation',
+        'restock',
+        'correction'
+    )),
+    sale_id UUID REFERENCES sales(id) ON DELETE SET NULL,
+    actor VARCHAR(100) NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_inventory_movements_product_id_created_at
+    ON inventory_movements(product_id, created_at DESC, id DESC);
+CREATE INDEX idx_inventory_movements_sale_id ON inventory_movements(sale_id);
+
+-- Open the ledger with the stock every product holds today, so that the
+-- sum of a product's movements equals its in_stock from here on
+INSERT INTO inventory_movements (product_id, quantity, reason, actor, note)
+SELECT id, in_stock, 'opening', 'system', 'ledger opened'
+FROM products
+WHERE in_stock <> 0;
@@ -0,0 +1,40 @@
+-- Persian-aware product search with trigram indexes
+-- Migration: 20251016143000_product_search.sql
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- normalize_search folds the spelling variants of Persian text typed on
+-- Arabic keyboards into one form: Arabic yeh and kaf become Persian, teh
+-- marbuta and heh with yeh become heh, hamza-carrying alefs become alef,
+-- Persian and Arabic-Indic digits become ASCII, the zero-width non-joiner
+-- becomes a space and diacritics are dropped. It must stay in step with
+-- products.NormalizeSearch.
+CREATE OR REPLACE FUNCTION normalize_search(value TEXT)
+RETURNS TEXT AS $$
+    SELECT translate(
+        lower(value),
+        'يىكةۀأإ' || chr(8204) ||
+            '۰۱۲۳۴۵۶۷۸۹٠١٢٣٤٥٦٧٨٩' ||
+            chr(1611) || chr(1612) || chr(1613) || chr(1614) ||
+            chr(1615) || chr(1616) || chr(1617) || chr(1618) ||
+            chr(1648),
+        'ییکههاا ' ||
+            '01234567890123456789'
+    )
+$$ LANGUAGE SQL IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE products ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+    normalize_search(
+        coalesce(name, '') || ' ' ||
+        coalesce(name_en, '') || ' ' ||
+        coalesce(description, '')
+    )
+) STORED;
+
+CREATE INDEX idx_products_search_text
+    ON products USING GIN (search_text gin_trgm_ops);
+
+-- Keyset pagination over every sort order of the catalogue
+CREATE INDEX idx_products_created_at ON products(created_at, id);
+CREATE INDEX idx_products_price ON products(price, id);
+CREATE INDEX idx_products_name ON products(name, id);
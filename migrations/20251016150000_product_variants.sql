@@ -0,0 +1,122 @@
+-- Product variants by bag size and grind
+-- Migration: 20251016150000_product_variants.sql
+
+CREATE TABLE product_variants (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id VARCHAR(100) NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    sku VARCHAR(100) UNIQUE NOT NULL,
+    -- Bag size in grams
+    weight INTEGER NOT NULL CHECK (weight > 0),
+    grind VARCHAR(30) NOT NULL DEFAULT 'whole_bean' CHECK (grind IN (
+        'whole_bean',
+        'espresso',
+        'moka_pot',
+        'filter',
+        'french_press',
+        'turkish'
+    )),
+    price INTEGER NOT NULL CHECK (price > 0),
+    in_stock INTEGER NOT NULL DEFAULT 0 CHECK (in_stock >= 0),
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    archived_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (product_id, weight, grind)
+);
+
+CREATE INDEX idx_product_variants_product_id ON product_variants(product_id);
+
+-- Exactly one default variant per product, used when an order names only
+-- the product
+CREATE UNIQUE INDEX idx_product_variants_product_id_default
+    ON product_variants(product_id)
+    WHERE is_default;
+
+CREATE TRIGGER update_product_variants_updated_at
+    BEFORE UPDATE ON product_variants
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Every existing product becomes a single whole-bean variant holding its
+-- price and stock. Product weights were entered in grams or in kilograms:
+-- no bag weighs under 10 g, so smaller weights are taken as kilograms.
+-- Products without a usable weight are taken to be 250 g bags.
+INSERT INTO product_variants (
+    product_id, sku, weight, grind, price, in_stock, is_default
+)
+SELECT
+    id,
+    id,
+    COALESCE(NULLIF(GREATEST(CASE
+        WHEN weight < 10 THEN ROUND(weight * 1000)
+        ELSE ROUND(weight)
+    END, 0), 0)::INTEGER, 250),
+    'whole_bean',
+    price,
+    COALESCE(in_stock, 0),
+    TRUE
+FROM products;
+
+-- products.in_stock and products.price become summaries of the variants:
+-- the stock of all active variants together and the lowest active price
+CREATE OR REPLACE FUNCTION sync_product_from_variants()
+RETURNS TRIGGER AS $$
+DECLARE
+    target VARCHAR(100);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target := OLD.product_id;
+    ELSE
+        target := NEW.product_id;
+    END IF;
+
+    UPDATE products p
+    SET
+        in_stock = COALESCE((
+            SELECT SUM(v.in_stock)
+            FROM product_variants v
+            WHERE v.product_id = target AND v.archived_at IS NULL
+        ), 0),
+        price = COALESCE((
+            SELECT MIN(v.price)
+            FROM product_variants v
+            WHERE v.product_id = target AND v.archived_at IS NULL
+        ), p.price)
+    WHERE p.id = target;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sync_product_from_variants
+    AFTER INSERT OR UPDATE OR DELETE ON product_variants
+    FOR EACH ROW EXECUTE FUNCTION sync_product_from_variants();
+
+-- Sales, reservations and the ledger refer to the variant that moved
+ALTER TABLE sale_items
+    ADD COLUMN variant_id UUID REFERENCES product_variants(id) ON DELETE RESTRICT;
+ALTER TABLE cart_reservations
+    ADD COLUMN variant_id UUID REFERENCES product_variants(id) ON DELETE RESTRICT;
+ALTER TABLE inventory_movements
+    ADD COLUMN variant_id UUID REFERENCES product_variants(id) ON DELETE RESTRICT;
+
+UPDATE sale_items s
+SET variant_id = v.id
+FROM product_variants v
+WHERE v.product_id = s.product_id AND v.is_default;
+
+UPDATE cart_reservations r
+SET variant_id = v.id
+FROM product_variants v
+WHERE v.product_id = r.product_id AND v.is_default;
+
+UPDATE inventory_movements m
+SET variant_id = v.id
+FROM product_variants v
+WHERE v.product_id = m.product_id AND v.is_default;
+
+ALTER TABLE sale_items ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE cart_reservations ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE inventory_movements ALTER COLUMN variant_id SET NOT NULL;
+
+CREATE INDEX idx_inventory_movements_variant_id
+    ON inventory_movements(variant_id);
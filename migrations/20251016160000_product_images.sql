@@ -0,0 +1,59 @@
+-- Product image gallery
+-- Migration: 20251016160000_product_images.sql
+
+CREATE TABLE product_images (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id VARCHAR(100) NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position >= 0),
+    url TEXT NOT NULL,
+    thumbnail_url TEXT,
+    -- Storage keys of uploaded files; NULL for images hosted elsewhere
+    storage_key TEXT,
+    thumbnail_key TEXT,
+    content_type VARCHAR(50),
+    width INTEGER,
+    height INTEGER,
+    size BIGINT,
+    alt_text VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_product_images_product_position
+    ON product_images(product_id, position);
+
+-- Hand-hosted images become the first image of their product's gallery
+INSERT INTO product_images (product_id, position, url)
+SELECT id, 0, image_url
+FROM products
+WHERE image_url IS NOT NULL AND image_url <> '';
+
+-- products.image_url becomes the cover of the gallery: the URL of its
+-- first image
+CREATE OR REPLACE FUNCTION sync_product_cover_image()
+RETURNS TRIGGER AS $$
+DECLARE
+    target VARCHAR(100);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target := OLD.product_id;
+    ELSE
+        target := NEW.product_id;
+    END IF;
+
+    UPDATE products p
+    SET image_url = (
+        SELECT i.url
+        FROM product_images i
+        WHERE i.product_id = target
+        ORDER BY i.position, i.created_at
+        LIMIT 1
+    )
+    WHERE p.id = target;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sync_product_cover_image
+    AFTER INSERT OR UPDATE OR DELETE ON product_images
+    FOR EACH ROW EXECUTE FUNCTION sync_product_cover_image();
@@ -0,0 +1,50 @@
+-- Payment reconciliation with the gateways
+-- Migration: 20251016170000_payment_reconciliation.sql
+
+ALTER TABLE payments ADD COLUMN reconciled_at TIMESTAMP;
+
+-- Reconciliation only scans payments it has not settled yet
+CREATE INDEX idx_payments_unreconciled ON payments(created_at)
+    WHERE reconciled_at IS NULL;
+
+-- Reports of reconciliation runs
+CREATE TABLE payment_reconciliations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    checked INTEGER NOT NULL DEFAULT 0,
+    settled INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    released INTEGER NOT NULL DEFAULT 0,
+    flagged INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Payments whose state differed from the gateway's, per run
+CREATE TABLE payment_reconciliation_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reconciliation_id UUID NOT NULL
+        REFERENCES payment_reconciliations(id) ON DELETE CASCADE,
+    payment_id UUID NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    order_id UUID NOT NULL REFERENCES sales(id) ON DELETE CASCADE,
+    method VARCHAR(50) NOT NULL,
+    gateway_ref_id VARCHAR(255),
+    local_status VARCHAR(50) NOT NULL,
+    gateway_status VARCHAR(50) NOT NULL,
+    amount INTEGER NOT NULL,
+    gateway_amount INTEGER,
+    action VARCHAR(50) NOT NULL CHECK (
+        action IN ('settled', 'failed', 'released', 'flagged', 'error')
+    ),
+    detail TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for performance
+CREATE INDEX idx_payment_reconciliations_started_at
+    ON payment_reconciliations(started_at DESC);
+CREATE INDEX idx_payment_reconciliation_items_reconciliation_id
+    ON payment_reconciliation_items(reconciliation_id);
+CREATE INDEX idx_payment_reconciliation_items_payment_id
+    ON payment_reconciliation_items(payment_id);
@@ -0,0 +1,68 @@
+-- Full and partial refunds of payments
+-- Migration: 20251016180000_payment_refunds.sql
+
+-- Refunds table (one row per refund of a payment)
+CREATE TABLE payment_refunds (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    payment_id UUID NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    order_id UUID NOT NULL REFERENCES sales(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    channel VARCHAR(50) NOT NULL CHECK (
+        channel IN ('gateway', 'manual_bank_transfer')
+    ),
+    status VARCHAR(50) NOT NULL CHECK (status IN ('pending', 'completed')),
+    reference VARCHAR(255),
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for performance
+CREATE INDEX idx_payment_refunds_payment_id ON payment_refunds(payment_id);
+CREATE INDEX idx_payment_refunds_order_id ON payment_refunds(order_id);
+
+-- Triggers for updated_at
+CREATE TRIGGER update_payment_refunds_updated_at BEFORE UPDATE ON payment_refunds
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Payments awaiting a refund by hand become refunds awaiting a bank
+-- transfer
+INSERT INTO payment_refunds (
+    payment_id, order_id, amount, reason, actor, channel, status,
+    created_at, updated_at
+)
+SELECT
+    id,
+    order_id,
+    amount,
+    'order cancelled by customer',
+    'system',
+    'manual_bank_transfer',
+    'pending',
+    updated_at,
+    updated_at
+FROM payments
+WHERE status = 'refund_pending';
+
+UPDATE payments SET status = 'refunded' WHERE status = 'refund_pending';
+
+-- How much of each sale item refunds put back into stock
+ALTER TABLE sale_items
+    ADD COLUMN restocked_quantity INTEGER NOT NULL DEFAULT 0
+    CHECK (restocked_quantity >= 0 AND restocked_quantity <= quantity);
+
+-- Refunds put stock back into the ledger under their own reason
+ALTER TABLE inventory_movements
+    DROP CONSTRAINT inventory_movements_reason_check;
+ALTER TABLE inventory_movements
+    ADD CONSTRAINT inventory_movements_reason_check CHECK (reason IN (
+        'opening',
+        'order_reservation',
+        'reservation_expiry',
+        'order_cancellation',
+        'order_refund',
+        'restock',
+        'correction'
+    ));
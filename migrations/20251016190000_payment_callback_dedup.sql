@@ -0,0 +1,36 @@
+-- Deduplication of replayed payment callbacks
+-- Migration: 20251016190000_payment_callback_dedup.sql
+
+-- The gateway and the status each callback reported
+ALTER TABLE payment_callbacks
+    ADD COLUMN method VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN gateway_status VARCHAR(50) NOT NULL DEFAULT '';
+
+UPDATE payment_callbacks c
+SET method = p.method
+FROM payments p
+WHERE p.id = c.payment_id;
+
+UPDATE payment_callbacks SET method = 'zibal' WHERE method = '';
+
+UPDATE payment_callbacks
+SET gateway_status = COALESCE(
+    gateway_data->>'status', gateway_data->>'Status', ''
+);
+
+-- Only the first of the callbacks already processed for the same track ID
+-- and status counts; the rest were replays
+UPDATE payment_callbacks c
+SET outcome = 'duplicate'
+FROM payment_callbacks o
+WHERE o.method = c.method
+    AND o.track_id = c.track_id
+    AND o.gateway_status = c.gateway_status
+    AND o.outcome IN ('processed', 'fraud_alert')
+    AND c.outcome IN ('processed', 'fraud_alert')
+    AND (o.created_at, o.id) < (c.created_at, c.id);
+
+-- A callback is processed at most once per track ID and status
+CREATE UNIQUE INDEX idx_payment_callbacks_processed
+    ON payment_callbacks(method, track_id, gateway_status)
+    WHERE outcome IN ('processed', 'fraud_alert');
@@ -0,0 +1,11 @@
+-- Refunds the gateway refused
+-- Migration: 20251016200000_payment_refund_failures.sql
+
+-- A gateway refund is stored as pending before the gateway is called, and
+-- fails if the gateway refuses it
+ALTER TABLE payment_refunds
+    DROP CONSTRAINT payment_refunds_status_check;
+ALTER TABLE payment_refunds
+    ADD CONSTRAINT payment_refunds_status_check CHECK (
+        status IN ('pending', 'completed', 'failed')
+    );
@@ -2,22 +2,29 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Env      string         `mapstructure:"env"`
-	App      AppConfig      `mapstructure:"app"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	Server   ServerConfig   `mapstructure:"server"`
-	Auth     AuthConfig     `mapstructure:"auth"`
-	Log      LogConfig      `mapstructure:"log"`
-	CORS     CORSConfig     `mapstructure:"cors"`
-	Payment  PaymentConfig  `mapstructure:"payment"`
+	Env       string          `mapstructure:"env"`
+	App       AppConfig       `mapstructure:"app"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Redis     RedisConfig     `mapstructure:"redis"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	Log       LogConfig       `mapstructure:"log"`
+	CORS      CORSConfig      `mapstructure:"cors"`
+	Payment   PaymentConfig   `mapstructure:"payment"`
+	Orders    OrdersConfig    `mapstructure:"orders"`
+	Products  ProductsConfig  `mapstructure:"products"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Scheduler SchedulerConfig `mapstructure:"scheduler"`
+	Mail      MailConfig      `mapstructure:"mail"`
 }
 
 type DatabaseConfig struct {
@@ -31,6 +38,9 @@ type DatabaseConfig struct {
 
 type AppConfig struct {
 	Domain string `mapstructure:"domain"`
+	// ReturnHosts are the hosts, besides the one of Domain, that customers
+	// may be sent back to once paying is over, such as the storefront's
+	ReturnHosts []string `mapstructure:"return_hosts"`
 }
 
 type RedisConfig struct {
@@ -48,6 +58,25 @@ type ServerConfig struct {
 type AuthConfig struct {
 	KavenegarAPIKey string `mapstructure:"kavenegar_api_key"`
 	OTPTemplate     string `mapstructure:"otp_template"`
+	// EmailTokenSecret signs email verification links
+	EmailTokenSecret string `mapstructure:"email_token_secret"`
+	// EmailTokenTTL is how long, in seconds, a verification link is valid
+	EmailTokenTTL int `mapstructure:"email_token_ttl"`
+}
+
+type MailConfig struct {
+	// Provider is one of smtp, file or log
+	Provider string     `mapstructure:"provider"`
+	From     string     `mapstructure:"from"`
+	SMTP     SMTPConfig `mapstructure:"smtp"`
+	FileDir  string     `mapstructure:"file_dir"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
 }
 
 type LogConfig struct {
@@ -62,16 +91,80 @@ type CORSConfig struct {
 }
 
 type PaymentConfig struct {
-	Zibal ZibalConfig `mapstructure:"zibal"`
+	// DefaultMethod is the gateway used when an order does not name one
+	DefaultMethod string `mapstructure:"default_method"`
+	// Failover tries the other enabled gateways when the default one
+	// cannot create a payment
+	Failover       bool                 `mapstructure:"failover"`
+	Zibal          ZibalConfig          `mapstructure:"zibal"`
+	ZarinPal       ZarinPalConfig       `mapstructure:"zarinpal"`
+	Reconciliation ReconciliationConfig `mapstructure:"reconciliation"`
 }
 
 type ZibalConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
 	MerchantID string `mapstructure:"merchant_id"`
 	BaseURL    string `mapstructure:"base_url"`
+	RefundURL  string `mapstructure:"refund_url"`
 	Timeout    int    `mapstructure:"timeout"`
 	APIToken   string `mapstructure:"api_token"`
 }
 
+type ZarinPalConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	MerchantID string `mapstructure:"merchant_id"`
+	BaseURL    string `mapstructure:"base_url"`
+	Timeout    int    `mapstructure:"timeout"`
+}
+
+type ReconciliationConfig struct {
+	// MinAge is how old, in seconds, a payment must be before it is
+	// checked with its gateway, leaving newer ones to their callback
+	MinAge int `mapstructure:"min_age"`
+	// Lookback is how far back, in seconds, cancelled and failed payments
+	// are checked for money the gateway took anyway
+	Lookback int `mapstructure:"lookback"`
+	// BatchSize is the most payments checked per run
+	BatchSize int `mapstructure:"batch_size"`
+}
+
+type OrdersConfig struct {
+	// ReservationTTL is how long, in seconds, stock stays reserved for an
+	// order that has not been paid. While a payment of the order is still
+	// open at its gateway, the stock stays reserved until it expires.
+	ReservationTTL int `mapstructure:"reservation_ttl"`
+}
+
+type ProductsConfig struct {
+	// MaxImageSize is the largest image upload accepted, in bytes
+	MaxImageSize int64 `mapstructure:"max_image_size"`
+	// ThumbnailSize is the longest side of image thumbnails, in pixels
+	ThumbnailSize int `mapstructure:"thumbnail_size"`
+	// MaxImages is the most images a product gallery can hold
+	MaxImages int `mapstructure:"max_images"`
+}
+
+type StorageConfig struct {
+	// Driver is local, the only driver so far
+	Driver   string `mapstructure:"driver"`
+	LocalDir string `mapstructure:"local_dir"`
+	// BaseURL is the public URL of stored files; it defaults to /media
+	// under app.domain, where the local driver's files are served
+	BaseURL string `mapstructure:"base_url"`
+}
+
+type SchedulerConfig struct {
+	// ReservationSweepInterval is how often, in seconds, expired
+	// reservations are released
+	ReservationSweepInterval int `mapstructure:"reservation_sweep_interval"`
+	// InventoryCheckInterval is how often, in seconds, product stock is
+	// compared with the inventory ledger
+	InventoryCheckInterval int `mapstructure:"inventory_check_interval"`
+	// PaymentReconciliationInterval is how often, in seconds, payments are
+	// reconciled with their gateways
+	PaymentReconciliationInterval int `mapstructure:"payment_reconciliation_interval"`
+}
+
 func Load(configFile string) (*Config, error) {
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
 		return nil, err
@@ -118,8 +211,19 @@ func setDefaults() {
 
 	viper.SetDefault("auth.kavenegar_api_key", "")
 	viper.SetDefault("auth.otp_template", "dunhayat-otp")
+	viper.SetDefault("auth.email_token_secret", "")
+	viper.SetDefault("auth.email_token_ttl", 86400)
+
+	viper.SetDefault("mail.provider", "log")
+	viper.SetDefault("mail.from", "Dunhayat <no-reply@dunhayat.com>")
+	viper.SetDefault("mail.smtp.host", "localhost")
+	viper.SetDefault("mail.smtp.port", 587)
+	viper.SetDefault("mail.smtp.username", "")
+	viper.SetDefault("mail.smtp.password", "")
+	viper.SetDefault("mail.file_dir", "mail")
 
 	viper.SetDefault("app.domain", "http://localhost:8080")
+	viper.SetDefault("app.return_hosts", []string{})
 	viper.SetDefault("env", "development")
 	viper.SetDefault("log.level", "info")
 
@@ -129,7 +233,7 @@ func setDefaults() {
 	)
 	viper.SetDefault(
 		"cors.allowed_methods",
-		[]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		[]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 	)
 	viper.SetDefault(
 		"cors.allowed_headers",
@@ -137,10 +241,56 @@ func setDefaults() {
 	)
 	viper.SetDefault("cors.allow_credentials", false)
 
+	viper.SetDefault("payment.default_method", "zibal")
+	viper.SetDefault("payment.failover", true)
+	viper.SetDefault("payment.zibal.enabled", true)
 	viper.SetDefault("payment.zibal.merchant_id", "")
 	viper.SetDefault("payment.zibal.base_url", "https://gateway.zibal.ir/v1")
+	viper.SetDefault("payment.zibal.refund_url", "https://api.zibal.ir/v1/refund")
 	viper.SetDefault("payment.zibal.timeout", 30)
 	viper.SetDefault("payment.zibal.api_token", "")
+	viper.SetDefault("payment.zarinpal.enabled", false)
+	viper.SetDefault("payment.zarinpal.merchant_id", "")
+	viper.SetDefault("payment.zarinpal.base_url", "https://payment.zarinpal.com/pg")
+	viper.SetDefault("payment.zarinpal.timeout", 30)
+	viper.SetDefault("payment.reconciliation.min_age", 900)
+	viper.SetDefault("payment.reconciliation.lookback", 86400)
+	viper.SetDefault("payment.reconciliation.batch_size", 100)
+
+	viper.SetDefault("orders.reservation_ttl", 600)
+	viper.SetDefault("products.max_image_size", 5<<20)
+	viper.SetDefault("products.thumbnail_size", 400)
+	viper.SetDefault("products.max_images", 10)
+
+	viper.SetDefault("storage.driver", "local")
+	viper.SetDefault("storage.local_dir", "media")
+	viper.SetDefault("storage.base_url", "")
+
+	viper.SetDefault("scheduler.reservation_sweep_interval", 60)
+	viper.SetDefault("scheduler.inventory_check_interval", 3600)
+	viper.SetDefault("scheduler.payment_reconciliation_interval", 300)
+}
+
+// AllowsReturnURL reports whether customers may be sent to raw once paying
+// is over: it must be an https URL on the host of Domain or on one of
+// ReturnHosts.
+func (c *AppConfig) AllowsReturnURL(raw string) bool {
+	target, err := url.Parse(raw)
+	if err != nil || target.Scheme != "https" || target.User != nil {
+		return false
+	}
+	host := strings.ToLower(target.Hostname())
+	if host == "" {
+		return false
+	}
+
+	if domain, err := url.Parse(c.Domain); err == nil &&
+		strings.EqualFold(domain.Hostname(), host) {
+		return true
+	}
+	return slices.ContainsFunc(c.ReturnHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
 }
 
 func (c *DatabaseConfig) GetDSN() string {
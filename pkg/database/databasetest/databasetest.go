@@ -0,0 +1,113 @@
+// Package databasetest gives tests a migrated Postgres database. Tests
+// using it are skipped unless TEST_DATABASE_URL holds the URL of a
+// database they may create schemas in, such as
+// postgres://postgres@localhost:5432/dunhayat_test?sslmode=disable.
+package databasetest
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// EnvURL names the environment variable holding the database URL.
+const EnvURL = "TEST_DATABASE_URL"
+
+// Open applies every migration to a schema of its own and returns a
+// connection pool using it. The schema is dropped when the test ends.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	rawURL := os.Getenv(EnvURL)
+	if rawURL == "" {
+		t.Skipf("%s is not set", EnvURL)
+	}
+
+	admin, err := gorm.Open(postgres.Open(rawURL), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	dsn, err := withSearchPath(rawURL, schema)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", EnvURL, err)
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to the test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("failed to migrate the test schema: %v", err)
+	}
+
+	return db
+}
+
+// withSearchPath puts schema first on the search path of every connection,
+// keeping public for extensions that already live there.
+func withSearchPath(rawURL string, schema string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("search_path", schema+",public")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// migrate runs the migration files in the order Atlas applies them.
+func migrate(db *gorm.DB) error {
+	files, err := filepath.Glob(filepath.Join(migrationsDir(), "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := db.Exec(string(content)).Error; err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "migrations")
+}
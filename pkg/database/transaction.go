@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+// Transactor runs a unit of work inside a single database transaction.
+// The transaction travels in the context handed to fn, so repositories of
+// any slice that resolve their connection through Conn take part in it
+// without knowing about each other.
+type Transactor interface {
+	// WithinTransaction commits when fn returns nil and rolls back
+	// otherwise. Nested calls join the outer transaction.
+	WithinTransaction(
+		ctx context.Context,
+		fn func(ctx context.Context) error,
+	) error
+}
+
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &gormTransactor{db: db}
+}
+
+func (t *gormTransactor) WithinTransaction(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// Conn returns the transaction carried by ctx, if any, or db otherwise,
+// bound to ctx in both cases.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
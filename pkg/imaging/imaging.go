@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+)
+
+// Formats maps the content types that can be decoded to the file extension
+// they are stored with.
+var Formats = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// MaxPixels bounds the size of a decoded image, so that a small file cannot
+// expand into gigabytes of memory.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooManyPixels     = errors.New("image dimensions are too large")
+)
+
+// Decode sniffs the content type of data and decodes it. The content type
+// comes from the data itself, never from what the client claims.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := Formats[contentType]; !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		config.Width*config.Height > MaxPixels {
+		return nil, "", fmt.Errorf(
+			"%w: %dx%d", ErrTooManyPixels, config.Width, config.Height,
+		)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return img, contentType, nil
+}
+
+// Thumbnail scales img down to fit within size by size pixels, keeping its
+// aspect ratio, by averaging the source pixels each target pixel covers.
+// Images that already fit are copied unscaled. Transparent areas become
+// white, as thumbnails are encoded as JPEG.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	scaled := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := range dstH {
+		y0 := dy * srcH / dstH
+		y1 := max(y0+1, (dy+1)*srcH/dstH)
+		for dx := range dstW {
+			x0 := dx * srcW / dstW
+			x1 := max(x0+1, (dx+1)*srcW/dstW)
+
+			var r, g, b, a, n int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			// Pixels are premultiplied, so plain averages stay correct
+			scaled.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n),
+				G: uint8(g / n),
+				B: uint8(b / n),
+				A: uint8(a / n),
+			})
+		}
+	}
+
+	thumb := image.NewRGBA(scaled.Bounds())
+	draw.Draw(thumb, thumb.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(thumb, thumb.Bounds(), scaled, image.Point{}, draw.Over)
+
+	return thumb
+}
+
+// EncodeJPEG writes img as a JPEG of the given quality.
+func EncodeJPEG(w io.Writer, img image.Image, quality int) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileProvider writes every message to its own .eml file instead of
+// sending it, for development and tests.
+type FileProvider struct {
+	dir  string
+	from string
+}
+
+func NewFileProvider(dir, from string) Provider {
+	return &FileProvider{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (p *FileProvider) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf(
+		"%s-%s.eml",
+		time.Now().Format("20060102T150405.000000000"),
+		strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To),
+	)
+
+	if err := os.WriteFile(
+		filepath.Join(p.dir, name), format(p.from, msg), 0o644,
+	); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+
+	return nil
+}
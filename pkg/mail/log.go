@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"context"
+
+	"dunhayat-api/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// LogProvider only logs messages; nothing is delivered.
+type LogProvider struct {
+	logger logger.Interface
+}
+
+func NewLogProvider(logger logger.Interface) Provider {
+	return &LogProvider{
+		logger: logger,
+	}
+}
+
+func (p *LogProvider) Send(ctx context.Context, msg Message) error {
+	p.logger.Info("Mail not delivered, logging instead",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+	)
+	return nil
+}
@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"time"
+
+	"dunhayat-api/pkg/logger"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Provider interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	// Provider is one of "smtp", "file" or "log"
+	Provider string
+	From     string
+	SMTP     SMTPConfig
+	// FileDir is where the file provider writes messages
+	FileDir string
+}
+
+// NewProvider returns the provider selected by cfg.Provider.
+func NewProvider(cfg Config, log logger.Interface) (Provider, error) {
+	switch cfg.Provider {
+	case "smtp":
+		return NewSMTPProvider(cfg.SMTP, cfg.From), nil
+	case "file":
+		return NewFileProvider(cfg.FileDir, cfg.From), nil
+	case "log", "":
+		return NewLogProvider(log), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider: %s", cfg.Provider)
+	}
+}
+
+// format renders msg as an RFC 5322 plain text message.
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return b.Bytes()
+}
@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+type SMTPProvider struct {
+	cfg  SMTPConfig
+	from string
+}
+
+func NewSMTPProvider(cfg SMTPConfig, from string) Provider {
+	return &SMTPProvider{
+		cfg:  cfg,
+		from: from,
+	}
+}
+
+func (p *SMTPProvider) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if p.cfg.Username != "" {
+		auth = smtp.PlainAuth("", p.cfg.Username, p.cfg.Password, p.cfg.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", p.cfg.Host, p.cfg.Port)
+	if err := smtp.SendMail(
+		addr, auth, p.from, []string{msg.To}, format(p.from, msg),
+	); err != nil {
+		return fmt.Errorf("failed to send mail via SMTP: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor packs the sort key values of the last item of a page into an
+// opaque token that clients hand back to fetch the next page.
+func EncodeCursor(values ...string) string {
+	raw, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor unpacks a token produced by EncodeCursor holding exactly n
+// values.
+func DecodeCursor(cursor string, n int) ([]string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var values []string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if len(values) != n {
+		return nil, ErrInvalidCursor
+	}
+
+	return values, nil
+}
+
+// Limit clamps a requested page size to [1, MaxLimit], falling back to
+// DefaultLimit when none was requested.
+func Limit(requested int) int {
+	switch {
+	case requested <= 0:
+		return DefaultLimit
+	case requested > MaxLimit:
+		return MaxLimit
+	default:
+		return requested
+	}
+}
@@ -0,0 +1,157 @@
+package fakegateway
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Fake Zibal gateway</title>
+<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 4rem auto; }
+dt { font-weight: bold; }
+button { font-size: 1rem; padding: 0.5rem 1.5rem; margin-right: 0.5rem; }
+</style>
+</head>
+<body>
+<h1>Fake Zibal gateway</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Payment}}
+<dl>
+<dt>Track ID</dt><dd>{{.TrackID}}</dd>
+<dt>Order</dt><dd>{{.Payment.OrderID}}</dd>
+<dt>Amount</dt><dd>{{.Payment.Amount}} Rials</dd>
+{{if .Payment.Description}}<dt>Description</dt><dd>{{.Payment.Description}}</dd>{{end}}
+<dt>Callback</dt><dd>{{.Payment.CallbackURL}}</dd>
+</dl>
+{{if .Pending}}
+<form method="post">
+<button name="decision" value="approve">Approve</button>
+<button name="decision" value="decline">Decline</button>
+</form>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type pageData struct {
+	TrackID int64
+	Payment *pagePayment
+	Pending bool
+	Message string
+}
+
+type pagePayment struct {
+	OrderID     string
+	Amount      int
+	Description string
+	CallbackURL string
+}
+
+// handleStart shows the payment page the customer is sent to.
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	trackID, err := strconv.ParseInt(r.PathValue("trackId"), 10, 64)
+	if err != nil {
+		s.renderPage(w, http.StatusBadRequest, pageData{
+			Message: "Invalid trackId.",
+		})
+		return
+	}
+
+	data, ok := s.pageData(trackID)
+	if !ok {
+		s.renderPage(w, http.StatusNotFound, pageData{
+			Message: "No payment has this trackId.",
+		})
+		return
+	}
+	if !data.Pending {
+		data.Message = "This payment was already completed."
+	}
+
+	s.renderPage(w, http.StatusOK, data)
+}
+
+// handleDecision completes the payment with the button pressed on the
+// payment page and sends the callback.
+func (s *Server) handleDecision(w http.ResponseWriter, r *http.Request) {
+	trackID, err := strconv.ParseInt(r.PathValue("trackId"), 10, 64)
+	if err != nil {
+		s.renderPage(w, http.StatusBadRequest, pageData{
+			Message: "Invalid trackId.",
+		})
+		return
+	}
+
+	var approved bool
+	switch r.FormValue("decision") {
+	case "approve":
+		approved = true
+	case "decline":
+	default:
+		s.renderPage(w, http.StatusBadRequest, pageData{
+			Message: "Choose approve or decline.",
+		})
+		return
+	}
+
+	callbackStatus, err := s.complete(r.Context(), trackID, approved)
+
+	data, _ := s.pageData(trackID)
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, ErrPaymentNotFound):
+		status = http.StatusNotFound
+		data.Message = "No payment has this trackId."
+	case errors.Is(err, ErrPaymentNotPending):
+		status = http.StatusConflict
+		data.Message = "This payment was already completed."
+	case err != nil:
+		status = http.StatusBadGateway
+		data.Message = "The payment was completed but the callback failed: " +
+			err.Error()
+	case callbackStatus == 0:
+		data.Message = "The payment was completed; the callback was dropped."
+	case approved:
+		data.Message = "Payment approved. The callback was answered with " +
+			"status " + strconv.Itoa(callbackStatus) + "."
+	default:
+		data.Message = "Payment declined. The callback was answered with " +
+			"status " + strconv.Itoa(callbackStatus) + "."
+	}
+
+	s.renderPage(w, status, data)
+}
+
+func (s *Server) pageData(trackID int64) (pageData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pmt, ok := s.payments[trackID]
+	if !ok {
+		return pageData{TrackID: trackID}, false
+	}
+
+	return pageData{
+		TrackID: trackID,
+		Payment: &pagePayment{
+			OrderID:     pmt.request.OrderID,
+			Amount:      pmt.request.Amount,
+			Description: pmt.request.Description,
+			CallbackURL: pmt.request.CallbackURL,
+		},
+		Pending: pmt.status == statusPending,
+	}, true
+}
+
+func (s *Server) renderPage(w http.ResponseWriter, status int, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = pageTemplate.Execute(w, data)
+}
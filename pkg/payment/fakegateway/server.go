@@ -0,0 +1,551 @@
+// Package fakegateway is a stand-in for the Zibal gateway, for developing
+// checkout and testing the order, payment and callback flow offline. It is
+// an http.Handler, so it runs under httptest or as cmd/fakegateway.
+package fakegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"go.uber.org/zap"
+)
+
+// Zibal result codes
+const (
+	ResultSuccess          = 100
+	ResultMerchantNotFound = 102
+	ResultMerchantInactive = 103
+	ResultInvalidAmount    = 105
+	ResultAlreadyVerified  = 201
+	ResultNotPaid          = 202
+	ResultInvalidTrackID   = 203
+)
+
+var resultMessages = map[int]string{
+	ResultSuccess:          "success",
+	ResultMerchantNotFound: "merchant not found",
+	ResultMerchantInactive: "merchant is inactive",
+	ResultInvalidAmount:    "amount must be at least 1,000 rials",
+	ResultAlreadyVerified:  "already verified",
+	ResultNotPaid:          "order not paid or failed",
+	ResultInvalidTrackID:   "invalid trackId",
+}
+
+// Zibal payment statuses, as reported by inquiries and callbacks
+const (
+	statusPending    = -1
+	statusVerified   = 1
+	statusUnverified = 2
+	statusCancelled  = 3
+)
+
+// minAmount is the smallest payment Zibal accepts, in Rials
+const minAmount = 1000
+
+// Endpoint names the calls a Failure can be scripted for.
+type Endpoint string
+
+const (
+	EndpointRequest  Endpoint = "request"
+	EndpointVerify   Endpoint = "verify"
+	EndpointInquiry  Endpoint = "inquiry"
+	EndpointCallback Endpoint = "callback"
+)
+
+// Failure changes the next answer of an endpoint. Failures are queued per
+// endpoint and each one is used once. Zero fields change nothing.
+type Failure struct {
+	// Delay holds the answer back, long enough to trip client timeouts
+	Delay time.Duration `json:"-"`
+	// HTTPStatus answers with this status and no JSON body
+	HTTPStatus int `json:"http_status,omitempty"`
+	// Result replaces the result code, such as 102, 201 or 202
+	Result int `json:"result,omitempty"`
+	// Amount replaces the amount reported by verify and inquiry
+	Amount int `json:"amount,omitempty"`
+	// OrderID replaces the order ID reported by verify and inquiry
+	OrderID string `json:"order_id,omitempty"`
+	// Drop skips sending the callback, as if it never reached the server
+	Drop bool `json:"drop,omitempty"`
+}
+
+var (
+	ErrPaymentNotFound   = errors.New("payment not found")
+	ErrPaymentNotPending = errors.New("payment is not pending")
+)
+
+type Config struct {
+	// Merchant is the only merchant ID accepted; any is accepted if empty
+	Merchant string
+	// CallbackTimeout bounds the callbacks sent to the shop
+	CallbackTimeout time.Duration
+}
+
+type fakePayment struct {
+	request    payment.ZibalPaymentRequest
+	status     int
+	cardNumber string
+	refNumber  int64
+}
+
+// Server is a fake Zibal gateway. Payments live in memory.
+type Server struct {
+	config     Config
+	logger     logger.Interface
+	mux        *http.ServeMux
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	payments    map[int64]*fakePayment
+	failures    map[Endpoint][]Failure
+	nextTrackID int64
+	nextRef     int64
+}
+
+func NewServer(config Config, logger logger.Interface) *Server {
+	if config.CallbackTimeout <= 0 {
+		config.CallbackTimeout = 10 * time.Second
+	}
+
+	s := &Server{
+		config: config,
+		logger: logger,
+		mux:    http.NewServeMux(),
+		httpClient: &http.Client{
+			Timeout: config.CallbackTimeout,
+		},
+		payments:    make(map[int64]*fakePayment),
+		failures:    make(map[Endpoint][]Failure),
+		nextTrackID: 3_000_000_000,
+		nextRef:     100_000,
+	}
+
+	s.mux.HandleFunc("POST /request", s.handleRequest)
+	s.mux.HandleFunc("POST /verify", s.handleVerify)
+	s.mux.HandleFunc("POST /inquiry", s.handleInquiry)
+	s.mux.HandleFunc("GET /start/{trackId}", s.handleStart)
+	s.mux.HandleFunc("POST /start/{trackId}", s.handleDecision)
+
+	s.mux.HandleFunc("POST /fake/failures", s.handleAddFailure)
+	s.mux.HandleFunc("DELETE /fake/failures", s.handleReset)
+	s.mux.HandleFunc(
+		"POST /fake/payments/{trackId}/{decision}", s.handleFakeDecision,
+	)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Fail queues a failure for the next call of an endpoint.
+func (s *Server) Fail(endpoint Endpoint, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = append(s.failures[endpoint], failure)
+}
+
+// Reset drops every queued failure. Payments are kept.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[Endpoint][]Failure)
+}
+
+// Approve pays a pending payment, as the customer would on the payment
+// page, and sends the callback.
+func (s *Server) Approve(ctx context.Context, trackID int64) error {
+	_, err := s.complete(ctx, trackID, true)
+	return err
+}
+
+// Decline cancels a pending payment and sends the callback.
+func (s *Server) Decline(ctx context.Context, trackID int64) error {
+	_, err := s.complete(ctx, trackID, false)
+	return err
+}
+
+func (s *Server) nextFailure(endpoint Endpoint) Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.failures[endpoint]
+	if len(queue) == 0 {
+		return Failure{}
+	}
+	s.failures[endpoint] = queue[1:]
+	return queue[0]
+}
+
+// applyFailure delays the answer and reports whether the failure already
+// answered the request.
+func (s *Server) applyFailure(
+	w http.ResponseWriter,
+	r *http.Request,
+	failure Failure,
+) bool {
+	if failure.Delay > 0 {
+		select {
+		case <-time.After(failure.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if failure.HTTPStatus != 0 {
+		http.Error(w, http.StatusText(failure.HTTPStatus), failure.HTTPStatus)
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	failure := s.nextFailure(EndpointRequest)
+	if s.applyFailure(w, r, failure) {
+		return
+	}
+
+	var req payment.ZibalPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result := ResultSuccess
+	switch {
+	case !s.merchantAccepted(req.MerchantID):
+		result = ResultMerchantNotFound
+	case req.Amount < minAmount:
+		result = ResultInvalidAmount
+	}
+	if failure.Result != 0 {
+		result = failure.Result
+	}
+
+	resp := payment.ZibalPaymentResponse{
+		Result:  result,
+		Message: resultMessages[result],
+	}
+	if result == ResultSuccess {
+		s.mu.Lock()
+		s.nextTrackID++
+		resp.TrackID = s.nextTrackID
+		s.payments[resp.TrackID] = &fakePayment{
+			request: req,
+			status:  statusPending,
+		}
+		s.mu.Unlock()
+
+		s.logger.Info("Fake payment created",
+			zap.Int64("track_id", resp.TrackID),
+			zap.String("order_id", req.OrderID),
+			zap.Int("amount", req.Amount),
+		)
+	}
+
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	failure := s.nextFailure(EndpointVerify)
+	if s.applyFailure(w, r, failure) {
+		return
+	}
+
+	var req payment.ZibalVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pmt, ok := s.payments[req.TrackID]
+	result := ResultSuccess
+	switch {
+	case !s.merchantAccepted(req.MerchantID):
+		result = ResultMerchantNotFound
+	case !ok:
+		result = ResultInvalidTrackID
+	case pmt.status == statusVerified:
+		result = ResultAlreadyVerified
+	case pmt.status != statusUnverified:
+		result = ResultNotPaid
+	}
+	if failure.Result != 0 {
+		result = failure.Result
+	}
+
+	resp := payment.ZibalVerifyResponse{
+		Result:  result,
+		Message: resultMessages[result],
+	}
+	if ok {
+		// Only a successful verify settles the payment
+		if result == ResultSuccess {
+			pmt.status = statusVerified
+		}
+		if result == ResultSuccess || result == ResultAlreadyVerified {
+			resp.Amount = pmt.request.Amount
+			resp.OrderID = pmt.request.OrderID
+			resp.CardNumber = pmt.cardNumber
+			resp.RefNumber = pmt.refNumber
+		}
+		resp.Status = pmt.status
+	}
+	if failure.Amount != 0 {
+		resp.Amount = failure.Amount
+	}
+	if failure.OrderID != "" {
+		resp.OrderID = failure.OrderID
+	}
+
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleInquiry(w http.ResponseWriter, r *http.Request) {
+	failure := s.nextFailure(EndpointInquiry)
+	if s.applyFailure(w, r, failure) {
+		return
+	}
+
+	var req payment.ZibalInquiryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pmt, ok := s.payments[req.TrackID]
+	result := ResultSuccess
+	switch {
+	case !s.merchantAccepted(req.MerchantID):
+		result = ResultMerchantNotFound
+	case !ok:
+		result = ResultInvalidTrackID
+	}
+	if failure.Result != 0 {
+		result = failure.Result
+	}
+
+	resp := payment.ZibalInquiryResponse{
+		Result:  result,
+		Message: resultMessages[result],
+	}
+	if ok {
+		resp.Status = pmt.status
+		resp.Amount = pmt.request.Amount
+		resp.OrderID = pmt.request.OrderID
+		resp.CardNumber = pmt.cardNumber
+		resp.RefNumber = pmt.refNumber
+	}
+	if failure.Amount != 0 {
+		resp.Amount = failure.Amount
+	}
+	if failure.OrderID != "" {
+		resp.OrderID = failure.OrderID
+	}
+
+	writeJSON(w, resp)
+}
+
+// complete settles a pending payment as paid or cancelled and sends the
+// callback. It returns the status the shop answered the callback with, or
+// 0 if none was sent.
+func (s *Server) complete(
+	ctx context.Context,
+	trackID int64,
+	approved bool,
+) (int, error) {
+	s.mu.Lock()
+	pmt, ok := s.payments[trackID]
+	if !ok {
+		s.mu.Unlock()
+		return 0, ErrPaymentNotFound
+	}
+	if pmt.status != statusPending {
+		s.mu.Unlock()
+		return 0, ErrPaymentNotPending
+	}
+	if approved {
+		s.nextRef++
+		pmt.status = statusUnverified
+		pmt.cardNumber = "603799******1234"
+		pmt.refNumber = s.nextRef
+	} else {
+		pmt.status = statusCancelled
+	}
+	callbackURL := pmt.request.CallbackURL
+	body := map[string]any{
+		"success": 0,
+		"trackId": trackID,
+		"orderId": pmt.request.OrderID,
+		"status":  pmt.status,
+	}
+	if approved {
+		body["success"] = 1
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("Fake payment completed",
+		zap.Int64("track_id", trackID),
+		zap.Bool("approved", approved),
+	)
+
+	failure := s.nextFailure(EndpointCallback)
+	if failure.Drop {
+		s.logger.Info("Fake callback dropped", zap.Int64("track_id", trackID))
+		return 0, nil
+	}
+	if failure.Delay > 0 {
+		select {
+		case <-time.After(failure.Delay):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	return s.sendCallback(ctx, callbackURL, body)
+}
+
+func (s *Server) sendCallback(
+	ctx context.Context,
+	callbackURL string,
+	body map[string]any,
+) (int, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal callback: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, callbackURL, bytes.NewReader(payload),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create callback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send callback: %w", err)
+	}
+	defer resp.Body.Close()
+
+	s.logger.Info("Fake callback sent",
+		zap.String("callback_url", callbackURL),
+		zap.Int("status", resp.StatusCode),
+	)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode, fmt.Errorf(
+			"callback answered with status %d", resp.StatusCode,
+		)
+	}
+	return resp.StatusCode, nil
+}
+
+// failureRequest is the JSON form of a Failure for POST /fake/failures.
+type failureRequest struct {
+	Failure
+	Endpoint Endpoint `json:"endpoint"`
+	// Delay is a Go duration, such as "45s"
+	Delay string `json:"delay,omitempty"`
+}
+
+func (s *Server) handleAddFailure(w http.ResponseWriter, r *http.Request) {
+	var req failureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Endpoint {
+	case EndpointRequest, EndpointVerify, EndpointInquiry, EndpointCallback:
+	default:
+		http.Error(w, "unknown endpoint", http.StatusBadRequest)
+		return
+	}
+
+	if req.Delay != "" {
+		delay, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			http.Error(w, "invalid delay", http.StatusBadRequest)
+			return
+		}
+		req.Failure.Delay = delay
+	}
+
+	s.Fail(req.Endpoint, req.Failure)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFakeDecision approves or declines a payment without the payment
+// page, for scripts.
+func (s *Server) handleFakeDecision(w http.ResponseWriter, r *http.Request) {
+	trackID, err := strconv.ParseInt(r.PathValue("trackId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid trackId", http.StatusBadRequest)
+		return
+	}
+
+	var approved bool
+	switch r.PathValue("decision") {
+	case "approve":
+		approved = true
+	case "decline":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	callbackStatus, err := s.complete(r.Context(), trackID, approved)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPaymentNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrPaymentNotPending):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"trackId":        trackID,
+		"approved":       approved,
+		"callbackStatus": callbackStatus,
+	})
+}
+
+func (s *Server) merchantAccepted(merchant string) bool {
+	return s.config.Merchant == "" || merchant == s.config.Merchant
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,498 @@
+package fakegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dunhayat-api/pkg/logger"
+	"dunhayat-api/pkg/payment"
+
+	"go.uber.org/zap"
+)
+
+const testMerchant = "zibal-test"
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...zap.Field)  {}
+func (nopLogger) Error(string, ...zap.Field) {}
+func (nopLogger) Warn(string, ...zap.Field)  {}
+func (nopLogger) Debug(string, ...zap.Field) {}
+
+func (nopLogger) WithContext(context.Context) *logger.Logger {
+	return nil
+}
+
+// testShop records the callbacks the gateway sends it.
+type testShop struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	callbacks []map[string]string
+}
+
+func newTestShop(t *testing.T) *testShop {
+	shop := &testShop{}
+	shop.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			decoder := json.NewDecoder(r.Body)
+			decoder.UseNumber()
+			if err := decoder.Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			params := make(map[string]string, len(body))
+			for key, value := range body {
+				params[key] = fmt.Sprint(value)
+			}
+			shop.mu.Lock()
+			shop.callbacks = append(shop.callbacks, params)
+			shop.mu.Unlock()
+		},
+	))
+	t.Cleanup(shop.server.Close)
+	return shop
+}
+
+func (s *testShop) received() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.callbacks...)
+}
+
+type testGateway struct {
+	server *Server
+	http   *httptest.Server
+	client *payment.ZibalClient
+	shop   *testShop
+}
+
+func newTestGateway(t *testing.T) *testGateway {
+	g := &testGateway{
+		server: NewServer(Config{
+			Merchant:        testMerchant,
+			CallbackTimeout: 5 * time.Second,
+		}, nopLogger{}),
+		shop: newTestShop(t),
+	}
+	g.http = httptest.NewServer(g.server)
+	t.Cleanup(g.http.Close)
+
+	g.client = payment.NewZibalClient(payment.ZibalConfig{
+		MerchantID: testMerchant,
+		BaseURL:    g.http.URL,
+		Timeout:    time.Second,
+	})
+	return g
+}
+
+// create registers a payment of amount and returns its trackId.
+func (g *testGateway) create(t *testing.T, amount int) int64 {
+	t.Helper()
+
+	reference, err := g.client.CreatePayment(
+		context.Background(), payment.CreateRequest{
+			Amount:      amount,
+			OrderID:     "order-1",
+			CallbackURL: g.shop.server.URL + "/callback/zibal",
+			Description: "Payment for order order-1",
+		},
+	)
+	if err != nil {
+		t.Fatalf("CreatePayment failed: %v", err)
+	}
+	trackID, err := strconv.ParseInt(reference, 10, 64)
+	if err != nil {
+		t.Fatalf("trackId %q is not a number", reference)
+	}
+	return trackID
+}
+
+func (g *testGateway) verify(t *testing.T, trackID int64) *payment.Verification {
+	t.Helper()
+
+	verification, err := g.client.Verify(
+		context.Background(), payment.VerifyRequest{
+			Reference: strconv.FormatInt(trackID, 10),
+		},
+	)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	return verification
+}
+
+func (g *testGateway) inquire(t *testing.T, trackID int64) payment.InquiryStatus {
+	t.Helper()
+
+	inquiry, err := g.client.Inquire(
+		context.Background(), strconv.FormatInt(trackID, 10),
+	)
+	if err != nil {
+		t.Fatalf("Inquire failed: %v", err)
+	}
+	return inquiry.Status
+}
+
+func TestApprovedPaymentIsVerifiedOnce(t *testing.T) {
+	g := newTestGateway(t)
+	trackID := g.create(t, 250_000)
+
+	if status := g.inquire(t, trackID); status != payment.InquiryPending {
+		t.Errorf("inquiry before paying = %s, want pending", status)
+	}
+
+	if err := g.server.Approve(context.Background(), trackID); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+
+	callbacks := g.shop.received()
+	if len(callbacks) != 1 {
+		t.Fatalf("shop received %d callbacks, want 1", len(callbacks))
+	}
+	callback, err := g.client.ParseCallback(callbacks[0])
+	if err != nil {
+		t.Fatalf("ParseCallback failed: %v", err)
+	}
+	if callback.Reference != strconv.FormatInt(trackID, 10) ||
+		!callback.Success || callback.Status != "2" {
+		t.Errorf("callback = %+v, want a successful one with status 2", callback)
+	}
+
+	if status := g.inquire(t, trackID); status != payment.InquiryPaid {
+		t.Errorf("inquiry before verifying = %s, want paid", status)
+	}
+
+	verification := g.verify(t, trackID)
+	if !verification.Verified || verification.Code != ResultSuccess {
+		t.Errorf("verification = %+v, want verified", verification)
+	}
+	if verification.Amount != 250_000 || verification.OrderID != "order-1" {
+		t.Errorf(
+			"verified %d for %q, want 250000 for order-1",
+			verification.Amount, verification.OrderID,
+		)
+	}
+	if verification.RefNumber == "" {
+		t.Error("verification has no bank reference")
+	}
+
+	again := g.verify(t, trackID)
+	if !again.Verified || again.Code != ResultAlreadyVerified {
+		t.Errorf("second verification = %+v, want already verified", again)
+	}
+	if status := g.inquire(t, trackID); status != payment.InquiryVerified {
+		t.Errorf("inquiry after verifying = %s, want verified", status)
+	}
+
+	if err := g.server.Approve(
+		context.Background(), trackID,
+	); !errors.Is(err, ErrPaymentNotPending) {
+		t.Errorf("approving twice: error = %v, want not pending", err)
+	}
+}
+
+func TestDeclinedPaymentIsNotVerified(t *testing.T) {
+	g := newTestGateway(t)
+	trackID := g.create(t, 250_000)
+
+	if err := g.server.Decline(context.Background(), trackID); err != nil {
+		t.Fatalf("Decline failed: %v", err)
+	}
+
+	callbacks := g.shop.received()
+	if len(callbacks) != 1 {
+		t.Fatalf("shop received %d callbacks, want 1", len(callbacks))
+	}
+	callback, err := g.client.ParseCallback(callbacks[0])
+	if err != nil {
+		t.Fatalf("ParseCallback failed: %v", err)
+	}
+	if callback.Success || callback.Status != "3" {
+		t.Errorf("callback = %+v, want a failed one with status 3", callback)
+	}
+
+	verification := g.verify(t, trackID)
+	if verification.Verified || verification.Code != ResultNotPaid {
+		t.Errorf("verification = %+v, want not paid", verification)
+	}
+	if status := g.inquire(t, trackID); status != payment.InquiryFailed {
+		t.Errorf("inquiry = %s, want failed", status)
+	}
+
+	if err := g.server.Approve(
+		context.Background(), 1,
+	); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("approving an unknown payment: error = %v", err)
+	}
+}
+
+func TestPaymentPageApprovesPayment(t *testing.T) {
+	g := newTestGateway(t)
+	trackID := g.create(t, 250_000)
+	pageURL := g.client.PaymentURL(strconv.FormatInt(trackID, 10))
+
+	page := getPage(t, pageURL)
+	if !strings.Contains(page, "250000 Rials") ||
+		!strings.Contains(page, `value="approve"`) {
+		t.Errorf("payment page does not offer to pay 250000 Rials:\n%s", page)
+	}
+
+	resp, err := http.PostForm(pageURL, url.Values{"decision": {"approve"}})
+	if err != nil {
+		t.Fatalf("failed to approve on the payment page: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK ||
+		!strings.Contains(string(body), "Payment approved") {
+		t.Errorf(
+			"approving answered %d:\n%s", resp.StatusCode, body,
+		)
+	}
+
+	if callbacks := g.shop.received(); len(callbacks) != 1 {
+		t.Errorf("shop received %d callbacks, want 1", len(callbacks))
+	}
+	if page := getPage(t, pageURL); !strings.Contains(page, "already completed") ||
+		strings.Contains(page, `value="approve"`) {
+		t.Errorf("completed payment can still be approved:\n%s", page)
+	}
+}
+
+func getPage(t *testing.T, pageURL string) string {
+	t.Helper()
+
+	resp, err := http.Get(pageURL)
+	if err != nil {
+		t.Fatalf("failed to open the payment page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read the payment page: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("payment page answered %d:\n%s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestCreatePaymentRefused(t *testing.T) {
+	g := newTestGateway(t)
+
+	other := payment.NewZibalClient(payment.ZibalConfig{
+		MerchantID: "someone-else",
+		BaseURL:    g.http.URL,
+		Timeout:    time.Second,
+	})
+	if _, err := other.CreatePayment(
+		context.Background(), payment.CreateRequest{Amount: 250_000},
+	); err == nil || !strings.Contains(err.Error(), "result: 102") {
+		t.Errorf("unknown merchant: error = %v, want result 102", err)
+	}
+
+	if _, err := g.client.CreatePayment(
+		context.Background(), payment.CreateRequest{Amount: 500},
+	); err == nil || !strings.Contains(err.Error(), "result: 105") {
+		t.Errorf("amount below the minimum: error = %v, want result 105", err)
+	}
+}
+
+// Each scripted failure changes one verify answer; the next one is real.
+func TestVerifyFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure Failure
+		check   func(*payment.Verification, error) error
+	}{
+		{
+			name:    "not paid",
+			failure: Failure{Result: ResultNotPaid},
+			check:   wantUnverified(ResultNotPaid),
+		},
+		{
+			name:    "merchant not found",
+			failure: Failure{Result: ResultMerchantNotFound},
+			check:   wantUnverified(ResultMerchantNotFound),
+		},
+		{
+			name:    "already verified",
+			failure: Failure{Result: ResultAlreadyVerified},
+			check: func(v *payment.Verification, err error) error {
+				if err != nil || !v.Verified {
+					return fmt.Errorf("verification = %+v, %v", v, err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "amount mismatch",
+			failure: Failure{Amount: 1000, OrderID: "order-2"},
+			check: func(v *payment.Verification, err error) error {
+				if err != nil || v.Amount != 1000 || v.OrderID != "order-2" {
+					return fmt.Errorf("verification = %+v, %v", v, err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "server error",
+			failure: Failure{HTTPStatus: http.StatusServiceUnavailable},
+			check:   wantError,
+		},
+		{
+			name:    "timeout",
+			failure: Failure{Delay: 3 * time.Second},
+			check:   wantError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			trackID := g.create(t, 250_000)
+			if err := g.server.Approve(context.Background(), trackID); err != nil {
+				t.Fatalf("Approve failed: %v", err)
+			}
+
+			g.server.Fail(EndpointVerify, tt.failure)
+			verification, err := g.client.Verify(
+				context.Background(), payment.VerifyRequest{
+					Reference: strconv.FormatInt(trackID, 10),
+				},
+			)
+			if checkErr := tt.check(verification, err); checkErr != nil {
+				t.Error(checkErr)
+			}
+
+			if next := g.verify(t, trackID); !next.Verified ||
+				next.Amount != 250_000 {
+				t.Errorf("verification after the failure = %+v", next)
+			}
+		})
+	}
+}
+
+func wantUnverified(code int) func(*payment.Verification, error) error {
+	return func(v *payment.Verification, err error) error {
+		if err != nil || v.Verified || v.Code != code {
+			return fmt.Errorf(
+				"verification = %+v, %v, want unverified with %d", v, err, code,
+			)
+		}
+		return nil
+	}
+}
+
+func wantError(v *payment.Verification, err error) error {
+	if err == nil {
+		return fmt.Errorf("verification = %+v, want an error", v)
+	}
+	return nil
+}
+
+func TestDroppedCallback(t *testing.T) {
+	g := newTestGateway(t)
+	g.server.Fail(EndpointCallback, Failure{Drop: true})
+
+	dropped := g.create(t, 250_000)
+	if err := g.server.Approve(context.Background(), dropped); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if callbacks := g.shop.received(); len(callbacks) != 0 {
+		t.Fatalf("shop received %d callbacks, want none", len(callbacks))
+	}
+	// The payment went through even though the shop was never told
+	if status := g.inquire(t, dropped); status != payment.InquiryPaid {
+		t.Errorf("inquiry = %s, want paid", status)
+	}
+
+	delivered := g.create(t, 250_000)
+	if err := g.server.Approve(context.Background(), delivered); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if callbacks := g.shop.received(); len(callbacks) != 1 {
+		t.Errorf("shop received %d callbacks, want 1", len(callbacks))
+	}
+}
+
+// Failures and decisions can be scripted over HTTP, as cmd/fakegateway
+// has no other way in.
+func TestScriptingOverHTTP(t *testing.T) {
+	g := newTestGateway(t)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"endpoint": "request", "result": 103}`, http.StatusNoContent},
+		{`{"endpoint": "verify", "delay": "45s"}`, http.StatusNoContent},
+		{`{"endpoint": "refund"}`, http.StatusBadRequest},
+		{`{"endpoint": "verify", "delay": "soon"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status := send(
+			t, http.MethodPost, g.http.URL+"/fake/failures", tt.body,
+		); status != tt.want {
+			t.Errorf("adding failure %s answered %d, want %d", tt.body, status, tt.want)
+		}
+	}
+
+	if _, err := g.client.CreatePayment(
+		context.Background(), payment.CreateRequest{Amount: 250_000},
+	); err == nil || !strings.Contains(err.Error(), "result: 103") {
+		t.Errorf("scripted request failure: error = %v, want result 103", err)
+	}
+
+	if status := send(
+		t, http.MethodDelete, g.http.URL+"/fake/failures", "",
+	); status != http.StatusNoContent {
+		t.Errorf("reset answered %d", status)
+	}
+
+	trackID := g.create(t, 250_000)
+	approveURL := fmt.Sprintf("%s/fake/payments/%d/approve", g.http.URL, trackID)
+	if status := send(t, http.MethodPost, approveURL, ""); status != http.StatusOK {
+		t.Errorf("approving answered %d, want 200", status)
+	}
+	if status := send(t, http.MethodPost, approveURL, ""); status != http.StatusConflict {
+		t.Errorf("approving twice answered %d, want 409", status)
+	}
+	// The queued 45s delay was reset, so verify answers at once
+	if verification := g.verify(t, trackID); !verification.Verified {
+		t.Errorf("verification = %+v, want verified", verification)
+	}
+}
+
+func send(t *testing.T, method string, target string, body string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, target, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,216 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Gateway is a payment service provider. Amounts are in Rials.
+type Gateway interface {
+	// CreatePayment registers a payment and returns the gateway's
+	// reference for it, such as a Zibal trackId or a ZarinPal authority
+	CreatePayment(ctx context.Context, req CreateRequest) (string, error)
+	// PaymentURL is where the customer is sent to pay
+	PaymentURL(reference string) string
+	// Verify confirms a payment once the customer is back. Verifying an
+	// already verified payment is not an error.
+	Verify(ctx context.Context, req VerifyRequest) (*Verification, error)
+	// Inquire reports the state of a payment without changing it
+	Inquire(ctx context.Context, reference string) (*Inquiry, error)
+	// Refund returns part or all of a verified payment to the customer's
+	// card. Gateways without a refund API return ErrRefundNotSupported, and
+	// a refund the gateway did not make returns ErrRefundRejected; any
+	// other error leaves it unknown whether the money was sent.
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+	// ParseCallback reads the query or body parameters the gateway sends
+	// to the callback URL
+	ParseCallback(params map[string]string) (*Callback, error)
+}
+
+type CreateRequest struct {
+	Amount      int
+	OrderID     string
+	CallbackURL string
+	Description string
+	Mobile      string
+}
+
+type VerifyRequest struct {
+	Reference string
+	// Amount is what the payment was created for; some gateways check it
+	Amount int
+}
+
+// Verification is the outcome of verifying a payment. OrderID is empty for
+// gateways that do not report it back.
+type Verification struct {
+	Verified   bool
+	Amount     int
+	OrderID    string
+	CardNumber string
+	// RefNumber is the bank's reference of the transaction
+	RefNumber string
+	// Code and Message are the gateway's own result
+	Code    int
+	Message string
+}
+
+type InquiryStatus string
+
+const (
+	// InquiryPending means the customer has not paid yet
+	InquiryPending InquiryStatus = "pending"
+	// InquiryPaid means the money was taken but the payment was never
+	// verified; the gateway returns it unless it is verified in time
+	InquiryPaid      InquiryStatus = "paid"
+	InquiryVerified  InquiryStatus = "verified"
+	InquiryFailed    InquiryStatus = "failed"
+	InquiryRefunded  InquiryStatus = "refunded"
+	InquiryUndefined InquiryStatus = "unknown"
+)
+
+func (s InquiryStatus) String() string {
+	return string(s)
+}
+
+type Inquiry struct {
+	Status  InquiryStatus
+	Amount  int
+	OrderID string
+	Code    int
+	Message string
+}
+
+type RefundRequest struct {
+	Reference   string
+	Amount      int
+	Description string
+}
+
+type RefundResult struct {
+	// RefundID is the gateway's reference of the refund
+	RefundID string
+}
+
+// Callback is what a gateway's callback claims about a payment. It is not
+// proof of payment; payments are only settled by Verify.
+type Callback struct {
+	Reference string
+	Success   bool
+	// Status is the gateway's own status code or word
+	Status string
+}
+
+var (
+	ErrUnknownGateway     = errors.New("unknown payment gateway")
+	ErrRefundNotSupported = errors.New("gateway does not support refunds")
+	ErrRefundRejected     = errors.New("gateway rejected the refund")
+	ErrInvalidCallback    = errors.New("invalid gateway callback")
+)
+
+// Registry holds the enabled gateways by name, in the order they are
+// tried when a payment does not name one.
+type Registry struct {
+	gateways map[string]Gateway
+	names    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		gateways: make(map[string]Gateway),
+	}
+}
+
+// Register adds a gateway; registering a name again replaces its gateway.
+func (r *Registry) Register(name string, gateway Gateway) {
+	if _, ok := r.gateways[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.gateways[name] = gateway
+}
+
+func (r *Registry) Get(name string) (Gateway, error) {
+	gateway, ok := r.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGateway, name)
+	}
+	return gateway, nil
+}
+
+// Names returns the registered gateways in registration order.
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// postJSON sends body as JSON and decodes the JSON response into out.
+// Statuses other than 200 are errors.
+func postJSON(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	headers map[string]string,
+	body any,
+	out any,
+) error {
+	status, respBody, err := sendJSON(ctx, client, url, headers, body)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf(
+			"request failed with status %d: %s", status, string(respBody),
+		)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
+
+// sendJSON posts body as JSON and returns the status and body of the
+// response, whatever the status.
+func sendJSON(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	headers map[string]string,
+	body any,
+) (int, []byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, url, bytes.NewReader(payload),
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return resp.StatusCode, respBody, nil
+}
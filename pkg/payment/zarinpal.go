@@ -0,0 +1,283 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ZarinPalConfig struct {
+	MerchantID string
+	// BaseURL is https://payment.zarinpal.com/pg, or
+	// https://sandbox.zarinpal.com/pg for the sandbox
+	BaseURL string
+	Timeout time.Duration
+}
+
+const (
+	zarinPalCodeSuccess         = 100
+	zarinPalCodeAlreadyVerified = 101
+)
+
+// ZarinPalClient is the ZarinPal gateway, through its v4 REST API.
+type ZarinPalClient struct {
+	config     ZarinPalConfig
+	httpClient *http.Client
+}
+
+type ZarinPalPaymentRequest struct {
+	MerchantID  string           `json:"merchant_id"`
+	Amount      int              `json:"amount"`
+	Currency    string           `json:"currency"`
+	CallbackURL string           `json:"callback_url"`
+	Description string           `json:"description"`
+	Metadata    ZarinPalMetadata `json:"metadata"`
+}
+
+type ZarinPalMetadata struct {
+	Mobile  string `json:"mobile,omitempty"`
+	OrderID string `json:"order_id,omitempty"`
+}
+
+type ZarinPalVerifyRequest struct {
+	MerchantID string `json:"merchant_id"`
+	Amount     int    `json:"amount"`
+	Authority  string `json:"authority"`
+}
+
+type ZarinPalInquiryRequest struct {
+	MerchantID string `json:"merchant_id"`
+	Authority  string `json:"authority"`
+}
+
+// ZarinPalResponse is the envelope of every v4 response. Data is an empty
+// array instead of an object when the call fails, and Errors the other way
+// round, so both are decoded lazily.
+type ZarinPalResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors json.RawMessage `json:"errors"`
+}
+
+type ZarinPalResponseData struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Authority string `json:"authority"`
+	CardPan   string `json:"card_pan"`
+	RefID     int64  `json:"ref_id"`
+	// Status is only set by inquiries
+	Status string `json:"status"`
+}
+
+type ZarinPalError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewZarinPalClient(config ZarinPalConfig) *ZarinPalClient {
+	return &ZarinPalClient{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}
+}
+
+func (c *ZarinPalClient) CreatePayment(
+	ctx context.Context,
+	req CreateRequest,
+) (string, error) {
+	description := req.Description
+	if description == "" {
+		// ZarinPal refuses payments without a description
+		description = "Payment for order " + req.OrderID
+	}
+
+	data, err := c.call(ctx, "/v4/payment/request.json", ZarinPalPaymentRequest{
+		MerchantID:  c.config.MerchantID,
+		Amount:      req.Amount,
+		Currency:    "IRR",
+		CallbackURL: req.CallbackURL,
+		Description: description,
+		Metadata: ZarinPalMetadata{
+			Mobile:  req.Mobile,
+			OrderID: req.OrderID,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("zarinpal payment request failed: %w", err)
+	}
+
+	if data.Code != zarinPalCodeSuccess || data.Authority == "" {
+		return "", fmt.Errorf(
+			"zarinpal payment request failed: %s (code: %d)",
+			data.Message, data.Code,
+		)
+	}
+
+	return data.Authority, nil
+}
+
+func (c *ZarinPalClient) PaymentURL(reference string) string {
+	return fmt.Sprintf("%s/StartPay/%s", c.config.BaseURL, reference)
+}
+
+// Verify reports no order ID, as ZarinPal does not return one. ZarinPal
+// checks the amount itself and refuses to verify a different one.
+func (c *ZarinPalClient) Verify(
+	ctx context.Context,
+	req VerifyRequest,
+) (*Verification, error) {
+	data, err := c.call(ctx, "/v4/payment/verify.json", ZarinPalVerifyRequest{
+		MerchantID: c.config.MerchantID,
+		Amount:     req.Amount,
+		Authority:  req.Reference,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zarinpal verify request failed: %w", err)
+	}
+
+	verified := data.Code == zarinPalCodeSuccess ||
+		data.Code == zarinPalCodeAlreadyVerified
+
+	verification := &Verification{
+		Verified:   verified,
+		CardNumber: data.CardPan,
+		Code:       data.Code,
+		Message:    data.Message,
+	}
+	if verified {
+		verification.Amount = req.Amount
+	}
+	if data.RefID != 0 {
+		verification.RefNumber = strconv.FormatInt(data.RefID, 10)
+	}
+
+	return verification, nil
+}
+
+func (c *ZarinPalClient) Inquire(
+	ctx context.Context,
+	reference string,
+) (*Inquiry, error) {
+	data, err := c.call(ctx, "/v4/payment/inquiry.json", ZarinPalInquiryRequest{
+		MerchantID: c.config.MerchantID,
+		Authority:  reference,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zarinpal inquiry request failed: %w", err)
+	}
+
+	if data.Code != zarinPalCodeSuccess {
+		return nil, fmt.Errorf(
+			"zarinpal inquiry failed: %s (code: %d)",
+			data.Message, data.Code,
+		)
+	}
+
+	inquiry := &Inquiry{
+		Code:    data.Code,
+		Message: data.Status,
+	}
+	switch data.Status {
+	case "IN_BANK":
+		inquiry.Status = InquiryPending
+	case "PAID":
+		inquiry.Status = InquiryPaid
+	case "VERIFIED":
+		inquiry.Status = InquiryVerified
+	case "FAILED":
+		inquiry.Status = InquiryFailed
+	case "REVERSED":
+		inquiry.Status = InquiryRefunded
+	default:
+		inquiry.Status = InquiryUndefined
+	}
+
+	return inquiry, nil
+}
+
+// Refund is not supported: ZarinPal refunds go through its GraphQL merchant
+// API with session IDs this client never sees, so they are made from the
+// ZarinPal panel.
+func (c *ZarinPalClient) Refund(
+	ctx context.Context,
+	req RefundRequest,
+) (*RefundResult, error) {
+	return nil, ErrRefundNotSupported
+}
+
+// ParseCallback reads Authority and Status, which ZarinPal appends to the
+// callback URL as it redirects the customer back.
+func (c *ZarinPalClient) ParseCallback(
+	params map[string]string,
+) (*Callback, error) {
+	authority := params["Authority"]
+	if authority == "" {
+		return nil, fmt.Errorf("%w: Authority is missing", ErrInvalidCallback)
+	}
+
+	return &Callback{
+		Reference: authority,
+		Success:   params["Status"] == "OK",
+		Status:    params["Status"],
+	}, nil
+}
+
+// call posts body to a v4 endpoint and returns the data of the response.
+// When ZarinPal answers with an error, such as -51 for a payment the
+// customer did not complete, its code and message are returned as data,
+// whatever the HTTP status: only failing to get an answer is an error.
+func (c *ZarinPalClient) call(
+	ctx context.Context,
+	path string,
+	body any,
+) (*ZarinPalResponseData, error) {
+	status, respBody, err := sendJSON(
+		ctx, c.httpClient, c.config.BaseURL+path, nil, body,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp ZarinPalResponse
+	if status >= http.StatusInternalServerError ||
+		json.Unmarshal(respBody, &resp) != nil {
+		return nil, fmt.Errorf(
+			"request failed with status %d: %s", status, string(respBody),
+		)
+	}
+
+	if isJSONObject(resp.Errors) {
+		var zpErr ZarinPalError
+		if err := json.Unmarshal(resp.Errors, &zpErr); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal errors: %w", err)
+		}
+		return &ZarinPalResponseData{
+			Code:    zpErr.Code,
+			Message: zpErr.Message,
+		}, nil
+	}
+
+	if !isJSONObject(resp.Data) {
+		return nil, fmt.Errorf(
+			"unexpected response with status %d: %s",
+			status, string(respBody),
+		)
+	}
+
+	var data ZarinPalResponseData
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal data: %w", err)
+	}
+
+	return &data, nil
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
@@ -1,21 +1,28 @@
 package payment
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 type ZibalConfig struct {
 	MerchantID string
 	BaseURL    string
-	Timeout    time.Duration
-	APIToken   string
+	// RefundURL is Zibal's refund endpoint, which needs APIToken
+	RefundURL string
+	Timeout   time.Duration
+	APIToken  string
 }
 
+const (
+	zibalResultSuccess         = 100
+	zibalResultAlreadyVerified = 201
+)
+
+// ZibalClient is the Zibal gateway.
 type ZibalClient struct {
 	config     ZibalConfig
 	httpClient *http.Client
@@ -44,7 +51,7 @@ type ZibalPaymentResponse struct {
 }
 
 type ZibalVerifyRequest struct {
-	MerchantID string `json:"merchantId"`
+	MerchantID string `json:"merchant"`
 	TrackID    int64  `json:"trackId"`
 }
 
@@ -54,19 +61,49 @@ type ZibalVerifyResponse struct {
 	OrderID          string `json:"orderId"`
 	CardNumber       string `json:"cardNumber"`
 	HashedCardNumber string `json:"hashedCardNumber"`
+	RefNumber        int64  `json:"refNumber"`
+	Status           int    `json:"status"`
 	Message          string `json:"message"`
 }
 
-type ZibalCallbackData struct {
-	Success          bool   `json:"success"`
-	Status           int    `json:"status"`
-	TrackID          int64  `json:"trackId"`
-	OrderID          string `json:"orderId"`
-	Amount           int    `json:"amount"`
-	CardNumber       string `json:"cardNumber"`
-	HashedCardNumber string `json:"hashedCardNumber"`
+// ZibalInquiryRequest has the same shape as a verify request but never
+// changes the payment.
+type ZibalInquiryRequest = ZibalVerifyRequest
+
+type ZibalInquiryResponse struct {
+	Result     int    `json:"result"`
+	Status     int    `json:"status"`
+	Amount     int    `json:"amount"`
+	OrderID    string `json:"orderId"`
+	CardNumber string `json:"cardNumber"`
+	RefNumber  int64  `json:"refNumber"`
+	Message    string `json:"message"`
 }
 
+type ZibalRefundRequest struct {
+	TrackID     int64  `json:"trackId"`
+	Amount      int    `json:"amount"`
+	Description string `json:"description,omitempty"`
+}
+
+type ZibalRefundResponse struct {
+	Result  int    `json:"result"`
+	Message string `json:"message"`
+	Data    struct {
+		RefundID string `json:"refundId"`
+	} `json:"data"`
+}
+
+// Payment statuses reported by Zibal's inquiry
+const (
+	zibalStatusPending    = -1
+	zibalStatusVerified   = 1
+	zibalStatusUnverified = 2
+	zibalStatusRefunded   = 15
+	zibalStatusRefunding  = 16
+	zibalStatusReversed   = 18
+)
+
 func NewZibalClient(config ZibalConfig) *ZibalClient {
 	return &ZibalClient{
 		config: config,
@@ -76,139 +113,200 @@ func NewZibalClient(config ZibalConfig) *ZibalClient {
 	}
 }
 
-func (c *ZibalClient) CreatePaymentRequest(
-	req ZibalPaymentRequest,
-) (*ZibalPaymentResponse, error) {
-	req.MerchantID = c.config.MerchantID
+func (c *ZibalClient) CreatePayment(
+	ctx context.Context,
+	req CreateRequest,
+) (string, error) {
+	var resp ZibalPaymentResponse
+	if err := postJSON(
+		ctx, c.httpClient, c.config.BaseURL+"/request", c.headers(),
+		ZibalPaymentRequest{
+			MerchantID:  c.config.MerchantID,
+			Amount:      req.Amount,
+			OrderID:     req.OrderID,
+			CallbackURL: req.CallbackURL,
+			Description: req.Description,
+			Mobile:      req.Mobile,
+		},
+		&resp,
+	); err != nil {
+		return "", fmt.Errorf("zibal payment request failed: %w", err)
+	}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to marshal payment request: %w", err,
+	if resp.Result != zibalResultSuccess {
+		return "", fmt.Errorf(
+			"zibal payment request failed: %s (result: %d)",
+			resp.Message, resp.Result,
 		)
 	}
 
-	httpReq, err := http.NewRequest(
-		"POST",
-		c.config.BaseURL+"/request",
-		bytes.NewBuffer(jsonData),
-	)
+	return strconv.FormatInt(resp.TrackID, 10), nil
+}
+
+func (c *ZibalClient) PaymentURL(reference string) string {
+	return fmt.Sprintf("%s/start/%s", c.config.BaseURL, reference)
+}
+
+func (c *ZibalClient) Verify(
+	ctx context.Context,
+	req VerifyRequest,
+) (*Verification, error) {
+	trackID, err := parseTrackID(req.Reference)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to create HTTP request: %w", err,
-		)
+		return nil, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	if c.config.APIToken != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+c.config.APIToken)
+	var resp ZibalVerifyResponse
+	if err := postJSON(
+		ctx, c.httpClient, c.config.BaseURL+"/verify", c.headers(),
+		ZibalVerifyRequest{
+			MerchantID: c.config.MerchantID,
+			TrackID:    trackID,
+		},
+		&resp,
+	); err != nil {
+		return nil, fmt.Errorf("zibal verify request failed: %w", err)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to send payment request: %w", err,
-		)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("failed to close response body: %v", err)
-		}
-	}()
+	return &Verification{
+		Verified: resp.Result == zibalResultSuccess ||
+			resp.Result == zibalResultAlreadyVerified,
+		Amount:     resp.Amount,
+		OrderID:    resp.OrderID,
+		CardNumber: resp.CardNumber,
+		RefNumber:  formatRefNumber(resp.RefNumber),
+		Code:       resp.Result,
+		Message:    resp.Message,
+	}, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (c *ZibalClient) Inquire(
+	ctx context.Context,
+	reference string,
+) (*Inquiry, error) {
+	trackID, err := parseTrackID(reference)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to read response body: %w", err,
-		)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(
-			"payment request failed with status %d: %s",
-			resp.StatusCode, string(body),
-		)
+	var resp ZibalInquiryResponse
+	if err := postJSON(
+		ctx, c.httpClient, c.config.BaseURL+"/inquiry", c.headers(),
+		ZibalInquiryRequest{
+			MerchantID: c.config.MerchantID,
+			TrackID:    trackID,
+		},
+		&resp,
+	); err != nil {
+		return nil, fmt.Errorf("zibal inquiry request failed: %w", err)
 	}
 
-	var paymentResp ZibalPaymentResponse
-	if err := json.Unmarshal(body, &paymentResp); err != nil {
+	if resp.Result != zibalResultSuccess {
 		return nil, fmt.Errorf(
-			"failed to unmarshal payment response: %w", err,
+			"zibal inquiry failed: %s (result: %d)",
+			resp.Message, resp.Result,
 		)
 	}
 
-	if paymentResp.Result != 100 {
-		return nil, fmt.Errorf(
-			"zibal payment request failed: %s (result: %d)",
-			paymentResp.Message, paymentResp.Result,
-		)
+	inquiry := &Inquiry{
+		Amount:  resp.Amount,
+		OrderID: resp.OrderID,
+		Code:    resp.Status,
+		Message: resp.Message,
+	}
+	switch resp.Status {
+	case zibalStatusPending:
+		inquiry.Status = InquiryPending
+	case zibalStatusVerified:
+		inquiry.Status = InquiryVerified
+	case zibalStatusUnverified:
+		inquiry.Status = InquiryPaid
+	case zibalStatusRefunded, zibalStatusRefunding, zibalStatusReversed:
+		inquiry.Status = InquiryRefunded
+	default:
+		// Every other status is a reason the payment did not go through
+		inquiry.Status = InquiryFailed
 	}
 
-	return &paymentResp, nil
+	return inquiry, nil
 }
 
-func (c *ZibalClient) VerifyPayment(
-	req ZibalVerifyRequest,
-) (*ZibalVerifyResponse, error) {
-	req.MerchantID = c.config.MerchantID
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
+func (c *ZibalClient) Refund(
+	ctx context.Context,
+	req RefundRequest,
+) (*RefundResult, error) {
+	if c.config.APIToken == "" || c.config.RefundURL == "" {
 		return nil, fmt.Errorf(
-			"failed to marshal verify request: %w", err,
+			"%w: zibal refunds need an API token", ErrRefundNotSupported,
 		)
 	}
 
-	httpReq, err := http.NewRequest(
-		"POST", c.config.BaseURL+"/verify", bytes.NewBuffer(jsonData),
-	)
+	trackID, err := parseTrackID(req.Reference)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to create HTTP request: %w", err,
-		)
+		return nil, fmt.Errorf("%w: %v", ErrRefundRejected, err)
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	if c.config.APIToken != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+c.config.APIToken)
+	var resp ZibalRefundResponse
+	if err := postJSON(
+		ctx, c.httpClient, c.config.RefundURL, c.headers(),
+		ZibalRefundRequest{
+			TrackID:     trackID,
+			Amount:      req.Amount,
+			Description: req.Description,
+		},
+		&resp,
+	); err != nil {
+		return nil, fmt.Errorf("zibal refund request failed: %w", err)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
+	if resp.Result != zibalResultSuccess {
 		return nil, fmt.Errorf(
-			"failed to send verify request: %w", err,
+			"%w: zibal: %s (result: %d)",
+			ErrRefundRejected, resp.Message, resp.Result,
 		)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("failed to close response body: %v", err)
-		}
-	}()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to read response body: %w", err,
-		)
-	}
+	return &RefundResult{RefundID: resp.Data.RefundID}, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(
-			"verify request failed with status %d: %s",
-			resp.StatusCode, string(body),
-		)
+// ParseCallback reads trackId, success and status, which Zibal sends
+// either as query parameters or as a JSON body.
+func (c *ZibalClient) ParseCallback(
+	params map[string]string,
+) (*Callback, error) {
+	trackID := params["trackId"]
+	if trackID == "" {
+		return nil, fmt.Errorf("%w: trackId is missing", ErrInvalidCallback)
 	}
 
-	var verifyResp ZibalVerifyResponse
-	if err := json.Unmarshal(body, &verifyResp); err != nil {
-		return nil, fmt.Errorf(
-			"failed to unmarshal verify response: %w", err,
-		)
+	success := params["success"]
+	return &Callback{
+		Reference: trackID,
+		Success:   success == "1" || success == "true",
+		Status:    params["status"],
+	}, nil
+}
+
+func (c *ZibalClient) headers() map[string]string {
+	if c.config.APIToken == "" {
+		return nil
+	}
+	return map[string]string{
+		"Authorization": "Bearer " + c.config.APIToken,
 	}
+}
 
-	return &verifyResp, nil
+func parseTrackID(reference string) (int64, error) {
+	trackID, err := strconv.ParseInt(reference, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zibal track ID %q: %w", reference, err)
+	}
+	return trackID, nil
 }
 
-func (c *ZibalClient) GetPaymentURL(trackID int64) string {
-	return fmt.Sprintf("%s/start/%d", c.config.BaseURL, trackID)
+func formatRefNumber(refNumber int64) string {
+	if refNumber == 0 {
+		return ""
+	}
+	return strconv.FormatInt(refNumber, 10)
 }
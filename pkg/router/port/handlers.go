@@ -7,12 +7,30 @@ import (
 type ProductHandler interface {
 	ListProducts(c *fiber.Ctx) error
 	GetProduct(c *fiber.Ctx) error
+	CreateProduct(c *fiber.Ctx) error
+	UpdateProduct(c *fiber.Ctx) error
+	ArchiveProduct(c *fiber.Ctx) error
+	RestoreProduct(c *fiber.Ctx) error
+	CreateVariant(c *fiber.Ctx) error
+	UpdateVariant(c *fiber.Ctx) error
+	ArchiveVariant(c *fiber.Ctx) error
+	RestoreVariant(c *fiber.Ctx) error
+	AdjustStock(c *fiber.Ctx) error
+	UploadImage(c *fiber.Ctx) error
+	DeleteImage(c *fiber.Ctx) error
+	ReorderImages(c *fiber.Ctx) error
+	ListMovements(c *fiber.Ctx) error
+	GetInventoryDrift(c *fiber.Ctx) error
 }
 
 type OrderHandler interface {
 	CreateOrder(c *fiber.Ctx) error
+	ListOrders(c *fiber.Ctx) error
 	GetOrder(c *fiber.Ctx) error
 	CancelOrder(c *fiber.Ctx) error
+	UpdateOrderStatus(c *fiber.Ctx) error
+	RefundOrder(c *fiber.Ctx) error
+	ListRefunds(c *fiber.Ctx) error
 }
 
 type PaymentHandler interface {
@@ -20,6 +38,24 @@ type PaymentHandler interface {
 	VerifyPayment(c *fiber.Ctx) error
 	HandleCallback(c *fiber.Ctx) error
 	GetPaymentStatus(c *fiber.Ctx) error
+	ListCallbacks(c *fiber.Ctx) error
+	RunReconciliation(c *fiber.Ctx) error
+	ListReconciliations(c *fiber.Ctx) error
+	GetReconciliation(c *fiber.Ctx) error
+	CompleteRefund(c *fiber.Ctx) error
+	VoidRefund(c *fiber.Ctx) error
+}
+
+type UserHandler interface {
+	GetProfile(c *fiber.Ctx) error
+	UpdateProfile(c *fiber.Ctx) error
+	RequestEmailVerification(c *fiber.Ctx) error
+	VerifyEmail(c *fiber.Ctx) error
+	ListAddresses(c *fiber.Ctx) error
+	CreateAddress(c *fiber.Ctx) error
+	UpdateAddress(c *fiber.Ctx) error
+	DeleteAddress(c *fiber.Ctx) error
+	UpdateUserRole(c *fiber.Ctx) error
 }
 
 type AuthHandler interface {
@@ -31,4 +67,5 @@ type AuthHandler interface {
 
 type AuthMiddleware interface {
 	Authenticate() fiber.Handler
+	RequireRole(roles ...string) fiber.Handler
 }
@@ -23,6 +23,7 @@ type FiberRouter struct {
 	productHandler port.ProductHandler
 	orderHandler   port.OrderHandler
 	paymentHandler port.PaymentHandler
+	userHandler    port.UserHandler
 	authHandler    port.AuthHandler
 	authMiddleware port.AuthMiddleware
 	version        string
@@ -31,6 +32,11 @@ type FiberRouter struct {
 type FiberConfig struct {
 	AppEnv string
 	CORS   *config.CORSConfig
+	// BodyLimit is the largest request body accepted, in bytes; zero keeps
+	// the Fiber default
+	BodyLimit int
+	// MediaDir, when set, is served under /media for the local storage
+	MediaDir string
 }
 
 func NewFiberRouter(
@@ -39,6 +45,7 @@ func NewFiberRouter(
 	productHandler port.ProductHandler,
 	orderHandler port.OrderHandler,
 	paymentHandler port.PaymentHandler,
+	userHandler port.UserHandler,
 	authHandler port.AuthHandler,
 	authMiddleware port.AuthMiddleware,
 	version string,
@@ -50,6 +57,7 @@ func NewFiberRouter(
 		ReadTimeout:             30 * time.Second,
 		WriteTimeout:            30 * time.Second,
 		IdleTimeout:             120 * time.Second,
+		BodyLimit:               cfg.BodyLimit,
 		ReadBufferSize:          8192,
 		WriteBufferSize:         8192,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -83,6 +91,7 @@ func NewFiberRouter(
 		productHandler: productHandler,
 		orderHandler:   orderHandler,
 		paymentHandler: paymentHandler,
+		userHandler:    userHandler,
 		authHandler:    authHandler,
 		authMiddleware: authMiddleware,
 		version:        version,
@@ -161,6 +170,12 @@ func (r *FiberRouter) setupRoutes() {
 
 	r.app.Get("/version", r.handleVersion)
 
+	if r.cfg.MediaDir != "" {
+		r.app.Static("/media", r.cfg.MediaDir, fiber.Static{
+			MaxAge: 86400,
+		})
+	}
+
 	if r.cfg.AppEnv == "development" {
 		r.logger.Info(
 			"Development mode detected - Swagger UI enabled",
@@ -188,6 +203,48 @@ func (r *FiberRouter) setupRoutes() {
 		r.authHandler.Logout,
 	)
 
+	api.Get(
+		"/verify-email",
+		r.userHandler.VerifyEmail,
+	)
+
+	me := api.Group("/me")
+	me.Get(
+		"/",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.GetProfile,
+	)
+	me.Patch(
+		"/",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.UpdateProfile,
+	)
+	me.Post(
+		"/verify-email",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.RequestEmailVerification,
+	)
+	me.Get(
+		"/addresses",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.ListAddresses,
+	)
+	me.Post(
+		"/addresses",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.CreateAddress,
+	)
+	me.Put(
+		"/addresses/:id",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.UpdateAddress,
+	)
+	me.Delete(
+		"/addresses/:id",
+		r.authMiddleware.Authenticate(),
+		r.userHandler.DeleteAddress,
+	)
+
 	products := api.Group("/products")
 	products.Get(
 		"/",
@@ -204,11 +261,21 @@ func (r *FiberRouter) setupRoutes() {
 		r.authMiddleware.Authenticate(),
 		r.orderHandler.CreateOrder,
 	)
+	orders.Get(
+		"/",
+		r.authMiddleware.Authenticate(),
+		r.orderHandler.ListOrders,
+	)
 	orders.Get(
 		"/:id",
 		r.authMiddleware.Authenticate(),
 		r.orderHandler.GetOrder,
 	)
+	orders.Post(
+		"/:id/cancel",
+		r.authMiddleware.Authenticate(),
+		r.orderHandler.CancelOrder,
+	)
 
 	payments := api.Group("/payments")
 	payments.Post(
@@ -221,15 +288,128 @@ func (r *FiberRouter) setupRoutes() {
 		r.authMiddleware.Authenticate(),
 		r.paymentHandler.VerifyPayment,
 	)
+	// Zibal posts to /callback, which predates the other gateways
 	payments.Post(
 		"/callback",
 		r.paymentHandler.HandleCallback,
 	)
+	payments.Get(
+		"/callback/:method",
+		r.paymentHandler.HandleCallback,
+	)
+	payments.Post(
+		"/callback/:method",
+		r.paymentHandler.HandleCallback,
+	)
 	payments.Get(
 		"/:id/status",
 		r.authMiddleware.Authenticate(),
 		r.paymentHandler.GetPaymentStatus,
 	)
+	payments.Get(
+		"/:id/callbacks",
+		r.authMiddleware.Authenticate(),
+		r.paymentHandler.ListCallbacks,
+	)
+
+	admin := api.Group(
+		"/admin",
+		r.authMiddleware.Authenticate(),
+		r.authMiddleware.RequireRole("staff", "admin"),
+	)
+	admin.Post(
+		"/products",
+		r.productHandler.CreateProduct,
+	)
+	admin.Patch(
+		"/products/:id",
+		r.productHandler.UpdateProduct,
+	)
+	admin.Delete(
+		"/products/:id",
+		r.productHandler.ArchiveProduct,
+	)
+	admin.Post(
+		"/products/:id/restore",
+		r.productHandler.RestoreProduct,
+	)
+	admin.Post(
+		"/products/:id/variants",
+		r.productHandler.CreateVariant,
+	)
+	admin.Patch(
+		"/products/:id/variants/:variantID",
+		r.productHandler.UpdateVariant,
+	)
+	admin.Delete(
+		"/products/:id/variants/:variantID",
+		r.productHandler.ArchiveVariant,
+	)
+	admin.Post(
+		"/products/:id/variants/:variantID/restore",
+		r.productHandler.RestoreVariant,
+	)
+	admin.Post(
+		"/products/:id/variants/:variantID/stock",
+		r.productHandler.AdjustStock,
+	)
+	admin.Post(
+		"/products/:id/images",
+		r.productHandler.UploadImage,
+	)
+	admin.Put(
+		"/products/:id/images/order",
+		r.productHandler.ReorderImages,
+	)
+	admin.Delete(
+		"/products/:id/images/:imageID",
+		r.productHandler.DeleteImage,
+	)
+	admin.Get(
+		"/products/:id/movements",
+		r.productHandler.ListMovements,
+	)
+	admin.Get(
+		"/inventory/drift",
+		r.productHandler.GetInventoryDrift,
+	)
+	admin.Patch(
+		"/orders/:id/status",
+		r.orderHandler.UpdateOrderStatus,
+	)
+	admin.Post(
+		"/orders/:id/refunds",
+		r.orderHandler.RefundOrder,
+	)
+	admin.Get(
+		"/orders/:id/refunds",
+		r.orderHandler.ListRefunds,
+	)
+	admin.Post(
+		"/payments/refunds/:id/complete",
+		r.paymentHandler.CompleteRefund,
+	)
+	admin.Post(
+		"/payments/refunds/:id/void",
+		r.paymentHandler.VoidRefund,
+	)
+	admin.Post(
+		"/payments/reconciliations",
+		r.paymentHandler.RunReconciliation,
+	)
+	admin.Get(
+		"/payments/reconciliations",
+		r.paymentHandler.ListReconciliations,
+	)
+	admin.Get(
+		"/payments/reconciliations/:id",
+		r.paymentHandler.GetReconciliation,
+	)
+	admin.Put(
+		"/users/:id/role",
+		r.authMiddleware.RequireRole("admin"),
+		r.userHandler.UpdateUserRole,
+	)
 }
 
 func (r *FiberRouter) setupSwaggerRoutes() {
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"dunhayat-api/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// JobFunc is a unit of background work. It receives a context that is
+// cancelled when the scheduler stops.
+type JobFunc func(ctx context.Context) error
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      JobFunc
+}
+
+// Scheduler runs registered jobs periodically, each in its own goroutine.
+// A job never overlaps with itself: the next run starts one interval after
+// the previous one finished.
+type Scheduler struct {
+	logger logger.Interface
+	jobs   []job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(logger logger.Interface) *Scheduler {
+	return &Scheduler{
+		logger: logger,
+	}
+}
+
+// Register adds a job. Jobs must be registered before Start. The interval
+// must be positive, or the job would run back to back.
+func (s *Scheduler) Register(
+	name string,
+	interval time.Duration,
+	run JobFunc,
+) error {
+	if interval <= 0 {
+		return fmt.Errorf(
+			"job %s: interval must be positive, got %s", name, interval,
+		)
+	}
+
+	s.jobs = append(s.jobs, job{
+		name:     name,
+		interval: interval,
+		run:      run,
+	})
+	return nil
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+	}
+
+	s.logger.Info("Scheduler started", zap.Int("jobs", len(s.jobs)))
+}
+
+// Stop cancels all jobs and waits for running ones to return, or for ctx
+// to expire, whichever comes first.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("Scheduler stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(j.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.runOnce(ctx, j)
+			timer.Reset(j.interval)
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("Scheduled job panicked",
+				zap.String("job", j.name),
+				zap.Any("panic", r),
+			)
+		}
+	}()
+
+	started := time.Now()
+	if err := j.run(ctx); err != nil {
+		s.logger.Error("Scheduled job failed",
+			zap.String("job", j.name),
+			zap.Duration("duration", time.Since(started)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	s.logger.Debug("Scheduled job completed",
+		zap.String("job", j.name),
+		zap.Duration("duration", time.Since(started)),
+	)
+}
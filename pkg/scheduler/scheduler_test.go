@@ -0,0 +1,25 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRegisterRejectsNonPositiveInterval(t *testing.T) {
+	s := New(nil)
+	noop := func(context.Context) error { return nil }
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := s.Register("job", interval, noop); err == nil {
+			t.Errorf("Register(%s) succeeded, want an error", interval)
+		}
+	}
+	if len(s.jobs) != 0 {
+		t.Fatalf("got %d jobs registered, want none", len(s.jobs))
+	}
+
+	if err := s.Register("job", time.Minute, noop); err != nil {
+		t.Fatalf("Register(1m) failed: %v", err)
+	}
+}
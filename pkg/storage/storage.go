@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Storage keeps uploaded files under slash-separated keys such as
+// "products/espresso-blend/1f0c.jpg".
+type Storage interface {
+	// Put stores content under key, replacing anything stored there
+	Put(
+		ctx context.Context,
+		key string,
+		content io.Reader,
+		contentType string,
+	) error
+	// Delete removes the content under key; a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is where clients can fetch the content stored under key
+	URL(key string) string
+}
+
+type Config struct {
+	// Driver is "local", the only driver so far
+	Driver string
+	// LocalDir is where the local driver keeps files
+	LocalDir string
+	// BaseURL is the public URL the stored keys are served under
+	BaseURL string
+}
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// NewStorage returns the storage selected by cfg.Driver.
+func NewStorage(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "local", "":
+		return NewLocalStorage(cfg.LocalDir, cfg.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", cfg.Driver)
+	}
+}
+
+// validateKey rejects keys that are absolute, not clean or that would
+// escape the storage root.
+func validateKey(key string) error {
+	if key == "" ||
+		strings.HasPrefix(key, "/") ||
+		path.Clean(key) != key ||
+		key == ".." ||
+		strings.HasPrefix(key, "../") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}